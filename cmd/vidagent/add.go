@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// addCmd adds actions to a filter file, each one on its own line
+// where it goes in order of time, keeping the rest of the file as it
+// is. The file is only written if it's still valid with the actions,
+// so an action that overlaps another is refused instead of breaking
+// the file.
+func addCmd(args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	o := defaultOptions()
+	editFlags(o).VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), `usage: vidagent add -filter <file> [options] "<action>"...`)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	e := newEdit(o)
+
+	if e.opts.filterFile == "" || e.opts.filterFile == "-" {
+		fs.Usage()
+		return errors.New("filter file required (use -filter)")
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("at least one action required")
+	}
+	data, err := os.ReadFile(e.opts.filterFile)
+	if err != nil {
+		return err
+	}
+	for _, act := range fs.Args() {
+		var line int
+		data, line, err = e.addAction(data, act)
+		if err != nil {
+			return fmt.Errorf("%s: %v", act, err)
+		}
+		log.Printf("added %s at line %d", strings.TrimSpace(act), line)
+	}
+
+	info, err := os.Stat(e.opts.filterFile)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(e.opts.filterFile, data, info.Mode().Perm())
+}
+
+// addAction returns the filter file with the action added on a line
+// before the first action that starts after it (and the comments
+// just above that action), or at the end, and the number of its line.
+// It fails if the file isn't valid with it.
+func (e *edit) addAction(data []byte, act string) ([]byte, int, error) {
+	act = strings.TrimSpace(act)
+	if strings.ContainsAny(act, "\r\n") {
+		return nil, 0, errors.New("an action must be one line")
+	}
+	newline := []byte("\n")
+	if bytes.Contains(data, []byte("\r\n")) {
+		newline = []byte("\r\n")
+	}
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, newline...)
+	}
+
+	// parse it at the end at first, after anything it could use,
+	// like definitions, to find its time
+	withAct := append(bytes.Clone(data), act+string(newline)...)
+	tree, err := parseSyntax(bytes.NewReader(withAct), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	actions, err := e.getActions(tree, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(actions) == 0 || actions[len(actions)-1].line() != len(tree.lines) {
+		return nil, 0, errors.New("not an action")
+	}
+	added := actions[len(actions)-1]
+	at := len(tree.lines) // (from 1)
+	for _, other := range actions[:len(actions)-1] {
+		if other.start.SecondNum() > added.start.SecondNum() {
+			at = other.line()
+			break
+		}
+	}
+	// the comments above an action are about it
+	for at > 1 {
+		prev := tree.lines[at-2]
+		if prev.action != nil || prev.directive != nil || prev.comment == nil {
+			break
+		}
+		at--
+	}
+
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	if len(lines) > 0 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	var out []byte
+	for i, l := range lines {
+		if i == at-1 {
+			out = append(out, act...)
+			out = append(out, newline...)
+		}
+		out = append(out, l...)
+	}
+	if at > len(lines) {
+		out = append(out, act...)
+		out = append(out, newline...)
+	}
+
+	if _, err := e.parseFilter(bytes.NewReader(out), 1, 0); err != nil {
+		return nil, 0, fmt.Errorf("added as line %d: %v", at, err)
+	}
+	return out, at, nil
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	// alignSampleRate is the rate audio is decoded at for alignment;
+	// speech and music are recognizable well below full quality.
+	alignSampleRate = 8000
+
+	// envelopeRate is how many loudness values per second are
+	// compared. Comparing loudness over time instead of samples
+	// is much faster and isn't thrown off by pitch changes
+	// (like from a PAL speed-up) or different mixes.
+	envelopeRate = 100
+
+	// minAlignConfidence is the lowest correlation that
+	// counts as finding the same audio in both files.
+	minAlignConfidence = 0.5
+)
+
+// alignMatch is where audio from the reference video
+// was found in the other video.
+type alignMatch struct {
+	ref, local float64 // seconds
+	confidence float64 // correlation, at most 1
+}
+
+// alignCmd works out the -time-scale and -offset that make a filter
+// file made for one release of a video fit another release, by
+// finding the audio from around some of the actions in the release
+// the filter was made for (the reference) in the other release.
+func alignCmd(args []string) error {
+	fs := flag.NewFlagSet("align", flag.ExitOnError)
+	e := newEdit(defaultOptions())
+	ref := fs.String("ref", "", "the release of the video that the filter file was made for")
+	in := fs.String("in", "", "the release of the video to apply the filter file to")
+	filter := fs.String("filter", "", "the filter file")
+	points := fs.Int("points", 6, "how many places around actions to compare")
+	window := fs.Duration("window", time.Minute, "how far from where it's expected to look for each place")
+	clip := fs.Duration("clip", 8*time.Second, "how much audio to compare at each place")
+	fs.Parse(args)
+
+	if *ref == "" || *in == "" || *filter == "" {
+		return fmt.Errorf("-ref, -in, and -filter are required")
+	}
+	if *points < 1 || *window <= 0 || *clip <= 0 {
+		return fmt.Errorf("-points, -window, and -clip must be positive")
+	}
+
+	actions, err := e.readFilterFile(*filter)
+	if err != nil {
+		return err
+	}
+	if len(actions) == 0 {
+		return fmt.Errorf("%s has no actions to align", *filter)
+	}
+
+	clipSec, windowSec := clip.Seconds(), window.Seconds()
+	scale, offset := 1.0, 0.0
+	var matches []alignMatch
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "line\treference\tfound at\toffset\tconfidence\t")
+	for _, act := range spreadActions(actions, *points) {
+		// the audio leading up to an action, which is there in
+		// both releases even if they differ in what's edited
+		refStart := math.Max(act.start.SecondNum()-clipSec, 0)
+		refEnv, err := audioEnvelope(*ref, refStart, clipSec)
+		if err != nil {
+			return fmt.Errorf("reading reference audio: %v", err)
+		}
+
+		// look around where the audio should be, based on
+		// the places that have been found so far
+		searchStart := math.Max(refStart*scale+offset-windowSec, 0)
+		searchEnv, err := audioEnvelope(*in, searchStart, clipSec+2*windowSec)
+		if err != nil {
+			return fmt.Errorf("reading input audio: %v", err)
+		}
+
+		// the releases may run at different speeds, so try matching
+		// the reference audio at each of the likely ones
+		var lag int
+		var corr float64
+		for _, candidate := range append([]string{"1"}, knownScales...) {
+			l, c := bestMatch(stretch(refEnv, parseRate(candidate)), searchEnv)
+			if c > corr {
+				lag, corr = l, c
+			}
+		}
+		m := alignMatch{
+			ref:        refStart,
+			local:      searchStart + float64(lag)/envelopeRate,
+			confidence: corr,
+		}
+		var note string
+		if corr >= minAlignConfidence {
+			matches = append(matches, m)
+			scale, offset = fitAlignment(matches)
+		} else {
+			note = "(not used)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%+.2fs\t%.2f\t%s\n", act.line(), secondsTime(m.ref),
+			secondsTime(m.local), m.local-m.ref, m.confidence, note)
+	}
+	tw.Flush()
+	fmt.Println()
+
+	if len(matches) == 0 {
+		return fmt.Errorf("couldn't find the reference audio in %s; the releases may be too different", *in)
+	}
+
+	var confidence, residual float64
+	for _, m := range matches {
+		confidence += m.confidence
+		residual = math.Max(residual, math.Abs(m.ref*scale+offset-m.local))
+	}
+	confidence /= float64(len(matches))
+
+	flags := fmt.Sprintf("-offset %+.2fs", offset)
+	if scale != 1 {
+		flags = fmt.Sprintf("-time-scale %s %s", scaleString(scale), flags)
+	}
+	fmt.Printf("suggested: %s\n", flags)
+	fmt.Printf("confidence: %.2f from %d of %d places, which are within %.2fs of the fit\n",
+		confidence, len(matches), min(*points, len(actions)), residual)
+	if len(matches) < 2 || confidence < 0.7 || residual > 0.5 {
+		fmt.Println("this is not very certain; check the result before relying on it")
+	}
+	return nil
+}
+
+// spreadActions returns up to n of the actions, spread
+// out as evenly as possible over the list.
+func spreadActions(actions []action, n int) []action {
+	if n >= len(actions) {
+		return actions
+	}
+	spread := make([]action, n)
+	for i := range spread {
+		spread[i] = actions[i*(len(actions)-1)/max(n-1, 1)]
+	}
+	return spread
+}
+
+// audioEnvelope returns the loudness, envelopeRate times per
+// second, of dur seconds of the file's audio starting at start.
+func audioEnvelope(file string, start, dur float64) ([]float64, error) {
+	samples, err := decodeAudio(file, start, dur)
+	if err != nil {
+		return nil, err
+	}
+
+	perValue := alignSampleRate / envelopeRate
+	env := make([]float64, len(samples)/perValue)
+	for i := range env {
+		var sum float64
+		for _, sample := range samples[i*perValue : (i+1)*perValue] {
+			sum += sample * sample
+		}
+		// log scale, so quiet passages count as well as loud ones
+		env[i] = math.Log(sum/float64(perValue) + 1e-6)
+	}
+	return env, nil
+}
+
+// decodeAudio returns the samples, from -1 to 1, of dur seconds of
+// the file's audio starting at start, in mono at alignSampleRate.
+func decodeAudio(file string, start, dur float64) ([]float64, error) {
+	pcm, err := ffmpegOutput(
+		"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-t", strconv.FormatFloat(dur, 'f', 3, 64),
+		"-i", fileArg(file),
+		"-map", "0:a:0", "-ac", "1", "-ar", strconv.Itoa(alignSampleRate),
+		"-f", "s16le", "-")
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]float64, len(pcm)/2)
+	for i := range samples {
+		samples[i] = float64(int16(binary.LittleEndian.Uint16(pcm[2*i:]))) / 32768
+	}
+	return samples, nil
+}
+
+// stretch returns env resampled to be scale times as long.
+func stretch(env []float64, scale float64) []float64 {
+	if scale == 1 || len(env) == 0 {
+		return env
+	}
+	stretched := make([]float64, int(float64(len(env))*scale))
+	for i := range stretched {
+		pos := float64(i) / scale
+		j := int(pos)
+		if j+1 >= len(env) {
+			stretched[i] = env[len(env)-1]
+			continue
+		}
+		frac := pos - float64(j)
+		stretched[i] = env[j]*(1-frac) + env[j+1]*frac
+	}
+	return stretched
+}
+
+// bestMatch returns where in search ref fits best, as an index
+// into search, and the normalized correlation there.
+func bestMatch(ref, search []float64) (int, float64) {
+	n := len(ref)
+	if n == 0 || len(search) < n {
+		return 0, 0
+	}
+
+	var refMean float64
+	for _, v := range ref {
+		refMean += v
+	}
+	refMean /= float64(n)
+	var refVar float64
+	for _, v := range ref {
+		refVar += (v - refMean) * (v - refMean)
+	}
+
+	bestLag, bestCorr := 0, math.Inf(-1)
+	for lag := 0; lag+n <= len(search); lag++ {
+		window := search[lag : lag+n]
+		var mean float64
+		for _, v := range window {
+			mean += v
+		}
+		mean /= float64(n)
+		var cov, variance float64
+		for i, v := range window {
+			cov += (ref[i] - refMean) * (v - mean)
+			variance += (v - mean) * (v - mean)
+		}
+		if variance == 0 || refVar == 0 {
+			continue
+		}
+		if corr := cov / math.Sqrt(refVar*variance); corr > bestCorr {
+			bestLag, bestCorr = lag, corr
+		}
+	}
+	if math.IsInf(bestCorr, -1) {
+		return 0, 0
+	}
+	return bestLag, bestCorr
+}
+
+// knownScales are the speed differences between releases that are
+// common enough that a measured scale close to one is probably it.
+var knownScales = []string{"25/24", "24/25", "25/23.976", "23.976/25", "24/23.976", "23.976/24"}
+
+// fitAlignment returns the scale and offset that best map the
+// reference times of the matches to the local times. The scale is
+// snapped to 1 or one of knownScales if it's close to it.
+func fitAlignment(matches []alignMatch) (float64, float64) {
+	scale := 1.0
+	first, last := matches[0].ref, matches[len(matches)-1].ref
+	// a scale can't be measured from places close together
+	if len(matches) > 1 && last-first >= 60 {
+		var meanRef, meanLocal float64
+		for _, m := range matches {
+			meanRef += m.ref
+			meanLocal += m.local
+		}
+		meanRef /= float64(len(matches))
+		meanLocal /= float64(len(matches))
+		var cov, variance float64
+		for _, m := range matches {
+			cov += (m.ref - meanRef) * (m.local - meanLocal)
+			variance += (m.ref - meanRef) * (m.ref - meanRef)
+		}
+		scale = snapScale(cov / variance)
+	}
+
+	var offset float64
+	for _, m := range matches {
+		offset += m.local - m.ref*scale
+	}
+	return scale, offset / float64(len(matches))
+}
+
+// snapScale returns 1 or one of knownScales if scale
+// is within 0.1% of it, or scale itself otherwise.
+func snapScale(scale float64) float64 {
+	if math.Abs(scale-1) < 0.001 {
+		return 1
+	}
+	for _, known := range knownScales {
+		if k := parseRate(known); math.Abs(scale-k)/k < 0.001 {
+			return k
+		}
+	}
+	return scale
+}
+
+// scaleString formats scale for -time-scale, as
+// one of knownScales if it's one of them.
+func scaleString(scale float64) string {
+	for _, known := range knownScales {
+		if parseRate(known) == scale {
+			return known
+		}
+	}
+	return strconv.FormatFloat(scale, 'f', 6, 64)
+}
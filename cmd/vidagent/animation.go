@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// An output ending in .gif or .webp is an animation: the edit is made
+// into a video first, which is then made into the animation with
+// settings that suit it, since it has no audio, and every frame of a
+// big, smooth one makes for a huge file.
+
+// animationWidth is the width an animation is scaled down to, if it's
+// wider, unless -scale or -max-height says otherwise.
+const animationWidth = 480
+
+// longAnimation is how many seconds of output are too long for an
+// animation to be a reasonable size.
+const longAnimation = 60
+
+// isAnimation returns true if the file is an animated GIF or WebP.
+func isAnimation(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".gif", ".webp":
+		return true
+	}
+	return false
+}
+
+// animationArgs returns the ffmpeg options that make video into an
+// animation in the format of the file.
+func (e *edit) animationArgs(file string) []string {
+	gif := strings.EqualFold(filepath.Ext(file), ".gif")
+	var filters []string
+	if e.opts.frameRate == "" {
+		if gif {
+			filters = append(filters, "fps=10")
+		} else {
+			filters = append(filters, "fps=15")
+		}
+	}
+	if e.opts.outputSize == "" && e.opts.maxHeight == 0 {
+		filters = append(filters, fmt.Sprintf("scale='min(iw,%d)':-2:flags=lanczos", animationWidth))
+	}
+	if !gif {
+		return []string{"-vf", strings.Join(append(filters, "format=yuv420p"), ","),
+			"-c:v", "libwebp", "-quality", "75", "-compression_level", "4", "-loop", "0", "-an"}
+	}
+	// (GIF has at most 256 colors, so the palette is made
+	// from the video itself, for the colors to look right)
+	filters = append(filters, "split[a][b];[a]palettegen=stats_mode=diff[p];[b][p]paletteuse=dither=bayer:bayer_scale=5:diff_mode=rectangle")
+	return []string{"-filter_complex", "[0:v:0]" + strings.Join(filters, ","), "-loop", "0", "-an"}
+}
+
+// writeAnimation makes the edited video into the animation.
+func (e *edit) writeAnimation(video, file string) error {
+	info, err := probe(video)
+	if err != nil {
+		return err
+	}
+	if dur := info.Format.duration(); dur > longAnimation {
+		log.Printf("WARNING: the animation is %s long, so it will be big; use -from and -to for a short excerpt", clockString(dur))
+	}
+	e.setStage("making the animation", info.Format.duration())
+	args := []string{"-y", "-i", fileArg(video)}
+	args = append(args, e.animationArgs(file)...)
+	args = append(args, fileArg(file))
+	return e.runFFmpeg(args, file)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// With -archive, an edit also saves what it cut or changed: a small
+// re-encode of each segment of the input that any action touched, in
+// a folder with a manifest of where each one was in the input and is
+// in the output. vidagent restore puts them back into the output, to
+// get something close to the original without keeping all of it.
+
+// archiveManifestFile is the name of the manifest in an archive.
+const archiveManifestFile = "archive.json"
+
+// archiveManifest describes the output of an edit and the segments of
+// its input that it archived.
+type archiveManifest struct {
+	Input         string           `json:"input"`
+	InputDuration float64          `json:"input_duration"`
+	Filter        string           `json:"filter"`
+	Output        string           `json:"output"`
+	OutputHash    string           `json:"output_hash"`
+	Segments      []archiveSegment `json:"segments"`
+}
+
+// archiveSegment is a segment of the input that's archived: where it
+// was in the input, what it is in the output (nothing, for a cut),
+// the verbs of the actions in it, and its file in the archive.
+type archiveSegment struct {
+	Start       float64  `json:"start"`
+	End         float64  `json:"end"`
+	OutputStart float64  `json:"output_start"`
+	OutputEnd   float64  `json:"output_end"`
+	Verbs       []string `json:"verbs"`
+	File        string   `json:"file"`
+}
+
+// archiveSize is the height the archived segments are scaled down to,
+// if they're taller: they only need to be good enough to watch.
+const archiveSize = 480
+
+// writeArchive saves the segments of the input that the actions (and
+// effects) touched, and the manifest, in the -archive folder.
+func (e *edit) writeArchive(actions []action) error {
+	var edits []action
+	for _, act := range actions {
+		if act.verb != ChapterBreakVerb && act.verb != ExtractVerb && act.verb != NoteVerb {
+			edits = append(edits, act)
+		}
+	}
+	slices.SortStableFunc(edits, func(a, b action) int {
+		switch {
+		case a.start.SecondNum() < b.start.SecondNum():
+			return -1
+		case a.start.SecondNum() > b.start.SecondNum():
+			return 1
+		}
+		return 0
+	})
+
+	err := os.MkdirAll(e.opts.archiveDir, 0755)
+	if err != nil {
+		return fmt.Errorf("-archive: %v", err)
+	}
+	outputHash, err := fileHash(e.opts.outputFile)
+	if err != nil {
+		return err
+	}
+	manifest := archiveManifest{
+		Input:         absPath(e.inputName()),
+		InputDuration: e.inputInfo.Format.duration(),
+		Filter:        absPath(e.opts.filterFile),
+		Output:        absPath(e.opts.outputFile),
+		OutputHash:    outputHash,
+	}
+
+	// actions that overlap (like a blur in a mute) are archived
+	// together, as one segment
+	times := e.newTimeMap(actions)
+	var segments []archiveSegment
+	for _, act := range edits {
+		start, end := act.start.SecondNum(), act.end.SecondNum()
+		if n := len(segments); n > 0 && start <= segments[n-1].End {
+			last := &segments[n-1]
+			last.End = max(last.End, end)
+			if !slices.Contains(last.Verbs, string(act.verb)) {
+				last.Verbs = append(last.Verbs, string(act.verb))
+			}
+			continue
+		}
+		segments = append(segments, archiveSegment{Start: start, End: end, Verbs: []string{string(act.verb)}})
+	}
+
+	for i := range segments {
+		seg := &segments[i]
+		seg.OutputStart, seg.OutputEnd, _ = times.rangeToOutput(seg.Start, seg.End)
+		seg.File = fmt.Sprintf("segment-%03d.mkv", i+1)
+		e.setStage(fmt.Sprintf("archiving segment %d of %d", i+1, len(segments)), seg.End-seg.Start)
+
+		file := filepath.Join(e.opts.archiveDir, seg.File)
+		args := []string{"-y"}
+		args = append(args, e.spanInputArgs(span{start: secondsTime(seg.Start), end: secondsTime(seg.End)})...)
+		args = append(args, "-map", "0:v:0?", "-map", e.audioSpec(0)+"?",
+			"-vf", fmt.Sprintf("scale=-2:'min(ih,%d)'", archiveSize),
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "28",
+			"-c:a", "aac", "-b:a", "96k",
+			fileArg(file))
+		err := e.runFFmpeg(args, file)
+		if err != nil {
+			return fmt.Errorf("archiving %s-%s: %v", clockString(seg.Start), clockString(seg.End), err)
+		}
+	}
+	manifest.Segments = segments
+
+	data, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(e.opts.archiveDir, archiveManifestFile), append(data, '\n'), 0644)
+	if err != nil {
+		return err
+	}
+	log.Printf("archived %d segments of the input in %s", len(segments), e.opts.archiveDir)
+	return nil
+}
+
+// restoreCmd puts the segments of an archive back into the output
+// the archive was made with, in the places they were in the input,
+// replacing what the edit changed.
+func restoreCmd(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	e := newEdit(defaultOptions())
+	archiveDir := fs.String("archive", "", "the archive the edit made")
+	input := fs.String("in", "", "the edited file (by default, the output the archive was made with)")
+	output := fs.String("out", "", "the file to write")
+	fs.BoolVar(&e.opts.overwrite, "f", e.opts.overwrite, "overwrite the output file if it exists")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent restore -archive <folder> [-in <edited file>] -out <file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *archiveDir == "" || *output == "" {
+		fs.Usage()
+		return errors.New("archive folder and output file required (use -archive and -out)")
+	}
+	data, err := os.ReadFile(filepath.Join(*archiveDir, archiveManifestFile))
+	if err != nil {
+		return err
+	}
+	var manifest archiveManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return fmt.Errorf("%s: %v", archiveManifestFile, err)
+	}
+	if *input == "" {
+		*input = manifest.Output
+	}
+	if hash, err := fileHash(*input); err != nil {
+		return err
+	} else if hash != manifest.OutputHash {
+		log.Printf("WARNING: %s isn't the output the archive was made with; the segments may not line up", *input)
+	}
+
+	e.opts.inputFile, e.opts.outputFile = *input, *output
+	e.inputInfo, err = probe(*input)
+	if err != nil {
+		return err
+	}
+	video, audio := e.inputInfo.stream("video"), e.inputInfo.stream("audio")
+	if video == nil && audio == nil {
+		return fmt.Errorf("%s has no video or audio", *input)
+	}
+
+	// the archived segments are made to match the edited file,
+	// so they can be joined with it
+	var videoFormat, audioFormat string
+	if video != nil {
+		videoFormat = "setsar=1"
+		if video.Width > 0 && video.Height > 0 {
+			videoFormat = fmt.Sprintf("scale=%d:%d,setsar=1", video.Width, video.Height)
+		}
+		if rate := video.AvgFrameRate; parseRate(rate) > 0 {
+			videoFormat += ",fps=" + rate
+		}
+		if video.PixFmt != "" {
+			videoFormat += ",format=" + video.PixFmt
+		}
+	}
+	if audio != nil {
+		audioFormat = "aformat=sample_fmts=fltp"
+		if audio.SampleRate != "" {
+			audioFormat += ":sample_rates=" + audio.SampleRate
+		}
+		if audio.Channels > 0 {
+			audioFormat += ":channel_layouts=" + strconv.Itoa(audio.Channels) + "c"
+		}
+	}
+
+	var graph strings.Builder
+	var links string
+	n := 0
+	add := func(videoIn, audioIn string) {
+		if video != nil {
+			fmt.Fprintf(&graph, "%s%s[v%d];", videoIn, videoFormat, n)
+			links += fmt.Sprintf("[v%d]", n)
+		}
+		if audio != nil {
+			fmt.Fprintf(&graph, "%s%s[a%d];", audioIn, audioFormat, n)
+			links += fmt.Sprintf("[a%d]", n)
+		}
+		n++
+	}
+	kept := func(from, to float64, open bool) {
+		if !open && to-from < .001 {
+			return
+		}
+		trim := "start=" + strconv.FormatFloat(from, 'f', 3, 64)
+		if !open {
+			trim += ":end=" + strconv.FormatFloat(to, 'f', 3, 64)
+		}
+		add("[0:v]trim="+trim+",setpts=PTS-STARTPTS,", "[0:a]atrim="+trim+",asetpts=PTS-STARTPTS,")
+	}
+
+	ffmpegArgs := []string{e.overwriteArg(), "-i", fileArg(*input)}
+	var pos float64
+	for i, seg := range manifest.Segments {
+		kept(pos, seg.OutputStart, false)
+		ffmpegArgs = append(ffmpegArgs, "-i", fileArg(filepath.Join(*archiveDir, seg.File)))
+		add(fmt.Sprintf("[%d:v]", i+1), fmt.Sprintf("[%d:a]", i+1))
+		pos = seg.OutputEnd
+	}
+	kept(pos, 0, true)
+
+	var maps []string
+	fmt.Fprintf(&graph, "%sconcat=n=%d:v=%d:a=%d", links, n, boolInt(video != nil), boolInt(audio != nil))
+	if video != nil {
+		graph.WriteString("[outv]")
+		maps = append(maps, "-map", "[outv]")
+	}
+	if audio != nil {
+		graph.WriteString("[outa]")
+		maps = append(maps, "-map", "[outa]")
+	}
+	ffmpegArgs = append(ffmpegArgs, "-filter_complex", graph.String())
+	ffmpegArgs = append(ffmpegArgs, maps...)
+	ffmpegArgs = append(ffmpegArgs, e.videoEncodeArgs()...)
+	ffmpegArgs = append(ffmpegArgs, fileArg(*output))
+
+	e.setStage("restoring", manifest.InputDuration)
+	err = e.runFFmpeg(ffmpegArgs, *output)
+	if err != nil {
+		return err
+	}
+	log.Printf("restored %d segments into %s", len(manifest.Segments), *output)
+	return nil
+}
+
+// boolInt returns 1 if b is true, otherwise 0.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Releases in more than one language have an audio track for each,
+// and since a dub's dialogue isn't where the original's is, their
+// mutes differ. -audio-lang chooses the track to edit, which becomes
+// the output's audio; otherwise it's the first one. In a filter file,
+// a verb can be for a language, like mute@spa, for an action that's
+// only applied when the track edited is in that language; actions
+// without one are applied whatever it is.
+
+// languagePattern matches a language code, like eng.
+var languagePattern = regexp.MustCompile(`^[A-Za-z]{2,3}$`)
+
+// audioStream returns the input's audio track that's edited, and its
+// number among the input's audio tracks, or nil if it has none (or
+// none in the language of -audio-lang).
+func (e *edit) audioStream() (*probeStream, int) {
+	n := 0
+	for i := range e.inputInfo.Streams {
+		st := &e.inputInfo.Streams[i]
+		if st.CodecType != "audio" {
+			continue
+		}
+		if e.opts.audioLang == "" || strings.EqualFold(st.Tags["language"], e.opts.audioLang) {
+			return st, n
+		}
+		n++
+	}
+	return nil, 0
+}
+
+// audioSpec returns the stream specifier of the audio track that's
+// edited, in the input with the given ffmpeg input number.
+func (e *edit) audioSpec(input int) string {
+	_, n := e.audioStream()
+	return fmt.Sprintf("%d:a:%d", input, n)
+}
+
+// audioLanguages returns the languages of the input's audio tracks.
+func audioLanguages(info probeResult) []string {
+	var langs []string
+	for _, st := range info.Streams {
+		if st.CodecType != "audio" {
+			continue
+		}
+		lang := st.Tags["language"]
+		if lang == "" {
+			lang = "und"
+		}
+		langs = append(langs, lang)
+	}
+	return langs
+}
+
+// checkAudioLang returns an error if the input doesn't have an audio
+// track in the language of -audio-lang.
+func (e *edit) checkAudioLang() error {
+	if e.opts.audioLang == "" || len(e.inputInfo.Streams) == 0 {
+		return nil
+	}
+	if st, _ := e.audioStream(); st == nil {
+		langs := audioLanguages(e.inputInfo)
+		if len(langs) == 0 {
+			return fmt.Errorf("-audio-lang %s: the input has no audio", e.opts.audioLang)
+		}
+		return fmt.Errorf("-audio-lang %s: the input has no audio track in that language, only %s", e.opts.audioLang, strings.Join(langs, ", "))
+	}
+	return nil
+}
+
+// actionLanguages returns the languages that the actions are for.
+func actionLanguages(actions []action) []string {
+	var langs []string
+	for _, act := range actions {
+		if act.lang != "" && !slices.Contains(langs, act.lang) {
+			langs = append(langs, act.lang)
+		}
+	}
+	return langs
+}
+
+// forLanguage returns the actions that are applied when the track
+// edited is in the language: those for it or for any.
+func forLanguage(actions []action, lang string) []action {
+	var applied []action
+	for _, act := range actions {
+		if act.lang == "" || strings.EqualFold(act.lang, lang) {
+			applied = append(applied, act)
+		}
+	}
+	return applied
+}
+
+// selectLanguage returns the actions for the language of the audio
+// track that's edited, if any of them are for a language.
+func (e *edit) selectLanguage(actions []action) ([]action, error) {
+	langs := actionLanguages(actions)
+	if len(langs) == 0 {
+		return actions, nil
+	}
+	lang := e.opts.audioLang
+	if lang == "" {
+		if e.opts.inputFile == "" {
+			return nil, fmt.Errorf("the filter file has actions for %s, so the language of the audio is needed (use -audio-lang or -in)", strings.Join(langs, ", "))
+		}
+		info, err := probe(e.opts.inputFile)
+		if err != nil {
+			return nil, err
+		}
+		if all := audioLanguages(info); len(all) > 0 {
+			lang = all[0]
+		}
+		if lang == "" || lang == "und" {
+			return nil, fmt.Errorf("the filter file has actions for %s, but the input's audio has no language (use -audio-lang)", strings.Join(langs, ", "))
+		}
+	}
+	return forLanguage(actions, lang), nil
+}
+
+// validateLanguageTimes validates the times of the actions that are
+// applied together, for each language: actions for different ones
+// may overlap, since they're never applied together.
+func validateLanguageTimes(actions []action) error {
+	langs := actionLanguages(actions)
+	if len(langs) == 0 {
+		return validateSegmentTimes(actions)
+	}
+	for _, lang := range langs {
+		err := validateSegmentTimes(forLanguage(actions, lang))
+		if err != nil {
+			return fmt.Errorf("%v (for %s)", err, lang)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// sliceSkipFlags are the options that bench and compare don't pass on
+// to the edits they run on slices of the input: they choose the files
+// and engine themselves, and the actions they give them are already
+// shifted and selected (and a slice being mostly cut isn't a mistake).
+var sliceSkipFlags = []string{
+	"in", "out", "filter", "filter-repo", "verify-key", "policy", "engine", "f",
+	"only-lines", "only-verb", "only-category", "lenient", "offset", "time-scale",
+	"split", "progress-json", "no-history", "profiles", "out-profile",
+	"on-success", "on-failure", "dump-graph", "action", "archive",
+	"from", "to", "in-format", "fps", "in-size", "in-pix-fmt", "in-sample-rate", "in-channels",
+	"large-edit-percent", "large-edit-minutes", "allow-large-edits",
+}
+
+// benchCmd times each engine performing the filter file's actions on
+// a slice of the input, where the actions are busiest, and
+// recommends the fastest.
+func benchCmd(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	o := defaultOptions()
+	edits := editFlags(o)
+	edits.VisitAll(func(f *flag.Flag) {
+		if f.Name != "out" && f.Name != "engine" {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	sliceLength := fs.Duration("duration", time.Minute, "how much of the input to edit with each engine")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent bench -in <file> -filter <file> [-duration <duration>] [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	e := newEdit(o)
+	if err := e.inlineFilter(); err != nil {
+		return err
+	}
+
+	if e.opts.inputFile == "" || e.opts.filterFile == "" {
+		fs.Usage()
+		return errors.New("input and filter files required (use -in and -filter)")
+	}
+	if *sliceLength <= 0 {
+		return errors.New("-duration must be positive")
+	}
+	scale, err := parseScale(e.opts.timeScale)
+	if err != nil {
+		return fmt.Errorf("-time-scale: %v", err)
+	}
+	offset, err := parseOffset(e.opts.timeOffset)
+	if err != nil {
+		return fmt.Errorf("-offset: %v", err)
+	}
+	actions, err := e.readShiftedFilterFile(e.opts.filterFile, scale, offset)
+	if err != nil {
+		return err
+	}
+	actions, err = e.selectActions(actions)
+	if err != nil {
+		return err
+	}
+	// notes and extracts are the same whatever the engine
+	actions = withoutVerb(actions, NoteVerb, ExtractVerb)
+	if len(actions) == 0 {
+		return errors.New("no actions to time the engines with")
+	}
+	removeImport, err := e.importTempInput()
+	if err != nil {
+		return err
+	}
+	defer removeImport()
+	e.inputInfo, err = probe(e.opts.inputFile)
+	if err != nil {
+		return err
+	}
+
+	start, end := busiestSlice(actions, sliceLength.Seconds(), e.inputInfo.Format.duration())
+	sliceActions := cropActions(actions, start, end)
+	log.Printf("timing the engines on %s-%s of the input, which has %d actions",
+		secondsTime(start), secondsTime(end), len(sliceActions))
+
+	dir, err := makeTempDir(e.opts.tempRoot, "vidagent-bench-")
+	if err != nil {
+		return err
+	}
+	defer removeTempDir(dir)
+
+	ext := filepath.Ext(e.opts.inputFile)
+	slice := filepath.Join(dir, "slice"+ext)
+	_, err = ffmpegOutput("-ss", e.secondString(secondsTime(start)), "-i", fileArg(e.opts.inputFile),
+		"-t", e.secondString(secondsTime(end-start)), "-map", "0", "-c", "copy",
+		"-avoid_negative_ts", "make_zero", fileArg(slice))
+	if err != nil {
+		return fmt.Errorf("cutting the slice out of the input: %v", err)
+	}
+	sliceFilter := filepath.Join(dir, "slice.filter")
+	err = writeActions(sliceFilter, sliceActions)
+	if err != nil {
+		return err
+	}
+
+	var editArgs []string
+	fs.Visit(func(f *flag.Flag) {
+		if edits.Lookup(f.Name) != nil && !slices.Contains(sliceSkipFlags, f.Name) {
+			editArgs = append(editArgs, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var engineNames []string
+	for name := range engines {
+		engineNames = append(engineNames, name)
+	}
+	slices.Sort(engineNames)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "engine\ttime\tspeed\tsize\t")
+	var fastest string
+	var fastestTime time.Duration
+	for _, engine := range engineNames {
+		out := filepath.Join(dir, "out-"+engine+ext)
+		args := append(slices.Clone(editArgs), "-in", slice, "-out", out, "-filter", sliceFilter,
+			"-engine", engine, "-f", "-no-history", "-allow-large-edits")
+		cmd := exec.Command(exe, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		started := time.Now()
+		err := cmd.Run()
+		took := time.Since(started)
+		if err != nil {
+			lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+			fmt.Fprintf(tw, "%s\t-\t-\t-\tfailed: %s\n", engine, exitMessage(lines))
+			continue
+		}
+		info, err := os.Stat(out)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.1fx\t%s\t\n", engine, took.Round(10*time.Millisecond),
+			(end-start)/took.Seconds(), byteSize(uint64(info.Size())))
+		if fastest == "" || took < fastestTime {
+			fastest, fastestTime = engine, took
+		}
+	}
+	tw.Flush()
+
+	if fastest == "" {
+		return errors.New("none of the engines could perform the actions")
+	}
+	fmt.Printf("\nrecommended: -engine %s\n", fastest)
+	return nil
+}
+
+// busiestSlice returns the start and end, in seconds, of the part of
+// the input that's length seconds long (or all of it, if it isn't
+// that long) and overlaps the most actions.
+func busiestSlice(actions []action, length, duration float64) (float64, float64) {
+	if duration <= length {
+		return 0, duration
+	}
+	var bestStart float64
+	bestCount := -1
+	for _, candidate := range actions {
+		start := math.Min(math.Max(candidate.start.SecondNum()-1, 0), duration-length)
+		var count int
+		for _, act := range actions {
+			if act.end.SecondNum() >= start && act.start.SecondNum() <= start+length {
+				count++
+			}
+		}
+		if count > bestCount {
+			bestStart, bestCount = start, count
+		}
+	}
+	return bestStart, bestStart + length
+}
+
+// cropActions returns the parts of the actions between start and
+// end seconds, with their times from start.
+func cropActions(actions []action, start, end float64) []action {
+	var cropped []action
+	for _, act := range actions {
+		actStart := math.Max(act.start.SecondNum(), start) - start
+		actEnd := math.Min(act.end.SecondNum(), end) - start
+		isMarker := act.start == act.end
+		if actStart > end-start || actEnd < 0 || (!isMarker && actEnd-actStart < .1) {
+			continue
+		}
+		act.start, act.end = secondsTime(actStart), secondsTime(actEnd)
+		cropped = append(cropped, act)
+	}
+	return cropped
+}
+
+// writeActions writes the actions to a new filter file.
+func writeActions(filename string, actions []action) error {
+	var b strings.Builder
+	for _, act := range actions {
+		fmt.Fprintf(&b, "%s %s", act.verb, clockString(act.start.SecondNum()))
+		if act.end != act.start {
+			fmt.Fprintf(&b, "-%s", clockString(act.end.SecondNum()))
+		}
+		if act.reason.Category != "" {
+			fmt.Fprintf(&b, " (%s)", reasonString(act.reason))
+		}
+		if act.syntax.params != nil {
+			fmt.Fprintf(&b, " %s", act.syntax.params.text)
+		}
+		b.WriteString("\n")
+	}
+	return os.WriteFile(filename, []byte(b.String()), 0644)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The blur verb takes box parameters, each a region of the picture to
+// blur given as x:y:width:height in pixels of the upright video
+// (before -scale):
+//
+//	blur 1:02:03-1:02:09 (nudity) [box=320:180:200:240, box=900:200:150:150]
+//
+// Without boxes, the whole picture is blurred.
+
+// blurRadius is how strongly whole pictures are blurred.
+const blurRadius = 20
+
+// blurBox is a region of the picture.
+type blurBox struct {
+	x, y, w, h int
+}
+
+func (b blurBox) String() string {
+	return fmt.Sprintf("%d:%d:%d:%d", b.x, b.y, b.w, b.h)
+}
+
+// parseBlurBox parses a box parameter value, x:y:width:height.
+func parseBlurBox(s string) (blurBox, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 4 {
+		return blurBox{}, fmt.Errorf("box '%s' must be x:y:width:height", s)
+	}
+	var nums [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return blurBox{}, fmt.Errorf("box '%s' must be x:y:width:height in whole pixels", s)
+		}
+		nums[i] = n
+	}
+	if nums[2] == 0 || nums[3] == 0 {
+		return blurBox{}, fmt.Errorf("box '%s' is empty", s)
+	}
+	return blurBox{nums[0], nums[1], nums[2], nums[3]}, nil
+}
+
+// isEffect returns true if verb changes the picture or sound
+// without changing the timing of the video.
+func isEffect(verb Verb) bool {
+	switch verb {
+	case BlurVerb, ScrambleVerb, FadeOutVerb, FadeInVerb:
+		return true
+	}
+	return isPlugin(verb)
+}
+
+// applyBlurs adds the filters for the blur actions to effects,
+// the same way as for plugins, and returns the other actions.
+func (e *edit) applyBlurs(actions []action) ([]action, error) {
+	var others []action
+	for _, act := range actions {
+		if act.verb != BlurVerb {
+			others = append(others, act)
+			continue
+		}
+		enable := fmt.Sprintf("enable='between(t,%s,%s)'", e.secondString(act.start), e.secondString(act.end))
+		boxes, err := act.blurBoxes()
+		if err != nil {
+			return nil, err
+		}
+		if len(boxes) == 0 {
+			e.effects.video = append(e.effects.video, fmt.Sprintf("boxblur=%d:%s", blurRadius, enable))
+			continue
+		}
+		for _, box := range boxes {
+			// delogo smears the region from its surroundings,
+			// which hides it about as well as a blur, and it
+			// doesn't need a filter graph of its own
+			e.effects.video = append(e.effects.video, fmt.Sprintf("delogo=x=%d:y=%d:w=%d:h=%d:%s",
+				box.x, box.y, box.w, box.h, enable))
+		}
+	}
+	return others, nil
+}
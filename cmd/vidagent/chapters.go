@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Times in a filter file can be relative to the start of one of the
+// input's chapters: "ch3+1:20" is 1 minute 20 seconds into the third
+// chapter, and "ch3" is its start. Releases with a different amount of
+// footage before the movie still line up with the filter file that way,
+// as long as they're chaptered the same.
+
+// inputChapterStarts returns chapterStarts, probing them if needed.
+func (e *edit) inputChapterStarts() ([]float64, error) {
+	if e.chaptersProbed {
+		return e.chapterStarts, nil
+	}
+	if e.opts.inputFile == "" {
+		return nil, fmt.Errorf("times relative to chapters need -in, to find its chapters")
+	}
+	info, err := probe(e.opts.inputFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, ch := range info.Chapters {
+		start, err := strconv.ParseFloat(ch.StartTime, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad chapter start time '%s' from ffprobe", ch.StartTime)
+		}
+		e.chapterStarts = append(e.chapterStarts, start)
+	}
+	e.chaptersProbed = true
+	return e.chapterStarts, nil
+}
+
+// isChapterTime returns true if s starts with a time relative
+// to a chapter, which is "ch" and the chapter number.
+func isChapterTime(s string) bool {
+	return len(s) > 2 && strings.EqualFold(s[:2], "ch") && unicode.IsDigit(rune(s[2]))
+}
+
+// parseChapterTime parses a time, which may be relative to a chapter
+// or a timecode.
+// It also returns the chapter (from 1) that the time is relative to,
+// or 0 if it isn't.
+func (e *edit) parseChapterTime(s string) (Time, int, error) {
+	if isTimecode(s) {
+		t, err := e.parseTimecode(s)
+		return t, 0, err
+	}
+	if !isChapterTime(s) {
+		t, err := ParseTime(s)
+		return t, 0, err
+	}
+	num, rel, _ := strings.Cut(s[2:], "+")
+	chapter, err := strconv.Atoi(num)
+	if err != nil || chapter < 1 {
+		return Time{}, 0, fmt.Errorf("bad chapter number in '%s'", s)
+	}
+	relTime, err := ParseTime(rel)
+	if err != nil {
+		return Time{}, 0, err
+	}
+	starts, err := e.inputChapterStarts()
+	if err != nil {
+		return Time{}, 0, err
+	}
+	if chapter > len(starts) {
+		return Time{}, 0, fmt.Errorf("the input has %d chapters, so there's no chapter %d", len(starts), chapter)
+	}
+	return secondsTime(starts[chapter-1] + relTime.SecondNum()), chapter, nil
+}
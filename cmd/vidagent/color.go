@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// hdr returns true if the stream uses an HDR transfer
+// function: PQ (HDR10) or HLG.
+func (s probeStream) hdr() bool {
+	return s.ColorTransfer == "smpte2084" || s.ColorTransfer == "arib-std-b67"
+}
+
+// highBitDepth returns true if the stream has more
+// than 8 bits per color component, like yuv420p10le.
+func (s probeStream) highBitDepth() bool {
+	for _, depth := range []string{"p10", "p12", "p14", "p16"} {
+		if strings.Contains(s.PixFmt, depth) {
+			return true
+		}
+	}
+	return false
+}
+
+// toneMapFilters convert HDR video to 8-bit SDR (BT.709).
+// This requires ffmpeg to be built with zimg.
+var toneMapFilters = []string{
+	"zscale=t=linear:npl=100",
+	"format=gbrpf32le",
+	"zscale=p=bt709",
+	"tonemap=tonemap=hable:desat=0",
+	"zscale=t=bt709:m=bt709:r=tv",
+	"format=yuv420p",
+}
+
+// videoEncodeArgs returns the output options needed to keep the
+// input's bit depth, color properties, and rotation when the video
+// is re-encoded; otherwise ffmpeg would output 8-bit video with
+// unspecified colors, which makes HDR look washed out. HDR video
+// is encoded as HEVC since that's what HDR players expect, unless
+// -video-codec says otherwise.
+func (e *edit) videoEncodeArgs() []string {
+	video := e.inputInfo.stream("video")
+	if video == nil {
+		return nil
+	}
+
+	args := e.rotationArgs()
+	if e.opts.toneMap {
+		return append(args, e.codecArgs(nil)...)
+	}
+	if video.highBitDepth() {
+		args = append(args, "-pix_fmt", video.PixFmt)
+	}
+	colorProps := []struct{ option, value string }{
+		{"-color_primaries", video.ColorPrimaries},
+		{"-color_trc", video.ColorTransfer},
+		{"-colorspace", video.ColorSpace},
+		{"-color_range", video.ColorRange},
+	}
+	for _, prop := range colorProps {
+		if prop.value != "" && prop.value != "unknown" {
+			args = append(args, prop.option, prop.value)
+		}
+	}
+
+	if !video.hdr() {
+		video = nil
+	}
+	return append(args, e.codecArgs(video)...)
+}
+
+// codecArgs returns the ffmpeg options of the encoder of the video,
+// which is HDR if hdr isn't nil, as -video-codec, -crf, and -preset
+// say.
+func (e *edit) codecArgs(hdr *probeStream) []string {
+	var args []string
+	codec := e.opts.videoCodec
+	if hdr != nil && codec == "" {
+		codec = "libx265"
+	}
+	if codec != "" {
+		args = append(args, "-c:v", codec)
+	}
+	if hdr != nil && codec == "libx265" {
+		args = append(args, "-x265-params",
+			fmt.Sprintf("hdr-opt=1:repeat-headers=1:colorprim=%s:transfer=%s:colormatrix=%s",
+				hdr.ColorPrimaries, hdr.ColorTransfer, hdr.ColorSpace))
+	}
+	if strings.Contains(codec, "265") || strings.HasPrefix(codec, "hevc") {
+		switch strings.ToLower(filepath.Ext(e.opts.outputFile)) {
+		case ".mp4", ".m4v", ".mov":
+			// Apple devices only play HEVC in MP4 with this tag
+			args = append(args, "-tag:v", "hvc1")
+		}
+	}
+	if e.opts.crf != "" {
+		args = append(args, "-crf", e.opts.crf)
+	}
+	if e.opts.preset != "" {
+		args = append(args, "-preset", e.opts.preset)
+	}
+	return args
+}
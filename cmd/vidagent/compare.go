@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// compareCmd renders a short clip around each blur, mute, and scramble
+// action with the original on the left and the edited video on the
+// right, so it's easy to see (and hear) that the edit did what was
+// intended. Cuts aren't compared, since there's nothing left of them
+// to see.
+func compareCmd(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ExitOnError)
+	o := defaultOptions()
+	edits := editFlags(o)
+	edits.VisitAll(func(f *flag.Flag) {
+		if f.Name != "out" {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	outDir := fs.String("out-dir", ".", "the directory to write the clips to")
+	around := fs.Duration("around", 2*time.Second, "how much of the video to show before and after each edit")
+	lines := fs.String("lines", "", "only compare the actions on these lines (e.g. 3,7-12)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent compare -in <file> -filter <file> [-out-dir <dir>] [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	e := newEdit(o)
+	if err := e.inlineFilter(); err != nil {
+		return err
+	}
+
+	if e.opts.inputFile == "" || e.opts.filterFile == "" {
+		fs.Usage()
+		return errors.New("input and filter files required (use -in and -filter)")
+	}
+	if *around < 0 {
+		return errors.New("-around can't be negative")
+	}
+	var ranges [][2]int
+	if *lines != "" {
+		var err error
+		ranges, err = parseLineRanges(*lines)
+		if err != nil {
+			return err
+		}
+	}
+	if e.opts.policyFile != "" {
+		err := e.loadPolicy(e.opts.policyFile)
+		if err != nil {
+			return err
+		}
+	}
+	scale, err := parseScale(e.opts.timeScale)
+	if err != nil {
+		return fmt.Errorf("-time-scale: %v", err)
+	}
+	offset, err := parseOffset(e.opts.timeOffset)
+	if err != nil {
+		return fmt.Errorf("-offset: %v", err)
+	}
+	actions, err := e.readShiftedFilterFile(e.opts.filterFile, scale, offset)
+	if err != nil {
+		return err
+	}
+	actions, err = e.selectActions(actions)
+	if err != nil {
+		return err
+	}
+	// the clips are only of what's changed, not removed
+	actions = withoutVerb(actions, CutVerb, NoteVerb, ExtractVerb, ChapterBreakVerb)
+	removeImport, err := e.importTempInput()
+	if err != nil {
+		return err
+	}
+	defer removeImport()
+	e.inputInfo, err = probe(e.opts.inputFile)
+	if err != nil {
+		return err
+	}
+
+	dir, err := makeTempDir(e.opts.tempRoot, "vidagent-compare-")
+	if err != nil {
+		return err
+	}
+	defer removeTempDir(dir)
+	err = os.MkdirAll(*outDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	var editArgs []string
+	fs.Visit(func(f *flag.Flag) {
+		if edits.Lookup(f.Name) != nil && !slices.Contains(sliceSkipFlags, f.Name) {
+			editArgs = append(editArgs, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(e.opts.filterFile), filepath.Ext(e.opts.filterFile))
+	var count int
+	for _, act := range actions {
+		line := act.line()
+		if act.verb != BlurVerb && act.verb != MuteVerb && act.verb != ScrambleVerb || (ranges != nil && !lineInRanges(line, ranges)) {
+			continue
+		}
+		start := math.Max(act.start.SecondNum()-around.Seconds(), 0)
+		end := act.end.SecondNum() + around.Seconds()
+		if dur := e.inputInfo.Format.duration(); dur > 0 {
+			end = math.Min(end, dur)
+		}
+		clip := filepath.Join(*outDir, fmt.Sprintf("%s-line%d.mp4", base, line))
+		err := e.renderComparison(exe, editArgs, dir, clip, cropActions(actions, start, end), start, end)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		log.Printf("line %d: wrote %s", line, clip)
+		count++
+	}
+	if count == 0 {
+		return errors.New("no blur, mute, or scramble actions to compare")
+	}
+	return nil
+}
+
+// renderComparison writes clip: the input from start to end seconds
+// next to the same part of it with the actions (whose times are from
+// start) performed by an edit run with editArgs. Files made along the
+// way go in dir.
+func (e *edit) renderComparison(exe string, editArgs []string, dir, clip string, actions []action, start, end float64) error {
+	// the slice is encoded, not copied, so that it starts
+	// exactly where the actions' times are from
+	slice := filepath.Join(dir, "slice.mkv")
+	_, err := ffmpegOutput("-ss", e.secondString(secondsTime(start)), "-i", fileArg(e.opts.inputFile),
+		"-t", e.secondString(secondsTime(end-start)), "-map", "0:v:0", "-map", "0:a?",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "16", "-c:a", "flac", "-y", fileArg(slice))
+	if err != nil {
+		return fmt.Errorf("cutting the slice out of the input: %v", err)
+	}
+	sliceFilter := filepath.Join(dir, "slice.filter")
+	err = writeActions(sliceFilter, actions)
+	if err != nil {
+		return err
+	}
+
+	edited := filepath.Join(dir, "edited.mkv")
+	args := append(slices.Clone(editArgs), "-in", slice, "-out", edited, "-filter", sliceFilter, "-f", "-no-history", "-allow-large-edits")
+	cmd := exec.Command(exe, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		return fmt.Errorf("editing the slice: %s", exitMessage(lines))
+	}
+
+	// the audio is the edited slice's, so mutes can be heard; the
+	// original is scaled to the edited video's size (and format),
+	// in case those were changed
+	_, err = ffmpegOutput("-i", fileArg(slice), "-i", fileArg(edited),
+		"-filter_complex", "[0:v:0][1:v:0]scale2ref[o][e];[o]format=yuv420p[original];[e]format=yuv420p[edited];[original][edited]hstack[v]",
+		"-map", "[v]", "-map", "1:a:0?", "-c:v", "libx264", "-crf", "20", "-c:a", "aac",
+		"-shortest", "-y", fileArg(clip))
+	return err
+}
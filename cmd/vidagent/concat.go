@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// span is a portion of the input between two times. If open
+// is true, the span extends to the end of the input.
+type span struct {
+	start, end Time
+	open       bool
+	mute       bool
+}
+
+// seekMargin is how far before a span, in seconds, the input is
+// seeked to when reading it. Seeking the input is fast, because it
+// jumps to a keyframe instead of decoding everything before the span,
+// which matters for spans late in a long video; but it can only land
+// on a keyframe. The rest of the way is decoded and trimmed, so the
+// span still starts exactly where it should.
+const seekMargin = 30
+
+// spanInputArgs returns the ffmpeg input options, and the output
+// options that trim them to the span.
+func (e *edit) spanInputArgs(s span) []string {
+	seek := math.Max(s.start.SecondNum()-seekMargin, 0)
+	if e.opts.burnSubs != "" || e.hasEffects() {
+		// subtitles are burned in, and effects are enabled, by the
+		// times of the frames, which seeking the input would make
+		// start at zero
+		seek = 0
+	}
+	var args []string
+	if seek > 0 {
+		args = append(args, "-ss", e.secondString(secondsTime(seek)))
+	}
+	args = append(args, e.inputArgs()...)
+	// (the times are from where the input was seeked to)
+	args = append(args, "-ss", e.secondString(secondsTime(s.start.SecondNum()-seek)))
+	if !s.open {
+		args = append(args, "-t", e.secondString(secondsTime(s.seconds(e.inputInfo.Format.duration()))))
+	}
+	return args
+}
+
+// outputSpans returns the spans of the input that make it into
+// the output, in order: everything but the cut segments, with
+// each muted segment as its own span.
+func (e *edit) outputSpans(actions []action) []span {
+	var spans []span
+	var pos Time
+	for _, act := range actions {
+		if act.start.SecondNum() > pos.SecondNum() {
+			spans = append(spans, span{start: pos, end: act.start})
+		}
+		if act.verb == MuteVerb {
+			spans = append(spans, span{start: act.start, end: act.end, mute: true})
+		}
+		pos = act.end
+	}
+	// (an action may last to the end of the input, leaving nothing)
+	if dur := e.inputInfo.Format.duration(); dur > 0 && dur-pos.SecondNum() < .001 {
+		return spans
+	}
+	return append(spans, span{start: pos, open: true})
+}
+
+// runConcat performs the actions by extracting each span of the
+// output into its own file, then joining them with the concat
+// demuxer. Unlike the filter graph engine, the number of edits
+// doesn't affect memory use, and with -copy, streams that
+// don't need to change are not re-encoded.
+func (e *edit) runConcat(actions []action) (err error) {
+	err = checkConcatVerbs(actions)
+	if err != nil {
+		return err
+	}
+
+	// don't do all the work only for the final step to fail
+	if _, err := os.Stat(e.opts.outputFile); err == nil && !e.opts.overwrite {
+		return fmt.Errorf("output file %s already exists (use -f to overwrite)", e.opts.outputFile)
+	}
+
+	spans := e.outputSpans(actions)
+	if len(spans) == 0 {
+		return fmt.Errorf("nothing is left of the video after the cuts")
+	}
+
+	tmpDir, cleanup, err := e.editTempDir()
+	if err != nil {
+		return err
+	}
+	defer func() { cleanup(err) }()
+	err = e.checkSegmentSpace(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	segments, err := e.extractSpans(spans, tmpDir)
+	if err != nil {
+		return err
+	}
+
+	e.setStage("joining", e.spansSeconds(spans))
+	return e.joinSegments(segments, e.outputPath(), actions)
+}
+
+// checkConcatVerbs returns an error if any of the actions
+// can't be performed by extracting and joining spans.
+func checkConcatVerbs(actions []action) error {
+	for i, act := range actions {
+		if act.verb != CutVerb && act.verb != MuteVerb {
+			return fmt.Errorf("action %d: verb '%s' is not supported by the concat engine", i, act.verb)
+		}
+	}
+	return nil
+}
+
+// extractSpans extracts each span of the input into its own
+// file in dir, and returns the names of the files in order.
+func (e *edit) extractSpans(spans []span, dir string) ([]string, error) {
+	// segments use the same container as the output so
+	// their codecs will be suitable for it
+	ext := filepath.Ext(e.outputPath())
+
+	var segments []string
+	for i, sp := range spans {
+		segment := filepath.Join(dir, fmt.Sprintf("segment%04d%s", i, ext))
+		if _, err := os.Stat(segment); err == nil && e.opts.resumeTemp {
+			log.Printf("reusing segment %d from before", i)
+			segments = append(segments, segment)
+			continue
+		}
+		// segments are only given their names once they're done,
+		// so that a segment that's there is a whole one
+		partial := filepath.Join(dir, fmt.Sprintf("segment%04d.partial%s", i, ext))
+		e.setStage(fmt.Sprintf("segment %d of %d", i+1, len(spans)), sp.seconds(e.inputInfo.Format.duration()))
+
+		err := e.runFFmpeg(e.spanArgs(sp, partial), partial)
+		if err != nil {
+			return nil, fmt.Errorf("extracting segment %d: %v", i, err)
+		}
+		err = os.Rename(partial, segment)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, segment)
+	}
+
+	return segments, nil
+}
+
+// spanArgs returns the ffmpeg arguments that extract the span of the
+// input into file, as a segment.
+func (e *edit) spanArgs(sp span, file string) []string {
+	args := []string{"-y"}
+	videoMap, audioMap := "0:v:0", e.audioSpec(0)
+	if sp.mute && e.opts.muteFill != "" {
+		// the music comes first, since the options
+		// after the input are for the output
+		args = append(args, e.muteSourceArgs()...)
+		// (the music's own first track, whichever of the
+		// input's tracks -audio-lang chose)
+		videoMap, audioMap = "1:v:0", "0:a:0"
+	}
+	args = append(args, e.spanInputArgs(sp)...)
+	args = append(args, "-map", videoMap, "-map", audioMap)
+	if !e.opts.streamCopy {
+		if filters := e.videoFilters(); len(filters) > 0 {
+			args = append(args, "-vf", strings.Join(filters, ","))
+		}
+		args = append(args, e.videoEncodeArgs()...)
+	}
+	if audioFilters := e.audioInputFilters(); len(audioFilters) > 0 && !sp.mute {
+		args = append(args, "-af", strings.Join(audioFilters, ","))
+	}
+	if sp.mute {
+		args = append(args, e.muteSegmentArgs()...)
+		if e.opts.streamCopy {
+			args = append(args, "-c:v", "copy")
+		}
+	} else if e.opts.streamCopy {
+		args = append(args, "-c", "copy")
+	}
+	if e.opts.streamCopy {
+		args = append(args, "-avoid_negative_ts", "make_zero")
+	}
+	return append(args, fileArg(file))
+}
+
+// joinSegments joins the segment files, in order, into the output
+// file using the concat demuxer. The list of segments is written
+// next to the first segment.
+func (e *edit) joinSegments(segments []string, output string, actions []action) error {
+	var list strings.Builder
+	list.WriteString("ffconcat version 1.0\n")
+	for _, segment := range segments {
+		fmt.Fprintf(&list, "file %s\n", concatQuote(filepath.Base(segment)))
+	}
+
+	listFile := strings.TrimSuffix(segments[0], filepath.Ext(segments[0])) + ".ffconcat"
+	err := os.WriteFile(listFile, []byte(list.String()), 0644)
+	if err != nil {
+		return err
+	}
+
+	// the input file is only used for its metadata
+	args := []string{
+		e.overwriteArg(),
+		"-f", "concat",
+		"-i", fileArg(listFile),
+		"-i", fileArg(e.opts.inputFile),
+		"-map", "0",
+	}
+	args = append(args, e.metadataArgs(actions, 1, 2)...)
+	if audioFilters := e.audioOutputFilters(); len(audioFilters) > 0 {
+		args = append(args, "-c:v", "copy", "-af", strings.Join(audioFilters, ","))
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	args = append(args, fileArg(output))
+
+	return e.runFFmpeg(args, output)
+}
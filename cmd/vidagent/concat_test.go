@@ -0,0 +1,54 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestConcatSeekWithEffects makes sure that the concat engine only
+// seeks the input to shortly before a span when there are no effects,
+// since effects are enabled by the times of the frames, which seeking
+// would make start at zero.
+func TestConcatSeekWithEffects(t *testing.T) {
+	for _, tt := range []struct {
+		filter string
+		seek   bool
+	}{
+		{filter: "cut 1:00-1:05\n", seek: true},
+		{filter: "fadeout 0:50-0:52 (violence)\ncut 1:00-1:05\n", seek: false},
+	} {
+		o := defaultOptions()
+		o.inputFile = "in.mp4"
+		e := newEdit(o)
+
+		actions, err := e.parseFilter(strings.NewReader(tt.filter), 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actions, err = e.applyEffects(actions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		spans := e.outputSpans(actions)
+		if len(spans) != 2 {
+			t.Fatalf("%q: got %d spans, not 2", tt.filter, len(spans))
+		}
+		args := e.spanArgs(spans[1], "segment.mp4")
+
+		i := slices.Index(args, "-i")
+		if i < 0 || i+3 >= len(args) {
+			t.Fatalf("%q: no input in %q", tt.filter, args)
+		}
+		if seeked := slices.Contains(args[:i], "-ss"); seeked != tt.seek {
+			t.Errorf("%q: seeking the input is %t, not %t: %q", tt.filter, seeked, tt.seek, args)
+		}
+		if !tt.seek && (args[i+2] != "-ss" || args[i+3] != e.secondString(spans[1].start)) {
+			t.Errorf("%q: the span isn't trimmed from %s of the input: %q", tt.filter, e.secondString(spans[1].start), args)
+		}
+		vf := slices.Index(args, "-vf")
+		if e.hasEffects() && (vf < 0 || !strings.Contains(args[vf+1], "fade=")) {
+			t.Errorf("%q: the fade isn't in the video filters: %q", tt.filter, args)
+		}
+	}
+}
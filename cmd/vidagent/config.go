@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// config is the optional configuration file, which customizes
+// the words that filter files may use. For example:
+//
+//	{
+//		"aliases": {"skip": "cut", "silence": "mute"},
+//		"defaults": {"language": "mute", "nudity": "cut"},
+//		"reasons": {"language:deity": {"name": "Taking God's name in vain"}}
+//	}
+//
+// Aliases are other names for verbs. Defaults are the verbs for
+// actions whose lines have no verb, by their reason category.
+// Reasons are names and descriptions of reason categories (and
+// categories with specifiers) to show people instead of the
+// reasons as they're written in filter files.
+type config struct {
+	Aliases  map[string]string     `json:"aliases"`
+	Defaults map[string]string     `json:"defaults"`
+	Reasons  map[string]reasonInfo `json:"reasons"`
+
+	// the above resolved to verbs, keyed in lower case
+	aliases, defaults map[string]Verb
+
+	// the reasons, keyed in lower case
+	reasons map[string]reasonInfo
+}
+
+// reasonInfo is how a reason is shown to people.
+type reasonInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// cfg is the loaded configuration.
+var cfg config
+
+// configFile returns the name of the configuration file: the
+// VIDAGENT_CONFIG environment variable if set, otherwise
+// config.json in the user config directory.
+func configFile() (string, error) {
+	if name := os.Getenv("VIDAGENT_CONFIG"); name != "" {
+		return name, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "vidagent", "config.json"), nil
+}
+
+// loadConfig loads the configuration file into cfg, if there is one.
+func loadConfig() error {
+	name, err := configFile()
+	if err != nil {
+		return nil
+	}
+	data, err := os.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) && os.Getenv("VIDAGENT_CONFIG") == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var c config
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+
+	c.aliases = make(map[string]Verb)
+	for alias, target := range c.Aliases {
+		verb, ok := verbs[strings.ToLower(target)]
+		if !ok {
+			return fmt.Errorf("%s: alias '%s' is for unrecognized verb '%s'", name, alias, target)
+		}
+		if _, ok := verbs[strings.ToLower(alias)]; ok {
+			return fmt.Errorf("%s: alias '%s' is already a verb", name, alias)
+		}
+		c.aliases[strings.ToLower(alias)] = verb
+	}
+	c.defaults = make(map[string]Verb)
+	for category, verbName := range c.Defaults {
+		verb, ok := c.verb(verbName)
+		if !ok {
+			return fmt.Errorf("%s: default for '%s' is unrecognized verb '%s'", name, category, verbName)
+		}
+		c.defaults[strings.ToLower(category)] = verb
+	}
+	c.reasons = make(map[string]reasonInfo)
+	for reason, info := range c.Reasons {
+		if strings.TrimSpace(reason) == "" || strings.HasPrefix(reason, ":") {
+			return fmt.Errorf("%s: reason '%s' has no category", name, reason)
+		}
+		c.reasons[strings.ToLower(reason)] = info
+	}
+
+	cfg = c
+	return nil
+}
+
+// verb returns the verb with the given name or alias.
+func (c config) verb(name string) (Verb, bool) {
+	name = strings.ToLower(name)
+	if verb, ok := verbs[name]; ok {
+		return verb, true
+	}
+	verb, ok := c.aliases[name]
+	return verb, ok
+}
+
+// defaultVerb returns the verb for an action
+// with the given reason that has no verb.
+func (c config) defaultVerb(r Reason) (Verb, bool) {
+	verb, ok := c.defaults[strings.ToLower(r.Category)]
+	return verb, ok
+}
+
+// reasonName returns the name of r to show people: its configured
+// name, or its category's with the specifier after it, or else
+// the reason as it's written in filter files.
+func (c config) reasonName(r Reason) string {
+	if info, ok := c.reasons[strings.ToLower(reasonString(r))]; ok && info.Name != "" {
+		return info.Name
+	}
+	info, ok := c.reasons[strings.ToLower(r.Category)]
+	if !ok || info.Name == "" {
+		return reasonString(r)
+	}
+	if r.Specifier != "" {
+		return info.Name + ": " + r.Specifier
+	}
+	return info.Name
+}
+
+// reasonDescription returns the configured description of r,
+// or of its category if it has none, or "".
+func (c config) reasonDescription(r Reason) string {
+	if info := c.reasons[strings.ToLower(reasonString(r))]; info.Description != "" {
+		return info.Description
+	}
+	return c.reasons[strings.ToLower(r.Category)].Description
+}
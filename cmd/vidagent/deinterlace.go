@@ -0,0 +1,15 @@
+package main
+
+// deinterlaceFilter returns the deinterlacing filter to use,
+// if any, according to the -deinterlace flag.
+func (e *edit) deinterlaceFilter() string {
+	switch e.opts.deinterlace {
+	case "yadif", "bwdif":
+		return e.opts.deinterlace
+	case "auto":
+		if video := e.inputInfo.stream("video"); video != nil && video.interlaced() {
+			return "bwdif"
+		}
+	}
+	return ""
+}
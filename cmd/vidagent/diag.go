@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// When a filter file can't be used, every error in it is shown with
+// the line it's about and a caret under where on the line, the way
+// compilers do, so that a file with several mistakes can be fixed in
+// one go. On a terminal they're in color, unless -no-color or NO_COLOR
+// (https://no-color.org) says otherwise.
+
+// linePattern matches the start of an error about a line of a filter
+// file, like "line 12:5: ", with the column being optional.
+var linePattern = regexp.MustCompile(`^line (\d+)(?::(\d+))?: `)
+
+// filterError is what's wrong with a filter file.
+type filterError struct {
+	file  string
+	lines []string // of the file
+	errs  []error  // in order of line
+	// noColor is whether it's never shown in color, with -no-color
+	noColor bool
+}
+
+// newFilterError returns the error of reading the filter file, which
+// has the source src, with all its errors: reading stops at the first
+// one, so the file is read again, skipping the lines that can't be
+// used like -lenient does, to find the rest.
+func (e *edit) newFilterError(file string, src []byte, err error, scale, offset float64) *filterError {
+	fe := &filterError{file: file, errs: []error{err}, noColor: e.opts.noColor}
+	for _, s := range splitLines(src) {
+		fe.lines = append(fe.lines, s.text)
+	}
+	l := &lenientParse{quiet: true}
+	_, last := e.parseLenient(bytes.NewReader(src), scale, offset, l)
+	if last != nil {
+		l.skipped = append(l.skipped, last)
+	}
+	for _, err := range l.skipped {
+		if !slices.ContainsFunc(fe.errs, func(have error) bool { return have.Error() == err.Error() }) {
+			fe.errs = append(fe.errs, err)
+		}
+	}
+	slices.SortStableFunc(fe.errs, func(a, b error) int {
+		lineA, _, _ := errorLine(a)
+		lineB, _, _ := errorLine(b)
+		return lineA - lineB
+	})
+	return fe
+}
+
+// Error returns the errors without color or excerpts, each on a line.
+func (e *filterError) Error() string {
+	var msgs []string
+	for _, err := range e.errs {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", e.file, err))
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// errorLine returns the line and column (0 if there's none) that the
+// error is about, and the rest of its message, or a line of 0 if it
+// isn't about a line.
+func errorLine(err error) (int, int, string) {
+	msg := err.Error()
+	m := linePattern.FindStringSubmatch(msg)
+	if m == nil {
+		return 0, 0, msg
+	}
+	line, _ := strconv.Atoi(m[1])
+	col, _ := strconv.Atoi(m[2])
+	return line, col, msg[len(m[0]):]
+}
+
+// colors are the terminal escape sequences of the colors of the parts
+// of a diagnostic.
+var colors = map[string]string{
+	"bold":   "\x1b[1m",
+	"error":  "\x1b[1;31m",
+	"gutter": "\x1b[34m",
+	"caret":  "\x1b[1;32m",
+	"reset":  "\x1b[0m",
+}
+
+// render returns the errors with excerpts of the lines they're about,
+// in color if color is true.
+func (e *filterError) render(color bool) string {
+	paint := func(part, s string) string {
+		if !color {
+			return s
+		}
+		return colors[part] + s + colors["reset"]
+	}
+	// the gutter is as wide as the widest line number
+	width := 1
+	for _, err := range e.errs {
+		line, _, _ := errorLine(err)
+		width = max(width, len(strconv.Itoa(line)))
+	}
+
+	var b strings.Builder
+	for _, err := range e.errs {
+		line, col, msg := errorLine(err)
+		where := e.file
+		if line > 0 {
+			where += ":" + strconv.Itoa(line)
+		}
+		if col > 0 {
+			where += ":" + strconv.Itoa(col)
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", paint("bold", where+":"), paint("error", tr("error:")), msg)
+		if line < 1 || line > len(e.lines) {
+			continue
+		}
+		text := e.lines[line-1]
+		fmt.Fprintf(&b, "%s %s\n", paint("gutter", fmt.Sprintf("%*d |", width, line)), text)
+		if col > 0 {
+			fmt.Fprintf(&b, "%s %s%s\n", paint("gutter", strings.Repeat(" ", width)+" |"), caretIndent(text, col), paint("caret", "^"))
+		}
+	}
+	if len(e.errs) > 1 {
+		b.WriteString(trf("%d errors in %s", len(e.errs), e.file) + "\n")
+	}
+	return b.String()
+}
+
+// caretIndent returns the space before column col of the line text
+// (counting characters, as pos does), keeping its tabs so that the
+// caret lines up under it however wide the terminal shows them.
+func caretIndent(text string, col int) string {
+	var indent []rune
+	for _, r := range text {
+		if len(indent) == col-1 {
+			break
+		}
+		if r == '\t' {
+			indent = append(indent, '\t')
+		} else {
+			indent = append(indent, ' ')
+		}
+	}
+	return string(indent)
+}
+
+// useColor returns true if diagnostics should be in color: when
+// standard error is a terminal, and color hasn't been turned off.
+func useColor() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(os.Stderr)
+}
+
+// isTerminal returns true if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// fatal reports err and exits, like log.Fatal, but shows what's wrong
+// with a filter file as diagnostics.
+func fatal(err error) {
+	var fe *filterError
+	if errors.As(err, &fe) {
+		fmt.Fprint(os.Stderr, fe.render(!fe.noColor && useColor()))
+		os.Exit(1)
+	}
+	log.Fatal(err)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// outputSizeFactor is how much larger than the input file the output
+// is assumed to be, at most, when checking for free disk space. Edits
+// usually shrink a video, but re-encoding with different settings than
+// the source can make it bigger.
+const outputSizeFactor = 1.2
+
+// errFreeSpaceUnsupported is returned by freeSpace on platforms
+// where free disk space can't be determined.
+var errFreeSpaceUnsupported = errors.New("checking free disk space is not supported on this platform")
+
+// checkFreeSpace returns an error if the volume
+// containing dir has less than need bytes available.
+func checkFreeSpace(dir string, need int64) error {
+	free, err := freeSpace(dir)
+	if err == errFreeSpaceUnsupported {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checking free space in %s: %v", dir, err)
+	}
+	if uint64(need) > free {
+		return fmt.Errorf("not enough disk space in %s: need about %s but only %s is available (use -no-space-check to try anyway)",
+			dir, byteSize(uint64(need)), byteSize(free))
+	}
+	return nil
+}
+
+// estimateOutputSize returns the approximate largest size in bytes
+// the output file may be.
+func (e *edit) estimateOutputSize() (int64, error) {
+	info, err := os.Stat(e.opts.inputFile)
+	if err != nil {
+		return 0, err
+	}
+	return int64(float64(info.Size()) * outputSizeFactor), nil
+}
+
+// byteSize formats n as a human-readable size.
+func byteSize(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Dolby Vision and HDR10+ video carry dynamic metadata, which tells
+// the display how to map each scene's brightness, in the video
+// stream itself. ffmpeg's filters and encoders don't carry it over,
+// so re-encoding the video leaves only its static HDR10 (or HLG)
+// metadata; copying the video keeps it. -dynamic-hdr says what to do
+// when an edit would re-encode it: warn (with what to do instead),
+// copy the video (as with -copy), or drop the metadata without a word.
+
+// dolbyVision returns true if the stream has Dolby Vision metadata.
+func (s probeStream) dolbyVision() bool {
+	for _, sd := range s.SideData {
+		if strings.Contains(sd.SideDataType, "DOVI") {
+			return true
+		}
+	}
+	switch s.CodecTag {
+	case "dvh1", "dvhe", "dav1", "dva1", "dvav":
+		return true
+	}
+	return false
+}
+
+// hdr10Plus returns true if the first frame of the file's video has
+// HDR10+ metadata, which is only on the frames, not the stream.
+func hdr10Plus(file string) (bool, error) {
+	out, err := cachedProbeOutput(file,
+		"-select_streams", "v:0",
+		"-read_intervals", "%+#1",
+		"-show_entries", "frame=side_data_list",
+		"-print_format", "json")
+	if err != nil {
+		return false, err
+	}
+	var result struct {
+		Frames []struct {
+			SideData []struct {
+				SideDataType string `json:"side_data_type"`
+			} `json:"side_data_list"`
+		} `json:"frames"`
+	}
+	err = json.Unmarshal(out, &result)
+	if err != nil {
+		return false, fmt.Errorf("decoding ffprobe output: %v", err)
+	}
+	for _, frame := range result.Frames {
+		for _, sd := range frame.SideData {
+			if strings.Contains(sd.SideDataType, "HDR10+") || strings.Contains(sd.SideDataType, "2094-40") {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// dynamicHDR returns the kinds of dynamic HDR metadata ("Dolby
+// Vision" and "HDR10+") of the input's video.
+func (e *edit) dynamicHDR() []string {
+	video := e.inputInfo.stream("video")
+	if video == nil || !video.hdr() && !video.dolbyVision() {
+		return nil
+	}
+	var kinds []string
+	if video.dolbyVision() {
+		kinds = append(kinds, "Dolby Vision")
+	}
+	if plus, err := hdr10Plus(e.opts.inputFile); err != nil {
+		log.Printf("could not check the input for HDR10+ metadata: %v", err)
+	} else if plus {
+		kinds = append(kinds, "HDR10+")
+	}
+	return kinds
+}
+
+// videoCopied returns true if the edits copy the input's video as
+// it is instead of re-encoding it.
+func (e *edit) videoCopied(edits []action) bool {
+	if e.opts.streamCopy {
+		return true
+	}
+	return !hasVerb(edits, CutVerb) && !e.videoNeedsFilters()
+}
+
+// checkDynamicHDR does what -dynamic-hdr says if the edits would lose
+// the input's dynamic HDR metadata by re-encoding its video.
+func (e *edit) checkDynamicHDR(edits []action) error {
+	if e.opts.dynamicHDR == "drop" || e.opts.toneMap || e.videoCopied(edits) {
+		return nil
+	}
+	kinds := e.dynamicHDR()
+	if len(kinds) == 0 {
+		return nil
+	}
+	what := strings.Join(kinds, " and ")
+
+	if e.opts.dynamicHDR == "copy" {
+		if e.videoNeedsFilters() {
+			return fmt.Errorf("-dynamic-hdr copy: the input's %s metadata can only be kept by copying the video, but the edits or options filter it (like blurs or -scale)", what)
+		}
+		if e.opts.engine != "auto" && e.opts.engine != "concat" {
+			return errors.New("-dynamic-hdr copy: copying the video needs the concat engine")
+		}
+		log.Printf("input has %s metadata; copying the video to keep it, so cuts snap to keyframes", what)
+		e.opts.streamCopy = true
+		return nil
+	}
+
+	log.Printf("WARNING: input has %s metadata, which re-encoding the video loses: the output will only have its static HDR metadata, "+
+		"and may look different on %s displays. To keep it, use -dynamic-hdr copy to copy the video instead (cuts then snap to keyframes), "+
+		"or edit only the audio (like with mutes), which copies the video; use -dynamic-hdr drop to be quiet about it.", what, what)
+	return nil
+}
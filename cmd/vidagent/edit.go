@@ -0,0 +1,83 @@
+package main
+
+import "time"
+
+// An edit is the state of one edit of an input: its options, and
+// what's learned about the input and the filter file as it's made.
+// Each edit has its own, so that edits can be made at the same time
+// in one process, like the outputs of an edit with -out-profile.
+type edit struct {
+	opts *options
+
+	// policy decides the verbs of actions by their reasons,
+	// if there's a -policy
+	policy map[string]Verb
+
+	// filterHash is the SHA-256 of the filter file, which is
+	// recorded in the output's metadata
+	filterHash string
+
+	// effects are the filters returned by plugins, and of the
+	// other effects, like blurs
+	effects effectFilters
+
+	// inputInfo is what ffprobe reported about the input file;
+	// it is empty if the input couldn't be probed
+	inputInfo probeResult
+
+	// importedInput is the -in the input was imported from, if it was
+	importedInput string
+
+	// chapterStarts are the start times, in seconds, of the chapters
+	// of the input; they're probed the first time they're needed
+	chapterStarts  []float64
+	chaptersProbed bool
+
+	// timecodeRate is the frame rate of the input's video, and
+	// startFrame its first timecode in frames; they're probed the
+	// first time they're needed
+	timecodeRate     float64
+	startFrame       int
+	timecodeRateRead bool
+
+	// markedEffects are the effect actions, like blurs, which are
+	// taken out of the actions to be filters, for the chapters of
+	// the marked copy
+	markedEffects []action
+
+	// markedChaptersFile is the chapters of the marked copy, in
+	// ffmpeg's metadata format
+	markedChaptersFile string
+
+	// markedCopyDone is whether the command of the edit also
+	// wrote the marked copy
+	markedCopyDone bool
+
+	// stage is what the edit is doing, and how long the output of
+	// its ffmpeg command will be, in seconds (0 if unknown)
+	stage struct {
+		name     string
+		duration float64
+	}
+
+	// deadline is when ffmpeg must be done by, according
+	// to the -timeout flag; it is zero if there is no limit
+	deadline time.Time
+
+	// writingTo is the temporary file that the output is being
+	// written to, or "" if it's written in place
+	writingTo string
+
+	// graphDumped is whether the filter graph of the edit was
+	// written to the -dump-graph file
+	graphDumped bool
+
+	// editSummaries are the summaries of the edits of the outputs,
+	// by output file
+	editSummaries map[string]string
+}
+
+// newEdit returns a new edit with the options o.
+func newEdit(o *options) *edit {
+	return &edit{opts: o, editSummaries: make(map[string]string)}
+}
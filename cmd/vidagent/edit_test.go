@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestEditsAtOnce makes sure that edits made at the same time, with
+// options of their own, don't share any of their state: the graph of
+// each one's filter is the same as when it's built by itself.
+func TestEditsAtOnce(t *testing.T) {
+	dir := t.TempDir()
+	graph := func(i int) (string, error) {
+		filter := filepath.Join(dir, fmt.Sprintf("in%d.filter", i))
+		src := fmt.Sprintf("blur %d:00-%d:05 (nudity)\ncut 0:10-0:%d (violence)\n", i, i, 20+i)
+		err := os.WriteFile(filter, []byte(src), 0644)
+		if err != nil {
+			return "", err
+		}
+		o, err := newOptions(
+			withInput("in.mp4"),
+			withOutput(fmt.Sprintf("out%d.mp4", i)),
+			withFilter(filter),
+			withEngine("filtergraph"),
+			withVideoCodec("libx264", "18", "slow"),
+		)
+		if err != nil {
+			return "", err
+		}
+		o.timePrecision = fmt.Sprint(i % 4)
+		e := newEdit(o)
+		actions, err := e.readShiftedFilterFile(filter, 1, 0)
+		if err != nil {
+			return "", err
+		}
+		actions, err = e.applyEffects(actions)
+		if err != nil {
+			return "", err
+		}
+		return e.buildComplexFilter(actions)
+	}
+
+	const n = 8
+	want := make([]string, n)
+	for i := range want {
+		var err error
+		want[i], err = graph(i + 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range got {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got[i], errs[i] = graph(i + 1)
+		}()
+	}
+	wg.Wait()
+	for i := range got {
+		if errs[i] != nil {
+			t.Errorf("edit %d: %v", i+1, errs[i])
+		} else if got[i] != want[i] {
+			t.Errorf("edit %d, made with the others, has the graph:\n%s\nnot:\n%s", i+1, got[i], want[i])
+		}
+	}
+}
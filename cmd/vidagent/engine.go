@@ -0,0 +1,37 @@
+package main
+
+import "fmt"
+
+// manyEdits is how many edits make the filter graph of the
+// filtergraph engine big enough that another engine is better.
+const manyEdits = 50
+
+// chooseEngine returns the engine that -engine auto uses to perform
+// the edits, and why, based on what they are and on the input.
+func (e *edit) chooseEngine(edits []action) (string, string) {
+	if _, ok := e.trimmedSpan(edits); ok && !e.opts.splitOutput {
+		return "filtergraph", "only the head or tail is cut, so the input is seeked instead, and the engine isn't used"
+	}
+	if e.opts.streamCopy {
+		return "concat", "-copy needs it"
+	}
+	if !hasVerb(edits, CutVerb) {
+		return "filtergraph", "nothing is cut, so the edits are made in one pass, and the engine isn't used"
+	}
+	for _, act := range edits {
+		if act.verb != CutVerb && act.verb != MuteVerb {
+			return "filtergraph", fmt.Sprintf("it's the only one that can %s", act.verb)
+		}
+	}
+	if len(edits) < manyEdits {
+		return "filtergraph", fmt.Sprintf("with %d edits, its filter graph is small", len(edits))
+	}
+	if isHLS(e.opts.outputFile) {
+		// it can't be made of segments joined together
+		if video := e.inputInfo.stream("video"); video != nil && video.variableFrameRate() && e.opts.frameRate == "" {
+			return "filtergraph", fmt.Sprintf("with %d edits, its filter graph is big, but HLS output can't be made by concat, and select needs a constant frame rate", len(edits))
+		}
+		return "select", fmt.Sprintf("with %d edits, the filtergraph engine's filter graph would be big, and HLS output can't be made by concat", len(edits))
+	}
+	return "concat", fmt.Sprintf("with %d edits, the filtergraph engine's filter graph would use a lot of memory", len(edits))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+// -from and -to make the output only an excerpt of the edited input,
+// like for a short clip of what an edit looks like: the actions
+// outside of it are left out, those across its ends are cropped to
+// it, and everything before and after it is cut.
+
+// excerpt returns the times of -from and -to in seconds, with an end
+// of +Inf if there's no -to, or false if there's no excerpt.
+func (e *edit) excerpt() (float64, float64, bool, error) {
+	if e.opts.excerptFrom == "" && e.opts.excerptTo == "" {
+		return 0, 0, false, nil
+	}
+	from, to := 0.0, math.Inf(1)
+	if e.opts.excerptFrom != "" {
+		t, _, err := e.parseChapterTime(e.opts.excerptFrom)
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("-from: %v", err)
+		}
+		from = t.SecondNum()
+	}
+	if e.opts.excerptTo != "" {
+		t, _, err := e.parseChapterTime(e.opts.excerptTo)
+		if err != nil {
+			return 0, 0, false, fmt.Errorf("-to: %v", err)
+		}
+		to = t.SecondNum()
+	}
+	if to <= from {
+		return 0, 0, false, fmt.Errorf("-to (%s) must be after -from (%s)", clockString(to), clockString(from))
+	}
+	return from, to, true, nil
+}
+
+// excerptActions returns the actions for the excerpt of an input of
+// the given duration: the actions in it, cropped to it, with cuts of
+// what's before and after it.
+func (e *edit) excerptActions(actions []action, duration float64) ([]action, error) {
+	from, to, ok, err := e.excerpt()
+	if err != nil || !ok {
+		return actions, err
+	}
+	if duration <= 0 {
+		return nil, errors.New("-from and -to need the duration of the input, which couldn't be found")
+	}
+	if from >= duration {
+		return nil, fmt.Errorf("-from (%s) is after the end of the input (%s)", clockString(from), clockString(duration))
+	}
+	// (the cuts aren't in the filter file, so they have no line)
+	cut := func(start, end float64) action {
+		return action{syntax: &actionNode{}, verb: CutVerb, start: secondsTime(start), end: secondsTime(end)}
+	}
+
+	var excerpted []action
+	if from > 0 {
+		excerpted = append(excerpted, cut(0, from))
+	}
+	for _, act := range actions {
+		start, end := act.start.SecondNum(), act.end.SecondNum()
+		isMarker := act.start == act.end
+		if start >= to || end <= from && !isMarker || start < from && isMarker {
+			continue
+		}
+		act.start, act.end = secondsTime(max(start, from)), secondsTime(min(end, to))
+		excerpted = append(excerpted, act)
+	}
+	if to < duration {
+		excerpted = append(excerpted, cut(to, duration))
+	}
+	return excerpted, nil
+}
+
+// wavDuration returns the duration of the WAV file in seconds.
+func wavDuration(file string) (float64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	hdr, err := readWAVHeader(bufio.NewReader(f))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v", file, err)
+	}
+	return float64(hdr.frames()) / float64(hdr.sampleRate), nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// exportTag is one tagged segment in the exported JSON, in the shape
+// that commercial filtering services' tag lists generally have: a
+// span, what kind of content it is, and what to do about it.
+type exportTag struct {
+	Start       float64 `json:"start"` // seconds
+	End         float64 `json:"end"`   // seconds
+	Category    string  `json:"category"`
+	Subcategory string  `json:"subcategory,omitempty"`
+	Action      string  `json:"action"`
+	Line        int     `json:"line"`
+
+	// the action's parameters, from each key to its values
+	Params map[string][]string `json:"params,omitempty"`
+}
+
+// exportCmd writes the actions of filter files as tag JSON.
+// Markers and extracts, which aren't edits, are left out.
+func exportCmd(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	e := newEdit(defaultOptions())
+	out := fs.String("out", "", "write to this file instead of standard output")
+	policyFile := fs.String("policy", "", "decide the verbs of actions by their reasons according to this policy file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent export [options] <filter file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("one filter file required")
+	}
+	if *policyFile != "" {
+		err := e.loadPolicy(*policyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	actions, err := e.readFilterFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	tags := []exportTag{}
+	for _, act := range withoutVerb(actions, ChapterBreakVerb, ExtractVerb) {
+		tags = append(tags, exportTag{
+			Start:       roundMillis(act.start.SecondNum()),
+			End:         roundMillis(act.end.SecondNum()),
+			Category:    act.reason.Category,
+			Subcategory: act.reason.Specifier,
+			Action:      string(act.verb),
+			Line:        act.line(),
+			Params:      act.paramMap(),
+		})
+	}
+
+	if *out == "" {
+		return writeTags(os.Stdout, tags)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = writeTags(f, tags)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeTags writes the tags to w as indented JSON.
+func writeTags(w io.Writer, tags []exportTag) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(map[string]any{"tags": tags})
+}
+
+// roundMillis rounds seconds to the nearest millisecond,
+// dropping floating-point noise from parsing the times.
+func roundMillis(sec float64) float64 {
+	return math.Round(sec*1000) / 1000
+}
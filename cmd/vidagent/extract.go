@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+// gifFilters are the filters that turn video into a reasonably
+// small animated GIF; the palette is generated from the clip
+// itself so that its colors look right.
+const gifFilters = "fps=10,scale=480:-2:flags=lanczos,split[a][b];[a]palettegen[p];[b][p]paletteuse"
+
+// runExtracts saves the segment of each extract action to its own
+// side file, in the format given by -extract-format. Clips are taken
+// from the input as it is, so they include whatever the other actions
+// removed; the main output isn't affected.
+func (e *edit) runExtracts(actions []action) error {
+	var n int
+	for _, act := range actions {
+		if act.verb != ExtractVerb {
+			continue
+		}
+		n++
+		name := e.extractName(n)
+		e.setStage(fmt.Sprintf("extracting clip %d", n), act.end.SecondNum()-act.start.SecondNum())
+
+		args := []string{e.overwriteArg()}
+		args = append(args, e.spanInputArgs(span{start: act.start, end: act.end})...)
+		if e.opts.extractFormat == "gif" {
+			videoChain := append(e.videoInputFilters(), gifFilters)
+			args = append(args, "-filter_complex", "[0:v:0]"+strings.Join(videoChain, ","), "-an")
+		} else {
+			args = append(args, "-map", "0:v:0", "-map", e.audioSpec(0))
+			if filters := e.videoFilters(); len(filters) > 0 {
+				args = append(args, "-vf", strings.Join(filters, ","))
+			}
+			args = append(args, e.videoEncodeArgs()...)
+		}
+		args = append(args, fileArg(name))
+
+		err := e.runFFmpeg(args, name)
+		if err != nil {
+			return fmt.Errorf("line %d: extracting %s-%s: %v", act.line(), act.start, act.end, err)
+		}
+		log.Printf("extracted %s-%s to %s", act.start, act.end, name)
+	}
+
+	return nil
+}
+
+// extractName returns the file name of the nth extracted clip.
+func (e *edit) extractName(n int) string {
+	return fmt.Sprintf("%s-extract-%03d.%s", strings.TrimSuffix(e.opts.outputFile, filepath.Ext(e.opts.outputFile)), n, e.opts.extractFormat)
+}
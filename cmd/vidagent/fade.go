@@ -0,0 +1,56 @@
+package main
+
+import "fmt"
+
+// A fadeout action fades the picture to black and the sound to
+// silence over its segment, and they stay that way until the next
+// fadein action, which fades them back over its own segment. This
+// way a fade can go around a cut without being part of it:
+//
+//	fadeout 41:05-41:07 (violence)
+//	cut 41:07-41:30 (violence)
+//	fadein 41:30-41:32 (violence)
+
+// applyFades adds the filters for the fadeout and fadein actions to
+// effects, the same way as for blurs, and returns the other actions.
+func (e *edit) applyFades(actions []action) []action {
+	var others []action
+	for i, act := range actions {
+		start, end := e.secondString(act.start), e.secondString(act.end)
+		length := fmt.Sprintf("%.3f", act.end.SecondNum()-act.start.SecondNum())
+		switch act.verb {
+		case FadeOutVerb:
+			// black and silent until the next fadein, if any
+			until := ""
+			for _, next := range actions[i+1:] {
+				if next.verb == FadeInVerb {
+					until = e.secondString(next.start)
+					break
+				}
+			}
+			enable := fmt.Sprintf("enable='gte(t,%s)'", start)
+			if until != "" {
+				enable = fmt.Sprintf("enable='between(t,%s,%s)'", start, until)
+			}
+			if !streamOff(act, "video") {
+				e.effects.video = append(e.effects.video, fmt.Sprintf("fade=t=out:st=%s:d=%s:%s", start, length, enable))
+			}
+			if !streamOff(act, "audio") {
+				e.effects.audio = append(e.effects.audio, fmt.Sprintf("volume='clip((%s-t)/%s,0,1)':eval=frame:%s", end, length, enable))
+			}
+		case FadeInVerb:
+			// (before it starts, the fadeout before it, if any,
+			// decides what it's like)
+			enable := fmt.Sprintf("enable='gte(t,%s)'", start)
+			if !streamOff(act, "video") {
+				e.effects.video = append(e.effects.video, fmt.Sprintf("fade=t=in:st=%s:d=%s:%s", start, length, enable))
+			}
+			if !streamOff(act, "audio") {
+				e.effects.audio = append(e.effects.audio, fmt.Sprintf("volume='clip((t-%s)/%s,0,1)':eval=frame:%s", start, length, enable))
+			}
+		default:
+			others = append(others, act)
+		}
+	}
+	return others
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errTimeout = errors.New("ffmpeg exceeded the time limit")
+	errStalled = errors.New("ffmpeg stopped making progress")
+)
+
+// ffmpegProcs are the ffmpeg processes that are running,
+// to kill if vidagent is interrupted.
+var ffmpegProcs = struct {
+	sync.Mutex
+	m map[*os.Process]bool
+}{m: make(map[*os.Process]bool)}
+
+// runFFmpeg runs ffmpeg with args, killing it if the deadline
+// passes or it stops making progress for -stall-timeout. If
+// ffmpeg is killed, the partial output file is removed.
+func (e *edit) runFFmpeg(args []string, partial string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if e.opts.threads > 0 {
+		args = e.threadArgs(args)
+	}
+	// progress reports are how we know ffmpeg isn't stuck
+	watchProgress := e.opts.stallTimeout > 0 || e.opts.progressJSON
+	if watchProgress {
+		args = append([]string{"-progress", "pipe:1"}, args...)
+	}
+
+	ffmpeg, err := findTool("ffmpeg")
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, ffmpeg, args...)
+	cmd.Stdout = os.Stdout
+	// it's shown as it goes, and kept to explain failures
+	stderr := &tailWriter{max: 200}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+
+	var mu sync.Mutex
+	var killErr error
+	kill := func(err error) {
+		mu.Lock()
+		if killErr == nil {
+			killErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	var progressDone chan struct{}
+	activity := make(chan struct{}, 1)
+	if watchProgress {
+		cmd.Stdout = nil
+		progress, err := cmd.StdoutPipe()
+		if err != nil {
+			return err
+		}
+		progressDone = make(chan struct{})
+		go func() {
+			defer close(progressDone)
+			var lastTime string
+			fields := make(map[string]string)
+			scanner := bufio.NewScanner(progress)
+			for scanner.Scan() {
+				key, val, ok := strings.Cut(scanner.Text(), "=")
+				if !ok {
+					continue
+				}
+				fields[key] = val
+				// each block of reports ends with progress
+				if key == "progress" {
+					e.reportProgress(e.ffmpegProgress(fields))
+				}
+				if key != "out_time_us" || val == lastTime {
+					continue
+				}
+				lastTime = val
+				select {
+				case activity <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+	if e.opts.stallTimeout > 0 {
+		go func() {
+			timer := time.NewTimer(e.opts.stallTimeout)
+			defer timer.Stop()
+			for {
+				select {
+				case <-activity:
+					timer.Reset(e.opts.stallTimeout)
+				case <-timer.C:
+					kill(fmt.Errorf("%w for %s", errStalled, e.opts.stallTimeout))
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	if e.opts.lowPriority {
+		err = startLowPriority(cmd)
+	} else {
+		err = cmd.Start()
+	}
+	if err != nil {
+		return err
+	}
+	ffmpegProcs.Lock()
+	ffmpegProcs.m[cmd.Process] = true
+	ffmpegProcs.Unlock()
+	defer func() {
+		ffmpegProcs.Lock()
+		delete(ffmpegProcs.m, cmd.Process)
+		ffmpegProcs.Unlock()
+	}()
+
+	if !e.deadline.IsZero() {
+		timer := time.AfterFunc(time.Until(e.deadline), func() {
+			kill(fmt.Errorf("%w of %s", errTimeout, e.opts.timeout))
+		})
+		defer timer.Stop()
+	}
+
+	if progressDone != nil {
+		<-progressDone
+	}
+	err = cmd.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if killErr != nil {
+		os.Remove(partial)
+		return killErr
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return ffmpegFailure(err, stderr.lines())
+	}
+	return err
+}
+
+// threadArgs returns the ffmpeg args with options added that limit
+// it to -threads threads: before each input for its decoder, before
+// the output file (which is last, unless it's followed by the marked
+// copy) for the encoders, and for the filters.
+func (e *edit) threadArgs(args []string) []string {
+	n := strconv.Itoa(e.opts.threads)
+	output := fileArg(e.outputPath())
+	limited := []string{"-filter_threads", n, "-filter_complex_threads", n}
+	for i, arg := range args {
+		if arg == "-i" || i == len(args)-1 || (arg == output && i > 0 && args[i-1] != "-i") {
+			limited = append(limited, "-threads", n)
+		}
+		limited = append(limited, arg)
+	}
+	return limited
+}
+
+// ffmpegOutput runs ffmpeg with args and returns what it writes
+// to standard output, for commands that output raw data to "-".
+func ffmpegOutput(args ...string) ([]byte, error) {
+	ffmpeg, err := findTool("ffmpeg")
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(ffmpeg, append([]string{"-v", "error"}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, ffmpegFailure(err, strings.Split(strings.TrimSpace(stderr.String()), "\n"))
+	}
+	return stdout.Bytes(), nil
+}
+
+// tailWriter keeps the last lines written to it. Lines end with
+// a newline or a carriage return, which ffmpeg ends its progress
+// lines with.
+type tailWriter struct {
+	mu      sync.Mutex
+	max     int
+	buf     []string
+	total   int // lines ever written
+	partial []byte
+	notify  func() // called after lines are written
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	total := t.total
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexAny(t.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		if line := string(bytes.TrimSpace(t.partial[:i])); line != "" {
+			t.buf = append(t.buf, line)
+			t.total++
+			if len(t.buf) > t.max {
+				t.buf = t.buf[1:]
+			}
+		}
+		t.partial = t.partial[i+1:]
+	}
+	written := t.total > total
+	t.mu.Unlock()
+	if written && t.notify != nil {
+		t.notify()
+	}
+	return len(p), nil
+}
+
+// lines returns the last lines written.
+func (t *tailWriter) lines() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.buf)
+}
+
+// since returns the lines written after the first n that still
+// are kept, and how many lines have been written in all.
+func (t *tailWriter) since(n int) ([]string, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	missed := min(t.total-n, len(t.buf))
+	return slices.Clone(t.buf[len(t.buf)-missed:]), t.total
+}
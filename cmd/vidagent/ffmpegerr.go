@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Failures of ffmpeg that are recognized by what it writes to stderr.
+var (
+	errMissingEncoder = errors.New("ffmpeg doesn't have a needed encoder")
+	errMissingFilter  = errors.New("ffmpeg doesn't have a needed filter")
+	errFilterGraph    = errors.New("ffmpeg rejected the filters")
+	errNoSuchFile     = errors.New("a file ffmpeg needs doesn't exist")
+	errNoSpace        = errors.New("out of disk space")
+	errPermission     = errors.New("ffmpeg isn't allowed to open a file")
+	errBadInput       = errors.New("ffmpeg can't read the input")
+)
+
+// ffmpegFailures recognize failures in the lines ffmpeg writes to
+// stderr, in order of precedence: a missing filter also makes the
+// filter graph fail, for example. Advice is what to tell the user,
+// with %s for the text the pattern captures, if any.
+var ffmpegFailures = []struct {
+	pattern *regexp.Regexp
+	err     error
+	advice  string
+}{
+	{regexp.MustCompile(`Unknown encoder '([^']+)'`), errMissingEncoder,
+		"ffmpeg doesn't have the %s encoder; install a build of ffmpeg that includes it"},
+	{regexp.MustCompile(`Encoder \(codec (\S+)\) not found`), errMissingEncoder,
+		"ffmpeg doesn't have an encoder for %s; install a build of ffmpeg that includes one"},
+	{regexp.MustCompile(`No such filter: '([^']+)'`), errMissingFilter,
+		"ffmpeg doesn't have the %s filter; install a build of ffmpeg that includes it"},
+	{regexp.MustCompile(`No space left on device`), errNoSpace,
+		"the disk is full; free up some space, or use -tmpdir to put temporary files on another disk"},
+	{regexp.MustCompile(`Error (?:parsing|initializing|reinitializing) (?:the )?(?:complex )?filter|Failed to configure|Error applying option`), errFilterGraph,
+		"ffmpeg rejected the filters, which include those from plugins and options like -scale and -burn-subs"},
+	{regexp.MustCompile(`No such file or directory`), errNoSuchFile,
+		"a file ffmpeg needs doesn't exist"},
+	{regexp.MustCompile(`Permission denied`), errPermission,
+		"ffmpeg isn't allowed to open a file it needs"},
+	{regexp.MustCompile(`Invalid data found when processing input|moov atom not found`), errBadInput,
+		"the input is damaged, incomplete, or in a format ffmpeg can't read"},
+}
+
+// maxExcerptLines is how many lines of ffmpeg's stderr
+// are included in the errors it fails with.
+const maxExcerptLines = 5
+
+// ffmpegError is ffmpeg failing. It wraps the failure it's recognized
+// as, if any, and the error from running it.
+type ffmpegError struct {
+	kind    error
+	advice  string
+	excerpt []string // the relevant lines of stderr
+	err     error
+}
+
+func (e *ffmpegError) Error() string {
+	msg := e.advice
+	if msg == "" {
+		msg = "running ffmpeg: " + e.err.Error()
+	}
+	if len(e.excerpt) > 0 {
+		msg += "; ffmpeg said:\n\t" + strings.Join(e.excerpt, "\n\t")
+	}
+	return msg
+}
+
+func (e *ffmpegError) Unwrap() []error {
+	if e.kind == nil {
+		return []error{e.err}
+	}
+	return []error{e.kind, e.err}
+}
+
+// ffmpegFailure explains err from running ffmpeg, which wrote the
+// lines to stderr: what went wrong, if it's recognized, and an
+// excerpt of the lines that matter, which are the line that shows
+// what went wrong and the last few.
+func ffmpegFailure(err error, stderr []string) error {
+	var lines []string
+	for _, line := range stderr {
+		// progress isn't relevant
+		if !strings.HasPrefix(line, "frame=") && !strings.HasPrefix(line, "size=") {
+			lines = append(lines, line)
+		}
+	}
+
+	e := &ffmpegError{err: err}
+	match := -1
+recognize:
+	for _, f := range ffmpegFailures {
+		for i, line := range lines {
+			m := f.pattern.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			e.kind, match = f.err, i
+			e.advice = f.advice
+			if len(m) > 1 {
+				e.advice = fmt.Sprintf(f.advice, m[1])
+			}
+			break recognize
+		}
+	}
+
+	last := max(len(lines)-maxExcerptLines, 0)
+	if match >= 0 && match < last {
+		e.excerpt = append(e.excerpt, lines[match])
+		last++
+	}
+	e.excerpt = append(e.excerpt, lines[last:]...)
+	return e
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// graphCmd prints the filter graph that the filtergraph engine would
+// give ffmpeg for the filter file, one filter chain per line. It only
+// depends on the filter file and the options (and, with -in, what
+// ffprobe says about the input), so keeping its output for a filter
+// file and comparing it after a change shows what the change does to
+// edits without running ffmpeg. With -o, it writes the graph to a
+// file instead, which can also be drawn, as Graphviz, Mermaid, or an
+// image, to see how the segments and effects flow into the output.
+func graphCmd(args []string) error {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	o := defaultOptions()
+	editFlags(o).VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	out := fs.String("o", "", "write the graph to this file instead: .dot for Graphviz, .mmd for Mermaid, .svg, .png, or .pdf to render it with Graphviz, or otherwise as text")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent graph -filter <file> [-in <file>] [-o <file>] [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	e := newEdit(o)
+	if err := e.inlineFilter(); err != nil {
+		return err
+	}
+
+	if e.opts.filterFile == "" {
+		fs.Usage()
+		return errors.New("filter file required (use -filter)")
+	}
+	scale, err := parseScale(e.opts.timeScale)
+	if err != nil {
+		return fmt.Errorf("-time-scale: %v", err)
+	}
+	offset, err := parseOffset(e.opts.timeOffset)
+	if err != nil {
+		return fmt.Errorf("-offset: %v", err)
+	}
+	actions, err := e.readShiftedFilterFile(e.opts.filterFile, scale, offset)
+	if err != nil {
+		return err
+	}
+	actions, err = e.selectActions(actions)
+	if err != nil {
+		return err
+	}
+	actions, err = e.applyEffects(actions)
+	if err != nil {
+		return err
+	}
+	if e.opts.inputFile != "" {
+		e.inputInfo, err = probe(e.opts.inputFile)
+		if err != nil {
+			log.Printf("could not probe input; continuing without it: %v", err)
+		}
+	}
+
+	edits := withoutVerb(actions, ChapterBreakVerb, ExtractVerb, NoteVerb)
+	graph, err := e.buildComplexFilter(edits)
+	if err != nil {
+		return err
+	}
+	if *out != "" {
+		return writeGraphFile(*out, graph, edits)
+	}
+	fmt.Println(strings.ReplaceAll(graph, ";", ";\n"))
+	return nil
+}
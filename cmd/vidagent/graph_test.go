@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata with what the tests get")
+
+// TestFilterGraphGolden builds the filter graph of each filter file in
+// testdata/graph, the way vidagent graph does without -in, and compares
+// it with the .golden file next to it. After a change to the graph
+// that's intended, run the tests with -update and review the diff.
+func TestFilterGraphGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "graph", "*.filter"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no filter files in testdata/graph")
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".filter")
+		t.Run(name, func(t *testing.T) {
+			e := newEdit(defaultOptions())
+
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actions, err := e.parseFilter(bytes.NewReader(src), 1, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actions, err = e.selectActions(actions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			actions, err = e.applyEffects(actions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			graph, err := e.buildComplexFilter(withoutVerb(actions, ChapterBreakVerb, ExtractVerb, NoteVerb))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := strings.ReplaceAll(graph, ";", ";\n") + "\n"
+
+			golden := strings.TrimSuffix(file, ".filter") + ".golden"
+			if *update {
+				err := os.WriteFile(golden, []byte(got), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run with -update to make it)", err)
+			}
+			if got != string(want) {
+				t.Errorf("the graph of %s isn't %s:\ngot:\n%s\nwant:\n%s", file, golden, got, want)
+			}
+		})
+	}
+}
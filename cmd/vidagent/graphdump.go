@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// spanOrigin returns where the span of the output of the actions
+// comes from, for the labels of its segments in the filter graph:
+// mute_l5 for the span of the mute on line 5, after_cut_l3 for the
+// span after the cut on line 3, or start for the one before any
+// action.
+func spanOrigin(actions []action, sp span) string {
+	for i := len(actions) - 1; i >= 0; i-- {
+		act := actions[i]
+		if sp.mute && act.verb == MuteVerb && act.start.SecondNum() == sp.start.SecondNum() {
+			return fmt.Sprintf("mute_l%d", act.line())
+		}
+		if !sp.mute && act.end.SecondNum() <= sp.start.SecondNum() {
+			if act.line() == 0 {
+				return "from" // (after the cut of what's before -from)
+			}
+			return fmt.Sprintf("after_%s_l%d", act.verb, act.line())
+		}
+	}
+	return "start"
+}
+
+// dumpGraph writes the filter graph of the actions to the -dump-graph
+// file, if there is one.
+func (e *edit) dumpGraph(graph string, actions []action) error {
+	if e.opts.dumpGraph == "" {
+		return nil
+	}
+	err := writeGraphFile(e.opts.dumpGraph, graph, actions)
+	if err != nil {
+		return fmt.Errorf("-dump-graph: %v", err)
+	}
+	e.graphDumped = true
+	if e.opts.verbose {
+		log.Printf("wrote the filter graph to %s", e.opts.dumpGraph)
+	}
+	return nil
+}
+
+// writeGraphFile writes the filter graph of the actions to the file,
+// in the format its extension says: Graphviz for .dot, Mermaid for
+// .mmd, an image rendered by Graphviz's dot command for .svg, .png,
+// or .pdf, and otherwise one filter chain per line, after a comment
+// for each action.
+func writeGraphFile(filename, graph string, actions []action) error {
+	ext := strings.ToLower(filepath.Ext(filename))
+	switch ext {
+	case ".svg", ".png", ".pdf":
+		return renderGraph(filename, ext[1:], graph, actions)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	switch ext {
+	case ".dot", ".gv":
+		writeGraphDot(w, graph, actions)
+	case ".mmd", ".mermaid":
+		writeGraphMermaid(w, graph, actions)
+	default:
+		writeGraphText(w, graph, actions)
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// renderGraph renders the filter graph into the file in the format
+// (svg, png, or pdf) with Graphviz's dot command.
+func renderGraph(filename, format, graph string, actions []action) error {
+	dot, err := findTool("dot")
+	if err != nil {
+		return fmt.Errorf("rendering %s requires Graphviz (or use .dot or .mmd): %v", filename, err)
+	}
+	var buf bytes.Buffer
+	writeGraphDot(&buf, graph, actions)
+	cmd := exec.Command(dot, "-T"+format, "-o", filename)
+	cmd.Stdin = &buf
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("dot: %v", err)
+	}
+	return nil
+}
+
+// actionSummary describes the action, for annotating filter graphs.
+func actionSummary(act action) string {
+	s := fmt.Sprintf("line %d: %s %s-%s", act.line(), act.verb,
+		clockString(act.start.SecondNum()), clockString(act.end.SecondNum()))
+	if act.line() == 0 {
+		s = fmt.Sprintf("-from and -to: cut %s-%s", clockString(act.start.SecondNum()), clockString(act.end.SecondNum()))
+	}
+	if act.reason.Category != "" {
+		s += " (" + reasonString(act.reason) + ")"
+	}
+	return s
+}
+
+// writeGraphText writes the filter graph one chain per line, after
+// a comment for each action, so the labels can be matched up with
+// the lines they name.
+func writeGraphText(w io.Writer, graph string, actions []action) {
+	for _, act := range actions {
+		fmt.Fprintf(w, "# %s\n", actionSummary(act))
+	}
+	for _, chain := range splitGraph(graph, ';') {
+		fmt.Fprintln(w, chain+";")
+	}
+}
+
+// graphChain is a chain of filters in a filter graph, with the
+// labels of the links into and out of it.
+type graphChain struct {
+	inputs, filters, outputs []string
+}
+
+// parseGraph returns the chains of the filter graph.
+func parseGraph(graph string) []graphChain {
+	var chains []graphChain
+	for _, s := range splitGraph(graph, ';') {
+		var c graphChain
+		s = strings.TrimSpace(s)
+		for strings.HasPrefix(s, "[") {
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				break
+			}
+			c.inputs = append(c.inputs, s[1:end])
+			s = s[end+1:]
+		}
+		for strings.HasSuffix(s, "]") {
+			start := strings.LastIndexByte(s, '[')
+			if start < 0 {
+				break
+			}
+			c.outputs = append([]string{s[start+1 : len(s)-1]}, c.outputs...)
+			s = s[:start]
+		}
+		c.filters = splitGraph(s, ',')
+		chains = append(chains, c)
+	}
+	return chains
+}
+
+// splitGraph splits the filter graph (or chain) at each sep that
+// isn't quoted or escaped.
+func splitGraph(s string, sep byte) []string {
+	var parts []string
+	var quoted bool
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '\'':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	if start < len(s) {
+		parts = append(parts, s[start:])
+	}
+	return parts
+}
+
+// graphNode is a filter of a filter graph, or a link into or out of
+// the graph (a pad), when it's drawn.
+type graphNode struct {
+	id, label string
+	pad       bool
+}
+
+// graphEdge is a link between the nodes of a filter graph, labeled
+// if it's labeled in the graph.
+type graphEdge struct {
+	from, to, label string
+}
+
+// graphNodes returns the nodes of the filter graph, with a node for
+// each filter and each input and output of the graph, and the links
+// between them.
+func graphNodes(graph string) ([]graphNode, []graphEdge) {
+	var nodes []graphNode
+	var edges []graphEdge
+
+	// each link goes from the filter that makes it to the one that
+	// reads it; links that no filter makes are inputs of the graph
+	chains := parseGraph(graph)
+	makers := make(map[string]string)
+	for i, c := range chains {
+		for _, out := range c.outputs {
+			makers[out] = fmt.Sprintf("f%d_%d", i, len(c.filters)-1)
+		}
+	}
+	read := make(map[string]bool)
+	for i, c := range chains {
+		for j, filter := range c.filters {
+			id := fmt.Sprintf("f%d_%d", i, j)
+			nodes = append(nodes, graphNode{id: id, label: filter})
+			if j > 0 {
+				edges = append(edges, graphEdge{from: fmt.Sprintf("f%d_%d", i, j-1), to: id})
+			}
+		}
+		for _, in := range c.inputs {
+			maker, ok := makers[in]
+			if !ok {
+				maker = "in_" + graphID(in)
+			}
+			if !ok && !read[in] {
+				nodes = append(nodes, graphNode{id: maker, label: in, pad: true})
+			}
+			read[in] = true
+			edges = append(edges, graphEdge{from: maker, to: fmt.Sprintf("f%d_0", i), label: in})
+		}
+	}
+	for i, c := range chains {
+		for _, out := range c.outputs {
+			if !read[out] {
+				id := "out_" + graphID(out)
+				nodes = append(nodes, graphNode{id: id, label: out, pad: true})
+				edges = append(edges, graphEdge{from: fmt.Sprintf("f%d_%d", i, len(c.filters)-1), to: id})
+			}
+		}
+	}
+	return nodes, edges
+}
+
+// writeGraphDot writes the filter graph in Graphviz's format, with
+// the actions in its label.
+func writeGraphDot(w io.Writer, graph string, actions []action) {
+	fmt.Fprintln(w, "digraph filtergraph {")
+	fmt.Fprintln(w, "\trankdir=LR;")
+	fmt.Fprintln(w, "\tnode [shape=box, fontname=monospace, fontsize=10];")
+	if len(actions) > 0 {
+		var legend string
+		for _, act := range actions {
+			legend += actionSummary(act) + `\l`
+		}
+		fmt.Fprintf(w, "\tlabelloc=t;\n\tlabeljust=l;\n\tlabel=%s;\n", dotQuote(legend))
+	}
+	nodes, edges := graphNodes(graph)
+	for _, n := range nodes {
+		if n.pad {
+			fmt.Fprintf(w, "\t%s [label=%s, shape=ellipse];\n", n.id, dotQuote(n.label))
+		} else {
+			fmt.Fprintf(w, "\t%s [label=%s];\n", n.id, dotQuote(n.label))
+		}
+	}
+	for _, e := range edges {
+		if e.label != "" {
+			fmt.Fprintf(w, "\t%s -> %s [label=%s];\n", e.from, e.to, dotQuote(e.label))
+		} else {
+			fmt.Fprintf(w, "\t%s -> %s;\n", e.from, e.to)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// writeGraphMermaid writes the filter graph as a Mermaid flowchart,
+// after a comment for each action.
+func writeGraphMermaid(w io.Writer, graph string, actions []action) {
+	fmt.Fprintln(w, "flowchart LR")
+	for _, act := range actions {
+		fmt.Fprintf(w, "\t%%%% %s\n", actionSummary(act))
+	}
+	nodes, edges := graphNodes(graph)
+	for _, n := range nodes {
+		if n.pad {
+			fmt.Fprintf(w, "\t%s([%s])\n", n.id, mermaidQuote(n.label))
+		} else {
+			fmt.Fprintf(w, "\t%s[%s]\n", n.id, mermaidQuote(n.label))
+		}
+	}
+	for _, e := range edges {
+		if e.label != "" {
+			fmt.Fprintf(w, "\t%s -->|%s| %s\n", e.from, mermaidQuote(e.label), e.to)
+		} else {
+			fmt.Fprintf(w, "\t%s --> %s\n", e.from, e.to)
+		}
+	}
+}
+
+// dotQuote returns s as a Graphviz string, keeping the escapes for
+// line breaks (\n and \l) as they are.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// mermaidQuote returns s as a Mermaid string, in which quotes are
+// written as entities.
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
+
+// graphID returns the label as part of the ID of a node.
+func graphID(label string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, label)
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// historyEntry is the record of one run in the history file.
+type historyEntry struct {
+	Time             time.Time `json:"time"`
+	Input            string    `json:"input"`
+	InputFingerprint string    `json:"input_fingerprint,omitempty"`
+	Filter           string    `json:"filter"`
+	FilterHash       string    `json:"filter_hash,omitempty"`
+	Output           string    `json:"output"`
+	Args             []string  `json:"args"`
+	Result           string    `json:"result"` // "ok" or the error
+	Seconds          float64   `json:"seconds"`
+}
+
+// historyFile returns the name of the history file, which
+// has one JSON-encoded historyEntry per line.
+func historyFile() (string, error) {
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config, "vidagent", "history.jsonl"), nil
+}
+
+// recordHistory appends an entry for the current run's output,
+// which started at the given time and ended with the given error.
+func (e *edit) recordHistory(started time.Time, output string, runErr error) error {
+	entry := historyEntry{
+		Time:    started.UTC(),
+		Input:   absPath(e.inputName()),
+		Filter:  absPath(e.opts.filterFile),
+		Output:  absPath(output),
+		Args:    os.Args[1:],
+		Result:  "ok",
+		Seconds: time.Since(started).Seconds(),
+	}
+	if runErr != nil {
+		entry.Result = runErr.Error()
+	}
+	// hashes are nice to have, but not worth failing over
+	entry.InputFingerprint, _ = fileFingerprint(e.opts.inputFile)
+	entry.FilterHash, _ = fileHash(e.opts.filterFile)
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	name, err := historyFile()
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(name), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readHistory returns all the entries in the history file.
+func readHistory() ([]historyEntry, error) {
+	name, err := historyFile()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry historyEntry
+		err := json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, lineNum, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// historyCmd lists past runs, optionally only those
+// whose input or output was one of the given files.
+func historyCmd(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	limit := fs.Int("n", 20, "list at most this many of the most recent runs (0 for all)")
+	asJSON := fs.Bool("json", false, "print the entries as JSON lines")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent history [options] [video files...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	entries, err := readHistory()
+	if err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		files := make(map[string]bool)
+		for _, name := range fs.Args() {
+			files[absPath(name)] = true
+		}
+		var matching []historyEntry
+		for _, e := range entries {
+			if files[e.Input] || files[e.Output] {
+				matching = append(matching, e)
+			}
+		}
+		entries = matching
+	}
+	if *limit > 0 && len(entries) > *limit {
+		entries = entries[len(entries)-*limit:]
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "time\tinput\tfilter\toutput\ttook\tresult")
+	for _, e := range entries {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04"),
+			e.Input, e.Filter, e.Output, seconds(e.Seconds), e.Result)
+	}
+	return tw.Flush()
+}
+
+// absPath returns the absolute form of name,
+// or name itself if that's not possible.
+func absPath(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return name
+}
+
+// fileHash returns the hex-encoded SHA-256 of the file.
+func fileHash(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fingerprintChunk is how much of each end of a file goes into its fingerprint.
+const fingerprintChunk = 1 << 20
+
+// fileFingerprint returns a hex-encoded SHA-256 of the file's size
+// and its first and last megabyte. Videos are too big to hash fully
+// every time, but this is enough to tell them apart.
+func fileFingerprint(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, info.Size())
+	if _, err := io.CopyN(h, f, fingerprintChunk); err != nil && err != io.EOF {
+		return "", err
+	}
+	if info.Size() > 2*fingerprintChunk {
+		if _, err := f.Seek(-fingerprintChunk, io.SeekEnd); err != nil {
+			return "", err
+		}
+	}
+	// the last megabyte, or the rest of a small file
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// hlsSegmentSeconds is about how long each segment
+// of an HLS output is.
+const hlsSegmentSeconds = 4
+
+// isHLS returns true if the file is an HLS playlist.
+func isHLS(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), ".m3u8")
+}
+
+// hlsArgs returns the output options for an HLS output: an event
+// playlist, which players can start playing from while it's still
+// being written, with its segments next to it. Segments are only
+// given their names once they're whole.
+func (e *edit) hlsArgs() []string {
+	if !isHLS(e.opts.outputFile) {
+		return nil
+	}
+	secs := strconv.Itoa(hlsSegmentSeconds)
+	return []string{
+		"-force_key_frames", "expr:gte(t,n_forced*" + secs + ")",
+		"-f", "hls",
+		"-hls_time", secs,
+		"-hls_playlist_type", "event",
+		"-hls_flags", "independent_segments+temp_file",
+		"-hls_segment_filename", strings.TrimSuffix(e.opts.outputFile, filepath.Ext(e.opts.outputFile)) + "%05d.ts",
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hooks are commands, given with -on-success and -on-failure, that
+// are run when an edit is done, like to send a notification, move
+// the output, or have a media server scan its library for it. They
+// get the details of the edit in environment variables:
+//
+//	VIDAGENT_RESULT   ok, or the error the edit failed with
+//	VIDAGENT_INPUT    the input file
+//	VIDAGENT_FILTER   the filter file
+//	VIDAGENT_OUTPUT   the output file
+//	VIDAGENT_PROFILE  the profile of the output, with -out-profile
+//	VIDAGENT_SUMMARY  the edits, like "2 cuts, 1 mute"
+//	VIDAGENT_SECONDS  how long the edit took
+//
+// With -out-profile, the hook is run for each output.
+
+// summarizeActions returns how many actions there are with each
+// verb, in the order their verbs first appear.
+func summarizeActions(actions []action) string {
+	var verbs []Verb
+	counts := make(map[Verb]int)
+	for _, act := range actions {
+		if counts[act.verb] == 0 {
+			verbs = append(verbs, act.verb)
+		}
+		counts[act.verb]++
+	}
+	if len(verbs) == 0 {
+		return "no edits"
+	}
+	var parts []string
+	for _, verb := range verbs {
+		part := fmt.Sprintf("%d %s", counts[verb], verb)
+		if counts[verb] > 1 {
+			part += "s"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runHook runs the -on-success or -on-failure command, if there is
+// one, for the output (of the profile, if any) of the edit that
+// started at the given time and ended with the given error. A hook
+// that fails is reported, but the edit is still done.
+func (e *edit) runHook(started time.Time, out outProfile, runErr error) {
+	command, flagName := e.opts.onSuccess, "on-success"
+	result := "ok"
+	if runErr != nil {
+		command, flagName = e.opts.onFailure, "on-failure"
+		result = runErr.Error()
+	}
+	if command == "" {
+		return
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"VIDAGENT_RESULT="+result,
+		"VIDAGENT_INPUT="+absPath(e.inputName()),
+		"VIDAGENT_FILTER="+absPath(e.opts.filterFile),
+		"VIDAGENT_OUTPUT="+absPath(out.file),
+		"VIDAGENT_PROFILE="+out.name,
+		"VIDAGENT_SUMMARY="+e.editSummaries[out.file],
+		"VIDAGENT_SECONDS="+strconv.FormatFloat(time.Since(started).Seconds(), 'f', 1, 64),
+	)
+	// (standard output may be for -progress-json)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		log.Printf("-%s command failed: %v", flagName, err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+)
+
+// Messages are written in English, which is also how a message's
+// translation is found in the catalog of a language: the file in
+// locales named by its code (like es.json), an object from each
+// English message to its translation, with the same format verbs
+// (like %s) in the same order. Messages without a translation are
+// shown in English. The CLI's language is the environment's (LC_ALL,
+// LC_MESSAGES, or LANG, like es_MX.UTF-8), and the web UI's is the
+// browser's.
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// localizer translates messages into a language.
+type localizer struct {
+	lang     string
+	messages map[string]string
+}
+
+// ui is the localizer of the CLI's messages.
+var ui = newLocalizer(envLanguage())
+
+// newLocalizer returns the localizer for the language, which
+// translates nothing if there's no catalog for it.
+func newLocalizer(lang string) localizer {
+	data, err := localeFiles.ReadFile(path.Join("locales", lang+".json"))
+	if err != nil {
+		return localizer{lang: "en"}
+	}
+	l := localizer{lang: lang}
+	err = json.Unmarshal(data, &l.messages)
+	if err != nil {
+		log.Printf("could not read the %s messages: %v", lang, err)
+		return localizer{lang: "en"}
+	}
+	return l
+}
+
+// tr returns the translation of the message, or the message if it
+// has none.
+func (l localizer) tr(msg string) string {
+	if t, ok := l.messages[msg]; ok && t != "" {
+		return t
+	}
+	return msg
+}
+
+// tr translates the message into the CLI's language.
+func tr(msg string) string {
+	return ui.tr(msg)
+}
+
+// trf formats the arguments with the format translated into the
+// CLI's language, like fmt.Sprintf. In English, the format goes to
+// fmt.Sprintf as it is, which is also what lets vet check the formats
+// of trf and logf against their arguments, as it does fmt.Sprintf's.
+func trf(format string, args ...any) string {
+	if ui.messages == nil {
+		return fmt.Sprintf(format, args...)
+	}
+	return fmt.Sprintf(ui.tr(format), args...)
+}
+
+// logf is log.Printf with the format translated into the CLI's
+// language.
+func logf(format string, args ...any) {
+	log.Print(trf(format, args...))
+}
+
+// envLanguage returns the language of the environment's locale, like
+// es for es_MX.UTF-8.
+func envLanguage() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if locale := os.Getenv(name); locale != "" {
+			return languageCode(locale)
+		}
+	}
+	return "en"
+}
+
+// languageCode returns the language of a locale (like pt_BR.UTF-8)
+// or language tag (like pt-BR), in lowercase.
+func languageCode(locale string) string {
+	code, _, _ := strings.Cut(locale, ".")
+	code, _, _ = strings.Cut(code, "@")
+	code, _, _ = strings.Cut(strings.ReplaceAll(code, "-", "_"), "_")
+	code = strings.ToLower(code)
+	if code == "c" || code == "posix" {
+		return "en"
+	}
+	return code
+}
+
+// acceptLanguage returns the localizer for the first language of an
+// Accept-Language header that there's a catalog for (or English).
+func acceptLanguage(header string) localizer {
+	for _, tag := range strings.Split(header, ",") {
+		tag, _, _ = strings.Cut(tag, ";")
+		code := languageCode(strings.TrimSpace(tag))
+		if code == "en" {
+			break
+		}
+		if l := newLocalizer(code); l.messages != nil {
+			return l
+		}
+	}
+	return localizer{lang: "en"}
+}
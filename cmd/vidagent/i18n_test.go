@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+// formatVerb matches a format verb, like %s, %5.2f, or %%.
+var formatVerb = regexp.MustCompile(`%[-+# 0]*(\[\d+\])?(\d+|\*)?(\.(\d+|\*)?)?[a-zA-Z%]`)
+
+// TestCatalogFormats makes sure that each translation in the catalogs
+// in locales has the format verbs of its English message, in the same
+// order, since it's formatted with the same arguments.
+func TestCatalogFormats(t *testing.T) {
+	files, err := fs.Glob(localeFiles, "locales/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no catalogs in locales")
+	}
+	for _, file := range files {
+		t.Run(path.Base(file), func(t *testing.T) {
+			data, err := localeFiles.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var messages map[string]string
+			err = json.Unmarshal(data, &messages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for msg, translation := range messages {
+				want := formatVerb.FindAllString(msg, -1)
+				got := formatVerb.FindAllString(translation, -1)
+				if !slices.Equal(got, want) {
+					t.Errorf("the translation of %q has the format verbs %q, not %q", msg, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// For quick edits, and scripts, the filter doesn't need to be a file:
+// "-filter -" reads it from standard input, and each -action adds a
+// line to it (or to the -filter file), like -action "mute 1:00-1:05
+// (language)". The filter
+// is saved in the cache by its checksum, like one downloaded with
+// -filter-repo, so everything that reads the filter file (and the
+// history, which records it) works the same.
+
+// actionFlags are the -action flags, which can be given more than once.
+type actionFlags []string
+
+func (a *actionFlags) String() string { return strings.Join(*a, "; ") }
+
+func (a *actionFlags) Set(s string) error {
+	*a = append(*a, s)
+	return nil
+}
+
+// inlineFilter saves the filter from standard input (with -filter -),
+// or from the -filter file, and then the -action flags, in that order,
+// as a filter file, which becomes the -filter file. It does nothing if
+// the filter is a file and there are no -action flags.
+func (e *edit) inlineFilter() error {
+	if e.opts.filterFile != "-" && len(e.opts.inlineActions) == 0 {
+		return nil
+	}
+	var filter []byte
+	var err error
+	switch e.opts.filterFile {
+	case "-":
+		filter, err = io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("reading the filter from standard input: %v", err)
+		}
+	case "":
+	default:
+		filter, err = os.ReadFile(e.opts.filterFile)
+		if err != nil {
+			return err
+		}
+	}
+	if len(filter) > 0 && filter[len(filter)-1] != '\n' {
+		filter = append(filter, '\n')
+	}
+	for _, act := range e.opts.inlineActions {
+		filter = append(filter, act+"\n"...)
+	}
+
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(cache, "vidagent", "filters")
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(filter)
+	filename := filepath.Join(dir, hex.EncodeToString(sum[:])+".filter")
+	err = os.WriteFile(filename, filter, 0644)
+	if err != nil {
+		return err
+	}
+	e.opts.filterFile = filename
+	return nil
+}
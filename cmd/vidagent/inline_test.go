@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestInlineFilterFile makes sure that -action adds its actions to the
+// -filter file's instead of replacing them.
+func TestInlineFilterFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Setenv("LocalAppData", filepath.Join(dir, "cache"))
+
+	file := filepath.Join(dir, "movie.filter")
+	err := os.WriteFile(file, []byte("cut 1:00-2:00 (violence)"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := defaultOptions()
+	o.filterFile = file
+	o.inlineActions = actionFlags{"mute 3:00-3:05 (language)"}
+	e := newEdit(o)
+
+	err = e.inlineFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.filterFile == file {
+		t.Fatal("the -filter file is still the filter, without the -action flags")
+	}
+	f, err := os.Open(o.filterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	actions, err := e.parseFilter(f, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, act := range actions {
+		got = append(got, string(act.verb)+" "+e.secondString(act.start))
+	}
+	want := []string{"cut 60.000", "mute 180.000"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got the actions %q, not %q", got, want)
+	}
+}
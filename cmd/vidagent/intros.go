@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// The episodes of a series mostly share their intro and credits, so
+// detect-intros finds them as the longest audio that an episode has in
+// common with another, near its start and near its end. Audio is
+// compared by fingerprints, which are the same for the same audio even
+// if it's encoded differently or is louder or quieter.
+
+const (
+	// fingerprintFrame is how many samples each fingerprint is of,
+	// and fingerprintHop how far apart they are; the frames overlap
+	// a lot so that audio that isn't at the same place in the
+	// frames in both episodes still matches.
+	fingerprintFrame = 2048
+	fingerprintHop   = 128
+
+	// fingerprintRate is how many fingerprints there are per second.
+	fingerprintRate = float64(alignSampleRate) / fingerprintHop
+
+	// maxBitErrors is how many of the 32 bits of two fingerprints
+	// can differ for them to be of the same audio.
+	maxBitErrors = 8
+)
+
+// detectIntrosCmd finds the intro and credits of each episode and
+// writes cut actions for them to a filter file for the episode.
+func detectIntrosCmd(args []string) error {
+	fs := flag.NewFlagSet("detect-intros", flag.ExitOnError)
+	window := fs.Duration("window", 10*time.Minute, "how far from the start and end of each episode to look for the intro and credits")
+	minLength := fs.Duration("min", 20*time.Second, "the shortest audio in common with another episode that counts as the intro or credits")
+	outDir := fs.String("out-dir", "", "the directory to write the episodes' filter files to (default is next to each episode)")
+	overwrite := fs.Bool("f", false, "overwrite existing filter files")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent detect-intros [options] <episodes or directories...>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	episodes, err := listEpisodes(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(episodes) < 2 {
+		fs.Usage()
+		return errors.New("at least two episodes required, to find what they have in common")
+	}
+	if *window <= 0 || *minLength <= 0 {
+		return errors.New("-window and -min must be positive")
+	}
+
+	if !*overwrite {
+		for _, episode := range episodes {
+			filterFile := introsFilterFile(episode, *outDir)
+			if _, err := os.Stat(filterFile); err == nil {
+				return fmt.Errorf("%s already exists (use -f to overwrite it)", filterFile)
+			}
+		}
+	}
+
+	heads := make([]episodePart, len(episodes))
+	tails := make([]episodePart, len(episodes))
+	for i, episode := range episodes {
+		log.Printf("fingerprinting %s", episode)
+		heads[i], tails[i], err = fingerprintEpisode(episode, window.Seconds())
+		if err != nil {
+			return fmt.Errorf("%s: %v", episode, err)
+		}
+	}
+
+	minFrames := int(minLength.Seconds() * fingerprintRate)
+	var found int
+	for i, episode := range episodes {
+		intro, introWith := commonAudio(heads, i, minFrames)
+		credits, creditsWith := commonAudio(tails, i, minFrames)
+		if intro != nil && credits != nil && credits[0] < intro[1] {
+			// (a short episode's head and tail can be the same part)
+			credits = nil
+		}
+		if intro == nil && credits == nil {
+			log.Printf("%s: no intro or credits in common with the other episodes", episode)
+			continue
+		}
+
+		var b strings.Builder
+		b.WriteString("# found by vidagent detect-intros; review before using\n")
+		if intro != nil {
+			fmt.Fprintf(&b, "cut %s-%s (intro) # in common with %s\n",
+				clockString(intro[0]), clockString(intro[1]), filepath.Base(episodes[introWith]))
+		}
+		if credits != nil {
+			fmt.Fprintf(&b, "cut %s-%s (credits) # in common with %s\n",
+				clockString(credits[0]), clockString(credits[1]), filepath.Base(episodes[creditsWith]))
+		}
+
+		filterFile := introsFilterFile(episode, *outDir)
+		err = os.MkdirAll(filepath.Dir(filterFile), 0755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filterFile, []byte(b.String()), 0644)
+		if err != nil {
+			return err
+		}
+		log.Printf("%s: wrote %s", episode, filterFile)
+		found++
+	}
+	log.Printf("found the intro or credits of %d of %d episodes", found, len(episodes))
+	return nil
+}
+
+// introsFilterFile returns the name of the filter file to write
+// for the episode, in outDir or, if it's empty, next to it.
+func introsFilterFile(episode, outDir string) string {
+	if outDir == "" {
+		outDir = filepath.Dir(episode)
+	}
+	base := filepath.Base(episode)
+	return filepath.Join(outDir, strings.TrimSuffix(base, filepath.Ext(base))+".filter")
+}
+
+// episodePart is the fingerprints of part of an episode.
+type episodePart struct {
+	start        float64 // seconds
+	fingerprints []uint32
+}
+
+// fingerprintEpisode returns the fingerprints of the first and last
+// window seconds of the episode, which don't overlap.
+func fingerprintEpisode(episode string, window float64) (episodePart, episodePart, error) {
+	info, err := probe(episode)
+	if err != nil {
+		return episodePart{}, episodePart{}, err
+	}
+	dur := info.Format.duration()
+	if dur <= 0 {
+		return episodePart{}, episodePart{}, errors.New("unknown duration")
+	}
+
+	headEnd := math.Min(window, dur)
+	head := episodePart{start: 0}
+	head.fingerprints, err = audioFingerprints(episode, 0, headEnd)
+	if err != nil {
+		return head, episodePart{}, err
+	}
+	tail := episodePart{start: math.Max(dur-window, headEnd)}
+	if tail.start < dur {
+		tail.fingerprints, err = audioFingerprints(episode, tail.start, dur-tail.start)
+	}
+	return head, tail, err
+}
+
+// commonAudio returns the start and end (in seconds) of the longest
+// audio that part i has in common with any of the other parts, and
+// which part that is, or nil if none is at least minFrames long.
+func commonAudio(parts []episodePart, i, minFrames int) ([]float64, int) {
+	var best []int
+	var with int
+	for j := range parts {
+		if j == i {
+			continue
+		}
+		if run := longestCommonRun(parts[i].fingerprints, parts[j].fingerprints); run != nil && (best == nil || run[1]-run[0] > best[1]-best[0]) {
+			best, with = run, j
+		}
+	}
+	if best == nil || best[1]-best[0] < minFrames {
+		return nil, 0
+	}
+	start := parts[i].start
+	return []float64{start + float64(best[0])/fingerprintRate, start + float64(best[1])/fingerprintRate}, with
+}
+
+// longestCommonRun returns the longest run of fingerprints in a (as
+// the index of its first and just after its last) that is also in b,
+// or nil if there isn't one. Fingerprints of the same audio often
+// match exactly, which tells where in b to look for each run.
+func longestCommonRun(a, b []uint32) []int {
+	index := make(map[uint32][]int)
+	for i, fp := range a {
+		if fp != 0 {
+			index[fp] = append(index[fp], i)
+		}
+	}
+	votes := make(map[int]int) // by where b is in a
+	for j, fp := range b {
+		for _, i := range index[fp] {
+			votes[i-j]++
+		}
+	}
+	var offsets []int
+	for offset, n := range votes {
+		if n >= 3 {
+			offsets = append(offsets, offset)
+		}
+	}
+	slices.SortFunc(offsets, func(x, y int) int {
+		if votes[x] != votes[y] {
+			return votes[y] - votes[x]
+		}
+		return x - y
+	})
+	offsets = offsets[:min(len(offsets), 5)]
+
+	// runs may have a few fingerprints that don't match, as
+	// long as there's no more than a second without any
+	gap := alignSampleRate / fingerprintHop
+	var best []int
+	for _, offset := range offsets {
+		runStart, last := -1, -1
+		for i := max(offset, 0); i < len(a) && i-offset < len(b); i++ {
+			same := a[i] != 0 && bits.OnesCount32(a[i]^b[i-offset]) <= maxBitErrors
+			if !same {
+				if runStart >= 0 && i-last > gap {
+					runStart = -1
+				}
+				continue
+			}
+			if runStart < 0 {
+				runStart = i
+			}
+			last = i
+			if best == nil || last+1-runStart > best[1]-best[0] {
+				best = []int{runStart, last + 1}
+			}
+		}
+	}
+	return best
+}
+
+// audioFingerprints returns the fingerprints of dur seconds of the
+// file's audio starting at start, fingerprintRate per second. Each is
+// 32 bits of whether the difference in loudness between neighboring
+// frequency bands grew or shrank since the previous frame; frames that
+// are silent are 0, since they'd match any other silence.
+func audioFingerprints(file string, start, dur float64) ([]uint32, error) {
+	samples, err := decodeAudio(file, start, dur)
+	if err != nil {
+		return nil, err
+	}
+
+	// 33 bands from 300 to 2000 Hz, where most of what's
+	// distinctive about music and speech is, spaced so they
+	// sound evenly spaced
+	const bands = 33
+	var edges [bands + 1]int
+	for i := range edges {
+		freq := 300 * math.Pow(2000.0/300, float64(i)/bands)
+		edges[i] = int(freq * fingerprintFrame / alignSampleRate)
+	}
+
+	window := make([]float64, fingerprintFrame)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/fingerprintFrame)
+	}
+
+	var fingerprints []uint32
+	var prev [bands]float64
+	frame := make([]complex128, fingerprintFrame)
+	for n := 0; n+fingerprintFrame <= len(samples); n += fingerprintHop {
+		for i := range frame {
+			frame[i] = complex(samples[n+i]*window[i], 0)
+		}
+		fft(frame)
+
+		var energy [bands]float64
+		var total float64
+		for b := range energy {
+			for k := edges[b]; k < max(edges[b+1], edges[b]+1); k++ {
+				energy[b] += cmplx.Abs(frame[k]) * cmplx.Abs(frame[k])
+			}
+			total += energy[b]
+		}
+
+		var fp uint32 // (the first frame has nothing to compare to)
+		if n > 0 && total > 1 {
+			for b := range bands - 1 {
+				if energy[b]-energy[b+1]-(prev[b]-prev[b+1]) > 0 {
+					fp |= 1 << b
+				}
+			}
+		}
+		fingerprints = append(fingerprints, fp)
+		prev = energy
+	}
+	return fingerprints, nil
+}
+
+// fft replaces x, whose length is a power of two, with its discrete
+// Fourier transform.
+func fft(x []complex128) {
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := range size / 2 {
+				even, odd := x[start+k], x[start+k+size/2]*w
+				x[start+k], x[start+k+size/2] = even+odd, even-odd
+				w *= step
+			}
+		}
+	}
+}
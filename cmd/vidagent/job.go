@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A job document describes an edit all in one place, for keeping with
+// a video or giving to someone else to run, like:
+//
+//	input: movie.mkv
+//	output: movie-filtered.mkv
+//	filter: movie.filter
+//	engine: concat
+//	codec:
+//	  video: libx265
+//	  crf: 22
+//	options:
+//	  only-category: language
+//
+// `vidagent run job.yaml` makes it, and the server's API takes the same
+// document (in JSON, or in YAML), so a job moves between the two as
+// it is. In a job file, paths are relative to the file's directory;
+// on the server, to its root.
+
+// jobRequest is a job document: what a client sends to the server to
+// start a job, or what a job file has. The filter file can be given by
+// its path or its contents. The output can be left out if there's a
+// profile. Options are other flags of the edit, without the -.
+type jobRequest struct {
+	Input      string            `json:"input"`
+	Filter     string            `json:"filter,omitempty"`
+	FilterText string            `json:"filter_text,omitempty"`
+	Output     string            `json:"output"`
+	Profile    string            `json:"profile,omitempty"`
+	Engine     string            `json:"engine,omitempty"`
+	Codec      jobCodec          `json:"codec,omitzero"`
+	Options    map[string]string `json:"options,omitempty"`
+}
+
+// jobCodec is how a job encodes the video, when it's re-encoded.
+type jobCodec struct {
+	Video  string `json:"video,omitempty"`
+	CRF    string `json:"crf,omitempty"`
+	Preset string `json:"preset,omitempty"`
+}
+
+// settingArgs returns the flags for the job's engine and codec.
+func (r jobRequest) settingArgs() []string {
+	var args []string
+	for _, setting := range []struct{ name, value string }{
+		{"engine", r.Engine},
+		{"video-codec", r.Codec.Video},
+		{"crf", r.Codec.CRF},
+		{"preset", r.Codec.Preset},
+	} {
+		if setting.value != "" {
+			args = append(args, "-"+setting.name+"="+setting.value)
+		}
+	}
+	return args
+}
+
+// decodeJob decodes a job document, which is YAML if yaml is true and
+// JSON otherwise. Fields it doesn't know are errors, since they're
+// likely misspelled.
+func decodeJob(data []byte, yaml bool) (jobRequest, error) {
+	if yaml {
+		doc, err := parseYAML(data)
+		if err != nil {
+			return jobRequest{}, err
+		}
+		// (its values are all strings and mappings, which
+		// JSON has too)
+		data, err = json.Marshal(doc)
+		if err != nil {
+			return jobRequest{}, err
+		}
+	}
+	var req jobRequest
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&req)
+	if err != nil {
+		return jobRequest{}, fmt.Errorf("job: %v", err)
+	}
+	return req, nil
+}
+
+// readJobFile reads the job file, which is JSON if its name ends in
+// .json, and YAML otherwise.
+func readJobFile(filename string) (jobRequest, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return jobRequest{}, err
+	}
+	req, err := decodeJob(data, !strings.EqualFold(filepath.Ext(filename), ".json"))
+	if err != nil {
+		return jobRequest{}, fmt.Errorf("%s: %v", filename, err)
+	}
+	return req, nil
+}
+
+// parseYAML parses the YAML that job documents are: mappings of keys
+// to scalars (plain or quoted, and literal blocks with |, for
+// filter_text) or to mappings indented under them, with comments.
+// Other YAML, like lists and flow style, isn't needed for them, and
+// is an error.
+func parseYAML(data []byte) (map[string]any, error) {
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	doc, i, err := parseYAMLMapping(lines, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	if i < len(lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", i+1)
+	}
+	return doc, nil
+}
+
+// parseYAMLMapping parses the mapping whose keys are indented by
+// indent, starting at line i, and returns it and the line after it.
+func parseYAMLMapping(lines []string, i, indent int) (map[string]any, int, error) {
+	m := make(map[string]any)
+	for i < len(lines) {
+		text := strings.TrimSpace(lines[i])
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			i++
+			continue
+		}
+		lineIndent := yamlIndent(lines[i])
+		if lineIndent < 0 {
+			return nil, i, fmt.Errorf("line %d: indentation must be spaces, not tabs", i+1)
+		}
+		if lineIndent < indent {
+			return m, i, nil
+		}
+		if lineIndent > indent {
+			return nil, i, fmt.Errorf("line %d: unexpected indentation", i+1)
+		}
+		if strings.HasPrefix(text, "- ") || text == "-" {
+			return nil, i, fmt.Errorf("line %d: lists aren't used in job files", i+1)
+		}
+		key, value, ok := strings.Cut(text, ":")
+		if !ok || key == "" {
+			return nil, i, fmt.Errorf("line %d: expected key: value", i+1)
+		}
+		key, err := yamlScalar(strings.TrimSpace(key))
+		if err != nil {
+			return nil, i, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if _, ok := m[key]; ok {
+			return nil, i, fmt.Errorf("line %d: %s is given twice", i+1, key)
+		}
+		value = strings.TrimSpace(value)
+		lineNum := i + 1
+		i++
+
+		switch {
+		case value == "|" || value == "|-":
+			// a literal block: the lines indented more than the
+			// key, with their line breaks
+			var block []string
+			blockIndent := -1
+			for ; i < len(lines); i++ {
+				if strings.TrimSpace(lines[i]) == "" {
+					block = append(block, "")
+					continue
+				}
+				ind := yamlIndent(lines[i])
+				if ind <= indent {
+					break
+				}
+				if blockIndent < 0 {
+					blockIndent = ind
+				}
+				if ind < blockIndent {
+					return nil, i, fmt.Errorf("line %d: the block's lines must be indented the same", i+1)
+				}
+				block = append(block, lines[i][blockIndent:])
+			}
+			// (blank lines after it aren't part of it)
+			for len(block) > 0 && block[len(block)-1] == "" {
+				block = block[:len(block)-1]
+			}
+			s := strings.Join(block, "\n")
+			if value == "|" && s != "" {
+				s += "\n"
+			}
+			m[key] = s
+		case value == "" || strings.HasPrefix(value, "#"):
+			// a mapping indented under the key, or nothing
+			next := i
+			for next < len(lines) && (strings.TrimSpace(lines[next]) == "" || strings.HasPrefix(strings.TrimSpace(lines[next]), "#")) {
+				next++
+			}
+			if next < len(lines) && yamlIndent(lines[next]) > indent {
+				child, after, err := parseYAMLMapping(lines, next, yamlIndent(lines[next]))
+				if err != nil {
+					return nil, after, err
+				}
+				m[key], i = child, after
+			} else {
+				m[key] = ""
+			}
+		default:
+			s, err := yamlScalar(value)
+			if err != nil {
+				return nil, i, fmt.Errorf("line %d: %v", lineNum, err)
+			}
+			m[key] = s
+		}
+	}
+	return m, i, nil
+}
+
+// yamlIndent returns how many spaces the line is indented by, or -1
+// if it's indented with a tab.
+func yamlIndent(line string) int {
+	n := len(line) - len(strings.TrimLeft(line, " "))
+	if strings.HasPrefix(line[n:], "\t") {
+		return -1
+	}
+	return n
+}
+
+// yamlScalar returns the string that a YAML scalar is: in double
+// quotes (with escapes), in single quotes (doubled inside them), or
+// plain, until a comment. Empty, null, and ~ are the empty string.
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		end := 1
+		for ; end < len(s); end++ {
+			if s[end] == '\\' {
+				end++
+			} else if s[end] == '"' {
+				break
+			}
+		}
+		if end >= len(s) {
+			return "", errors.New("quote isn't closed")
+		}
+		if err := yamlAfterScalar(s[end+1:]); err != nil {
+			return "", err
+		}
+		return strconv.Unquote(s[:end+1])
+	case strings.HasPrefix(s, "'"):
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			return b.String(), yamlAfterScalar(s[i+1:])
+		}
+		return "", errors.New("quote isn't closed")
+	case strings.HasPrefix(s, "[") || strings.HasPrefix(s, "{"):
+		return "", errors.New("flow style isn't used in job files")
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	if s == "null" || s == "~" {
+		return "", nil
+	}
+	return s, nil
+}
+
+// yamlAfterScalar returns an error if what's after a quoted scalar
+// isn't nothing or a comment.
+func yamlAfterScalar(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected '%s' after the quote", rest)
+	}
+	return nil
+}
+
+// editArgs returns the flags of the edit that the job file, which is
+// in dir, describes, with its profile (if any) in profilesFile.
+func (r jobRequest) editArgs(dir, profilesFile string) ([]string, error) {
+	if r.Input == "" {
+		return nil, errors.New("the job has no input")
+	}
+	if (r.Filter == "") == (r.FilterText == "") {
+		return nil, errors.New("the job needs one of filter or filter_text")
+	}
+	if r.Profile != "" && profilesFile == "" {
+		return nil, fmt.Errorf("the job's profile '%s' needs a profiles file (use -profiles)", r.Profile)
+	}
+	local := func(name string) string {
+		if name == "" || filepath.IsAbs(name) {
+			return name
+		}
+		return filepath.Join(dir, filepath.FromSlash(name))
+	}
+	input, output := local(r.Input), local(r.Output)
+	args := []string{"-in", input}
+
+	if r.Profile != "" {
+		profiles, err := loadProfiles(profilesFile)
+		if err != nil {
+			return nil, err
+		}
+		prof, ok := profiles[r.Profile]
+		if !ok {
+			return nil, fmt.Errorf("no profile '%s' in %s", r.Profile, profilesFile)
+		}
+		if output == "" {
+			output = local(prof.outputName(r.Profile, filepath.ToSlash(r.Input)))
+		}
+		args = append(args, "-profiles", profilesFile, "-out-profile", r.Profile+"="+output)
+	} else if output == "" {
+		return nil, errors.New("the job has no output (or profile)")
+	} else {
+		args = append(args, "-out", output)
+	}
+
+	if r.Filter != "" {
+		args = append(args, "-filter", local(r.Filter))
+	}
+	// (the filter's lines are given like -action's, so that the
+	// filter is saved where those are)
+	if r.FilterText != "" {
+		for _, line := range strings.Split(strings.TrimSuffix(r.FilterText, "\n"), "\n") {
+			args = append(args, "-action", line)
+		}
+	}
+	args = append(args, r.settingArgs()...)
+	// only the options the server lets jobs set, since a job file
+	// may come from someone else, and hooks run shell commands
+	edits := editFlags(defaultOptions())
+	for _, name := range slices.Sorted(maps.Keys(r.Options)) {
+		switch {
+		case edits.Lookup(name) == nil:
+			return nil, fmt.Errorf("the job's option '%s' isn't an option of an edit", name)
+		case !slices.Contains(jobOptions, name):
+			return nil, fmt.Errorf("the job's option '%s' can't be set by job files", name)
+		}
+		args = append(args, "-"+name+"="+r.Options[name])
+	}
+	return args, nil
+}
+
+// runCmd makes the edit that a job file describes.
+func runCmd(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	profilesFile := fs.String("profiles", "", "the file of viewer profiles that the job's profile is in (the same as for vidagent serve)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent run [-profiles <file>] <job file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	req, err := readJobFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	editArgs, err := req.editArgs(filepath.Dir(fs.Arg(0)), *profilesFile)
+	if err != nil {
+		return fmt.Errorf("%s: %v", fs.Arg(0), err)
+	}
+	o, err := newOptions(withFlags(flag.NewFlagSet("run", flag.ContinueOnError), editArgs))
+	if err != nil {
+		return err
+	}
+	newEdit(o).run()
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// A mistyped time in a filter file, like 1:05:00 for 0:05:00, can cut
+// half a movie without anyone noticing until it's watched. So an edit
+// that cuts more of the input than -large-edit-percent or
+// -large-edit-minutes says is asked about first, on a terminal, and
+// otherwise fails, unless -allow-large-edits says it's intended.
+
+// cutLength returns how many seconds of the input the actions cut,
+// and the longest cut. Cuts past the end of an input of the given
+// duration (if it's known) only count up to the end.
+func cutLength(actions []action, duration float64) (float64, action) {
+	var total, longest float64
+	var longestCut action
+	for _, act := range actions {
+		if act.verb != CutVerb {
+			continue
+		}
+		end := act.end.SecondNum()
+		if duration > 0 {
+			end = min(end, duration)
+		}
+		length := max(end-act.start.SecondNum(), 0)
+		total += length
+		if length > longest {
+			longest, longestCut = length, act
+		}
+	}
+	return total, longestCut
+}
+
+// checkLargeEdit returns an error if the actions cut more of an input
+// of the given duration (or 0 if it isn't known) than is allowed
+// without asking, and it isn't confirmed.
+func (e *edit) checkLargeEdit(actions []action, duration float64) error {
+	if e.opts.allowLargeEdits {
+		return nil
+	}
+	cut, longest := cutLength(actions, duration)
+	var limit string
+	switch {
+	case e.opts.largeEditMinutes > 0 && cut > e.opts.largeEditMinutes*60:
+		limit = fmt.Sprintf("more than %g minutes", e.opts.largeEditMinutes)
+	case e.opts.largeEditPercent > 0 && duration > 0 && cut/duration*100 > e.opts.largeEditPercent:
+		limit = fmt.Sprintf("more than %g%% of it", e.opts.largeEditPercent)
+	default:
+		return nil
+	}
+
+	what := fmt.Sprintf("the edit cuts %s of the input", clockString(cut))
+	if duration > 0 {
+		what += fmt.Sprintf(" (%.0f%% of %s)", cut/duration*100, clockString(duration))
+	}
+	what += fmt.Sprintf(", %s; the longest cut is %s", limit, clockString(longest.end.SecondNum()-longest.start.SecondNum()))
+	if line := longest.line(); line > 0 {
+		what += fmt.Sprintf(", on line %d", line)
+	}
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stderr) {
+		return fmt.Errorf("%s. Check the filter file for a mistyped time, or use -allow-large-edits if it's intended", what)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s. Make the edit anyway? [y/N] ", what)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return errors.New("edit not confirmed")
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("edit canceled")
+}
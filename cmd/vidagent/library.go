@@ -0,0 +1,428 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// libraryEntry describes a filter file in the library.
+type libraryEntry struct {
+	Title    string    `json:"title"`
+	Year     int       `json:"year,omitempty"`
+	Edition  string    `json:"edition,omitempty"`
+	Duration float64   `json:"duration,omitempty"` // of the video it's for, in seconds
+	File     string    `json:"file"`               // relative to the library directory
+	SHA256   string    `json:"sha256"`             // of the filter file
+	Actions  int       `json:"actions"`
+	Imported time.Time `json:"imported"`
+}
+
+// name returns the title and year of the entry, the way it
+// is written when applying it, e.g. "Movie (2004)".
+func (e libraryEntry) name() string {
+	if e.Year > 0 {
+		return fmt.Sprintf("%s (%d)", e.Title, e.Year)
+	}
+	return e.Title
+}
+
+// label returns the name of the entry with its edition, if any.
+func (e libraryEntry) label() string {
+	if e.Edition != "" {
+		return e.name() + " [" + e.Edition + "]"
+	}
+	return e.name()
+}
+
+// fits returns true if the entry's recorded duration is
+// within durationTolerance of duration (in seconds).
+func (e libraryEntry) fits(duration float64) bool {
+	return e.Duration > 0 && duration > 0 && math.Abs(e.Duration-duration) <= durationTolerance
+}
+
+// matches returns true if the entry is for the given title, year
+// (if non-zero), and edition (if not empty), ignoring case.
+func (e libraryEntry) matches(title string, year int, edition string) bool {
+	return strings.EqualFold(e.Title, title) &&
+		(year == 0 || e.Year == year) &&
+		(edition == "" || strings.EqualFold(e.Edition, edition))
+}
+
+// library is a directory of filter files and an index of them. The
+// index is a JSON file so that the library doesn't need a database
+// (or cgo); it is small enough to read and write whole.
+type library struct {
+	dir     string
+	entries []libraryEntry
+}
+
+const libraryIndexFile = "index.json"
+
+// libraryDir returns the default library directory.
+func libraryDir() (string, error) {
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config, "vidagent", "library"), nil
+}
+
+// openLibrary reads the library in dir. A library
+// that doesn't exist yet is empty.
+func openLibrary(dir string) (*library, error) {
+	lib := &library{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, libraryIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return lib, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &lib.entries)
+	if err != nil {
+		return nil, fmt.Errorf("reading library index: %v", err)
+	}
+	return lib, nil
+}
+
+// save writes the library's index.
+func (lib *library) save() error {
+	sort.SliceStable(lib.entries, func(i, j int) bool {
+		if !strings.EqualFold(lib.entries[i].Title, lib.entries[j].Title) {
+			return strings.ToLower(lib.entries[i].Title) < strings.ToLower(lib.entries[j].Title)
+		}
+		if lib.entries[i].Year != lib.entries[j].Year {
+			return lib.entries[i].Year < lib.entries[j].Year
+		}
+		return lib.entries[i].Edition < lib.entries[j].Edition
+	})
+	data, err := json.MarshalIndent(lib.entries, "", "\t")
+	if err != nil {
+		return err
+	}
+	// write then rename so a failed write doesn't lose the index
+	index := filepath.Join(lib.dir, libraryIndexFile)
+	err = os.WriteFile(index+".tmp", data, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(index+".tmp", index)
+}
+
+// add copies the filter file into the library with the details
+// in entry, replacing any entry for the same title, year, and
+// edition. It returns true if an existing entry was replaced.
+func (lib *library) add(filename string, entry libraryEntry) (bool, error) {
+	// (the filter file isn't read for any one input)
+	actions, err := newEdit(defaultOptions()).readFilterFile(filename)
+	if err != nil {
+		return false, err
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	entry.Actions = len(actions)
+	sum := sha256.Sum256(data)
+	entry.SHA256 = hex.EncodeToString(sum[:])
+	entry.Imported = time.Now().UTC()
+	entry.File = libraryFileName(entry)
+
+	err = os.MkdirAll(lib.dir, 0755)
+	if err != nil {
+		return false, err
+	}
+	err = os.WriteFile(filepath.Join(lib.dir, entry.File), data, 0644)
+	if err != nil {
+		return false, err
+	}
+	// keep the signature, if any, with the filter file
+	os.Remove(filepath.Join(lib.dir, entry.File+sigExt))
+	if sig, err := os.ReadFile(filename + sigExt); err == nil {
+		err = os.WriteFile(filepath.Join(lib.dir, entry.File+sigExt), sig, 0644)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	for i, existing := range lib.entries {
+		if strings.EqualFold(existing.Title, entry.Title) && existing.Year == entry.Year &&
+			strings.EqualFold(existing.Edition, entry.Edition) {
+			if existing.File != entry.File {
+				os.Remove(filepath.Join(lib.dir, existing.File))
+				os.Remove(filepath.Join(lib.dir, existing.File+sigExt))
+			}
+			lib.entries[i] = entry
+			return true, nil
+		}
+	}
+	lib.entries = append(lib.entries, entry)
+	return false, nil
+}
+
+// find returns the entry for the given title, year (if non-zero),
+// and edition (if not empty) that best fits a video of the given
+// duration in seconds (if non-zero): among several editions, the one
+// whose recorded duration is closest, within durationTolerance.
+func (lib *library) find(title string, year int, edition string, duration float64) (libraryEntry, error) {
+	var candidates []libraryEntry
+	for _, e := range lib.entries {
+		if e.matches(title, year, edition) {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		return libraryEntry{}, fmt.Errorf("no filter in the library for '%s'", title)
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	if best, ok := closestDuration(candidates, duration); ok {
+		return best, nil
+	}
+
+	names := make([]string, len(candidates))
+	for i, e := range candidates {
+		names[i] = e.label()
+	}
+	return libraryEntry{}, fmt.Errorf("several filters match '%s' and the input's duration doesn't tell them apart (add the year to the title or use -edition): %s",
+		title, strings.Join(names, ", "))
+}
+
+// closestDuration returns the entry whose recorded duration is
+// closest to duration, if any are within durationTolerance of it.
+func closestDuration(entries []libraryEntry, duration float64) (libraryEntry, bool) {
+	var best *libraryEntry
+	for i, e := range entries {
+		if !e.fits(duration) {
+			continue
+		}
+		if best == nil || math.Abs(e.Duration-duration) < math.Abs(best.Duration-duration) {
+			best = &entries[i]
+		}
+	}
+	if best == nil {
+		return libraryEntry{}, false
+	}
+	return *best, true
+}
+
+// durationTolerance is how far, in seconds, the duration of a
+// video may be from the duration recorded for a filter file
+// for the filter file to be considered a match.
+const durationTolerance = 2.0
+
+// libraryFileName returns the name of the file that the
+// filter file for entry is stored as in the library.
+func libraryFileName(entry libraryEntry) string {
+	name := entry.name()
+	if entry.Edition != "" {
+		name += " " + entry.Edition
+	}
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`<>:"/\|?*`, r) || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	return name + ".filter"
+}
+
+// titleYear matches a title with a year in parentheses at the end.
+var titleYear = regexp.MustCompile(`^(.*?)\s*\((\d{4})\)$`)
+
+// parseTitle splits a title like "Movie (2004)" into the
+// title and year; the year is 0 if there isn't one.
+func parseTitle(s string) (string, int) {
+	s = strings.TrimSpace(s)
+	m := titleYear.FindStringSubmatch(s)
+	if m == nil {
+		return s, 0
+	}
+	year, _ := strconv.Atoi(m[2])
+	return m[1], year
+}
+
+// libraryCmd manages a library of filter files.
+func libraryCmd(args []string) error {
+	usage := "usage: vidagent library <import|search|apply> [options]"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	switch args[0] {
+	case "import":
+		return libraryImport(args[1:])
+	case "search":
+		return librarySearch(args[1:])
+	case "apply":
+		return libraryApply(args[1:])
+	default:
+		return fmt.Errorf("unknown library command '%s'; %s", args[0], usage)
+	}
+}
+
+// libraryFlags adds the -dir flag to fs and returns a function
+// that opens the library in that directory after parsing.
+func libraryFlags(fs *flag.FlagSet) func() (*library, error) {
+	dir := fs.String("dir", "", "the library directory (default is in the user config directory)")
+	return func() (*library, error) {
+		if *dir == "" {
+			var err error
+			*dir, err = libraryDir()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return openLibrary(*dir)
+	}
+}
+
+// libraryImport adds filter files to the library.
+func libraryImport(args []string) error {
+	fs := flag.NewFlagSet("library import", flag.ExitOnError)
+	open := libraryFlags(fs)
+	title := fs.String("title", "", `the title, optionally with the year, e.g. "Movie (2004)" (default is the file name)`)
+	year := fs.Int("year", 0, "the year the title was released")
+	edition := fs.String("edition", "", "the edition, e.g. theatrical or extended")
+	duration := fs.Duration("duration", 0, "the duration of the video the filter is for")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent library import [options] <filter files...>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no filter files given")
+	}
+	if *title != "" && fs.NArg() > 1 {
+		return errors.New("-title can only be used when importing one filter file")
+	}
+
+	lib, err := open()
+	if err != nil {
+		return err
+	}
+
+	for _, filename := range fs.Args() {
+		name := *title
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		}
+		entry := libraryEntry{Edition: *edition, Duration: duration.Seconds()}
+		entry.Title, entry.Year = parseTitle(name)
+		if *year != 0 {
+			entry.Year = *year
+		}
+
+		replaced, err := lib.add(filename, entry)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		if replaced {
+			log.Printf("updated %s", entry.label())
+		} else {
+			log.Printf("imported %s", entry.label())
+		}
+	}
+
+	return lib.save()
+}
+
+// librarySearch lists the entries in the library whose
+// titles contain all of the search terms.
+func librarySearch(args []string) error {
+	fs := flag.NewFlagSet("library search", flag.ExitOnError)
+	open := libraryFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent library search [options] [terms...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	lib, err := open()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "title\tedition\tduration\tactions\tfile")
+nextEntry:
+	for _, e := range lib.entries {
+		for _, term := range fs.Args() {
+			if !strings.Contains(strings.ToLower(e.name()), strings.ToLower(term)) {
+				continue nextEntry
+			}
+		}
+		var duration string
+		if e.Duration > 0 {
+			duration = seconds(e.Duration)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", e.name(), e.Edition, duration, e.Actions, filepath.Join(lib.dir, e.File))
+	}
+	return tw.Flush()
+}
+
+// libraryApply edits the input using the filter file in the
+// library for the given title. It takes the same flags as a
+// normal edit, except that -title chooses the filter file.
+func libraryApply(args []string) error {
+	fs := flag.NewFlagSet("library apply", flag.ExitOnError)
+	o := defaultOptions()
+	editFlags(o).VisitAll(func(f *flag.Flag) {
+		if f.Name != "filter" {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	open := libraryFlags(fs)
+	title := fs.String("title", "", `the title of the filter to apply, optionally with the year, e.g. "Movie (2004)"`)
+	edition := fs.String("edition", "", "the edition of the filter to apply, if there are several")
+	fs.Parse(args)
+	e := newEdit(o)
+
+	if *title == "" {
+		return errors.New("title required (use -title)")
+	}
+	if e.opts.inputFile == "" {
+		return errors.New("input file required (use -in)")
+	}
+
+	lib, err := open()
+	if err != nil {
+		return err
+	}
+
+	// the input's duration tells editions apart
+	info, err := probe(e.opts.inputFile)
+	if err != nil {
+		log.Printf("could not probe input; matching by title only: %v", err)
+	}
+	name, year := parseTitle(*title)
+	entry, err := lib.find(name, year, *edition, info.Format.duration())
+	if err != nil {
+		return err
+	}
+
+	e.opts.filterFile = filepath.Join(lib.dir, entry.File)
+	log.Printf("applying filter for %s (%s)", entry.label(), e.opts.filterFile)
+	if err := e.opts.validate(); err != nil {
+		return err
+	}
+	e.run()
+	return nil
+}
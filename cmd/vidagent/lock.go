@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// errLocked is returned by lockFile if another process has the lock.
+var errLocked = errors.New("locked")
+
+// lockOutput takes the lock of the output, so that two runs of
+// vidagent don't write it at the same time (like when a cron job
+// fires again before the last run is done), and returns a function
+// that releases it. The lock is a hidden file next to the output,
+// which is removed when it's released. A run that's killed leaves
+// it behind, but it's only locked while a run has it open, so that
+// doesn't keep later runs from taking it.
+func lockOutput(output string) (func(), error) {
+	dir, base := filepath.Split(output)
+	unlock, err := lockFile(filepath.Join(dir, "."+base+".lock"))
+	if err == errLocked {
+		return nil, fmt.Errorf("%s is already being processed by another run of vidagent", output)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("locking %s: %v", output, err)
+	}
+	return unlock, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !netbsd && !openbsd && !windows
+
+package main
+
+// lockFile doesn't lock anything, since there's no advisory
+// locking on this platform.
+func lockFile(name string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,47 @@
+//go:build linux || darwin || freebsd || dragonfly || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an advisory lock on the file, creating it if
+// needed, and returns a function that releases it and removes the
+// file.
+func lockFile(name string) (func(), error) {
+	for {
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err != nil {
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, errLocked
+			}
+			return nil, err
+		}
+
+		// the process that had the lock may have removed the
+		// file after we opened it, so another one could take
+		// the lock of a new file by the same name
+		opened, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if current, err := os.Stat(name); err != nil || !os.SameFile(opened, current) {
+			f.Close()
+			continue
+		}
+
+		return func() {
+			// removed while it's still locked, for the same reason
+			os.Remove(name)
+			f.Close()
+		}, nil
+	}
+}
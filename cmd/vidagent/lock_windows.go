@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// errorSharingViolation is the error for opening a file that
+// another process has open without sharing it.
+const errorSharingViolation syscall.Errno = 32
+
+// lockFile takes the lock of the file by opening it, creating it if
+// needed, without sharing it with other processes, and returns a
+// function that releases it and removes the file.
+func lockFile(name string) (func(), error) {
+	path, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(path, syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		0, nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err == errorSharingViolation {
+		return nil, errLocked
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		syscall.CloseHandle(h)
+		// (if another process opened it since, it keeps it)
+		os.Remove(name)
+	}, nil
+}
@@ -1,358 +1,895 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
-	"unicode"
+	"time"
 )
 
-var (
-	inputFile, outputFile, filterFile string
-	overwrite                         bool
-)
+func main() {
+	err := loadConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cleanUpOnExit()
+
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			err := cmd(os.Args[2:])
+			if err != nil {
+				fatal(err)
+			}
+			return
+		}
+	}
 
-func init() {
-	flag.StringVar(&inputFile, "in", inputFile, "the input file")
-	flag.StringVar(&outputFile, "out", outputFile, "the output file")
-	flag.StringVar(&filterFile, "filter", filterFile, "the filter file")
-	flag.BoolVar(&overwrite, "f", overwrite, "force overwrite of output file if it exists")
+	o, err := newOptions(withFlags(flag.CommandLine, os.Args[1:]))
+	if err != nil {
+		log.Fatal(err)
+	}
+	newEdit(o).run()
 }
 
-func main() {
-	flag.Parse()
+// run edits the input file according to the filter file, as the
+// edit's options, which must be valid, say.
+func (e *edit) run() {
+	var err error
+	if e.opts.filterFile == "" && e.opts.filterRepo != "" {
+		e.opts.filterFile, err = e.fetchRepoFilter(e.opts.filterRepo, e.opts.inputFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	err = e.inlineFilter()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if e.opts.verifyKey != "" {
+		err := verifyFilterFile(e.opts.filterFile, e.opts.verifyKey)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if e.opts.policyFile != "" {
+		err := e.loadPolicy(e.opts.policyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	if inputFile == "" {
-		log.Fatal("input file required (use -in)")
+	scale, err := parseScale(e.opts.timeScale)
+	if err != nil {
+		log.Fatalf("-time-scale: %v", err)
 	}
-	if outputFile == "" {
-		log.Fatal("output file required (use -out)")
+	offset, err := parseOffset(e.opts.timeOffset)
+	if err != nil {
+		log.Fatalf("-offset: %v", err)
 	}
-	if filterFile == "" {
-		log.Fatal("filter file required (use -filter)")
+	if len(e.opts.outProfiles) > 0 {
+		started := time.Now()
+		err = e.editProfiles(scale, offset)
+		e.finish(started, err)
+		return
 	}
+	actions, err := e.readShiftedFilterFile(e.opts.filterFile, scale, offset)
+	if err != nil {
+		fatal(err)
+	}
+
+	if lines, err := disabledLines(e.opts.filterFile); err == nil && len(lines) > 0 {
+		logf("not applying the disabled actions on lines %s", lineList(lines))
+	}
+	// notes are only for people reading the filter file
+	actions = withoutVerb(actions, NoteVerb)
 
-	file, err := os.Open(filterFile)
+	actions, err = e.selectActions(actions)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer file.Close()
+	e.editSummaries[e.opts.outputFile] = summarizeActions(actions)
+	// (the effects are taken out of the actions next, but the marked
+	// copy and the archive need them)
+	var effectActions []action
+	for _, act := range actions {
+		if isEffect(act.verb) {
+			effectActions = append(effectActions, act)
+		}
+	}
+	if e.opts.markedCopy != "" {
+		e.markedEffects = effectActions
+	}
 
-	tokens, err := getTokens(file)
+	actions, err = e.applyEffects(actions)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	actions, err := getActions(tokens)
+	e.filterHash, err = fileHash(e.opts.filterFile)
 	if err != nil {
 		log.Fatal(err)
 	}
+	snapping, err := parseSnapKinds(e.opts.snapTo)
+	if err != nil {
+		log.Fatalf("-snap-to: %v", err)
+	}
 
-	err = validateSegmentTimes(actions)
+	variant := e.editVariant(scale, offset)
+	if e.opts.policyFile != "" {
+		policyHash, err := fileHash(e.opts.policyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		variant += " policy=" + policyHash
+	}
+	e.filterHash = variantHash(e.filterHash, variant)
+	unlock, err := lockOutput(e.opts.outputFile)
 	if err != nil {
 		log.Fatal(err)
 	}
-
-	filterCplx, err := buildComplexFilter(actions)
+	defer unlock()
+	if !e.opts.overwrite && !e.opts.splitOutput && alreadyProcessed(e.opts.outputFile, e.filterHash) {
+		logf("%s was already made with this filter file; skipping (use -f to make it again)", e.opts.outputFile)
+		return
+	}
+	removeImport, err := e.importTempInput()
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer removeImport()
 
-	ffmpegOverwriteOutput := "-n"
-	if overwrite {
-		ffmpegOverwriteOutput = "-y"
+	if !e.opts.noSpaceCheck {
+		need, err := e.estimateOutputSize()
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = checkFreeSpace(filepath.Dir(e.opts.outputFile), need)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	// order of arguments is important!
-	// input 0 is the video file
-	// input 1 is the null audio source (silence)
-	// these correspond to values in the complex filter!
-	args := []string{
-		ffmpegOverwriteOutput,
-		"-i", inputFile,
-		"-f", "lavfi",
-		"-i", "anullsrc",
-		"-filter_complex", filterCplx,
-		"-map", "[outv]",
-		"-map", "[outa]",
-		outputFile,
+	// simple audio edits can be done without ffmpeg, and raw audio
+	// is edited that way once it's imported
+	if _, err := findTool("ffmpeg"); err != nil && isWAV(e.opts.inputFile) || e.rawAudio() {
+		if e.rawAudio() {
+			log.Println(tr("editing the raw audio with the built-in WAV editor"))
+		} else {
+			log.Println(tr("ffmpeg not found; using built-in WAV editor"))
+		}
+		if hasVerb(actions, ExtractVerb) {
+			log.Println(tr("skipping extract actions, which the WAV editor can't make"))
+		}
+		if e.hasEffects() {
+			log.Println(tr("skipping plugin actions and effects like blurs and fades, which the WAV editor can't make"))
+		}
+		if e.opts.muteFill != "" {
+			log.Println(tr("filling mutes with silence, since the WAV editor can't use -mute-fill"))
+		}
+		if e.opts.markedCopy != "" {
+			log.Println(tr("not writing -marked-copy, which the WAV editor can't make"))
+		}
+		if len(snapping) > 0 {
+			log.Println(tr("not snapping cuts, which the WAV editor can't do"))
+		}
+		started := time.Now()
+		duration, err := wavDuration(e.opts.inputFile)
+		if err == nil {
+			err = e.checkLargeEdit(actions, duration)
+		}
+		if err == nil {
+			actions, err = e.excerptActions(actions, duration)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		e.writingTo, err = e.startPartial(e.opts.outputFile)
+		if err == nil {
+			err = e.editWAVFile(withoutVerb(actions, ChapterBreakVerb, ExtractVerb))
+			err = finishPartial(e.writingTo, e.opts.outputFile, err)
+		}
+		e.finish(started, err)
+		return
 	}
 
-	cmd := exec.Command("ffmpeg", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	err = cmd.Run()
+	e.inputInfo, err = probe(e.opts.inputFile)
 	if err != nil {
+		logf("could not probe input; continuing without it: %v", err)
+	}
+	if err := e.checkAudioLang(); err != nil {
 		log.Fatal(err)
 	}
-}
+	if err := e.checkLargeEdit(actions, e.inputInfo.Format.duration()); err != nil {
+		log.Fatal(err)
+	}
+	actions, err = e.excerptActions(actions, e.inputInfo.Format.duration())
+	if err != nil {
+		log.Fatal(err)
+	}
+	// (stream copy preserves timestamps however they are)
+	if video := e.inputInfo.stream("video"); video != nil && e.opts.frameRate == "" && !e.opts.streamCopy && video.variableFrameRate() {
+		e.opts.frameRate = video.AvgFrameRate
+		logf("input has a variable frame rate; converting to a constant %s fps (use -cfr to choose a rate)", e.opts.frameRate)
+	}
 
-func getTokens(input io.Reader) ([]token, error) {
-	var tokens []token
-	scanner := bufio.NewScanner(input)
+	if _, err := e.scaleFilter(); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := e.subtitlesFilter(); err != nil {
+		log.Fatal(err)
+	}
+	if !e.opts.streamCopy {
+		e.logRotation()
+	}
+	if video := e.inputInfo.stream("video"); video != nil && video.hdr() && !e.opts.toneMap {
+		logf("input is HDR (%s); its colors will be preserved (use -tonemap to convert to SDR)", video.ColorTransfer)
+	}
+	if err := e.checkDynamicHDR(withoutVerb(actions, ChapterBreakVerb, ExtractVerb)); err != nil {
+		log.Fatal(err)
+	}
 
-nextLine:
-	for lineNum := 1; scanner.Scan(); lineNum += 1 {
-		line := []rune(scanner.Text())
+	if len(snapping) > 0 {
+		e.setStage("snapping", 0)
+		actions, err = e.snapCuts(actions, snapping, e.opts.snapWindow.Seconds())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
-		tkn := token{linePos: lineNum, charPos: -1}
-		saveTkn := func(charNum int) {
-			tkn.val = strings.TrimSpace(tkn.val)
-			tokens = append(tokens, tkn)
-			tkn = token{linePos: lineNum, charPos: -1}
+	if e.opts.engine == "auto" {
+		var why string
+		e.opts.engine, why = e.chooseEngine(withoutVerb(actions, ChapterBreakVerb, ExtractVerb))
+		if e.opts.verbose {
+			logf("using the %s engine: %s", e.opts.engine, why)
 		}
+	}
+	run := engines[e.opts.engine]
+	if e.opts.streamCopy && e.videoNeedsFilters() {
+		log.Fatal("-copy can't be used with options that filter the video")
+	}
+	if e.opts.streamCopy && len(e.audioInputFilters()) > 0 {
+		log.Fatal("-copy can't be used with actions that filter the audio")
+	}
+	if e.opts.timeout > 0 {
+		e.deadline = time.Now().Add(e.opts.timeout)
+	}
 
-		field := "verb"
+	started := time.Now()
+	err = e.perform(run, actions)
+	if err == nil && e.opts.archiveDir != "" {
+		err = e.writeArchive(append(slices.Clone(actions), effectActions...))
+	}
+	e.finish(started, err)
+}
 
-		for charNum, ch := range line {
-			isSpace := unicode.IsSpace(ch)
+// editVariant returns how the options make the edit differ from
+// the filter file's edits as they are (other than by a policy), in
+// a form that identifies it, or "" if they don't.
+func (e *edit) editVariant(scale, offset float64) string {
+	variant := e.selectors()
+	if scale != 1 || offset != 0 {
+		variant += fmt.Sprintf(" scale=%g offset=%g", scale, offset)
+	}
+	if snapping, _ := parseSnapKinds(e.opts.snapTo); len(snapping) > 0 {
+		variant += fmt.Sprintf(" snap=%s window=%s", strings.Join(snapping, ","), e.opts.snapWindow)
+	}
+	if e.opts.excerptFrom != "" || e.opts.excerptTo != "" {
+		variant += fmt.Sprintf(" from=%s to=%s", e.opts.excerptFrom, e.opts.excerptTo)
+	}
+	if e.opts.lenient {
+		// the output may not have all of the file's edits
+		variant += " lenient"
+	}
+	return variant
+}
 
-			if ch == '#' {
-				continue nextLine
-			}
+// variantHash returns the hash that identifies the edits of the
+// filter file with the given hash, made differently by variant.
+func variantHash(filterHash, variant string) string {
+	if variant == "" {
+		return filterHash
+	}
+	// the output doesn't have the file's edits as they are
+	sum := sha256.Sum256([]byte(filterHash + " " + variant))
+	return hex.EncodeToString(sum[:])
+}
 
-			switch field {
-			case "verb":
-				if isSpace && tkn.val != "" {
-					saveTkn(charNum)
-					field = "start"
-					continue
-				}
-			case "start":
-				if ch == '-' && tkn.val != "" {
-					saveTkn(charNum)
-					field = "end"
-					continue
-				}
-			case "end":
-				if ch == '(' && tkn.val != "" {
-					saveTkn(charNum)
-					field = "reason"
-					continue
-				}
-			case "reason":
-				if ch == ')' {
-					saveTkn(charNum)
-					continue nextLine
-				}
-			default:
-				return tokens, fmt.Errorf("unexpected state")
+// finish records the run that started at the given time in the
+// history and runs its hook, then exits with the error the run ended with, if any.
+func (e *edit) finish(started time.Time, err error) {
+	outputs := []outProfile{{file: e.opts.outputFile}}
+	if len(e.opts.outProfiles) > 0 {
+		// each output gets its own entry, and hook
+		outputs = e.opts.outProfiles
+	}
+	if !e.opts.noHistory {
+		for _, out := range outputs {
+			if herr := e.recordHistory(started, out.file, err); herr != nil {
+				logf("could not record history: %v", herr)
+				break
 			}
+		}
+	}
+	for _, out := range outputs {
+		e.runHook(started, out, err)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-			if tkn.val == "" && isSpace {
-				continue
-			}
+// perform performs the actions with the engine's run function
+// (or splits the output), then saves any extracted clips.
+func (e *edit) perform(run func(*edit, []action) error, actions []action) error {
+	// markers and extracts don't edit anything
+	edits := withoutVerb(actions, ChapterBreakVerb, ExtractVerb)
 
-			if tkn.charPos == -1 {
-				tkn.charPos = charNum + 1
-			}
+	if e.opts.markedCopy != "" {
+		cleanup, err := e.writeMarkedChapters(append(slices.Clone(edits), e.markedEffects...))
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+	}
+
+	// the output is written to a temporary file until it's complete
+	// (if there is one, and not only clips to extract)
+	var err error
+	onlyExtracts := len(edits) == 0 && !e.hasEffects() && hasVerb(actions, ExtractVerb)
+	if !onlyExtracts && !e.writesInPlace() {
+		e.writingTo, err = e.startPartial(e.opts.outputFile)
+		if err != nil {
+			return err
+		}
+	}
+	var animation string
+	if isAnimation(e.opts.outputFile) && e.writingTo != "" {
+		dir, err := makeTempDir(e.opts.tempRoot, "vidagent-animation-")
+		if err != nil {
+			return err
+		}
+		defer removeTempDir(dir)
+		animation, e.writingTo = e.writingTo, filepath.Join(dir, "edit.mkv")
+	}
 
-			tkn.val += string(ch)
+	switch {
+	case e.opts.splitOutput:
+		err = e.runSplit(withoutVerb(actions, ExtractVerb))
+	case !hasVerb(edits, CutVerb) && (len(edits) > 0 || e.hasEffects()):
+		// nothing changes the timing, so the engines aren't needed,
+		// and the streams that aren't changed can be copied
+		e.setStage("encoding", e.inputInfo.Format.duration())
+		err = e.runWithoutCuts(edits)
+	case onlyExtracts:
+		log.Println(tr("no edits to make; only extracting clips"))
+	default:
+		e.setStage("encoding", e.spansSeconds(e.outputSpans(edits)))
+		if sp, ok := e.trimmedSpan(edits); ok {
+			// only one span is kept, so the engines aren't needed
+			err = e.runTrim(sp, edits)
+		} else {
+			err = run(e, edits)
+		}
+	}
+	if animation != "" {
+		if err == nil {
+			err = e.writeAnimation(e.writingTo, animation)
 		}
+		e.writingTo = animation
+	}
+	if e.writingTo != "" {
+		partial := e.writingTo
+		e.writingTo = ""
+		err = finishPartial(partial, e.opts.outputFile, err)
+	}
+	if err != nil {
+		return err
+	}
+	if e.opts.dumpGraph != "" && !e.graphDumped {
+		log.Println(tr("-dump-graph: not written, since the edit didn't use the filtergraph engine"))
+	}
 
-		if tkn.val != "" {
-			tkn.val = strings.TrimSpace(tkn.val)
-			tokens = append(tokens, tkn)
+	if e.opts.markedCopy != "" && !e.markedCopyDone {
+		err = e.writeMarkedCopy()
+		if err != nil {
+			return err
 		}
 	}
 
-	tokens = append(tokens)
+	err = e.runExtracts(actions)
+	if err != nil {
+		return err
+	}
 
-	return tokens, scanner.Err()
+	if e.opts.checkSyncAfter && !e.opts.splitOutput && len(edits) > 0 {
+		err = checkSync(e.opts.outputFile)
+		if err != nil {
+			return err
+		}
+	}
+	if e.opts.checkQuality && !e.opts.splitOutput && len(edits) > 0 {
+		return e.checkQuality(e.opts.outputFile, edits)
+	}
+	return nil
 }
 
-func getActions(tokens []token) ([]action, error) {
-	var actions []action
-	line := 1
+// readFilterFile reads and validates the actions in a filter file.
+func (e *edit) readFilterFile(filename string) ([]action, error) {
+	return e.readShiftedFilterFile(filename, 1, 0)
+}
 
-	var act action
-	for _, tkn := range tokens {
-		if tkn.linePos > line {
-			if len(act.tokens) > 0 {
-				actions = append(actions, act)
-			}
-			act = action{}
-			line = tkn.linePos
+// disabledLines returns the lines of the disabled actions
+// in a filter file.
+func disabledLines(filename string) ([]int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	tree, err := parseSyntax(file, nil)
+	if err != nil {
+		return nil, err
+	}
+	var lines []int
+	for _, line := range tree.lines {
+		if line.action != nil && line.action.disabled != nil {
+			lines = append(lines, line.start.line)
 		}
+	}
+	return lines, nil
+}
 
-		switch len(act.tokens) {
-		case 0:
-			// verb
-			verb, ok := verbs[strings.ToLower(tkn.val)]
-			if !ok {
-				return actions, fmt.Errorf("line %d:%d: unrecognized verb '%s'",
-					tkn.linePos, tkn.charPos, tkn.val)
-			}
-			act.verb = verb
-		case 1:
-			// start time
-			startTime, err := ParseTime(tkn.val)
-			if err != nil {
-				return actions, fmt.Errorf("invalid start time: %v", err)
+// readShiftedFilterFile reads and validates the actions in a filter
+// file like readFilterFile, then scales and shifts their times by
+// scale and offset after any directives in the file have.
+func (e *edit) readShiftedFilterFile(filename string, scale, offset float64) ([]action, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	actions, err := e.parseFilter(bytes.NewReader(src), scale, offset)
+	if err != nil {
+		return nil, e.newFilterError(filename, src, err, scale, offset)
+	}
+	return actions, nil
+}
+
+// parseFilter parses and validates the actions in the filter file
+// read from r, then scales and shifts their times by scale and offset
+// after any directives in the file have. It only depends on what it
+// reads (and the verbs of the config and plugins), which is what
+// lets FuzzParseFilter fuzz the parser with malformed filter files.
+func (e *edit) parseFilter(r io.Reader, scale, offset float64) ([]action, error) {
+	var l *lenientParse
+	if e.opts.lenient {
+		l = new(lenientParse)
+	}
+	return e.parseLenient(r, scale, offset, l)
+}
+
+// parseLenient is parseFilter, skipping the lines that l skips, or
+// none if it's nil.
+func (e *edit) parseLenient(r io.Reader, scale, offset float64, l *lenientParse) ([]action, error) {
+	tree, err := parseSyntax(r, l)
+	if err != nil {
+		return nil, err
+	}
+
+	fileScale, fileOffset, err := takeDirectives(tree, l)
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err := e.getActions(tree, l)
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err = e.resolveVerbs(actions, l)
+	if err != nil {
+		return nil, err
+	}
+
+	actions, err = validateParams(actions, l)
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateLanguageTimes(actions)
+	if err != nil {
+		return nil, err
+	}
+
+	if l != nil && !l.quiet && len(l.skipped) > 0 {
+		logf("skipped %d lines of the filter file that couldn't be used; applying the rest", len(l.skipped))
+	}
+	scale, offset = fileScale*scale, fileOffset*scale+offset
+	if scale == 1 && offset == 0 {
+		return actions, nil
+	}
+	// times relative to chapters aren't shifted, and those before the
+	// start are trimmed to it, so the shifted times can overlap or be
+	// out of order when the original ones weren't
+	actions = e.shiftActions(actions, scale, offset)
+	err = validateLanguageTimes(actions)
+	if err != nil {
+		return nil, fmt.Errorf("%v (after scaling and shifting the times)", err)
+	}
+	return actions, nil
+}
+
+// runFilterGraph performs all the actions in a single ffmpeg
+// command using one complex filter graph.
+func (e *edit) runFilterGraph(actions []action) error {
+	filterCplx, err := e.buildComplexFilter(actions)
+	if err != nil {
+		return err
+	}
+	err = e.dumpGraph(filterCplx, actions)
+	if err != nil {
+		return err
+	}
+
+	// order of arguments is important!
+	// input 0 is the video file
+	// input 1 is the audio of muted segments (silence, or -mute-fill)
+	// these correspond to values in the complex filter!
+	args := []string{
+		e.overwriteArg(),
+	}
+	args = append(args, e.inputArgs()...)
+	args = append(args, e.muteSourceArgs()...)
+	markedIn, markedOut := e.markedCopyArgs(2)
+	args = append(args, markedIn...)
+	args = append(args,
+		"-filter_complex", filterCplx,
+		"-map", "[outv]",
+		"-map", "[outa]")
+	args = append(args, e.metadataArgs(actions, 0, 2)...)
+	args = append(args, e.videoEncodeArgs()...)
+	args = append(args, e.hlsArgs()...)
+	args = append(args, fileArg(e.outputPath()))
+	args = append(args, markedOut...)
+
+	return e.runFFmpeg(args, e.outputPath())
+}
+
+// withoutVerb returns the actions that don't have any of the given verbs.
+func withoutVerb(actions []action, verbs ...Verb) []action {
+	var others []action
+nextAction:
+	for _, act := range actions {
+		for _, verb := range verbs {
+			if act.verb == verb {
+				continue nextAction
 			}
-			act.start = startTime
-		case 2:
-			// end time
-			endTime, err := ParseTime(tkn.val)
-			if err != nil {
-				return actions, fmt.Errorf("invalid end time: %v", err)
+		}
+		others = append(others, act)
+	}
+	return others
+}
+
+// inputArgs returns the ffmpeg arguments that specify
+// the input file, including any input options.
+func (e *edit) inputArgs() []string {
+	var args []string
+	if e.inputRotation() != 0 {
+		// we rotate the video ourselves (or not at all), so that
+		// it isn't rotated twice and so that the result is the
+		// same regardless of ffmpeg version
+		args = append(args, "-noautorotate")
+	}
+	return append(args, "-i", fileArg(e.opts.inputFile))
+}
+
+// overwriteArg returns the ffmpeg option that
+// controls overwriting the output file.
+func (e *edit) overwriteArg() string {
+	if e.opts.overwrite {
+		return "-y"
+	}
+	return "-n"
+}
+
+// getActions returns the actions of the filter file's syntax tree,
+// with their verbs, times, reasons, and parameters parsed. Actions
+// that can't be parsed are errors, unless l skips them, even if
+// they're disabled; but disabled actions aren't returned.
+func (e *edit) getActions(tree *syntaxTree, l *lenientParse) ([]action, error) {
+	var actions []action
+	vars := make(map[string]string)
+	for _, line := range tree.lines {
+		if d := line.directive; d != nil && strings.EqualFold(d.name.text, "@def") {
+			// (bad definitions are reported by takeDirectives)
+			if name, value, err := definition(d); err == nil {
+				vars[name] = value
 			}
-			act.end = endTime
-		case 3:
-			// reason
-			rsn, err := ParseReason(tkn.val)
-			if err != nil {
-				return actions, fmt.Errorf("invalid reason value: %v", err)
+		}
+		if line.action == nil {
+			continue
+		}
+		act, err := e.newAction(line.action, vars)
+		if err != nil {
+			if l.skip(err) {
+				continue
 			}
-			act.reason = rsn
-		default:
-			return actions, fmt.Errorf("line %d: unexpected token count of %d",
-				tkn.linePos, len(act.tokens))
+			return actions, err
+		}
+		if line.action.disabled != nil {
+			continue
 		}
-		act.tokens = append(act.tokens, tkn)
+		actions = append(actions, act)
 	}
+	return actions, nil
+}
 
-	if len(act.tokens) > 0 {
-		actions = append(actions, act)
+// newAction returns the action of the node, with the
+// variables defined before it.
+func (e *edit) newAction(n *actionNode, vars map[string]string) (action, error) {
+	act := action{syntax: n}
+
+	if n.verb != nil {
+		verb, ok := cfg.verb(n.verb.text)
+		if !ok {
+			verb, ok = pluginVerb(n.verb.text)
+		}
+		if !ok {
+			return act, fmt.Errorf("line %d:%d: unrecognized verb '%s'",
+				n.verb.start.line, n.verb.start.col, n.verb.text)
+		}
+		act.verb = verb
+	}
+	// (otherwise the verb comes from the reason; see resolveVerbs)
+	if n.lang != nil {
+		if !languagePattern.MatchString(n.lang.text) {
+			return act, fmt.Errorf("line %d:%d: bad language '%s'; it must be a code like eng",
+				n.lang.start.line, n.lang.start.col, n.lang.text)
+		}
+		act.lang = strings.ToLower(n.lang.text)
 	}
 
-	return actions, nil
+	startTime, startChapter, err := e.parseActionTime(n.startTime.text, vars)
+	if err != nil {
+		return act, fmt.Errorf("line %d:%d: invalid start time: %v", n.startTime.start.line, n.startTime.start.col, err)
+	}
+	act.start, act.startChapter = startTime, startChapter
+	if n.endTime != nil {
+		endTime, endChapter, err := e.parseActionTime(n.endTime.text, vars)
+		if err != nil {
+			return act, fmt.Errorf("line %d:%d: invalid end time: %v", n.endTime.start.line, n.endTime.start.col, err)
+		}
+		act.end, act.endChapter = endTime, endChapter
+	} else if act.verb == ChapterBreakVerb || act.verb == NoteVerb {
+		// markers are a point in time, so they only need a start time
+		act.end, act.endChapter = act.start, act.startChapter
+	}
+	if n.reason != nil {
+		rsn, err := ParseReason(n.reason.text)
+		if err != nil {
+			return act, fmt.Errorf("line %d:%d: invalid reason value: %v", n.reason.start.line, n.reason.start.col, err)
+		}
+		act.reason = rsn
+	}
+	if n.params != nil {
+		params, err := parseParams(n.params)
+		if err != nil {
+			return act, err
+		}
+		act.params = params
+	}
+	return act, nil
 }
 
 func validateSegmentTimes(actions []action) error {
+	var prev *action
 	for i, act := range actions {
-		if len(act.tokens) == 0 {
-			return fmt.Errorf("action %d: no tokens", i)
-		}
-		if act.end.SecondNum() < act.start.SecondNum() {
-			return fmt.Errorf("line %d: end time %s comes before start time %s",
-				act.tokens[0].linePos, act.end, act.start)
-		}
 		threshold := .001
-		if act.end.SecondNum()-act.start.SecondNum() < threshold {
-			return fmt.Errorf("line %d: start time %s and end time %s are too close; within %f of each other",
-				act.tokens[0].linePos, act.end, act.start, threshold)
+		pointNote := act.verb == NoteVerb && act.syntax != nil && act.syntax.endTime == nil
+		if act.verb != ChapterBreakVerb && !pointNote {
+			if act.end.SecondNum() < act.start.SecondNum() {
+				return fmt.Errorf("line %d: end time %s comes before start time %s",
+					act.line(), act.end, act.start)
+			}
+			if act.end.SecondNum()-act.start.SecondNum() < threshold {
+				return fmt.Errorf("line %d: start time %s and end time %s are too close; within %f of each other",
+					act.line(), act.end, act.start, threshold)
+			}
 		}
-		if i > 0 {
-			if actions[i].end.SecondNum() < actions[i-1].start.SecondNum() {
+		if act.verb == ChapterBreakVerb || act.verb == ExtractVerb || act.verb == NoteVerb || isEffect(act.verb) {
+			// these don't edit the video, so they can go anywhere
+			// (even within other segments), as long as they're in order
+			if i > 0 && act.start.SecondNum() < actions[i-1].start.SecondNum() {
+				return fmt.Errorf("lines %d-%d: actions are out of order",
+					actions[i-1].line(), act.line())
+			}
+			continue
+		}
+		if prev != nil {
+			if act.end.SecondNum() < prev.start.SecondNum() {
 				return fmt.Errorf("lines %d-%d: segments are out of order",
-					actions[i-1].tokens[0].linePos, act.tokens[0].linePos)
+					prev.line(), act.line())
+			}
+			// segments may touch, like muting a sentence and then
+			// cutting the scene that starts right after it, but a
+			// tiny gap between them would be a degenerate segment
+			gap := act.start.SecondNum() - prev.end.SecondNum()
+			if gap < 0 {
+				return fmt.Errorf("lines %d-%d: segments overlap",
+					prev.line(), act.line())
 			}
-			if actions[i].start.SecondNum()-actions[i-1].end.SecondNum() < threshold {
-				return fmt.Errorf("lines %d-%d: segments overlap or are too close",
-					actions[i-1].tokens[0].linePos, act.tokens[0].linePos)
+			if gap > 0 && gap < threshold {
+				return fmt.Errorf("lines %d-%d: segments are too close; start them at the same time the other ends, or further apart",
+					prev.line(), act.line())
 			}
 		}
+		prev = &actions[i]
 	}
 	return nil
 }
 
-func buildComplexFilter(actions []action) (string, error) {
+// buildComplexFilter returns the filter graph that performs the
+// actions: each span of the output is trimmed from the input, and
+// then they're all concatenated at once. Actions at the very start
+// or end of the input leave no empty spans to concatenate.
+func (e *edit) buildComplexFilter(actions []action) (string, error) {
 	if len(actions) == 0 {
 		return "", fmt.Errorf("no actions to perform")
 	}
+	for i, act := range actions {
+		if act.verb != CutVerb && act.verb != MuteVerb {
+			return "", fmt.Errorf("action %d: unsupported verb '%s'", i, act.verb)
+		}
+	}
+	spans := e.outputSpans(actions)
+	if len(spans) == 0 {
+		return "", fmt.Errorf("nothing is left of the video after the cuts")
+	}
 
-	var s string
-	var segmentCounter int
-
-	vidSegment := func() string { return fmt.Sprintf("video%d", segmentCounter) }
-	audSegment := func() string { return fmt.Sprintf("audio%d", segmentCounter) }
-	prevVidSegment := func(n int) string { return fmt.Sprintf("video%d", segmentCounter+n) }
-	prevAudSegment := func(n int) string { return fmt.Sprintf("audio%d", segmentCounter+n) }
+	// filters applied to every video segment right after it is
+	// trimmed, while it still has its original timestamps
+	videoIn := chain(e.videoInputFilters())
+	audioIn := chain(e.audioInputFilters())
+
+	// trim each span of the output into its own segment; muted
+	// spans get their audio from the mute source instead. The
+	// labels of the segments say where they came from, like
+	// v2_after_cut_l12 for the third video segment, which comes
+	// after the cut on line 12 of the filter file
+	var s, videoSegments, audioSegments string
+	for i, sp := range spans {
+		trim := "start=" + e.secondString(sp.start)
+		if !sp.open {
+			trim += ":end=" + e.secondString(sp.end)
+		}
+		origin := spanOrigin(actions, sp)
+		s += fmt.Sprintf("[0:v]trim=%s%s,setpts=PTS-STARTPTS[v%d_%s];", trim, videoIn, i, origin)
+		if sp.mute {
+			s += fmt.Sprintf("[1:a]atrim=%s,asetpts=PTS-STARTPTS%s[a%d_%s];", trim, chain(e.muteSourceFilters()), i, origin)
+		} else {
+			s += fmt.Sprintf("[%s]atrim=%s%s,asetpts=PTS-STARTPTS[a%d_%s];", e.audioSpec(0), trim, audioIn, i, origin)
+		}
+		videoSegments += fmt.Sprintf("[v%d_%s]", i, origin)
+		audioSegments += fmt.Sprintf("[a%d_%s]", i, origin)
+	}
 
-	// beginning of video
-	firstSec := actions[0].start.SecondString()
-	s += fmt.Sprintf("[0:v]trim=duration=%s[%s];[0:a]atrim=duration=%s[%s];",
-		firstSec, vidSegment(), firstSec, audSegment())
+	// concatenate the segments into the output
+	s += fmt.Sprintf("%sconcat=n=%d%s[outv];%sconcat=n=%d:v=0:a=1%s[outa]",
+		videoSegments, len(spans), chain(e.videoOutputFilters()),
+		audioSegments, len(spans), chain(e.audioOutputFilters()))
 
-	// trim for each action
-	for i, act := range actions {
-		switch act.verb {
-		case CutVerb:
-			// cut out this segment by splicing in the segments around it,
-			// concatenating as we go (concats are themselves new segments)
-			if i > 0 {
-				// before it
-				segmentCounter++
-				s += fmt.Sprintf("[0:v]trim=start=%s:end=%s,setpts=PTS-STARTPTS[%s];[0:a]atrim=start=%s:end=%s,asetpts=PTS-STARTPTS[%s];",
-					actions[i-1].end.SecondString(), act.start.SecondString(), vidSegment(),
-					actions[i-1].end.SecondString(), act.start.SecondString(), audSegment())
-				segmentCounter++
-				s += fmt.Sprintf("[%s][%s]concat[%s];[%s][%s]concat=v=0:a=1[%s];",
-					prevVidSegment(-2), prevVidSegment(-1), vidSegment(),
-					prevAudSegment(-2), prevAudSegment(-1), audSegment())
-			}
-			if i < len(actions)-1 && actions[i+1].verb != CutVerb {
-				// after it
-				segmentCounter++
-				s += fmt.Sprintf("[0:v]trim=start=%s:end=%s,setpts=PTS-STARTPTS[%s];[0:a]atrim=start=%s:end=%s,asetpts=PTS-STARTPTS[%s];",
-					act.end.SecondString(), actions[i+1].start.SecondString(), vidSegment(),
-					act.end.SecondString(), actions[i+1].start.SecondString(), audSegment())
-				segmentCounter++
-				s += fmt.Sprintf("[%s][%s]concat[%s];[%s][%s]concat=v=0:a=1[%s];",
-					prevVidSegment(-2), prevVidSegment(-1), vidSegment(),
-					prevAudSegment(-2), prevAudSegment(-1), audSegment())
-			}
+	return s, nil
+}
 
-		case MuteVerb:
-			// mute this segment
-			segmentCounter++
-			s += fmt.Sprintf("[0:v]trim=start=%s:end=%s,setpts=PTS-STARTPTS[%s];[1:a]atrim=start=%s:end=%s,asetpts=PTS-STARTPTS[%s];",
-				act.start.SecondString(), act.end.SecondString(), vidSegment(),
-				act.start.SecondString(), act.end.SecondString(), audSegment())
+// videoInputFilters returns the filters to apply to the video
+// before performing any actions.
+func (e *edit) videoInputFilters() []string {
+	var filters []string
+	if deinterlacer := e.deinterlaceFilter(); deinterlacer != "" {
+		// must come before anything that moves pixels
+		// around, or the fields get mixed up
+		filters = append(filters, deinterlacer)
+	}
+	filters = append(filters, e.rotationFilters()...)
+	if e.opts.frameRate != "" {
+		filters = append(filters, "fps="+e.opts.frameRate)
+	}
+	// plugin effects come before burning in subtitles,
+	// so that the subtitles aren't affected
+	filters = append(filters, e.effects.video...)
+	if subs, _ := e.subtitlesFilter(); subs != "" {
+		filters = append(filters, subs)
+	}
+	return filters
+}
 
-			// concatenate segments; this is itself a new segment
-			segmentCounter++
-			s += fmt.Sprintf("[%s][%s]concat[%s];[%s][%s]concat=v=0:a=1[%s];",
-				prevVidSegment(-2), prevVidSegment(-1), vidSegment(),
-				prevAudSegment(-2), prevAudSegment(-1), audSegment())
+// audioInputFilters returns the filters to apply to the audio
+// before performing any actions.
+func (e *edit) audioInputFilters() []string {
+	return e.effects.audio
+}
 
-		default:
-			return s, fmt.Errorf("action %d: unsupported verb '%s'", i, act.verb)
-		}
+// videoOutputFilters returns the filters to apply to the video
+// after all the actions have been performed.
+func (e *edit) videoOutputFilters() []string {
+	var filters []string
+	if scaler, _ := e.scaleFilter(); scaler != "" {
+		// before tone mapping, so there are fewer pixels to map
+		filters = append(filters, scaler)
 	}
+	if e.opts.toneMap {
+		filters = append(filters, toneMapFilters...)
+	}
+	return filters
+}
 
-	// end of video
-	lastAction := actions[len(actions)-1]
-	segmentCounter++
-	s += fmt.Sprintf("[0:v]trim=start=%s,setpts=PTS-STARTPTS[%s];[0:a]atrim=start=%s,asetpts=PTS-STARTPTS[%s];",
-		lastAction.end.SecondString(), vidSegment(),
-		lastAction.end.SecondString(), audSegment())
-
-	// concatenate final output segment
-	s += fmt.Sprintf("[%s][%s]concat[%s];[%s][%s]concat=v=0:a=1[%s]",
-		prevVidSegment(-1), vidSegment(), "outv",
-		prevAudSegment(-1), audSegment(), "outa")
+// videoFilters returns all the filters to apply to the video
+// when the actions don't need any filters of their own.
+func (e *edit) videoFilters() []string {
+	return append(e.videoInputFilters(), e.videoOutputFilters()...)
+}
 
-	return s, nil
+// audioOutputFilters returns the filters to apply to the audio
+// after all the actions have been performed.
+func (e *edit) audioOutputFilters() []string {
+	var filters []string
+	if e.opts.fixSync {
+		// stretch or squeeze audio to match its timestamps
+		filters = append(filters, "aresample=async=1")
+	}
+	return filters
 }
 
-type token struct {
-	val     string
-	linePos int
-	charPos int
+// chain returns filters as a filter chain that can be appended
+// to another filter, or an empty string if there are no filters.
+func chain(filters []string) string {
+	if len(filters) == 0 {
+		return ""
+	}
+	return "," + strings.Join(filters, ",")
 }
 
 type action struct {
-	tokens []token
+	syntax *actionNode
 	verb   Verb
 	start  Time
 	end    Time
 	reason Reason
+	params []param // in the order they're given
+	lang   string  // the audio language it's for, like eng; "" for any
+
+	// the chapters (from 1) the times are relative to; 0 if they aren't
+	startChapter, endChapter int
+}
+
+// line returns the line of the filter file the action is on.
+func (a action) line() int {
+	return a.syntax.start.line
 }
 
 type Verb string
 
 const (
-	CutVerb  Verb = "cut"
-	MuteVerb      = "mute"
+	CutVerb          Verb = "cut"
+	MuteVerb              = "mute"
+	ChapterBreakVerb      = "chapterbreak"
+	ExtractVerb           = "extract"
+	BlurVerb              = "blur"
+	NoteVerb              = "note"
+	ScrambleVerb          = "scramble"
+	FadeOutVerb           = "fadeout"
+	FadeInVerb            = "fadein"
 )
 
 type Time struct {
@@ -368,14 +905,38 @@ func (t Time) String() string {
 	return fmt.Sprintf("%d:%2.2f", t.Minute, t.Second)
 }
 
-func (t Time) SecondString() string {
-	return fmt.Sprintf("%.2f", t.SecondNum())
+// secondsTime returns the Time that is sec seconds from the start.
+func secondsTime(sec float64) Time {
+	hour := int(sec / 3600)
+	min := int(sec/60) % 60
+	return Time{Hour: hour, Minute: min, Second: sec - float64(hour*3600+min*60)}
+}
+
+// secondString formats the time in seconds for ffmpeg,
+// as precisely as -precision says.
+func (e *edit) secondString(t Time) string {
+	sec := t.SecondNum()
+	if e.opts.timePrecision == "frame" {
+		// frame rates aren't whole numbers of milliseconds
+		// per frame, so give the frame's time more precisely
+		if video := e.inputInfo.stream("video"); video != nil && !video.variableFrameRate() {
+			if fps := parseRate(video.AvgFrameRate); fps > 0 {
+				sec = math.Round(sec*fps) / fps
+			}
+		}
+		return strconv.FormatFloat(sec, 'f', 6, 64)
+	}
+	digits, _ := strconv.Atoi(e.opts.timePrecision)
+	return strconv.FormatFloat(sec, 'f', digits, 64)
 }
 
 func (t Time) SecondNum() float64 {
 	return float64(t.Hour*60*60+t.Minute*60) + t.Second
 }
 
+// maxHours is how long a time may be, far longer than any video.
+const maxHours = 100000
+
 func ParseTime(timeStr string) (Time, error) {
 	timeStr = strings.TrimSpace(timeStr)
 
@@ -396,13 +957,13 @@ func ParseTime(timeStr string) (Time, error) {
 
 	switch len(parts) {
 	case 1:
-		sec, err = strconv.ParseFloat(parts[0], 32)
+		sec, err = strconv.ParseFloat(parts[0], 64)
 	case 2:
 		min, err = strconv.Atoi(parts[0])
 		if err != nil {
 			return Time{}, fmt.Errorf("bad minute value %s: %v", parts[0], err)
 		}
-		sec, err = strconv.ParseFloat(parts[1], 32)
+		sec, err = strconv.ParseFloat(parts[1], 64)
 	case 3:
 		hour, err = strconv.Atoi(parts[0])
 		if err != nil {
@@ -412,13 +973,20 @@ func ParseTime(timeStr string) (Time, error) {
 		if err != nil {
 			return Time{}, fmt.Errorf("bad minute value %s: %v", parts[1], err)
 		}
-		sec, err = strconv.ParseFloat(parts[2], 32)
+		sec, err = strconv.ParseFloat(parts[2], 64)
 	default:
 		return Time{}, fmt.Errorf("bad time format '%s'", timeStr)
 	}
 	if err != nil {
 		return Time{}, fmt.Errorf("bad second value %s: %v", parts[len(parts)-1], err)
 	}
+	if hour < 0 || min < 0 || sec < 0 {
+		return Time{}, fmt.Errorf("time '%s' is negative", timeStr)
+	}
+	// (and so that the time in seconds doesn't overflow)
+	if math.IsNaN(sec) || math.IsInf(sec, 0) || hour > maxHours || min > maxHours*60 || sec > maxHours*60*60 {
+		return Time{}, fmt.Errorf("time '%s' is out of range", timeStr)
+	}
 
 	return Time{Hour: hour, Minute: min, Second: sec}, nil
 }
@@ -453,6 +1021,49 @@ func ParseReason(reasonStr string) (Reason, error) {
 }
 
 var verbs = map[string]Verb{
-	"cut":  CutVerb,
-	"mute": MuteVerb,
+	"cut":          CutVerb,
+	"mute":         MuteVerb,
+	"chapterbreak": ChapterBreakVerb,
+	"extract":      ExtractVerb,
+	"blur":         BlurVerb,
+	"scramble":     ScrambleVerb,
+	"fadeout":      FadeOutVerb,
+	"fadein":       FadeInVerb,
+	"note":         NoteVerb,
+}
+
+var engines = map[string]func(e *edit, actions []action) error{
+	"filtergraph": (*edit).runFilterGraph,
+	"concat":      (*edit).runConcat,
+	"select":      (*edit).runSelect,
+}
+
+var subcommands = map[string]func(args []string) error{
+	"add":             addCmd,
+	"align":           alignCmd,
+	"apply-template":  applyTemplateCmd,
+	"bench":           benchCmd,
+	"compare":         compareCmd,
+	"detect-intros":   detectIntrosCmd,
+	"export":          exportCmd,
+	"graph":           graphCmd,
+	"history":         historyCmd,
+	"keygen":          keygenCmd,
+	"library":         libraryCmd,
+	"mark":            markCmd,
+	"probe":           probeCmd,
+	"quick":           quickCmd,
+	"rating":          ratingCmd,
+	"restore":         restoreCmd,
+	"run":             runCmd,
+	"selftest":        selftestCmd,
+	"serve":           serveCmd,
+	"setup":           setupCmd,
+	"sign":            signCmd,
+	"stats":           statsCmd,
+	"suggest-blur":    suggestBlurCmd,
+	"transcribe-scan": transcribeScanCmd,
+	"upgrade-filter":  upgradeFilterCmd,
+	"verify":          verifyCmd,
+	"waveforms":       waveformsCmd,
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// manifestEntry is an episode in the manifest of the outputs that
+// apply-template writes with -manifest: the full SHA-256 hashes of
+// its files (the history only fingerprints inputs), the durations of
+// the input and output, and the options of its edit. With these, a
+// library of edited files can be checked later against their filter
+// files, and whether each one was made from the input it says.
+type manifestEntry struct {
+	Input          string   `json:"input"`
+	InputHash      string   `json:"input_hash,omitempty"`
+	InputDuration  float64  `json:"input_duration,omitempty"`
+	Filter         string   `json:"filter"`
+	FilterHash     string   `json:"filter_hash,omitempty"`
+	Output         string   `json:"output"`
+	OutputHash     string   `json:"output_hash,omitempty"`
+	OutputDuration float64  `json:"output_duration,omitempty"`
+	Args           []string `json:"args"`
+	Result         string   `json:"result"` // "ok" or the error
+}
+
+// newManifestEntry returns the manifest entry of the episode's edit
+// with args, which wrote the filter file and output (unless it ended
+// with the error). Files that can't be read are left out.
+func newManifestEntry(episode, filterFile, output string, args []string, editErr error) manifestEntry {
+	entry := manifestEntry{
+		Input:  absPath(episode),
+		Filter: absPath(filterFile),
+		Output: absPath(output),
+		Args:   args,
+		Result: "ok",
+	}
+	if editErr != nil {
+		entry.Result = editErr.Error()
+	}
+	entry.InputHash, _ = fileHash(episode)
+	entry.FilterHash, _ = fileHash(filterFile)
+	if info, err := probe(episode); err == nil {
+		entry.InputDuration = info.Format.duration()
+	}
+	if editErr == nil {
+		entry.OutputHash, _ = fileHash(output)
+		if info, err := probe(output); err == nil {
+			entry.OutputDuration = info.Format.duration()
+		}
+	}
+	return entry
+}
+
+// writeManifest writes the manifest entries to the file, as CSV if
+// its name ends in .csv, otherwise as JSON.
+func writeManifest(filename string, entries []manifestEntry) error {
+	if !strings.EqualFold(filepath.Ext(filename), ".csv") {
+		data, err := json.MarshalIndent(entries, "", "\t")
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filename, append(data, '\n'), 0644)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(f)
+	w.Write([]string{"input", "input_hash", "input_duration", "filter", "filter_hash",
+		"output", "output_hash", "output_duration", "args", "result"})
+	duration := func(sec float64) string {
+		if sec == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(sec, 'f', 3, 64)
+	}
+	for _, e := range entries {
+		w.Write([]string{e.Input, e.InputHash, duration(e.InputDuration), e.Filter, e.FilterHash,
+			e.Output, e.OutputHash, duration(e.OutputDuration), strings.Join(e.Args, " "), e.Result})
+	}
+	w.Flush()
+	err = w.Error()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// markKeys are the keys that vidagent mark binds in mpv, and the
+// messages they send it, with the time of the playhead for the marks.
+const markKeys = `s script-message vidagent start ${=time-pos}
+e script-message vidagent end ${=time-pos}
+c script-message vidagent verb cut
+m script-message vidagent verb mute
+b script-message vidagent verb blur
+u script-message vidagent undo
+`
+
+// markCmd plays the input in mpv, where keys mark the starts and ends
+// of actions as it plays, adding each one to the filter file when its
+// end is marked: s marks the start and e the end, c, m, and b choose
+// the verb, 1 to 9 choose the reason from -reasons (and 0 none), and
+// u takes back the last action added. mpv is controlled through its
+// JSON IPC socket, and shows what each key did.
+func markCmd(args []string) error {
+	fs := flag.NewFlagSet("mark", flag.ExitOnError)
+	input := fs.String("in", "", "the video to play")
+	filterFile := fs.String("filter", "", "add the actions to this filter file (created if it doesn't exist)")
+	reasonsList := fs.String("reasons", "language,violence,nudity,sex", "the reasons that the keys 1 to 9 choose, in order")
+	verbName := fs.String("verb", "mute", "the verb of the actions until another is chosen")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent mark -in <file> -filter <file> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *input == "" || *filterFile == "" {
+		fs.Usage()
+		return errors.New("input and filter files required (use -in and -filter)")
+	}
+	verb, ok := cfg.verb(*verbName)
+	if !ok {
+		return fmt.Errorf("-verb: unrecognized verb '%s'", *verbName)
+	}
+	var reasons []string
+	for _, r := range strings.Split(*reasonsList, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			reasons = append(reasons, r)
+		}
+	}
+	if len(reasons) > 9 {
+		return errors.New("-reasons: only 9 reasons have keys")
+	}
+	mpv, err := findTool("mpv")
+	if err != nil {
+		return fmt.Errorf("vidagent mark needs mpv: %v", err)
+	}
+
+	dir, err := makeTempDir("", "vidagent-mark-")
+	if err != nil {
+		return err
+	}
+	defer removeTempDir(dir)
+	keys := markKeys
+	for i, r := range reasons {
+		keys += fmt.Sprintf("%d script-message vidagent reason %s\n", i+1, r)
+	}
+	keys += "0 script-message vidagent reason\n"
+	inputConf := filepath.Join(dir, "input.conf")
+	err = os.WriteFile(inputConf, []byte(keys), 0644)
+	if err != nil {
+		return err
+	}
+	socket := filepath.Join(dir, "mpv.sock")
+	if runtime.GOOS == "windows" {
+		socket = fmt.Sprintf(`\\.\pipe\vidagent-mark-%d`, os.Getpid())
+	}
+
+	cmd := exec.Command(mpv, "--input-ipc-server="+socket, "--input-conf="+inputConf, "--really-quiet", "--", *input)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	if err != nil {
+		return fmt.Errorf("starting mpv: %v", err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	conn, err := dialMPV(socket, exited)
+	if err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+	defer conn.Close()
+
+	m := &marker{conn: conn, filterFile: *filterFile, verb: verb}
+	m.show(fmt.Sprintf("vidagent: s start, e end, c/m/b cut/mute/blur, 1-%d reason, u undo", len(reasons)))
+	logf("marking actions in %s; press s and e in mpv to mark the start and end of each", *filterFile)
+	err = m.run()
+	if err != nil {
+		cmd.Process.Kill()
+		<-exited
+		return err
+	}
+	<-exited
+	logf("added %d actions to %s", len(m.sizes), *filterFile)
+	return nil
+}
+
+// dialMPV connects to mpv's IPC socket, waiting for mpv to create
+// it, unless mpv exits first.
+func dialMPV(socket string, exited <-chan error) (io.ReadWriteCloser, error) {
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		var conn io.ReadWriteCloser
+		var err error
+		if runtime.GOOS == "windows" {
+			conn, err = os.OpenFile(socket, os.O_RDWR, 0)
+		} else {
+			conn, err = net.Dial("unix", socket)
+		}
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("connecting to mpv: %v", err)
+		}
+		select {
+		case err := <-exited:
+			return nil, fmt.Errorf("mpv exited before it could be controlled: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// marker adds the actions marked in mpv to the filter file.
+type marker struct {
+	conn       io.ReadWriteCloser
+	filterFile string
+
+	verb   Verb
+	reason string
+	start  float64
+	marked bool // whether start is marked
+
+	// the sizes of the filter file before each action was added
+	sizes []int64
+}
+
+// run handles the messages that the keys send until mpv quits.
+func (m *marker) run() error {
+	sc := bufio.NewScanner(m.conn)
+	for sc.Scan() {
+		var msg struct {
+			Event string   `json:"event"`
+			Args  []string `json:"args"`
+		}
+		if json.Unmarshal(sc.Bytes(), &msg) != nil || msg.Event != "client-message" ||
+			len(msg.Args) < 2 || msg.Args[0] != "vidagent" {
+			continue
+		}
+		err := m.handle(msg.Args[1], msg.Args[2:])
+		if err != nil {
+			return err
+		}
+	}
+	// (the socket closes when mpv quits)
+	return nil
+}
+
+// handle handles the message from a key.
+func (m *marker) handle(what string, args []string) error {
+	switch what {
+	case "start", "end":
+		if len(args) == 0 {
+			return nil
+		}
+		t, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			m.show("no time to mark")
+			return nil
+		}
+		if what == "start" {
+			m.start, m.marked = t, true
+			m.show(fmt.Sprintf("%s starts at %s", m.verb, clockString(t)))
+			return nil
+		}
+		if !m.marked {
+			m.show("press s to mark the start first")
+			return nil
+		}
+		if t <= m.start {
+			m.show(fmt.Sprintf("the end must come after the start (%s)", clockString(m.start)))
+			return nil
+		}
+		line := fmt.Sprintf("%s %s-%s", m.verb, clockString(m.start), clockString(t))
+		if m.reason != "" {
+			line += " (" + m.reason + ")"
+		}
+		err = m.add(line)
+		if err != nil {
+			return err
+		}
+		m.marked = false
+		m.show("added: " + line)
+	case "verb":
+		if len(args) > 0 {
+			m.verb = Verb(args[0])
+			m.show("verb: " + args[0])
+		}
+	case "reason":
+		m.reason = ""
+		if len(args) > 0 {
+			m.reason = args[0]
+		}
+		if m.reason == "" {
+			m.show("no reason")
+		} else {
+			m.show("reason: " + m.reason)
+		}
+	case "undo":
+		if len(m.sizes) == 0 {
+			m.show("nothing to undo")
+			return nil
+		}
+		size := m.sizes[len(m.sizes)-1]
+		err := os.Truncate(m.filterFile, size)
+		if err != nil {
+			return err
+		}
+		m.sizes = m.sizes[:len(m.sizes)-1]
+		m.show("took back the last action")
+	}
+	return nil
+}
+
+// add appends the line to the filter file.
+func (m *marker) add(line string) error {
+	f, err := os.OpenFile(m.filterFile, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	// (a file without a newline at its end gets one first)
+	if size > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, size-1); err == nil && last[0] != '\n' {
+			line = "\n" + line
+		}
+	}
+	_, err = f.WriteString(line + "\n")
+	if err != nil {
+		return err
+	}
+	m.sizes = append(m.sizes, size)
+	return nil
+}
+
+// show shows the message in mpv for a couple of seconds.
+func (m *marker) show(text string) {
+	cmd, _ := json.Marshal(map[string]any{"command": []any{"show-text", text, 3000}})
+	m.conn.Write(append(cmd, '\n'))
+}
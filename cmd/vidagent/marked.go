@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// With -marked-copy, an edit also writes an untouched copy of the
+// input with chapters that mark where the edits are, for viewers
+// who want the original but want to know where the content is.
+// Where the edit is one ffmpeg command, the copy is written by the
+// same command, so the input is only read once.
+
+// markedCopyArgs returns the ffmpeg arguments that make an edit's
+// command also write the marked copy, if there's one: the input of
+// its chapters, to add after the command's n other inputs, and the
+// output, to add at the end.
+func (e *edit) markedCopyArgs(n int) ([]string, []string) {
+	if e.markedChaptersFile == "" {
+		return nil, nil
+	}
+	e.markedCopyDone = true
+	in := []string{"-f", "ffmetadata", "-i", fileArg(e.markedChaptersFile)}
+	out := []string{"-map", "0", "-c", "copy", "-map_metadata", "0",
+		"-map_chapters", strconv.Itoa(n), fileArg(e.opts.markedCopy)}
+	return in, out
+}
+
+// writeMarkedChapters writes the chapters of the marked copy for the
+// edits to a temporary file, and returns a function that removes it.
+func (e *edit) writeMarkedChapters(edits []action) (func(), error) {
+	if _, err := os.Stat(e.opts.markedCopy); err == nil && !e.opts.overwrite {
+		return nil, fmt.Errorf("marked copy %s already exists (use -f to overwrite)", e.opts.markedCopy)
+	}
+	dir, err := makeTempDir(e.opts.tempRoot, "vidagent-marked-")
+	if err != nil {
+		return nil, err
+	}
+	e.markedChaptersFile = filepath.Join(dir, "chapters.txt")
+	err = os.WriteFile(e.markedChaptersFile, []byte(markedChapters(edits, e.inputInfo.Format.duration())), 0644)
+	if err != nil {
+		removeTempDir(dir)
+		return nil, err
+	}
+	return func() { removeTempDir(dir) }, nil
+}
+
+// writeMarkedCopy writes the marked copy in its own ffmpeg command,
+// for edits that aren't made in one.
+func (e *edit) writeMarkedCopy() error {
+	in, out := e.markedCopyArgs(1)
+	args := []string{e.overwriteArg(), "-i", fileArg(e.opts.inputFile)}
+	args = append(args, in...)
+	args = append(args, out...)
+	e.setStage("copying", e.inputInfo.Format.duration())
+	return e.runFFmpeg(args, e.opts.markedCopy)
+}
+
+// markedChapters returns the chapters, in ffmpeg's metadata format,
+// of the input (which is duration seconds long, if known): one for
+// each edit, or edits that overlap, and one for each part between
+// them.
+func markedChapters(edits []action, duration float64) string {
+	edits = slices.Clone(edits)
+	slices.SortStableFunc(edits, func(a, b action) int {
+		switch {
+		case a.start.SecondNum() < b.start.SecondNum():
+			return -1
+		case a.start.SecondNum() > b.start.SecondNum():
+			return 1
+		}
+		return 0
+	})
+
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	chapter := func(start, end float64, title string) {
+		if end-start < .001 {
+			return
+		}
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(start*1000+.5), int64(end*1000+.5), escapeMetadata(title))
+	}
+	var pos float64
+	for i := 0; i < len(edits); {
+		start, end := edits[i].start.SecondNum(), edits[i].end.SecondNum()
+		var titles []string
+		for ; i < len(edits) && edits[i].start.SecondNum() < end+.001; i++ {
+			end = max(end, edits[i].end.SecondNum())
+			title := string(edits[i].verb)
+			if edits[i].reason.Category != "" {
+				title += ": " + cfg.reasonName(edits[i].reason)
+			}
+			if !slices.Contains(titles, title) {
+				titles = append(titles, title)
+			}
+		}
+		chapter(pos, start, "unedited")
+		chapter(start, end, strings.Join(titles, ", "))
+		pos = end
+	}
+	if duration > pos {
+		chapter(pos, duration, "unedited")
+	}
+	return b.String()
+}
+
+// escapeMetadata escapes the characters that are special in
+// ffmpeg's metadata format.
+func escapeMetadata(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("=;#\\\n", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// metadataArgs returns the output options that carry the input's
+// global metadata, chapters, stream languages, cover art, and
+// attachments (like fonts for subtitles) through to the output;
+// without them, the edited file would lose its library metadata.
+// inputIndex is the ffmpeg input number of the input file, and
+// mapped is how many output streams are already mapped.
+func (e *edit) metadataArgs(actions []action, inputIndex, mapped int) []string {
+	in := strconv.Itoa(inputIndex)
+	args := []string{"-map_metadata", in}
+
+	// chapter times would be wrong after cutting or splitting
+	if hasVerb(actions, CutVerb) || e.opts.splitOutput {
+		args = append(args, "-map_chapters", "-1")
+	} else {
+		args = append(args, "-map_chapters", in)
+	}
+
+	audio, _ := e.audioStream()
+	for _, st := range []*probeStream{e.inputInfo.stream("video"), audio} {
+		if st != nil && st.Tags["language"] != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:%c:0", st.CodecType[0]), "language="+st.Tags["language"])
+		}
+	}
+
+	for _, st := range e.inputInfo.Streams {
+		// (HLS segments can't have cover art)
+		if st.Disposition.AttachedPic == 1 && !isHLS(e.opts.outputFile) {
+			args = append(args,
+				"-map", fmt.Sprintf("%s:%d", in, st.Index),
+				fmt.Sprintf("-c:%d", mapped), "copy",
+				fmt.Sprintf("-disposition:%d", mapped), "attached_pic")
+			mapped++
+		}
+	}
+
+	if e.filterHash != "" {
+		// so a later run can tell the output already has these edits
+		args = append(args, "-metadata", filterHashTag+"="+e.filterHash)
+		switch strings.ToLower(filepath.Ext(e.opts.outputFile)) {
+		case ".mp4", ".m4v", ".mov":
+			// otherwise custom tags are dropped
+			args = append(args, "-movflags", "+use_metadata_tags")
+		}
+	}
+
+	// only Matroska can store attachments
+	switch strings.ToLower(filepath.Ext(e.opts.outputFile)) {
+	case ".mkv", ".mka", ".mks":
+		args = append(args, "-map", in+":t?", "-c:t", "copy")
+	}
+
+	return args
+}
+
+// filterHashTag is the global metadata tag that records
+// the hash of the filter file that made the output.
+const filterHashTag = "vidagent_filter"
+
+// alreadyProcessed returns true if file exists and was made
+// with the filter file that has the given hash.
+func alreadyProcessed(file, hash string) bool {
+	if _, err := os.Stat(file); err != nil {
+		return false
+	}
+	info, err := probe(file)
+	if err != nil {
+		return false
+	}
+	for key, val := range info.Format.Tags {
+		// (Matroska tags are upper case)
+		if strings.EqualFold(key, filterHashTag) && val == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// hasVerb returns true if any action has the given verb.
+func hasVerb(actions []action, verb Verb) bool {
+	for _, act := range actions {
+		if act.verb == verb {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// With -mute-fill, muted segments get quiet music instead of dead
+// silence, which stands out less in quiet scenes. The music is looped
+// for as long as the input needs it.
+
+// muteSourceArgs returns the ffmpeg arguments of the input that muted
+// segments get their audio from: the -mute-fill music, or silence.
+func (e *edit) muteSourceArgs() []string {
+	if e.opts.muteFill == "" {
+		return []string{"-f", "lavfi", "-i", "anullsrc"}
+	}
+	return []string{"-stream_loop", "-1", "-i", fileArg(e.opts.muteFill)}
+}
+
+// muteSourceFilters returns the filters to apply to the audio of
+// muted segments from the mute source.
+func (e *edit) muteSourceFilters() []string {
+	if e.opts.muteFill == "" {
+		return nil
+	}
+	return []string{"volume=" + strconv.FormatFloat(e.opts.muteFillVolume, 'f', -1, 64)}
+}
+
+// muteFillMix returns the filters that mix the -mute-fill music, from
+// the input numbered in, into the audio labeled muted, only during the
+// mutes. The result is left unlabeled, to be continued by the caller.
+func (e *edit) muteFillMix(muted string, in int, mutes []action) string {
+	return fmt.Sprintf("[%d:a]volume=0:enable='not(%s)'%s[fill];[%s][fill]amix=inputs=2:duration=first:normalize=0",
+		in, e.timeExpr(mutes), chain(e.muteSourceFilters()), muted)
+}
+
+// muteSegmentArgs returns the ffmpeg arguments for the audio of a
+// muted segment that's extracted on its own, to be joined to the
+// others: silenced, or the -mute-fill music, in the same sample
+// rate and channels as the input's audio.
+func (e *edit) muteSegmentArgs() []string {
+	if e.opts.muteFill == "" {
+		return []string{"-af", "volume=0"}
+	}
+	args := []string{"-af", strings.Join(e.muteSourceFilters(), ",")}
+	if st, _ := e.audioStream(); st != nil && st.SampleRate != "" && st.Channels > 0 {
+		args = append(args, "-ar", st.SampleRate, "-ac", strconv.Itoa(st.Channels))
+	}
+	return args
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directives are lines in a filter file that start with @ instead of
+// a verb; they apply to the whole file. "@offset 2.5s" shifts every
+// action later by 2.5 seconds (or earlier, if negative), and
+// "@scale 25/23.976" multiplies every time by the factor, for
+// releases that run at a different speed. Times are scaled first.
+
+// takeDirectives returns the time scale and offset (in seconds)
+// set by the directives in the filter file, except those l skips.
+func takeDirectives(tree *syntaxTree, l *lenientParse) (float64, float64, error) {
+	scale, offset := 1.0, 0.0
+	for _, line := range tree.lines {
+		if line.directive == nil {
+			continue
+		}
+		s, off, err := directive(line.directive)
+		if err != nil {
+			if l.skip(err) {
+				continue
+			}
+			return 0, 0, err
+		}
+		scale *= s
+		offset += off
+	}
+	return scale, offset, nil
+}
+
+// directive returns the time scale and offset that d sets.
+func directive(d *directiveNode) (float64, float64, error) {
+	if strings.EqualFold(d.name.text, "@vidagent") {
+		// checked before parsing; see checkRequiredVersion
+		return 1, 0, nil
+	}
+	if strings.EqualFold(d.name.text, "@def") {
+		// the variables are used by getActions
+		_, _, err := definition(d)
+		return 1, 0, err
+	}
+	if len(d.args) != 1 {
+		return 0, 0, fmt.Errorf("line %d: directive %s needs one value", d.start.line, d.name.text)
+	}
+	arg := d.args[0]
+	scale, offset := 1.0, 0.0
+	var err error
+	switch strings.ToLower(d.name.text) {
+	case "@offset":
+		offset, err = parseOffset(arg.text)
+	case "@scale":
+		scale, err = parseScale(arg.text)
+	default:
+		return 0, 0, fmt.Errorf("line %d:%d: unrecognized directive '%s'", d.start.line, d.start.col, d.name.text)
+	}
+	if err != nil {
+		return 0, 0, fmt.Errorf("line %d:%d: %v", arg.start.line, arg.start.col, err)
+	}
+	return scale, offset, nil
+}
+
+// parseOffset parses a time offset, either a duration like
+// "+2.5s" or "-1m3s" or a number of seconds.
+func parseOffset(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return d.Seconds(), nil
+	}
+	sec, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad offset '%s'", s)
+	}
+	return sec, nil
+}
+
+// parseScale parses a time scale factor, either a number or a
+// ratio like "25/23.976"; it must be positive.
+func parseScale(s string) (float64, error) {
+	scale := parseRate(strings.TrimSpace(s))
+	if scale <= 0 {
+		return 0, fmt.Errorf("bad time scale '%s'", s)
+	}
+	return scale, nil
+}
+
+// shiftActions scales the times of the actions by scale and then
+// shifts them by offset seconds, except times relative to chapters,
+// which aren't shifted. Actions that end up entirely before the start
+// of the video are dropped, and those that end up partly before it
+// are trimmed to start at zero.
+func (e *edit) shiftActions(actions []action, scale, offset float64) []action {
+	if scale == 1 && offset == 0 {
+		return actions
+	}
+	shift := func(t Time, chapter int) float64 {
+		if chapter > 0 {
+			// the chapter starts where it does in the input, so
+			// only the time into the chapter is scaled
+			chStart := e.chapterStarts[chapter-1]
+			return chStart + (t.SecondNum()-chStart)*scale
+		}
+		return t.SecondNum()*scale + offset
+	}
+
+	var shifted []action
+	for _, act := range actions {
+		start, end := shift(act.start, act.startChapter), shift(act.end, act.endChapter)
+		// (markers are a point in time, so they can be at zero)
+		if end < 0 || (end == 0 && act.verb != ChapterBreakVerb) {
+			log.Printf("line %d: %s action is before the start of the video after shifting; skipping it",
+				act.line(), act.verb)
+			continue
+		}
+		act.start, act.end = secondsTime(max(start, 0)), secondsTime(end)
+		shifted = append(shifted, act)
+	}
+	return shifted
+}
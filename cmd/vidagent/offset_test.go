@@ -0,0 +1,24 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestShiftedTimesValidated makes sure that the times of a filter file
+// are validated again after they're shifted, since times relative to
+// chapters aren't, which can make segments overlap that didn't.
+func TestShiftedTimesValidated(t *testing.T) {
+	e := newEdit(defaultOptions())
+	e.chapterStarts, e.chaptersProbed = []float64{0, 60}, true
+
+	const filter = "mute ch2+0:05-ch2+0:10 (language)\ncut 1:10-1:20 (violence)\n"
+	_, err := e.parseFilter(strings.NewReader(filter), 1, 0)
+	if err != nil {
+		t.Fatalf("without an offset: %v", err)
+	}
+	_, err = e.parseFilter(strings.NewReader(filter), 1, -10)
+	if err == nil || !strings.HasPrefix(err.Error(), "lines 1-2: segments overlap") {
+		t.Errorf("with an offset that makes the segments overlap, got %v", err)
+	}
+}
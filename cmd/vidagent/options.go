@@ -0,0 +1,369 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// options are how an edit is made, as set by the command line flags.
+type options struct {
+	inputFile, outputFile, filterFile string
+	filterRepo, verifyKey             string
+	inlineActions                     actionFlags
+	policyFile, profilesFile          string
+	outProfiles                       outProfiles
+	onlyLines, onlyVerbs              string
+	onlyCategories                    string
+	timeOffset, timeScale             string
+	overwrite, noSpaceCheck           bool
+	noHistory, lenient, noColor       bool
+	lowPriority, streamCopy, toneMap  bool
+	checkSyncAfter, fixSync           bool
+	checkQuality                      bool
+	minSSIM                           float64
+	muteFill, markedCopy              string
+	muteFillVolume                    float64
+	splitOutput                       bool
+	timeout, stallTimeout             time.Duration
+	engine                            string
+	frameRate                         string
+	rotationMode                      string
+	deinterlace, outputSize, burnSubs string
+	extractFormat                     string
+	snapTo                            string
+	timePrecision                     string
+	tempRoot                          string
+	resumeTemp                        bool
+	snapWindow                        time.Duration
+	maxHeight, threads                int
+	progressJSON, verbose             bool
+	onSuccess, onFailure              string
+	dumpGraph                         string
+	archiveDir                        string
+	dynamicHDR                        string
+	inputFormat, inputSize            string
+	inputPixFmt, inputFrameRate       string
+	inputSampleRate, inputChannels    int
+	excerptFrom, excerptTo            string
+	audioLang                         string
+	largeEditPercent                  float64
+	largeEditMinutes                  float64
+	allowLargeEdits                   bool
+	videoCodec, crf, preset           string
+}
+
+// defaultOptions returns the options of an edit
+// that no flags have been given for.
+func defaultOptions() *options {
+	return &options{
+		timeOffset:     "0",
+		timeScale:      "1",
+		engine:         "auto",
+		dynamicHDR:     "warn",
+		rotationMode:   "bake",
+		extractFormat:  "mp4",
+		timePrecision:  "3",
+		snapWindow:     time.Second,
+		minSSIM:        0.95,
+		muteFillVolume: 0.1,
+
+		largeEditPercent: 25,
+		largeEditMinutes: 20,
+	}
+}
+
+// An option sets some of the options of an edit, for making one
+// without the command line, like a server or a test does.
+type option func(*options) error
+
+// newOptions returns the default options with opts applied to them,
+// or an error if they can't make an edit.
+func newOptions(opts ...option) (*options, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// withFlags sets the options that the flags in args give,
+// parsing them with the edit's flags defined in fs.
+func withFlags(fs *flag.FlagSet, args []string) option {
+	return func(o *options) error {
+		o.register(fs)
+		return fs.Parse(args)
+	}
+}
+
+// withInput sets the input file.
+func withInput(file string) option {
+	return func(o *options) error {
+		o.inputFile = file
+		return nil
+	}
+}
+
+// withOutput sets the output file.
+func withOutput(file string) option {
+	return func(o *options) error {
+		o.outputFile = file
+		return nil
+	}
+}
+
+// withFilter sets the filter file, and the actions to add to it,
+// like -action does.
+func withFilter(file string, actions ...string) option {
+	return func(o *options) error {
+		o.filterFile = file
+		o.inlineActions = append(o.inlineActions, actions...)
+		return nil
+	}
+}
+
+// withEngine sets the engine, which may be auto.
+func withEngine(name string) option {
+	return func(o *options) error {
+		o.engine = name
+		return nil
+	}
+}
+
+// withVideoCodec sets the encoder of the video, and its -crf and
+// -preset, any of which may be "" for the default.
+func withVideoCodec(codec, crf, preset string) option {
+	return func(o *options) error {
+		o.videoCodec, o.crf, o.preset = codec, crf, preset
+		return nil
+	}
+}
+
+// withProfiles makes the outputs of the edit those of the viewer
+// profiles in the profiles file, given as PROFILE=FILE like
+// -out-profile's.
+func withProfiles(profilesFile string, outputs ...string) option {
+	return func(o *options) error {
+		o.profilesFile = profilesFile
+		for _, s := range outputs {
+			if err := o.outProfiles.Set(s); err != nil {
+				return fmt.Errorf("-out-profile: %v", err)
+			}
+		}
+		return nil
+	}
+}
+
+// register defines the flags that set the options in fs.
+func (o *options) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.inputFile, "in", o.inputFile, "the input file, or an image sequence like frames/%05d.png or frames/*.png")
+	fs.StringVar(&o.inputFormat, "in-format", o.inputFormat, "the format of a raw input: rawvideo, or a PCM format like s16le or f32le (.yuv and .pcm files are rawvideo and s16le)")
+	fs.StringVar(&o.inputFrameRate, "fps", o.inputFrameRate, "the frame rate of an image sequence or raw video input (e.g. 24 or 24000/1001)")
+	fs.StringVar(&o.inputSize, "in-size", o.inputSize, "the size of a raw video input, as WIDTHxHEIGHT")
+	fs.StringVar(&o.inputPixFmt, "in-pix-fmt", o.inputPixFmt, "the pixel format of a raw video input (default yuv420p)")
+	fs.IntVar(&o.inputSampleRate, "in-sample-rate", o.inputSampleRate, "the sample rate of a raw audio input")
+	fs.IntVar(&o.inputChannels, "in-channels", o.inputChannels, "the number of channels of a raw audio input")
+	fs.StringVar(&o.outputFile, "out", o.outputFile, "the output file")
+	fs.StringVar(&o.filterFile, "filter", o.filterFile, "the filter file, or - to read it from standard input")
+	fs.Var(&o.inlineActions, "action", "add this action to the filter, like \"mute 1:00-1:05 (language)\" (can be given more than once)")
+	fs.StringVar(&o.filterRepo, "filter-repo", o.filterRepo, "if there's no -filter, download the filter file for the input from the repository at this URL")
+	fs.StringVar(&o.verifyKey, "verify-key", o.verifyKey, "only apply the filter file if it has a valid signature (in a .minisig file next to it) from this public key or key file")
+	fs.StringVar(&o.policyFile, "policy", o.policyFile, "decide the verbs of actions by their reasons according to this policy file")
+	fs.StringVar(&o.profilesFile, "profiles", o.profilesFile, "the file of viewer profiles that -out-profile names (the same as for vidagent serve)")
+	fs.Var(&o.outProfiles, "out-profile", "instead of -out, write an output with the policy of a viewer profile, as PROFILE=FILE (may be repeated)")
+	fs.StringVar(&o.excerptFrom, "from", o.excerptFrom, "only output the edited input from this time of the input (e.g. 41:00)")
+	fs.StringVar(&o.excerptTo, "to", o.excerptTo, "only output the edited input until this time of the input")
+	fs.StringVar(&o.audioLang, "audio-lang", o.audioLang, "edit the input's audio track in this language (e.g. spa), and apply the actions for it, like mute@spa")
+	fs.StringVar(&o.onlyLines, "only-lines", o.onlyLines, "only perform the actions on these lines of the filter file (e.g. 3,7-12)")
+	fs.StringVar(&o.onlyVerbs, "only-verb", o.onlyVerbs, "only perform the actions with these verbs (e.g. mute)")
+	fs.StringVar(&o.onlyCategories, "only-category", o.onlyCategories, "only perform the actions with these reason categories (e.g. language or violence:gore)")
+	fs.BoolVar(&o.lenient, "lenient", o.lenient, "skip the lines of the filter file that can't be used (like those from newer versions of vidagent) with a warning, instead of failing")
+	fs.BoolVar(&o.noColor, "no-color", o.noColor, "don't color the errors in the filter file, even on a terminal")
+	fs.StringVar(&o.timeOffset, "offset", o.timeOffset, "shift all the actions later by this much (e.g. +2.5s), or earlier if negative, for a different release of the video")
+	fs.StringVar(&o.timeScale, "time-scale", o.timeScale, "multiply all the action times by this factor (e.g. 23.976/25 for a PAL release of a film)")
+	fs.StringVar(&o.snapTo, "snap-to", o.snapTo, "move cut boundaries to nearby transitions of these kinds: scene, black, and/or silence")
+	fs.DurationVar(&o.snapWindow, "snap-window", o.snapWindow, "how far -snap-to may move a cut boundary")
+	fs.StringVar(&o.timePrecision, "precision", o.timePrecision, "how precisely to give times to ffmpeg: a number of decimal places, or frame to round to the nearest frame of the input")
+	fs.BoolVar(&o.overwrite, "f", o.overwrite, "force overwrite of output file if it exists")
+	fs.StringVar(&o.tempRoot, "tmpdir", o.tempRoot, "put temporary files, which can be as big as the output, in this directory")
+	fs.BoolVar(&o.resumeTemp, "resume", o.resumeTemp, "keep temporary files if the edit fails, and reuse them when the same edit is run again")
+	fs.BoolVar(&o.noSpaceCheck, "no-space-check", o.noSpaceCheck, "skip checking for enough free disk space before starting")
+	fs.BoolVar(&o.noHistory, "no-history", o.noHistory, "don't record this run in the history (see vidagent history)")
+	fs.StringVar(&o.engine, "engine", o.engine, "how to perform the edits: filtergraph, concat, select, or auto to choose one for the edits and input")
+	fs.BoolVar(&o.streamCopy, "copy", o.streamCopy, "copy streams without re-encoding where possible (concat engine only; cuts snap to keyframes)")
+	fs.StringVar(&o.markedCopy, "marked-copy", o.markedCopy, "also write an untouched copy of the input to this file, with chapters marking where the edits are")
+	fs.BoolVar(&o.splitOutput, "split", o.splitOutput, "write each part of the output between cuts (or chapterbreak markers, if any) to its own numbered file")
+	fs.StringVar(&o.extractFormat, "extract-format", o.extractFormat, "the format of clips saved by extract actions: mp4 or gif")
+	fs.BoolVar(&o.checkSyncAfter, "check-sync", o.checkSyncAfter, "after encoding, report whether the audio and video have drifted apart")
+	fs.BoolVar(&o.checkQuality, "check-quality", o.checkQuality, "after encoding, compare a few places in the output that weren't edited with the input, and warn if they lost too much quality")
+	fs.Float64Var(&o.largeEditPercent, "large-edit-percent", o.largeEditPercent, "ask before cutting more than this percent of the input (0 to never ask)")
+	fs.Float64Var(&o.largeEditMinutes, "large-edit-minutes", o.largeEditMinutes, "ask before cutting more than this many minutes of the input (0 to never ask)")
+	fs.BoolVar(&o.allowLargeEdits, "allow-large-edits", o.allowLargeEdits, "cut however much the filter file says without asking")
+	fs.Float64Var(&o.minSSIM, "min-ssim", o.minSSIM, "the lowest SSIM (from 0 to 1) that -check-quality accepts")
+	fs.StringVar(&o.muteFill, "mute-fill", o.muteFill, "fill muted segments with quiet audio from this music file, looped as needed, instead of silence")
+	fs.Float64Var(&o.muteFillVolume, "mute-fill-volume", o.muteFillVolume, "the volume of -mute-fill, as a fraction of the music's own")
+	fs.BoolVar(&o.fixSync, "fix-sync", o.fixSync, "resample audio to keep it in sync with the video across edits")
+	fs.StringVar(&o.videoCodec, "video-codec", o.videoCodec, "the ffmpeg encoder of the video when it's re-encoded (e.g. libx264; by default ffmpeg's for the output, or libx265 for HDR)")
+	fs.StringVar(&o.crf, "crf", o.crf, "the quality of the re-encoded video, as the encoder's constant rate factor (lower is better, e.g. 18)")
+	fs.StringVar(&o.preset, "preset", o.preset, "the encoder's preset for the re-encoded video, trading speed for size (e.g. slow)")
+	fs.StringVar(&o.frameRate, "cfr", o.frameRate, "convert the video to this constant frame rate (e.g. 30 or 24000/1001); variable frame rate input is converted to its average frame rate automatically")
+	fs.BoolVar(&o.toneMap, "tonemap", o.toneMap, "convert HDR video to SDR instead of preserving HDR (requires ffmpeg with zimg)")
+	fs.StringVar(&o.dynamicHDR, "dynamic-hdr", o.dynamicHDR, "if re-encoding would lose the input's Dolby Vision or HDR10+ metadata: warn, copy the video instead (like -copy), or drop it")
+	fs.StringVar(&o.deinterlace, "deinterlace", o.deinterlace, "deinterlace the video with yadif or bwdif, or auto to use bwdif if the input is interlaced")
+	fs.StringVar(&o.outputSize, "scale", o.outputSize, "resize the video to WIDTHxHEIGHT (use -2 for either to keep the aspect ratio)")
+	fs.IntVar(&o.maxHeight, "max-height", o.maxHeight, "downscale the video, keeping its aspect ratio, if it is taller than this")
+	fs.StringVar(&o.burnSubs, "burn-subs", o.burnSubs, "burn in subtitles from this subtitle track number of the input (starting at 0) or subtitles file")
+	fs.StringVar(&o.rotationMode, "rotation", o.rotationMode, "for rotated (e.g. phone) videos: bake to rotate the frames upright, or keep to keep the rotation metadata")
+	fs.IntVar(&o.threads, "threads", o.threads, "limit ffmpeg to this many threads for each of decoding, filtering, and encoding (0 for its default)")
+	fs.BoolVar(&o.lowPriority, "low-priority", o.lowPriority, "run ffmpeg at reduced CPU and IO priority")
+	fs.DurationVar(&o.timeout, "timeout", o.timeout, "kill ffmpeg if it runs longer than this (0 for no limit)")
+	fs.DurationVar(&o.stallTimeout, "stall-timeout", o.stallTimeout, "kill ffmpeg if it makes no progress for this long (0 for no limit)")
+	fs.BoolVar(&o.progressJSON, "progress-json", o.progressJSON, "report progress as lines of JSON on standard output")
+	fs.BoolVar(&o.verbose, "v", o.verbose, "explain the choices made automatically, like which engine to use")
+	fs.StringVar(&o.archiveDir, "archive", o.archiveDir, "also save small copies of the segments the edit cuts or changes in this folder, for vidagent restore")
+	fs.StringVar(&o.dumpGraph, "dump-graph", o.dumpGraph, "write the filter graph of the edit to this file, with its labels explained, or as Graphviz if it ends in .dot")
+	fs.StringVar(&o.onSuccess, "on-success", o.onSuccess, "run this shell command when the edit succeeds, with its details in VIDAGENT_ environment variables")
+	fs.StringVar(&o.onFailure, "on-failure", o.onFailure, "run this shell command when the edit fails, with its details in VIDAGENT_ environment variables")
+}
+
+// editFlags returns the flags of an edit, which set the options in o,
+// for a subcommand to take some or all of them too.
+func editFlags(o *options) *flag.FlagSet {
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	o.register(fs)
+	return fs
+}
+
+// args returns the flags that give the options that aren't the
+// defaults, like -engine=concat, except those named in except.
+func (o *options) args(except ...string) []string {
+	defaults := editFlags(defaultOptions())
+	var args []string
+	editFlags(o).VisitAll(func(f *flag.Flag) {
+		if !slices.Contains(except, f.Name) && f.Value.String() != defaults.Lookup(f.Name).Value.String() {
+			args = append(args, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	return args
+}
+
+// validate returns an error if the options can't make an edit,
+// whatever the input is. Options that depend on the input, like
+// whether -copy can be used with it, are checked once it's probed.
+func (o *options) validate() error {
+	if o.inputFile == "" {
+		return errors.New("input file required (use -in)")
+	}
+	if o.outputFile == "" && len(o.outProfiles) == 0 {
+		return errors.New("output file required (use -out or -out-profile)")
+	}
+	if len(o.outProfiles) > 0 {
+		if err := o.outProfiles.validate(o); err != nil {
+			return err
+		}
+	}
+	if o.audioLang != "" && !languagePattern.MatchString(o.audioLang) {
+		return fmt.Errorf("bad -audio-lang '%s'; it must be a code like eng", o.audioLang)
+	}
+	if err := validateRawInput(o); err != nil {
+		return err
+	}
+	if o.filterFile == "" && o.filterRepo == "" && len(o.inlineActions) == 0 {
+		return errors.New("filter file required (use -filter, -filter-repo, or -action)")
+	}
+	if o.archiveDir != "" && (o.splitOutput || isHLS(o.outputFile)) {
+		return errors.New("-archive can't be used with -split or HLS output")
+	}
+	if o.verifyKey != "" && (o.filterFile == "-" || len(o.inlineActions) > 0) {
+		return errors.New("-verify-key can only check filter files, not -filter - or -action")
+	}
+	if _, err := parseScale(o.timeScale); err != nil {
+		return fmt.Errorf("-time-scale: %v", err)
+	}
+	if _, err := parseOffset(o.timeOffset); err != nil {
+		return fmt.Errorf("-offset: %v", err)
+	}
+	if _, err := parseSnapKinds(o.snapTo); err != nil {
+		return fmt.Errorf("-snap-to: %v", err)
+	}
+	if _, ok := engines[o.engine]; !ok && o.engine != "auto" {
+		return fmt.Errorf("unknown engine '%s'", o.engine)
+	}
+	if o.rotationMode != "bake" && o.rotationMode != "keep" {
+		return fmt.Errorf("unknown rotation mode '%s'; must be bake or keep", o.rotationMode)
+	}
+	switch o.dynamicHDR {
+	case "warn", "copy", "drop":
+	default:
+		return fmt.Errorf("unknown -dynamic-hdr '%s'; must be warn, copy, or drop", o.dynamicHDR)
+	}
+	switch o.deinterlace {
+	case "", "auto", "yadif", "bwdif":
+	default:
+		return fmt.Errorf("unknown deinterlacer '%s'; must be yadif, bwdif, or auto", o.deinterlace)
+	}
+	if n, err := strconv.Atoi(o.timePrecision); o.timePrecision != "frame" && (err != nil || n < 0 || n > 9) {
+		return fmt.Errorf("bad precision '%s'; must be a number of decimal places (0-9) or frame", o.timePrecision)
+	}
+	if o.extractFormat != "mp4" && o.extractFormat != "gif" {
+		return fmt.Errorf("unknown extract format '%s'; must be mp4 or gif", o.extractFormat)
+	}
+	if isHLS(o.outputFile) && (o.engine == "concat" || o.splitOutput) {
+		return errors.New("HLS output (.m3u8) can't be made with -engine concat or -split")
+	}
+	if isAnimation(o.outputFile) {
+		switch {
+		case o.streamCopy:
+			return errors.New("GIF and WebP output can't be made with -copy")
+		case o.splitOutput:
+			return errors.New("GIF and WebP output can't be made with -split")
+		case o.checkSyncAfter || o.checkQuality:
+			return errors.New("GIF and WebP output can't be checked with -check-sync or -check-quality")
+		}
+	}
+	if o.streamCopy && o.engine != "concat" && o.engine != "auto" {
+		return errors.New("-copy requires -engine concat")
+	}
+	if o.minSSIM < 0 || o.minSSIM > 1 {
+		return errors.New("-min-ssim must be from 0 to 1")
+	}
+	if o.muteFill != "" {
+		if _, err := os.Stat(o.muteFill); err != nil {
+			return fmt.Errorf("-mute-fill: %v", err)
+		}
+	}
+	if o.markedCopy != "" {
+		for _, other := range []string{o.inputFile, o.outputFile} {
+			if same, err := samePath(o.markedCopy, other); err != nil || same {
+				return fmt.Errorf("-marked-copy can't be %s", other)
+			}
+		}
+	}
+	if o.crf != "" {
+		if crf, err := strconv.ParseFloat(o.crf, 64); err != nil || crf < 0 {
+			return fmt.Errorf("bad -crf '%s'; it must be a number like 18", o.crf)
+		}
+	}
+	if o.streamCopy && (o.videoCodec != "" || o.crf != "" || o.preset != "") {
+		return errors.New("-video-codec, -crf, and -preset can't be used with -copy, which doesn't re-encode the video")
+	}
+	if o.largeEditPercent < 0 || o.largeEditMinutes < 0 {
+		return errors.New("-large-edit-percent and -large-edit-minutes can't be negative")
+	}
+	if o.muteFillVolume <= 0 {
+		return errors.New("-mute-fill-volume must be positive")
+	}
+	if o.threads < 0 {
+		return errors.New("-threads can't be negative")
+	}
+	return nil
+}
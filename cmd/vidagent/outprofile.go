@@ -0,0 +1,380 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// With -out-profile, one edit writes an output for each of several
+// viewer profiles from the -profiles file (the same as the server's),
+// each with the actions its profile's policy decides on, and with its
+// profile's options. Where the filtergraph engine can make all of
+// them, they're made by one ffmpeg command, which decodes the input
+// once and splits it between the outputs; that's faster than editing
+// the input once for each of them.
+
+// outProfile is an output of an edit with -out-profile: the name of
+// its profile, and its file.
+type outProfile struct {
+	name, file string
+}
+
+// outProfiles are the values of the -out-profile flags.
+type outProfiles []outProfile
+
+func (p *outProfiles) String() string {
+	var s []string
+	for _, op := range *p {
+		s = append(s, op.name+"="+op.file)
+	}
+	return strings.Join(s, " ")
+}
+
+func (p *outProfiles) Set(s string) error {
+	name, file, ok := strings.Cut(s, "=")
+	if !ok || name == "" || file == "" {
+		return errors.New("must be PROFILE=FILE")
+	}
+	*p = append(*p, outProfile{name, file})
+	return nil
+}
+
+// files returns the output files.
+func (p outProfiles) files() []string {
+	var files []string
+	for _, op := range p {
+		files = append(files, op.file)
+	}
+	return files
+}
+
+// validate returns an error if the outputs can't be made with the
+// other options in o.
+func (p outProfiles) validate(o *options) error {
+	switch {
+	case o.outputFile != "":
+		return errors.New("-out and -out-profile can't be used together")
+	case o.profilesFile == "":
+		return errors.New("-out-profile requires -profiles")
+	case o.policyFile != "":
+		return errors.New("-policy can't be used with -out-profile, which uses the profiles' policies")
+	case o.splitOutput, o.markedCopy != "":
+		return errors.New("-split and -marked-copy can't be used with -out-profile")
+	case o.dumpGraph != "", o.archiveDir != "":
+		return errors.New("-dump-graph and -archive can't be used with -out-profile")
+	}
+	for i, op := range p {
+		for _, other := range append([]string{o.inputFile}, p[:i].files()...) {
+			if same, err := samePath(op.file, other); err != nil || same {
+				return fmt.Errorf("-out-profile: output %s can't be %s", op.file, other)
+			}
+		}
+	}
+	return nil
+}
+
+// profileOutput is an output of an edit with -out-profile, ready to
+// be made: its own edit, with its options and its profile's policy
+// (and the arguments that set the profile's options, and the policy's
+// rules, as JSON), its effects, and the hash that identifies its
+// edits, and its actions, with the effects taken out of them.
+type profileOutput struct {
+	outProfile
+	*edit
+	optionArgs []string
+	rules      []byte
+	actions    []action
+}
+
+// editProfiles makes the outputs of an edit with -out-profile.
+func (e *edit) editProfiles(scale, offset float64) error {
+	profiles, err := loadProfiles(e.opts.profilesFile)
+	if err != nil {
+		return err
+	}
+	baseHash, err := fileHash(e.opts.filterFile)
+	if err != nil {
+		return err
+	}
+	_, ffmpegErr := findTool("ffmpeg")
+	if ffmpegErr == nil {
+		removeImport, err := e.importTempInput()
+		if err != nil {
+			return err
+		}
+		defer removeImport()
+		e.inputInfo, err = probe(e.opts.inputFile)
+		if err != nil {
+			log.Printf("could not probe input; continuing without it: %v", err)
+		}
+		if err := e.checkAudioLang(); err != nil {
+			return err
+		}
+		if video := e.inputInfo.stream("video"); video != nil && e.opts.frameRate == "" && !e.opts.streamCopy && video.variableFrameRate() {
+			e.opts.frameRate = video.AvgFrameRate
+			log.Printf("input has a variable frame rate; converting to a constant %s fps (use -cfr to choose a rate)", e.opts.frameRate)
+		}
+	}
+
+	var outs []*profileOutput
+	for _, op := range e.opts.outProfiles {
+		prof, ok := profiles[op.name]
+		if !ok {
+			return fmt.Errorf("-out-profile: no profile '%s' in %s", op.name, e.opts.profilesFile)
+		}
+		out, err := e.prepareProfile(op, prof, baseHash, scale, offset)
+		if err != nil {
+			return fmt.Errorf("profile '%s': %v", op.name, err)
+		}
+		if !e.opts.overwrite && alreadyProcessed(op.file, out.filterHash) {
+			log.Printf("%s was already made with this filter file; skipping (use -f to make it again)", op.file)
+			continue
+		}
+		outs = append(outs, out)
+	}
+	if len(outs) == 0 {
+		return nil
+	}
+
+	why := ffmpegErr
+	if why == nil {
+		why = e.sharedDecodeError(outs)
+	}
+	if why != nil {
+		if e.opts.verbose {
+			log.Printf("making each profile's output separately: %v", why)
+		}
+		return e.runProfilesSeparately(outs)
+	}
+	if e.opts.verbose {
+		log.Printf("making the profiles' outputs with one ffmpeg command")
+	}
+	if !e.opts.noSpaceCheck {
+		need, err := e.estimateOutputSize()
+		if err != nil {
+			return err
+		}
+		for _, out := range outs {
+			err = checkFreeSpace(filepath.Dir(out.file), need)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return e.runProfilesShared(outs)
+}
+
+// prepareProfile returns the output of the profile: an edit of its
+// own, with the edit's options and the profile's, and the actions of
+// the filter file with the profile's policy.
+func (e *edit) prepareProfile(op outProfile, prof profile, baseHash string, scale, offset float64) (*profileOutput, error) {
+	o := *e.opts
+	o.outputFile, o.outProfiles, o.profilesFile = op.file, nil, ""
+	names := make([]string, 0, len(prof.Options))
+	for name := range prof.Options {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var args []string
+	for _, name := range names {
+		args = append(args, "-"+name+"="+prof.Options[name])
+	}
+	fs := flag.NewFlagSet("profile", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o.register(fs)
+	err := fs.Parse(args)
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pol, err := parsePolicy(prof.Policy)
+	if err != nil {
+		return nil, err
+	}
+	// the output's edit is of the same input, with the profile's
+	// options and policy, and effects of its own
+	pe := *e
+	pe.opts, pe.policy, pe.effects = &o, pol, effectFilters{}
+	actions, err := pe.readShiftedFilterFile(o.filterFile, scale, offset)
+	if err != nil {
+		return nil, err
+	}
+	actions, err = pe.selectActions(withoutVerb(actions, NoteVerb))
+	if err != nil {
+		return nil, err
+	}
+	err = pe.checkLargeEdit(actions, pe.inputInfo.Format.duration())
+	if err != nil {
+		return nil, err
+	}
+	pe.editSummaries[op.file] = summarizeActions(actions)
+	actions, err = pe.applyEffects(actions)
+	if err != nil {
+		return nil, err
+	}
+	actions, err = pe.excerptActions(actions, pe.inputInfo.Format.duration())
+	if err != nil {
+		return nil, err
+	}
+	err = pe.checkDynamicHDR(withoutVerb(actions, ChapterBreakVerb, ExtractVerb))
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := json.Marshal(prof.Policy)
+	if err != nil {
+		return nil, err
+	}
+	out := &profileOutput{outProfile: op, edit: &pe, optionArgs: args, rules: rules, actions: actions}
+	// the same as the hash of an edit with the policy in a file
+	sum := sha256.Sum256(rules)
+	out.filterHash = variantHash(baseHash, pe.editVariant(scale, offset)+" policy="+hex.EncodeToString(sum[:]))
+	return out, nil
+}
+
+// sharedDecodeError returns why the outputs can't all be made by the
+// filtergraph engine in one command, or nil if they can.
+func (e *edit) sharedDecodeError(outs []*profileOutput) error {
+	for _, out := range outs {
+		o := out.opts
+		edits := withoutVerb(out.actions, ChapterBreakVerb, ExtractVerb)
+		switch {
+		case o.engine != "auto" && o.engine != "filtergraph":
+			return fmt.Errorf("profile '%s' uses the %s engine", out.name, o.engine)
+		case o.streamCopy, o.snapTo != "", o.checkSyncAfter, o.checkQuality:
+			return fmt.Errorf("profile '%s' uses options that need an edit of its own", out.name)
+		case isHLS(out.file):
+			return fmt.Errorf("%s is HLS", out.file)
+		case isAnimation(out.file):
+			return fmt.Errorf("%s is an animation, which is made from an edited video", out.file)
+		case len(e.inputInfo.Streams) > 0 && e.inputInfo.stream("video") == nil:
+			return errors.New("the input has no video")
+		case hasVerb(out.actions, ExtractVerb):
+			return fmt.Errorf("profile '%s' has extract actions", out.name)
+		case len(edits) == 0:
+			return fmt.Errorf("profile '%s' only has effects, or nothing, to edit", out.name)
+		}
+	}
+	return nil
+}
+
+// graphLabel matches the labels in a filter graph that aren't
+// inputs, which start with their number instead.
+var graphLabel = regexp.MustCompile(`\[([a-z][a-z0-9_]*)\]`)
+
+// runProfilesShared makes all the outputs in one ffmpeg command, with
+// each one's filter graph (with its labels made its own) reading the
+// same decoded input.
+func (e *edit) runProfilesShared(outs []*profileOutput) (err error) {
+	// the inputs are the same as for the filtergraph engine
+	args := []string{
+		e.overwriteArg(),
+	}
+	args = append(args, e.inputArgs()...)
+	args = append(args, e.muteSourceArgs()...)
+
+	// the outputs are renamed into place if they're all made,
+	// and then their locks are released
+	var graphs, outArgs, partials []string
+	var unlocks []func()
+	defer func() {
+		for i, partial := range partials {
+			if ferr := finishPartial(partial, outs[i].file, err); err == nil {
+				err = ferr
+			}
+		}
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}()
+	for i, out := range outs {
+		edits := withoutVerb(out.actions, ChapterBreakVerb, ExtractVerb)
+		graph, err := out.buildComplexFilter(edits)
+		if err != nil {
+			return fmt.Errorf("profile '%s': %v", out.name, err)
+		}
+		prefix := "p" + strconv.Itoa(i)
+		graphs = append(graphs, graphLabel.ReplaceAllString(graph, "["+prefix+"$1]"))
+		outArgs = append(outArgs, "-map", "["+prefix+"outv]", "-map", "["+prefix+"outa]")
+		outArgs = append(outArgs, out.metadataArgs(edits, 0, 2)...)
+		outArgs = append(outArgs, out.videoEncodeArgs()...)
+		if out.opts.threads > 0 && i < len(outs)-1 {
+			// (threadArgs only limits the encoders of the last output)
+			outArgs = append(outArgs, "-threads", strconv.Itoa(out.opts.threads))
+		}
+		unlock, err := lockOutput(out.file)
+		if err != nil {
+			return err
+		}
+		unlocks = append(unlocks, unlock)
+		partial, err := out.startPartial(out.file)
+		if err != nil {
+			return err
+		}
+		partials = append(partials, partial)
+		outArgs = append(outArgs, fileArg(partial))
+	}
+	args = append(args, "-filter_complex", strings.Join(graphs, ";"))
+	args = append(args, outArgs...)
+
+	e.setStage("encoding", e.inputInfo.Format.duration())
+	return e.runFFmpeg(args, partials[0])
+}
+
+// runProfilesSeparately makes each output with its own edit, run
+// like any other, with its profile's policy and options.
+func (e *edit) runProfilesSeparately(outs []*profileOutput) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	dir, err := makeTempDir(e.opts.tempRoot, "vidagent-profiles-")
+	if err != nil {
+		return err
+	}
+	defer removeTempDir(dir)
+
+	// (this run records the history of every output, and runs
+	// the hooks for them; and its filter file, which may be one
+	// it downloaded or made of -action flags, is the outputs', while
+	// each of them imports an image sequence or raw input itself)
+	skip := []string{"out", "policy", "profiles", "out-profile", "no-history", "on-success", "on-failure",
+		"filter", "filter-repo", "action", "in"}
+	editArgs := append([]string{"-in=" + e.inputName(), "-filter=" + e.opts.filterFile}, e.opts.args(skip...)...)
+
+	for i, out := range outs {
+		policyFile := filepath.Join(dir, fmt.Sprintf("policy%d.json", i))
+		err = os.WriteFile(policyFile, out.rules, 0644)
+		if err != nil {
+			return err
+		}
+		args := append(slices.Clone(editArgs), out.optionArgs...)
+		args = append(args, "-policy="+policyFile, "-out="+out.file, "-no-history")
+
+		log.Printf("making %s for profile '%s'", out.file, out.name)
+		cmd := exec.Command(exe, args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err = cmd.Run()
+		if err != nil {
+			return fmt.Errorf("profile '%s': %v", out.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// prepareTestProfile prepares the output of a profile that cuts for
+// violence, with the options in base, of a 10 minute input with the
+// filter, as an edit with -out-profile does.
+func prepareTestProfile(t *testing.T, base *options, filter string) (*profileOutput, error) {
+	t.Helper()
+	dir := t.TempDir()
+	base.inputFile = filepath.Join(dir, "in.mp4")
+	base.filterFile = filepath.Join(dir, "in.filter")
+	err := os.WriteFile(base.filterFile, []byte(filter), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := outProfile{name: "kids", file: filepath.Join(dir, "kids.mp4")}
+	base.profilesFile, base.outProfiles = filepath.Join(dir, "profiles.json"), outProfiles{op}
+	e := newEdit(base)
+	e.inputInfo = probeResult{Format: probeFormat{Duration: "600"}}
+
+	prof := profile{Policy: map[string]string{"violence": "cut"}}
+	return e.prepareProfile(op, prof, "hash", 1, 0)
+}
+
+// TestProfileLargeEdit makes sure that the outputs of -out-profile are
+// checked for cutting too much of the input, like the output of -out.
+func TestProfileLargeEdit(t *testing.T) {
+	const filter = "cut 1:00-6:00 (violence)\n"
+	_, err := prepareTestProfile(t, defaultOptions(), filter)
+	if err == nil || !strings.Contains(err.Error(), "-allow-large-edits") {
+		t.Errorf("cutting half the input for a profile: got %v, not an error about -allow-large-edits", err)
+	}
+
+	o := defaultOptions()
+	o.allowLargeEdits = true
+	_, err = prepareTestProfile(t, o, filter)
+	if err != nil {
+		t.Errorf("with -allow-large-edits: %v", err)
+	}
+}
+
+// TestProfileExcerpt makes sure that -from and -to make the outputs of
+// -out-profile excerpts, like the output of -out.
+func TestProfileExcerpt(t *testing.T) {
+	o := defaultOptions()
+	o.excerptFrom, o.excerptTo = "2:00", "3:00"
+	out, err := prepareTestProfile(t, o, "cut 2:30-2:40 (violence)\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, act := range out.actions {
+		got = append(got, string(act.verb)+" "+out.secondString(act.start)+"-"+out.secondString(act.end))
+	}
+	want := []string{"cut 0.000-120.000", "cut 150.000-160.000", "cut 180.000-600.000"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got the actions %q, not %q", got, want)
+	}
+}
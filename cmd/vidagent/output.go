@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Outputs are written to a temporary file next to them, which is
+// renamed to the output once it's complete. This way, a run that's
+// interrupted never leaves a truncated file where the output should
+// be, which a media server might find and add to its library. The
+// temporary file is hidden, since media servers skip hidden files.
+
+// outputPath returns the file that the output is written to.
+func (e *edit) outputPath() string {
+	if e.writingTo != "" {
+		return e.writingTo
+	}
+	return e.opts.outputFile
+}
+
+// startPartial returns the temporary file to write the output to,
+// in the same directory and with the same extension, so ffmpeg
+// writes it in the same format. Like ffmpeg, it fails if the output
+// already exists, unless it's to be overwritten.
+func (e *edit) startPartial(output string) (string, error) {
+	if _, err := os.Stat(output); err == nil && !e.opts.overwrite {
+		return "", fmt.Errorf("output file %s already exists (use -f to overwrite)", output)
+	}
+	dir, base := filepath.Split(output)
+	ext := filepath.Ext(base)
+	partial := filepath.Join(dir, "."+strings.TrimSuffix(base, ext)+".partial"+ext)
+	// (left by a run that couldn't clean up after itself)
+	os.Remove(partial)
+
+	// removed if vidagent is interrupted, like temporary
+	// directories (which RemoveAll removes files too)
+	tempDirs.Lock()
+	tempDirs.m[partial] = true
+	tempDirs.Unlock()
+	return partial, nil
+}
+
+// finishPartial renames the temporary file to the output if it was
+// written without an error, or otherwise removes it.
+func finishPartial(partial, output string, err error) error {
+	tempDirs.Lock()
+	delete(tempDirs.m, partial)
+	tempDirs.Unlock()
+	if err != nil {
+		os.Remove(partial)
+		return err
+	}
+	return os.Rename(partial, output)
+}
+
+// writesInPlace returns true if the output is written where it is,
+// instead of being renamed into place: HLS playlists, since they
+// can be played while they're written, and with -split, which
+// writes each part to its own file.
+func (e *edit) writesInPlace() bool {
+	return isHLS(e.opts.outputFile) || e.opts.splitOutput
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Actions may end with parameters in square brackets: key=value pairs
+// separated by commas or spaces, like [box=320:180:200:240, audio=off].
+// Keys are case-insensitive. Each verb has a schema of the parameters
+// it takes and the type of their values, and an action's parameters
+// are checked against it, so a misspelled key or a bad value is an
+// error about where it is on the line. Plugin verbs take any
+// parameters, which are passed along to the plugin as they are; so
+// that those can be lists, a value may have commas in it, as long as
+// what follows a comma isn't also key=value.
+
+// param is a parameter of an action.
+type param struct {
+	key, value string
+	start      pos // of the key
+}
+
+// paramKind is the type of a parameter's values.
+type paramKind int
+
+const (
+	onOffParam paramKind = iota // on or off
+	boxParam                    // a blurBox, x:y:width:height
+)
+
+// paramSpec is a parameter in a verb's schema.
+type paramSpec struct {
+	key      string
+	kind     paramKind
+	repeated bool // whether it can be given more than once
+}
+
+// streamParams are the parameters of the actions that change the
+// video or audio in place, which can leave one of them alone.
+var streamParams = []paramSpec{
+	{key: "video", kind: onOffParam},
+	{key: "audio", kind: onOffParam},
+}
+
+// paramSchemas are the parameters that actions with each verb take.
+// Verbs that aren't here take none, except plugin verbs.
+var paramSchemas = map[Verb][]paramSpec{
+	BlurVerb:     append([]paramSpec{{key: "box", kind: boxParam, repeated: true}}, streamParams...),
+	MuteVerb:     streamParams,
+	ScrambleVerb: streamParams,
+	FadeOutVerb:  streamParams,
+	FadeInVerb:   streamParams,
+}
+
+// check returns an error if the value isn't of the kind, for the
+// parameter key.
+func (k paramKind) check(key, value string) error {
+	switch k {
+	case onOffParam:
+		if value != "on" && value != "off" {
+			return fmt.Errorf("%s must be on or off, not '%s'", key, value)
+		}
+	case boxParam:
+		_, err := parseBlurBox(value)
+		return err
+	}
+	return nil
+}
+
+// parseParams parses a parameters node, including its brackets.
+func parseParams(n *node) ([]param, error) {
+	if !strings.HasSuffix(n.text, "]") {
+		return nil, fmt.Errorf("line %d:%d: parameters aren't closed with ]", n.start.line, n.start.col)
+	}
+	inner := n.inner()
+	text := inner.text
+	isSep := func(r rune) bool { return r == ',' || unicode.IsSpace(r) }
+
+	var params []param
+	prevEnd := -1 // of the last field
+	for i := 0; i < len(text); {
+		end := len(text)
+		if j := strings.IndexFunc(text[i:], isSep); j >= 0 {
+			end = i + j
+		}
+		if end > i {
+			field := text[i:end]
+			start := inner.start
+			start.col += utf8.RuneCountInString(text[:i])
+			start.offset += i
+			key, val, ok := strings.Cut(field, "=")
+			switch {
+			case ok && key != "":
+				params = append(params, param{key: strings.ToLower(key), value: val, start: start})
+			case !ok && prevEnd >= 0 && i == prevEnd+1 && text[prevEnd] == ',':
+				// more of a value with a comma in it
+				params[len(params)-1].value += text[prevEnd:end]
+			default:
+				return nil, fmt.Errorf("line %d:%d: parameter '%s' must be key=value", start.line, start.col, field)
+			}
+			prevEnd = end
+		}
+		_, size := utf8.DecodeRuneInString(text[end:])
+		i = end + size
+	}
+	return params, nil
+}
+
+// param returns the value of the action's parameter key (the last
+// one, if it's given more than once), and whether it has one.
+func (a action) param(key string) (string, bool) {
+	vals := a.paramValues(key)
+	if len(vals) == 0 {
+		return "", false
+	}
+	return vals[len(vals)-1], true
+}
+
+// paramValues returns the values of the action's parameter key, in
+// the order they're given.
+func (a action) paramValues(key string) []string {
+	var vals []string
+	for _, p := range a.params {
+		if p.key == key {
+			vals = append(vals, p.value)
+		}
+	}
+	return vals
+}
+
+// paramMap returns the action's parameters as a map from each key
+// to its values, or nil if it has none.
+func (a action) paramMap() map[string][]string {
+	if len(a.params) == 0 {
+		return nil
+	}
+	m := make(map[string][]string)
+	for _, p := range a.params {
+		m[p.key] = append(m[p.key], p.value)
+	}
+	return m
+}
+
+// blurBoxes returns the regions of the action's box parameters.
+func (a action) blurBoxes() ([]blurBox, error) {
+	var boxes []blurBox
+	for _, val := range a.paramValues("box") {
+		box, err := parseBlurBox(val)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", a.line(), err)
+		}
+		boxes = append(boxes, box)
+	}
+	return boxes, nil
+}
+
+// streamOff returns true if the action leaves the stream ("video" or
+// "audio") alone, with a parameter like [audio=off].
+func streamOff(act action, stream string) bool {
+	val, _ := act.param(stream)
+	return val == "off"
+}
+
+// validateParams makes sure that the parameters of the actions are
+// those of their verbs' schemas, with valid values. It returns the
+// actions without those that l skips.
+func validateParams(actions []action, l *lenientParse) ([]action, error) {
+	var valid []action
+	for _, act := range actions {
+		err := checkParams(act)
+		if err != nil {
+			if l.skip(err) {
+				continue
+			}
+			return nil, err
+		}
+		valid = append(valid, act)
+	}
+	return valid, nil
+}
+
+func checkParams(act action) error {
+	schema := paramSchemas[act.verb]
+	if isPlugin(act.verb) {
+		schema = streamParams
+	}
+	seen := make(map[string]bool)
+	for _, p := range act.params {
+		i := slices.IndexFunc(schema, func(spec paramSpec) bool { return spec.key == p.key })
+		switch {
+		case i < 0 && isPlugin(act.verb):
+			continue
+		case i < 0 && len(schema) == 0:
+			return fmt.Errorf("line %d:%d: %s actions don't take parameters", p.start.line, p.start.col, act.verb)
+		case i < 0:
+			return fmt.Errorf("line %d:%d: %s actions don't take a '%s' parameter, only %s",
+				p.start.line, p.start.col, act.verb, p.key, paramKeys(schema))
+		case seen[p.key] && !schema[i].repeated:
+			return fmt.Errorf("line %d:%d: %s is given more than once", p.start.line, p.start.col, p.key)
+		}
+		seen[p.key] = true
+		if err := schema[i].kind.check(p.key, p.value); err != nil {
+			return fmt.Errorf("line %d:%d: %v", p.start.line, p.start.col, err)
+		}
+	}
+	return checkStreamParams(act)
+}
+
+// paramKeys returns the keys of the schema, as a list for a message.
+func paramKeys(schema []paramSpec) string {
+	var keys []string
+	for _, spec := range schema {
+		keys = append(keys, spec.key)
+	}
+	switch len(keys) {
+	case 1:
+		return keys[0]
+	case 2:
+		return keys[0] + " or " + keys[1]
+	}
+	return strings.Join(keys[:len(keys)-1], ", ") + ", or " + keys[len(keys)-1]
+}
+
+// checkStreamParams makes sure the video and audio parameters of an
+// action, which are otherwise valid, leave it something to change.
+func checkStreamParams(act action) error {
+	var off []param
+	for _, p := range act.params {
+		if (p.key == "video" || p.key == "audio") && p.value == "off" {
+			off = append(off, p)
+		}
+	}
+	switch {
+	case len(off) == 0:
+		return nil
+	case len(off) == 2:
+		return fmt.Errorf("line %d:%d: with video=off and audio=off, the %s does nothing", off[1].start.line, off[1].start.col, act.verb)
+	case off[0].key == "video" && act.verb == BlurVerb,
+		off[0].key == "audio" && (act.verb == MuteVerb || act.verb == ScrambleVerb):
+		return fmt.Errorf("line %d:%d: %s actions only change the %s", off[0].start.line, off[0].start.col, act.verb, off[0].key)
+	}
+	return nil
+}
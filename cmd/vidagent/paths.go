@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// findTool returns the path to the named executable, such as
+// "ffmpeg". A copy next to the vidagent binary is preferred,
+// so that ffmpeg can be shipped in the same folder as vidagent
+// (common on Windows), followed by one installed with the setup
+// command, and finally one in the PATH.
+func findTool(name string) (string, error) {
+	name = exeName(name)
+	var dirs []string
+	if exe, err := os.Executable(); err == nil {
+		dirs = append(dirs, filepath.Dir(exe))
+	}
+	if dir, err := toolsDir(); err == nil {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		candidate := filepath.Join(dir, name)
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+	return exec.LookPath(name)
+}
+
+// fileArg prepares a local file path to be passed to ffmpeg as an
+// input or output. ffmpeg would otherwise interpret a leading dash
+// as an option and "name:" as a protocol, so such paths get an
+// explicit file: protocol prefix. Windows drive letters and URLs
+// (anything with "://") are left alone. On Windows, long paths are
+// also converted to their extended-length form.
+func fileArg(path string) string {
+	if strings.Contains(path, "://") {
+		return path
+	}
+	path = longPath(path)
+	if strings.HasPrefix(path, "-") || hasProtocolPrefix(path) {
+		return "file:" + path
+	}
+	return path
+}
+
+// hasProtocolPrefix returns true if ffmpeg would think path
+// begins with a protocol name, like "data:" or "pipe:".
+func hasProtocolPrefix(path string) bool {
+	colon := strings.IndexByte(path, ':')
+	if colon < 0 {
+		return false
+	}
+	if colon == 1 && filepath.VolumeName(path) != "" {
+		return false // drive letter
+	}
+	for _, ch := range path[:colon] {
+		if ch == '/' || ch == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// escapeFilterArg escapes s so that it can be used as the value of a
+// filter option (e.g. a file name) inside a filter graph. This has to
+// be done on two levels: once for the option value, where ':' and
+// backslashes are special (Windows paths have both), then again for
+// the filter graph description, where brackets, commas, and semicolons
+// are special as well.
+func escapeFilterArg(s string) string {
+	value := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(s)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`,
+		`,`, `\,`, `;`, `\;`).Replace(value)
+}
+
+// concatQuote quotes path for use in a concat demuxer list file.
+func concatQuote(path string) string {
+	return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is the traditional length limit for Windows paths.
+const maxPath = 260
+
+// longPath converts path to its extended-length form (\\?\C:\...
+// or \\?\UNC\server\share\...) if it is too long for Windows to
+// otherwise accept. Extended-length paths must be absolute and
+// use backslashes only.
+func longPath(path string) string {
+	if len(path) < maxPath || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Plugins add verbs without changing vidagent. A plugin for the
+// verb "name" is an executable called vidagent-verb-name, found the
+// same way as ffmpeg. For each action with its verb, the plugin is
+// run with a pluginRequest as JSON on its standard input, and it
+// writes a pluginResponse as JSON to its standard output.
+//
+// The filters a plugin returns are applied to the whole input, while
+// its frames and samples still have their original timestamps, so
+// they can use timeline editing (like enable='between(t,60,70)')
+// to only affect the action's segment. Plugin verbs don't change
+// the timing of the video, so their segments may overlap others.
+
+const pluginPrefix = "vidagent-verb-"
+
+// pluginRequest describes an action to a plugin.
+type pluginRequest struct {
+	Verb      string  `json:"verb"`
+	Start     float64 `json:"start"` // seconds
+	End       float64 `json:"end"`   // seconds
+	Category  string  `json:"category,omitempty"`
+	Specifier string  `json:"specifier,omitempty"`
+	Line      int     `json:"line"`
+	Input     string  `json:"input"`
+
+	// the parameters in square brackets at the end of the line
+	Params map[string][]string `json:"params,omitempty"`
+}
+
+// pluginResponse is what a plugin returns: a chain of ffmpeg
+// filters for the video and/or the audio (comma-separated, without
+// labels), either of which may be empty.
+type pluginResponse struct {
+	Video string `json:"video"`
+	Audio string `json:"audio"`
+}
+
+// plugins are the executables of the plugin verbs in use, which
+// edits made at the same time look up together.
+var plugins = struct {
+	sync.Mutex
+	m map[Verb]string
+}{m: make(map[Verb]string)}
+
+// effectFilters are the filters of effects, for the video and the
+// audio.
+type effectFilters struct {
+	video, audio []string
+}
+
+// hasEffects returns true if there are any effects to apply.
+func (e *edit) hasEffects() bool {
+	return len(e.effects.video) > 0 || len(e.effects.audio) > 0
+}
+
+// applyEffects adds the filters for all the effect actions, like
+// blurs and plugins, to effects, and returns the other actions.
+func (e *edit) applyEffects(actions []action) ([]action, error) {
+	actions, err := e.applyPlugins(actions)
+	if err != nil {
+		return nil, err
+	}
+	actions, err = e.applyBlurs(actions)
+	if err != nil {
+		return nil, err
+	}
+	actions = e.applyScrambles(actions)
+	return e.applyFades(actions), nil
+}
+
+// pluginVerb returns the verb for name if there's a plugin for it.
+func pluginVerb(name string) (Verb, bool) {
+	name = strings.ToLower(name)
+	if name == "" || strings.Trim(name, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+		return "", false
+	}
+	plugins.Lock()
+	defer plugins.Unlock()
+	if _, ok := plugins.m[Verb(name)]; ok {
+		return Verb(name), true
+	}
+	exe, err := findTool(pluginPrefix + name)
+	if err != nil {
+		return "", false
+	}
+	plugins.m[Verb(name)] = exe
+	return Verb(name), true
+}
+
+// isPlugin returns true if verb is provided by a plugin.
+func isPlugin(verb Verb) bool {
+	plugins.Lock()
+	_, ok := plugins.m[verb]
+	plugins.Unlock()
+	return ok
+}
+
+// applyPlugins runs the plugin for each action with a plugin verb,
+// adding the filters it returns to effects, and returns the other
+// actions.
+func (e *edit) applyPlugins(actions []action) ([]action, error) {
+	var others []action
+	for _, act := range actions {
+		if !isPlugin(act.verb) {
+			others = append(others, act)
+			continue
+		}
+		resp, err := e.runPlugin(act)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: plugin for '%s': %v", act.line(), act.verb, err)
+		}
+		// (video=off and audio=off are up to vidagent, so a
+		// plugin doesn't have to know about them)
+		if resp.Video != "" && !streamOff(act, "video") {
+			e.effects.video = append(e.effects.video, resp.Video)
+		}
+		if resp.Audio != "" && !streamOff(act, "audio") {
+			e.effects.audio = append(e.effects.audio, resp.Audio)
+		}
+	}
+	return others, nil
+}
+
+// runPlugin runs the plugin for the action.
+func (e *edit) runPlugin(act action) (pluginResponse, error) {
+	var resp pluginResponse
+	req, err := json.Marshal(pluginRequest{
+		Verb:      string(act.verb),
+		Start:     act.start.SecondNum(),
+		End:       act.end.SecondNum(),
+		Category:  act.reason.Category,
+		Specifier: act.reason.Specifier,
+		Line:      act.line(),
+		Input:     e.opts.inputFile,
+		Params:    act.paramMap(),
+	})
+	if err != nil {
+		return resp, err
+	}
+
+	var stdout bytes.Buffer
+	plugins.Lock()
+	exe := plugins.m[act.verb]
+	plugins.Unlock()
+	cmd := exec.Command(exe)
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return resp, err
+	}
+
+	err = json.Unmarshal(stdout.Bytes(), &resp)
+	if err != nil {
+		return resp, fmt.Errorf("decoding response: %v", err)
+	}
+	// the filters are spliced into bigger filter graphs, so
+	// they can't have labels or be more than one chain
+	for _, chain := range []string{resp.Video, resp.Audio} {
+		if strings.ContainsAny(chain, "[];") {
+			return resp, fmt.Errorf("filters must be a single chain without labels: %s", chain)
+		}
+	}
+	return resp, nil
+}
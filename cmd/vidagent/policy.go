@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// An edit's policy maps reason categories, and categories with
+// specifiers (like "language:strong"), to the verb to use for actions
+// with that reason, regardless of the verb in the filter file. The
+// verb "none" means to leave those segments alone. For example:
+//
+//	{"language": "mute", "language:mild": "none", "nudity": "cut"}
+//
+// This way, one file of annotated segments can be applied
+// differently according to each viewer's preferences.
+
+// noVerb is the policy verb that drops an action.
+const noVerb Verb = "none"
+
+// loadPolicy reads the policy file into policy.
+func (e *edit) loadPolicy(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var rules map[string]string
+	err = json.Unmarshal(data, &rules)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	e.policy, err = parsePolicy(rules)
+	if err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+	return nil
+}
+
+// parsePolicy resolves the verbs of a policy's rules.
+func parsePolicy(rules map[string]string) (map[string]Verb, error) {
+	p := make(map[string]Verb)
+	for reason, verbName := range rules {
+		verb, ok := cfg.verb(verbName)
+		if strings.EqualFold(verbName, string(noVerb)) {
+			verb, ok = noVerb, true
+		}
+		if !ok {
+			return nil, fmt.Errorf("unrecognized verb '%s' for '%s'", verbName, reason)
+		}
+		p[strings.ToLower(reason)] = verb
+	}
+	return p, nil
+}
+
+// resolveVerbs decides the verb of each action: the policy's verb
+// for its reason if there is one, otherwise the verb in the filter
+// file, or if there isn't one, the default verb for its reason in
+// the config. Actions the policy says to leave alone are removed,
+// as are those without a verb that l skips.
+func (e *edit) resolveVerbs(actions []action, l *lenientParse) ([]action, error) {
+	var resolved []action
+	for _, act := range actions {
+		if verb, ok := e.policyVerb(act.reason); ok && act.verb != NoteVerb {
+			// notes are left alone, since they're
+			// about what was deliberately not edited
+			act.verb = verb
+		} else if act.verb == "" {
+			verb, ok := cfg.defaultVerb(act.reason)
+			if !ok {
+				err := fmt.Errorf("line %d: no verb, and no verb for reason category '%s' in the policy or config",
+					act.line(), act.reason.Category)
+				if l.skip(err) {
+					continue
+				}
+				return nil, err
+			}
+			act.verb = verb
+		}
+		if act.verb == noVerb {
+			continue
+		}
+		resolved = append(resolved, act)
+	}
+	return resolved, nil
+}
+
+// policyVerb returns the policy's verb for the reason, preferring
+// a rule for its category and specifier over one for its category.
+func (e *edit) policyVerb(r Reason) (Verb, bool) {
+	if r.Specifier != "" {
+		if verb, ok := e.policy[strings.ToLower(reasonString(r))]; ok {
+			return verb, true
+		}
+	}
+	verb, ok := e.policy[strings.ToLower(r.Category)]
+	return verb, ok
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"log"
+	"os/exec"
+)
+
+// startLowPriority starts cmd at reduced CPU and IO priority
+// so that encoding in the background doesn't starve other
+// programs on the same machine. Failing to lower the priority
+// is not fatal; it is only logged.
+func startLowPriority(cmd *exec.Cmd) error {
+	prepareLowPriority(cmd)
+	err := cmd.Start()
+	if err != nil {
+		return err
+	}
+	if err := lowerPriority(cmd.Process.Pid); err != nil {
+		log.Printf("could not lower ffmpeg priority: %v", err)
+	}
+	return nil
+}
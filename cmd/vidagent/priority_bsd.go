@@ -0,0 +1,17 @@
+//go:build darwin || freebsd || dragonfly || netbsd || openbsd
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+func prepareLowPriority(cmd *exec.Cmd) {}
+
+// lowerPriority sets the nice value of process pid to the
+// lowest level. These platforms have no portable way to
+// change the IO priority of another process.
+func lowerPriority(pid int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, pid, 20)
+}
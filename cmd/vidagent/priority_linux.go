@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const (
+	ioprioWhoProcess = 1
+	ioprioClassIdle  = 3
+	ioprioClassShift = 13
+)
+
+func prepareLowPriority(cmd *exec.Cmd) {}
+
+// lowerPriority sets the nice value and IO scheduling class of
+// process pid to the lowest levels, like `nice -n 19 ionice -c 3`.
+func lowerPriority(pid int) error {
+	if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, 19); err != nil {
+		return err
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET,
+		ioprioWhoProcess, uintptr(pid), ioprioClassIdle<<ioprioClassShift)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
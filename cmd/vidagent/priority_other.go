@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly && !netbsd && !openbsd && !windows
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func prepareLowPriority(cmd *exec.Cmd) {}
+
+func lowerPriority(pid int) error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+const belowNormalPriorityClass = 0x00004000
+
+// prepareLowPriority makes cmd start in the below-normal
+// priority class, which also lowers its IO priority.
+func prepareLowPriority(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.CreationFlags |= belowNormalPriorityClass
+}
+
+func lowerPriority(pid int) error { return nil }
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// probeResult is the part of ffprobe's JSON output that vidagent uses.
+type probeResult struct {
+	Format   probeFormat    `json:"format"`
+	Streams  []probeStream  `json:"streams"`
+	Chapters []probeChapter `json:"chapters"`
+}
+
+type probeFormat struct {
+	Filename   string            `json:"filename"`
+	FormatName string            `json:"format_name"`
+	StartTime  string            `json:"start_time"`
+	Duration   string            `json:"duration"`
+	Size       string            `json:"size"`
+	BitRate    string            `json:"bit_rate"`
+	Tags       map[string]string `json:"tags"`
+}
+
+type probeChapter struct {
+	StartTime string            `json:"start_time"`
+	EndTime   string            `json:"end_time"`
+	Tags      map[string]string `json:"tags"`
+}
+
+type probeStream struct {
+	Index     int               `json:"index"`
+	CodecType string            `json:"codec_type"`
+	CodecName string            `json:"codec_name"`
+	CodecTag  string            `json:"codec_tag_string"`
+	StartTime string            `json:"start_time"`
+	Duration  string            `json:"duration"`
+	BitRate   string            `json:"bit_rate"`
+	Tags      map[string]string `json:"tags"`
+
+	Disposition struct {
+		AttachedPic int `json:"attached_pic"`
+	} `json:"disposition"`
+
+	// audio streams only
+	SampleRate string `json:"sample_rate"`
+	Channels   int    `json:"channels"`
+
+	// video streams only
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
+	RFrameRate     string `json:"r_frame_rate"`
+	AvgFrameRate   string `json:"avg_frame_rate"`
+	PixFmt         string `json:"pix_fmt"`
+	ColorRange     string `json:"color_range"`
+	ColorSpace     string `json:"color_space"`
+	ColorTransfer  string `json:"color_transfer"`
+	ColorPrimaries string `json:"color_primaries"`
+	FieldOrder     string `json:"field_order"`
+	SideData       []struct {
+		SideDataType string  `json:"side_data_type"`
+		Rotation     float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+// probe runs ffprobe on file.
+func probe(file string) (probeResult, error) {
+	var result probeResult
+	out, err := cachedProbeOutput(file,
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		"-show_chapters")
+	if err != nil {
+		return result, fmt.Errorf("probing %s: %v", file, err)
+	}
+	err = json.Unmarshal(out, &result)
+	if err != nil {
+		return result, fmt.Errorf("decoding ffprobe output: %v", err)
+	}
+	return result, nil
+}
+
+// keyframeTimes returns the times, in seconds, of the keyframes of
+// the file's video in its first dur seconds. Only keyframes are
+// decoded, so this is fairly quick.
+func keyframeTimes(file string, dur float64) ([]float64, error) {
+	out, err := cachedProbeOutput(file,
+		"-select_streams", "v:0",
+		"-skip_frame", "nokey",
+		"-read_intervals", "%+"+strconv.FormatFloat(dur, 'f', 0, 64),
+		"-show_entries", "frame=best_effort_timestamp_time",
+		"-of", "csv=p=0")
+	if err != nil {
+		return nil, fmt.Errorf("finding keyframes of %s: %v", file, err)
+	}
+	var times []float64
+	for _, line := range strings.Split(string(out), "\n") {
+		if t, err := strconv.ParseFloat(strings.Trim(line, " \r,"), 64); err == nil {
+			times = append(times, t)
+		}
+	}
+	return times, nil
+}
+
+// ffprobeOutput runs ffprobe with args and returns its output.
+func ffprobeOutput(args ...string) ([]byte, error) {
+	ffprobe, err := findTool("ffprobe")
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(ffprobe, append([]string{"-v", "error"}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// stream returns the first stream of the given codec type
+// ("video", "audio", etc.), or nil if there is none. Cover
+// art is not considered a video stream.
+func (p probeResult) stream(codecType string) *probeStream {
+	for i := range p.Streams {
+		if p.Streams[i].CodecType == codecType && p.Streams[i].Disposition.AttachedPic == 0 {
+			return &p.Streams[i]
+		}
+	}
+	return nil
+}
+
+// start returns the start time of the stream in seconds.
+func (s probeStream) start() float64 {
+	start, _ := strconv.ParseFloat(s.StartTime, 64)
+	return start
+}
+
+// duration returns the duration of the stream in seconds, or 0 if
+// unknown. Not all containers record stream durations the same way;
+// Matroska, for example, puts them in a tag.
+func (s probeStream) duration() float64 {
+	if dur, err := strconv.ParseFloat(s.Duration, 64); err == nil {
+		return dur
+	}
+	for key, val := range s.Tags {
+		if strings.EqualFold(key, "DURATION") {
+			if t, err := ParseTime(val); err == nil {
+				return t.SecondNum()
+			}
+		}
+	}
+	return 0
+}
+
+// duration returns the duration of the file in seconds, or 0 if unknown.
+func (f probeFormat) duration() float64 {
+	dur, _ := strconv.ParseFloat(f.Duration, 64)
+	return dur
+}
+
+// variableFrameRate returns true if the stream's average frame rate
+// differs from its base frame rate, which is how ffprobe reveals a
+// variable frame rate (VFR) stream.
+func (s probeStream) variableFrameRate() bool {
+	base, avg := parseRate(s.RFrameRate), parseRate(s.AvgFrameRate)
+	if base == 0 || avg == 0 {
+		return false
+	}
+	return math.Abs(base-avg)/base > 0.001
+}
+
+// parseRate parses a rational rate like "30000/1001" as ffprobe
+// reports it. It returns 0 if rate is invalid or unknown ("0/0").
+func parseRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		den = "1"
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// interlaced returns true if the stream is known to be interlaced.
+func (s probeStream) interlaced() bool {
+	switch s.FieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What ffprobe reports about a file is cached, since the same file is
+// often probed again and again while working on its filter file (with
+// graph, probe, and editing it). Results are kept in the user cache
+// directory, keyed by the file's path, size, and modification time,
+// so a file that's changed is probed again, and by the ffprobe that
+// probed it and how.
+
+// cachedProbeOutput returns the output of running ffprobe with args
+// and then file, from the cache if it's there.
+func cachedProbeOutput(file string, args ...string) ([]byte, error) {
+	args = append(args, fileArg(file))
+	cacheFile, ok := probeCacheFile(file, args)
+	if ok {
+		if out, err := os.ReadFile(cacheFile); err == nil {
+			return out, nil
+		}
+	}
+	out, err := ffprobeOutput(args...)
+	if err != nil || !ok {
+		return out, err
+	}
+	// the cache is only to save time, so it's
+	// fine if it can't be written
+	if os.MkdirAll(filepath.Dir(cacheFile), 0755) == nil &&
+		os.WriteFile(cacheFile+".tmp", out, 0644) == nil {
+		os.Rename(cacheFile+".tmp", cacheFile)
+	}
+	return out, nil
+}
+
+// probeCacheFile returns the name of the file in the cache for
+// probing file with args, or false if it can't be cached, like
+// if it isn't a regular file.
+func probeCacheFile(file string, args []string) (string, bool) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", false
+	}
+	info, err := os.Stat(abs)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	ffprobe, err := findTool("ffprobe")
+	if err != nil {
+		return "", false
+	}
+	tool, err := os.Stat(ffprobe)
+	if err != nil {
+		return "", false
+	}
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", false
+	}
+
+	key := fmt.Sprintf("%s\x00%d\x00%d\x00%s\x00%d\x00%d\x00%s",
+		abs, info.Size(), info.ModTime().UnixNano(),
+		ffprobe, tool.Size(), tool.ModTime().UnixNano(),
+		strings.Join(args, "\x00"))
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(cache, "vidagent", "probe", hex.EncodeToString(sum[:])), true
+}
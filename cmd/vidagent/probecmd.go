@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// keyframeProbeSeconds is how much of the video is
+// read to measure how far apart its keyframes are.
+const keyframeProbeSeconds = 300
+
+// probeReport is what vidagent probe reports about a file.
+type probeReport struct {
+	File     string          `json:"file"`
+	Format   string          `json:"format"`
+	Duration float64         `json:"duration"` // seconds
+	Size     int64           `json:"size,omitempty"`
+	BitRate  int64           `json:"bit_rate,omitempty"`
+	Streams  []streamReport  `json:"streams"`
+	Chapters []chapterReport `json:"chapters,omitempty"`
+
+	// the largest and average distance between keyframes, in
+	// seconds, in the first keyframeProbeSeconds of the video
+	MaxKeyframeInterval float64 `json:"max_keyframe_interval,omitempty"`
+	AvgKeyframeInterval float64 `json:"avg_keyframe_interval,omitempty"`
+
+	Verdict verdict `json:"verdict"`
+}
+
+type streamReport struct {
+	Index    int    `json:"index"`
+	Type     string `json:"type"`
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	BitRate  int64  `json:"bit_rate,omitempty"`
+
+	Width      int     `json:"width,omitempty"`
+	Height     int     `json:"height,omitempty"`
+	FrameRate  float64 `json:"frame_rate,omitempty"`
+	VFR        bool    `json:"vfr,omitempty"`
+	Interlaced bool    `json:"interlaced,omitempty"`
+	HDR        bool    `json:"hdr,omitempty"`
+	Rotation   int     `json:"rotation,omitempty"`
+	CoverArt   bool    `json:"cover_art,omitempty"`
+
+	SampleRate int `json:"sample_rate,omitempty"`
+	Channels   int `json:"channels,omitempty"`
+}
+
+type chapterReport struct {
+	Start float64 `json:"start"` // seconds
+	End   float64 `json:"end"`   // seconds
+	Title string  `json:"title,omitempty"`
+}
+
+// verdict is which ways of editing the file will work well.
+type verdict struct {
+	Editable   bool     `json:"editable"`
+	StreamCopy bool     `json:"stream_copy"`
+	Select     bool     `json:"select_engine"`
+	WAVEditor  bool     `json:"wav_editor"`
+	Notes      []string `json:"notes"`
+}
+
+// probeCmd reports what ffprobe says about files that matters for
+// editing them, and which engines and modes suit them.
+func probeCmd(args []string) error {
+	fs := flag.NewFlagSet("probe", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the reports as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent probe [options] <file>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("at least one file required")
+	}
+
+	var reports []probeReport
+	for _, file := range fs.Args() {
+		report, err := makeProbeReport(file)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, report)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(reports)
+	}
+	for i, report := range reports {
+		if i > 0 {
+			fmt.Println()
+		}
+		printProbeReport(os.Stdout, report)
+	}
+	return nil
+}
+
+// makeProbeReport probes the file and works out its verdict.
+func makeProbeReport(file string) (probeReport, error) {
+	info, err := probe(file)
+	if err != nil {
+		return probeReport{}, err
+	}
+
+	report := probeReport{
+		File:     file,
+		Format:   info.Format.FormatName,
+		Duration: info.Format.duration(),
+	}
+	report.Size, _ = strconv.ParseInt(info.Format.Size, 10, 64)
+	report.BitRate, _ = strconv.ParseInt(info.Format.BitRate, 10, 64)
+	for _, s := range info.Streams {
+		sr := streamReport{
+			Index:    s.Index,
+			Type:     s.CodecType,
+			Codec:    s.CodecName,
+			Language: s.Tags["language"],
+			CoverArt: s.Disposition.AttachedPic != 0,
+		}
+		sr.BitRate, _ = strconv.ParseInt(s.BitRate, 10, 64)
+		switch s.CodecType {
+		case "video":
+			sr.Width, sr.Height = s.Width, s.Height
+			sr.FrameRate = math.Round(parseRate(s.AvgFrameRate)*1000) / 1000
+			sr.VFR = s.variableFrameRate()
+			sr.Interlaced = s.interlaced()
+			sr.HDR = s.hdr()
+			sr.Rotation = s.rotation()
+		case "audio":
+			sr.SampleRate, _ = strconv.Atoi(s.SampleRate)
+			sr.Channels = s.Channels
+		}
+		report.Streams = append(report.Streams, sr)
+	}
+	for _, ch := range info.Chapters {
+		start, _ := strconv.ParseFloat(ch.StartTime, 64)
+		end, _ := strconv.ParseFloat(ch.EndTime, 64)
+		report.Chapters = append(report.Chapters, chapterReport{start, end, ch.Tags["title"]})
+	}
+
+	if info.stream("video") != nil {
+		times, err := keyframeTimes(file, keyframeProbeSeconds)
+		if err != nil {
+			return report, err
+		}
+		if len(times) > 1 {
+			for i := 1; i < len(times); i++ {
+				report.MaxKeyframeInterval = math.Max(report.MaxKeyframeInterval, times[i]-times[i-1])
+			}
+			report.AvgKeyframeInterval = (times[len(times)-1] - times[0]) / float64(len(times)-1)
+		}
+	}
+
+	report.Verdict = judge(file, info, report)
+	return report, nil
+}
+
+// maxCopyKeyframeInterval is the largest distance between keyframes,
+// in seconds, at which cutting with -copy is still fairly precise.
+// It's a little over 2s, since that's a common interval (and at
+// 23.976 fps, 48 frames is slightly more than 2s).
+const maxCopyKeyframeInterval = 2.1
+
+// judge decides which ways of editing suit the file.
+func judge(file string, info probeResult, report probeReport) verdict {
+	var v verdict
+	note := func(format string, a ...any) {
+		v.Notes = append(v.Notes, fmt.Sprintf(format, a...))
+	}
+
+	video, audio := info.stream("video"), info.stream("audio")
+	v.WAVEditor = isWAV(file)
+	if audio == nil {
+		note("there's no audio stream, which all the engines need")
+	} else {
+		v.Editable = true
+	}
+	if v.WAVEditor {
+		note("cut and mute can be done without ffmpeg by the built-in WAV editor")
+	}
+	if video == nil {
+		note("there's no video stream; only audio will be edited")
+		return v
+	}
+
+	v.Select = !video.variableFrameRate()
+	if video.variableFrameRate() {
+		note("the frame rate is variable; it will be converted to a constant %s fps, and the select engine can't be used", video.AvgFrameRate)
+	}
+
+	switch {
+	case report.MaxKeyframeInterval == 0:
+		note("couldn't measure the keyframe interval; -copy may cut imprecisely")
+	case report.MaxKeyframeInterval <= maxCopyKeyframeInterval:
+		v.StreamCopy = true
+		note("keyframes are at most %.1fs apart, so -copy (with -engine concat) cuts within that of the given times", report.MaxKeyframeInterval)
+	default:
+		note("keyframes are up to %.1fs apart, so -copy would cut that far from the given times; re-encoding is more precise", report.MaxKeyframeInterval)
+	}
+
+	if video.hdr() {
+		note("the video is HDR (%s); it will be re-encoded as HEVC to preserve it, or use -tonemap for SDR", video.ColorTransfer)
+	} else if video.highBitDepth() {
+		note("the video has more than 8 bits per component (%s), which re-encoding preserves", video.PixFmt)
+	}
+	if video.interlaced() {
+		note("the video is interlaced; consider -deinterlace auto")
+	}
+	if deg := video.rotation(); deg != 0 {
+		note("the video is rotated %d°; see -rotation", deg)
+	}
+	if len(info.Chapters) > 0 {
+		note("chapters are kept only if nothing is cut")
+	}
+	note("filter files without cuts copy the streams they don't change: the video for mutes, the audio for blurs")
+	return v
+}
+
+// printProbeReport writes the report for people to read.
+func printProbeReport(w io.Writer, r probeReport) {
+	fmt.Fprintf(w, "%s: ", r.File)
+	if r.Format != "" {
+		fmt.Fprintf(w, "%s, ", r.Format)
+	}
+	fmt.Fprint(w, clockString(r.Duration))
+	if r.Size > 0 {
+		fmt.Fprintf(w, ", %.1f MB", float64(r.Size)/1e6)
+	}
+	if r.BitRate > 0 {
+		fmt.Fprintf(w, ", %d kb/s", r.BitRate/1000)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "stream\ttype\tcodec\tdetails\t")
+	for _, s := range r.Streams {
+		var details []string
+		switch {
+		case s.CoverArt:
+			details = append(details, "cover art")
+		case s.Type == "video":
+			if s.Width > 0 {
+				details = append(details, fmt.Sprintf("%dx%d", s.Width, s.Height))
+			}
+			if s.FrameRate > 0 {
+				details = append(details, fmt.Sprintf("%g fps", s.FrameRate))
+			}
+			if s.VFR {
+				details = append(details, "VFR")
+			}
+			if s.Interlaced {
+				details = append(details, "interlaced")
+			}
+			if s.HDR {
+				details = append(details, "HDR")
+			}
+			if s.Rotation != 0 {
+				details = append(details, fmt.Sprintf("rotated %d°", s.Rotation))
+			}
+		case s.Type == "audio":
+			if s.SampleRate > 0 {
+				details = append(details, fmt.Sprintf("%d Hz", s.SampleRate))
+			}
+			if s.Channels > 0 {
+				details = append(details, fmt.Sprintf("%d channels", s.Channels))
+			}
+		}
+		if s.Language != "" {
+			details = append(details, s.Language)
+		}
+		if s.BitRate > 0 {
+			details = append(details, fmt.Sprintf("%d kb/s", s.BitRate/1000))
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t\n", s.Index, s.Type, s.Codec, strings.Join(details, ", "))
+	}
+	tw.Flush()
+
+	if r.AvgKeyframeInterval > 0 {
+		fmt.Fprintf(w, "keyframes: every %.2fs on average, at most %.2fs apart\n", r.AvgKeyframeInterval, r.MaxKeyframeInterval)
+	}
+	if len(r.Chapters) > 0 {
+		fmt.Fprintf(w, "chapters:\n")
+		for _, ch := range r.Chapters {
+			fmt.Fprintf(w, "  %s-%s  %s\n", clockString(ch.Start), clockString(ch.End), ch.Title)
+		}
+	}
+
+	yesNo := map[bool]string{true: "yes", false: "no"}
+	fmt.Fprintf(w, "editable: %s, -copy: %s, select engine: %s, WAV editor: %s\n",
+		yesNo[r.Verdict.Editable], yesNo[r.Verdict.StreamCopy], yesNo[r.Verdict.Select], yesNo[r.Verdict.WAVEditor])
+	for _, n := range r.Verdict.Notes {
+		fmt.Fprintf(w, "- %s\n", n)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// progressEvent is a report of how far along an edit is, written
+// as a line of JSON to standard output with -progress-json.
+type progressEvent struct {
+	Stage   string  `json:"stage"`
+	Percent float64 `json:"percent,omitempty"`
+	FPS     float64 `json:"fps,omitempty"`
+	Speed   float64 `json:"speed,omitempty"` // times real time
+	ETA     float64 `json:"eta,omitempty"`   // seconds left in the stage
+}
+
+// setStage starts the stage of the edit with the given name,
+// in which ffmpeg writes duration seconds of output.
+func (e *edit) setStage(name string, duration float64) {
+	e.stage.name, e.stage.duration = name, duration
+	e.reportProgress(progressEvent{Stage: name})
+}
+
+// reportProgress writes the event, if progress is being reported.
+func (e *edit) reportProgress(ev progressEvent) {
+	if !e.opts.progressJSON {
+		return
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	os.Stdout.Write(append(data, '\n'))
+}
+
+// ffmpegProgress turns a block of ffmpeg's -progress output, as
+// keys and values, into an event for the stage.
+func (e *edit) ffmpegProgress(fields map[string]string) progressEvent {
+	ev := progressEvent{Stage: e.stage.name}
+	ev.FPS, _ = strconv.ParseFloat(fields["fps"], 64)
+	ev.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(fields["speed"]), "x"), 64)
+	us, err := strconv.ParseInt(fields["out_time_us"], 10, 64)
+	if err != nil || e.stage.duration <= 0 {
+		return ev
+	}
+	done := float64(us) / 1e6
+	ev.Percent = math.Round(math.Min(done/e.stage.duration, 1)*1000) / 10
+	if ev.Speed > 0 {
+		ev.ETA = math.Round(math.Max(e.stage.duration-done, 0) / ev.Speed)
+	}
+	if fields["progress"] == "end" {
+		ev.Percent, ev.ETA = 100, 0
+	}
+	return ev
+}
+
+// seconds returns how long the span is, in an input that's
+// inputDuration seconds long; an open span is 0 seconds long if the
+// duration of the input isn't known.
+func (s span) seconds(inputDuration float64) float64 {
+	if s.open {
+		return math.Max(inputDuration-s.start.SecondNum(), 0)
+	}
+	return s.end.SecondNum() - s.start.SecondNum()
+}
+
+// spansSeconds returns the total length of the spans.
+func (e *edit) spansSeconds(spans []span) float64 {
+	var total float64
+	for _, sp := range spans {
+		total += sp.seconds(e.inputInfo.Format.duration())
+	}
+	return total
+}
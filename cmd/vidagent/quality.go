@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// qualitySamples is how many places in the output the quality
+	// check compares with the input, and qualitySampleSeconds how
+	// long each is.
+	qualitySamples       = 3
+	qualitySampleSeconds = 2.0
+
+	// qualityMargin is how far samples are kept from the edges
+	// of spans, in seconds, where encoders may make the frames
+	// differ a bit from the input for reasons that don't matter.
+	qualityMargin = 0.5
+)
+
+// qualitySample is a place in the output and where it came
+// from in the input, in seconds.
+type qualitySample struct {
+	output, input float64
+}
+
+// checkQuality compares a few places in the output file that the
+// edits didn't change with the same places in the input, and warns
+// if they've lost too much quality (by SSIM) in encoding, like from
+// settings that are accidentally destructive.
+func (e *edit) checkQuality(file string, edits []action) error {
+	if len(e.videoInputFilters()) > 0 || e.opts.toneMap || e.inputInfo.stream("video") == nil {
+		log.Println("quality check: skipped, since the video is changed on purpose (or there's none)")
+		return nil
+	}
+	samples := e.qualitySamplesOf(e.outputSpans(edits))
+	if len(samples) == 0 {
+		log.Println("quality check: skipped, since no part of the output is long enough to compare")
+		return nil
+	}
+
+	var worst qualityResult
+	for i, s := range samples {
+		r, err := e.compareQuality(file, s)
+		if err != nil {
+			return fmt.Errorf("quality check: %v", err)
+		}
+		log.Printf("quality check: at %s (%s of the input): SSIM %.4f, PSNR %.1f dB",
+			clockString(s.output), clockString(s.input), r.ssim, r.psnr)
+		if i == 0 || r.ssim < worst.ssim {
+			worst = r
+		}
+	}
+	if worst.ssim < e.opts.minSSIM {
+		log.Printf("quality check: WARNING: SSIM is as low as %.4f, below -min-ssim %.4f; the encoding settings may be losing too much quality",
+			worst.ssim, e.opts.minSSIM)
+	}
+	return nil
+}
+
+// qualitySamplesOf returns up to qualitySamples places spread
+// over the output spans, away from their edges.
+func (e *edit) qualitySamplesOf(spans []span) []qualitySample {
+	type usable struct {
+		output, input, seconds float64
+	}
+	var usables []usable
+	var total, pos float64
+	for _, sp := range spans {
+		dur := sp.seconds(e.inputInfo.Format.duration())
+		if room := dur - 2*qualityMargin - qualitySampleSeconds; room > 0 {
+			usables = append(usables, usable{pos + qualityMargin, sp.start.SecondNum() + qualityMargin, room})
+			total += room
+		}
+		pos += dur
+	}
+
+	// evenly spaced over the room there is for them
+	var samples []qualitySample
+	for k := range qualitySamples {
+		at := total * (float64(k) + 0.5) / qualitySamples
+		for _, u := range usables {
+			if at <= u.seconds {
+				samples = append(samples, qualitySample{u.output + at, u.input + at})
+				break
+			}
+			at -= u.seconds
+		}
+	}
+	return samples
+}
+
+// qualityResult is how close frames of the output are to the input.
+type qualityResult struct {
+	ssim float64 // from 0 to 1 (for identical frames)
+	psnr float64 // in dB; infinite for identical
+}
+
+// compareQuality returns the average SSIM and PSNR of a sample of the
+// output file compared with the input.
+func (e *edit) compareQuality(file string, s qualitySample) (qualityResult, error) {
+	length := strconv.FormatFloat(qualitySampleSeconds, 'f', 3, 64)
+	out, err := ffmpegOutput(
+		"-ss", strconv.FormatFloat(s.output, 'f', 3, 64), "-t", length, "-i", fileArg(file),
+		"-ss", strconv.FormatFloat(s.input, 'f', 3, 64), "-t", length, "-i", fileArg(e.opts.inputFile),
+		// (the output may have been scaled)
+		"-filter_complex", "[0:v:0][1:v:0]scale2ref[d][r];[r]split[r1][r2];[d][r1]ssim[s];[s][r2]psnr,metadata=mode=print:file=-",
+		"-an", "-f", "null", "-")
+	if err != nil {
+		return qualityResult{}, err
+	}
+
+	var r qualityResult
+	var ssims, psnrs []float64
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, val, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "lavfi.ssim.All":
+			if v, err := strconv.ParseFloat(val, 64); err == nil {
+				ssims = append(ssims, v)
+			}
+		case "lavfi.psnr.psnr_avg":
+			// identical frames are "inf"
+			if v, err := strconv.ParseFloat(val, 64); err == nil {
+				psnrs = append(psnrs, v)
+			}
+		}
+	}
+	if len(ssims) == 0 {
+		return r, fmt.Errorf("no frames were compared at %s", clockString(s.output))
+	}
+	r.ssim, r.psnr = mean(ssims), mean(psnrs)
+	return r, nil
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return math.NaN()
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+)
+
+// verbFlag is a flag, like -cut, that adds an action with its verb to
+// the actions for each time it's given.
+type verbFlag struct {
+	verb    Verb
+	actions *actionFlags
+}
+
+func (v verbFlag) String() string { return "" }
+
+func (v verbFlag) Set(s string) error {
+	return v.actions.Set(string(v.verb) + " " + s)
+}
+
+// quickCmd makes an edit with the actions given as flags, like
+// -cut 1:00-2:00 -mute 5:00-5:03, instead of a filter file. It
+// takes the same options as an edit otherwise.
+func quickCmd(args []string) error {
+	fs := flag.NewFlagSet("quick", flag.ExitOnError)
+	o := defaultOptions()
+	editFlags(o).VisitAll(func(f *flag.Flag) {
+		if f.Name != "filter" && f.Name != "filter-repo" {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	fs.Var(verbFlag{CutVerb, &o.inlineActions}, "cut", "cut this segment, like 1:00-2:00 (can be given more than once)")
+	fs.Var(verbFlag{MuteVerb, &o.inlineActions}, "mute", "mute this segment, like 5:00-5:03 (can be given more than once)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent quick -in <file> -out <file> [-cut <start-end>]... [-mute <start-end>]... [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	e := newEdit(o)
+
+	if len(e.opts.inlineActions) == 0 {
+		fs.Usage()
+		return errors.New("no edits to make (use -cut, -mute, or -action)")
+	}
+	if err := e.opts.validate(); err != nil {
+		return err
+	}
+	e.run()
+	return nil
+}
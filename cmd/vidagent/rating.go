@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// rubric decides how strong a movie's content of each reason category
+// is, from how many segments of it there are and how long they are in
+// all, so that a family can decide whether to watch it at all. For
+// example:
+//
+//	{
+//		"levels": ["none", "mild", "moderate", "severe"],
+//		"categories": {
+//			"language": {"mild": {"count": 1}, "moderate": {"count": 10}, "severe": {"count": 25}},
+//			"*": {"mild": {"count": 1}, "moderate": {"seconds": 60}, "severe": {"seconds": 300}}
+//		}
+//	}
+//
+// The levels are from least to most. A category is at the highest level
+// whose threshold, by count or by seconds, it reaches; it's at the first
+// level if it reaches none. The "*" category is for the categories the
+// rubric doesn't list.
+type rubric struct {
+	Levels     []string                        `json:"levels"`
+	Categories map[string]map[string]threshold `json:"categories"`
+}
+
+// threshold is how much content reaches a level of a rubric;
+// zero means that count or duration doesn't matter.
+type threshold struct {
+	Count   int     `json:"count"`
+	Seconds float64 `json:"seconds"`
+}
+
+// defaultRubric is the rubric used when none is given.
+var defaultRubric = rubric{
+	Levels: []string{"none", "mild", "moderate", "severe"},
+	Categories: map[string]map[string]threshold{
+		"*": {
+			"mild":     {Count: 1},
+			"moderate": {Count: 10, Seconds: 60},
+			"severe":   {Count: 30, Seconds: 300},
+		},
+	},
+}
+
+// ratingReport is the content summary of a filter file.
+type ratingReport struct {
+	Title      string           `json:"title"`
+	Rating     string           `json:"rating"` // the highest level of any category
+	Categories []ratingCategory `json:"categories"`
+}
+
+// ratingCategory is the content of one reason category.
+type ratingCategory struct {
+	Category    string            `json:"category"`
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Count       int               `json:"count"`
+	Seconds     float64           `json:"seconds"`
+	Level       string            `json:"level"`
+	Specifiers  []ratingSpecifier `json:"specifiers,omitempty"`
+}
+
+// ratingSpecifier is the content of a category with one specifier.
+type ratingSpecifier struct {
+	Specifier string  `json:"specifier"`
+	Name      string  `json:"name"`
+	Count     int     `json:"count"`
+	Seconds   float64 `json:"seconds"`
+}
+
+// ratingCmd summarizes the content of a filter file by the reasons
+// of its actions, rated according to a rubric.
+func ratingCmd(args []string) error {
+	fs := flag.NewFlagSet("rating", flag.ExitOnError)
+	e := newEdit(defaultOptions())
+	rubricFile := fs.String("rubric", "", "rate the content according to this rubric file (default is a count and duration rubric from none to severe)")
+	format := fs.String("format", "markdown", "the format of the summary: markdown or json")
+	out := fs.String("out", "", "write to this file instead of standard output")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent rating [options] <filter file>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("one filter file required")
+	}
+	if *format != "markdown" && *format != "json" {
+		return fmt.Errorf("-format must be markdown or json")
+	}
+	r := defaultRubric
+	if *rubricFile != "" {
+		var err error
+		r, err = loadRubric(*rubricFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	actions, err := e.readFilterFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	base := filepath.Base(fs.Arg(0))
+	report := rateContent(strings.TrimSuffix(base, filepath.Ext(base)),
+		withoutVerb(actions, ChapterBreakVerb, ExtractVerb), r)
+
+	if *out == "" {
+		return writeRating(os.Stdout, report, *format)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	err = writeRating(f, report, *format)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeRating writes the report to w in the format.
+func writeRating(w io.Writer, report ratingReport, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(report)
+	}
+	return writeRatingMarkdown(w, report)
+}
+
+// loadRubric reads a rubric file.
+func loadRubric(filename string) (rubric, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return rubric{}, err
+	}
+	var r rubric
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		return r, fmt.Errorf("%s: %v", filename, err)
+	}
+	if len(r.Levels) == 0 {
+		return r, fmt.Errorf("%s: no levels", filename)
+	}
+	categories := make(map[string]map[string]threshold)
+	for category, thresholds := range r.Categories {
+		for level := range thresholds {
+			if !slices.Contains(r.Levels, level) {
+				return r, fmt.Errorf("%s: category '%s' has a threshold for unknown level '%s'", filename, category, level)
+			}
+		}
+		categories[strings.ToLower(category)] = thresholds
+	}
+	r.Categories = categories
+	return r, nil
+}
+
+// level returns the level of content of the category that
+// there are count segments of, covering seconds in all.
+func (r rubric) level(category string, count int, seconds float64) string {
+	thresholds, ok := r.Categories[strings.ToLower(category)]
+	if !ok {
+		thresholds = r.Categories["*"]
+	}
+	level := r.Levels[0]
+	for _, l := range r.Levels[1:] {
+		t, ok := thresholds[l]
+		if ok && ((t.Count > 0 && count >= t.Count) || (t.Seconds > 0 && seconds >= t.Seconds)) {
+			level = l
+		}
+	}
+	return level
+}
+
+// rateContent summarizes the content of the actions, by the
+// categories of their reasons, according to the rubric.
+func rateContent(title string, actions []action, r rubric) ratingReport {
+	byCategory := make(map[string][]action)
+	var categories []string
+	for _, act := range actions {
+		category := strings.ToLower(act.reason.Category)
+		if category == "" {
+			continue
+		}
+		if _, ok := byCategory[category]; !ok {
+			categories = append(categories, category)
+		}
+		byCategory[category] = append(byCategory[category], act)
+	}
+	slices.Sort(categories)
+
+	report := ratingReport{Title: title, Rating: r.Levels[0], Categories: []ratingCategory{}}
+	for _, category := range categories {
+		acts := byCategory[category]
+		c := ratingCategory{
+			Category:    category,
+			Name:        cfg.reasonName(Reason{Category: acts[0].reason.Category}),
+			Description: cfg.reasonDescription(Reason{Category: category}),
+			Count:       len(acts),
+			Seconds:     roundMillis(coveredSeconds(acts)),
+		}
+		c.Level = r.level(category, c.Count, c.Seconds)
+		if slices.Index(r.Levels, c.Level) > slices.Index(r.Levels, report.Rating) {
+			report.Rating = c.Level
+		}
+
+		bySpecifier := make(map[string][]action)
+		var specifiers []string
+		for _, act := range acts {
+			spec := strings.ToLower(act.reason.Specifier)
+			if spec == "" {
+				continue
+			}
+			if _, ok := bySpecifier[spec]; !ok {
+				specifiers = append(specifiers, spec)
+			}
+			bySpecifier[spec] = append(bySpecifier[spec], act)
+		}
+		slices.Sort(specifiers)
+		for _, spec := range specifiers {
+			specActs := bySpecifier[spec]
+			c.Specifiers = append(c.Specifiers, ratingSpecifier{
+				Specifier: spec,
+				Name:      cfg.reasonName(specActs[0].reason),
+				Count:     len(specActs),
+				Seconds:   roundMillis(coveredSeconds(specActs)),
+			})
+		}
+		report.Categories = append(report.Categories, c)
+	}
+	return report
+}
+
+// coveredSeconds returns how much time the actions cover,
+// counting the time where they overlap only once.
+func coveredSeconds(actions []action) float64 {
+	spans := make([][2]float64, len(actions))
+	for i, act := range actions {
+		spans[i] = [2]float64{act.start.SecondNum(), act.end.SecondNum()}
+	}
+	slices.SortFunc(spans, func(a, b [2]float64) int {
+		switch {
+		case a[0] < b[0]:
+			return -1
+		case a[0] > b[0]:
+			return 1
+		}
+		return 0
+	})
+	var total, end float64
+	for _, sp := range spans {
+		if sp[0] > end {
+			end = sp[0]
+		}
+		if sp[1] > end {
+			total += sp[1] - end
+			end = sp[1]
+		}
+	}
+	return total
+}
+
+// writeRatingMarkdown writes the report to w as Markdown.
+func writeRatingMarkdown(w io.Writer, report ratingReport) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Content summary: %s\n\n", report.Title)
+	fmt.Fprintf(&b, "Overall: **%s**\n", report.Rating)
+	if len(report.Categories) == 0 {
+		b.WriteString("\nNo content is annotated with reasons.\n")
+	} else {
+		b.WriteString("\n| Content | Level | Count | Duration |\n|---|---|---|---|\n")
+		for _, c := range report.Categories {
+			fmt.Fprintf(&b, "| %s | %s | %d | %s |\n", c.Name, c.Level, c.Count, seconds(c.Seconds))
+			for _, s := range c.Specifiers {
+				fmt.Fprintf(&b, "| &nbsp;&nbsp;%s | | %d | %s |\n", s.Name, s.Count, seconds(s.Seconds))
+			}
+		}
+		var described bool
+		for _, c := range report.Categories {
+			if c.Description == "" {
+				continue
+			}
+			if !described {
+				b.WriteString("\n")
+				described = true
+			}
+			fmt.Fprintf(&b, "- **%s**: %s\n", c.Name, c.Description)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Render pipelines don't always make a finished file: the input can
+// be an image sequence (like frames/%05d.png) or a raw stream of video
+// (rawvideo, usually .yuv) or audio (PCM, like s16le), which have no
+// header to say what they are, so flags do. Before editing, the input
+// is imported into a file the engines can read: the video losslessly
+// into FFV1 in Matroska, with a silent audio track since the engines
+// expect one, and the audio into WAV, for the built-in WAV editor.
+
+// sequencePattern matches the number in the name of an image sequence.
+var sequencePattern = regexp.MustCompile(`%0?\d*d`)
+
+// pcmPattern matches the names of ffmpeg's raw PCM formats.
+var pcmPattern = regexp.MustCompile(`^([su](8|16|24|32)|f(32|64))(le|be)?$|^(mulaw|alaw)$`)
+
+// imageExts are the extensions of the images an image sequence can be.
+var imageExts = []string{".png", ".jpg", ".jpeg", ".tif", ".tiff", ".bmp", ".tga", ".dpx", ".exr", ".webp"}
+
+// isImageSequence returns true if name is a pattern of image files,
+// with a number (like %05d) or a glob (like *.png).
+func isImageSequence(name string) bool {
+	if !slices.Contains(imageExts, strings.ToLower(filepath.Ext(name))) {
+		return false
+	}
+	return sequencePattern.MatchString(name) || strings.ContainsAny(filepath.Base(name), "*?")
+}
+
+// rawFormat returns the format of the raw input: rawvideo, a PCM
+// format, or "" if it isn't raw. .yuv and .pcm files are raw without
+// -in-format.
+func (o *options) rawFormat() string {
+	if o.inputFormat != "" {
+		return o.inputFormat
+	}
+	switch strings.ToLower(filepath.Ext(o.inputFile)) {
+	case ".yuv":
+		return "rawvideo"
+	case ".pcm":
+		return "s16le"
+	}
+	return ""
+}
+
+// validateRawInput returns an error if the options don't say enough
+// about an image sequence or raw input to read it.
+func validateRawInput(o *options) error {
+	format := o.rawFormat()
+	switch {
+	case format == "rawvideo":
+		if o.inputSize == "" || o.inputFrameRate == "" {
+			return errors.New("raw video input needs its size and frame rate (use -in-size and -fps)")
+		}
+	case format != "":
+		if !pcmPattern.MatchString(format) {
+			return fmt.Errorf("unknown -in-format '%s'; must be rawvideo or a PCM format like s16le or f32le", format)
+		}
+		if o.inputSampleRate <= 0 || o.inputChannels <= 0 {
+			return errors.New("raw audio input needs its sample rate and number of channels (use -in-sample-rate and -in-channels)")
+		}
+		outputs := []string{o.outputFile}
+		if len(o.outProfiles) > 0 {
+			outputs = o.outProfiles.files()
+		}
+		for _, file := range outputs {
+			if !isWAV(file) {
+				return errors.New("raw audio input can only be edited into a WAV file")
+			}
+		}
+	case isImageSequence(o.inputFile):
+		if o.inputFrameRate == "" {
+			return errors.New("an image sequence needs its frame rate (use -fps)")
+		}
+	default:
+		return nil
+	}
+	if o.inputFrameRate != "" && parseRate(o.inputFrameRate) <= 0 {
+		return fmt.Errorf("bad -fps '%s'; must be a number of frames per second like 24 or 24000/1001", o.inputFrameRate)
+	}
+	if o.streamCopy {
+		return errors.New("-copy can't be used with image sequences or raw input, which are re-encoded")
+	}
+	return nil
+}
+
+// rawInputArgs returns the ffmpeg options that say how to read the
+// input, if it's an image sequence or raw.
+func (e *edit) rawInputArgs() []string {
+	format := e.opts.rawFormat()
+	switch {
+	case format == "rawvideo":
+		args := []string{"-f", "rawvideo", "-video_size", e.opts.inputSize, "-framerate", e.opts.inputFrameRate}
+		if e.opts.inputPixFmt != "" {
+			args = append(args, "-pixel_format", e.opts.inputPixFmt)
+		}
+		return args
+	case format != "":
+		return []string{"-f", format, "-ar", strconv.Itoa(e.opts.inputSampleRate), "-ac", strconv.Itoa(e.opts.inputChannels)}
+	case isImageSequence(e.opts.inputFile):
+		args := []string{"-f", "image2", "-framerate", e.opts.inputFrameRate}
+		if !sequencePattern.MatchString(e.opts.inputFile) {
+			args = append(args, "-pattern_type", "glob")
+		}
+		return args
+	}
+	return nil
+}
+
+// wavCodec returns the codec for raw audio of the PCM format in WAV,
+// which is always little-endian. The WAV editor only silences linear
+// PCM, which is unsigned at 8 bits and signed otherwise.
+func wavCodec(format string) string {
+	bits := strings.TrimRight(strings.TrimLeft(format, "suf"), "lbe")
+	switch {
+	case format == "mulaw" || format == "alaw":
+		return "pcm_s16le"
+	case bits == "8":
+		return "pcm_u8"
+	case format[0] == 'f':
+		return "pcm_f" + bits + "le"
+	}
+	return "pcm_s" + bits + "le"
+}
+
+// rawAudio returns true if the input is raw audio.
+func (e *edit) rawAudio() bool {
+	format := e.opts.rawFormat()
+	return format != "" && format != "rawvideo"
+}
+
+// importInput imports an image sequence or raw input into a file in
+// dir that the engines (or the WAV editor) can read, which becomes the
+// input. It does nothing for other inputs.
+func (e *edit) importInput(dir string) error {
+	args := e.rawInputArgs()
+	if args == nil {
+		return nil
+	}
+	var file string
+	args = append([]string{"-y"}, args...)
+	args = append(args, "-i", fileArg(e.opts.inputFile))
+	if e.rawAudio() {
+		file = filepath.Join(dir, "input.wav")
+		args = append(args, "-map", "0:a:0", "-c:a", wavCodec(e.opts.rawFormat()), fileArg(file))
+	} else {
+		file = filepath.Join(dir, "input.mkv")
+		args = append(args, "-f", "lavfi", "-i", "anullsrc=r=48000:cl=stereo",
+			"-map", "0:v:0", "-map", "1:a:0", "-shortest",
+			"-c:v", "ffv1", "-pix_fmt", "yuv420p", "-c:a", "pcm_s16le", fileArg(file))
+	}
+
+	e.setStage("importing", 0)
+	log.Printf("importing %s to edit it", e.opts.inputFile)
+	err := e.runFFmpeg(args, file)
+	if err != nil {
+		return fmt.Errorf("importing %s: %v", e.opts.inputFile, err)
+	}
+	e.importedInput, e.opts.inputFile = e.opts.inputFile, file
+	return nil
+}
+
+// importTempInput imports the input, if it needs to be, into a
+// temporary directory, and returns a function that removes it.
+func (e *edit) importTempInput() (func(), error) {
+	if e.rawInputArgs() == nil {
+		return func() {}, nil
+	}
+	dir, err := makeTempDir(e.opts.tempRoot, "vidagent-input-")
+	if err != nil {
+		return nil, err
+	}
+	err = e.importInput(dir)
+	if err != nil {
+		removeTempDir(dir)
+		return nil, err
+	}
+	return func() { removeTempDir(dir) }, nil
+}
+
+// inputName returns the name of the input as it was given, even if
+// it was imported.
+func (e *edit) inputName() string {
+	if e.importedInput != "" {
+		return e.importedInput
+	}
+	return e.opts.inputFile
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A filter repository is a library published over HTTP: the
+// index.json of a library directory, and the filter files it
+// lists, served from the same base URL.
+
+// fetchRepoFilter finds the filter file in the repository at
+// repoURL that matches the input and downloads it, returning
+// the name of the downloaded file. The input is matched by its
+// duration and, if that's not enough, by its file name.
+func (e *edit) fetchRepoFilter(repoURL, input string) (string, error) {
+	info, err := probe(input)
+	if err != nil {
+		return "", fmt.Errorf("probing input to find its filter: %v", err)
+	}
+	duration := info.Format.duration()
+	if duration == 0 {
+		return "", fmt.Errorf("input duration is unknown, so its filter can't be found")
+	}
+
+	base := strings.TrimSuffix(repoURL, "/") + "/"
+	var entries []libraryEntry
+	err = httpGetJSON(base+libraryIndexFile, &entries)
+	if err != nil {
+		return "", fmt.Errorf("getting repository index: %v", err)
+	}
+
+	entry, err := matchRepoEntry(entries, duration, filepath.Base(input))
+	if err != nil {
+		return "", err
+	}
+	// (the checksum is also used as a file name, so it must be hex)
+	if sum, err := hex.DecodeString(entry.SHA256); err != nil || len(sum) != sha256.Size {
+		return "", fmt.Errorf("repository doesn't list a valid checksum for %s", entry.label())
+	}
+	log.Printf("found filter for %s in %s", entry.label(), repoURL)
+
+	// files are saved by checksum, so one that's already been
+	// downloaded (and verified) doesn't need to be again
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(cache, "vidagent", "filters")
+	filename := filepath.Join(dir, entry.SHA256+".filter")
+	fileURL := base + (&url.URL{Path: entry.File}).EscapedPath()
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	if e.opts.verifyKey != "" {
+		// the signature is checked with the file, before it's used
+		if _, err := os.Stat(filename + sigExt); err != nil {
+			sig, err := httpGet(fileURL + sigExt)
+			if err != nil {
+				return "", fmt.Errorf("downloading signature %s%s: %v", fileURL, sigExt, err)
+			}
+			err = os.WriteFile(filename+sigExt, sig, 0644)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	if _, err := os.Stat(filename); err == nil {
+		return filename, nil
+	}
+
+	data, err := httpGet(fileURL)
+	if err != nil {
+		return "", fmt.Errorf("downloading %s: %v", fileURL, err)
+	}
+	sum := sha256.Sum256(data)
+	if got := hex.EncodeToString(sum[:]); got != strings.ToLower(entry.SHA256) {
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s but got %s", fileURL, entry.SHA256, got)
+	}
+
+	// write then rename so there's never a partial file at filename
+	err = os.WriteFile(filename+".tmp", data, 0644)
+	if err != nil {
+		return "", err
+	}
+	return filename, os.Rename(filename+".tmp", filename)
+}
+
+// matchRepoEntry returns the entry for a video of the given duration,
+// using the video's file name to choose if several have about the
+// same duration.
+func matchRepoEntry(entries []libraryEntry, duration float64, name string) (libraryEntry, error) {
+	var candidates []libraryEntry
+	for _, e := range entries {
+		if e.fits(duration) {
+			candidates = append(candidates, e)
+		}
+	}
+	if len(candidates) == 0 {
+		return libraryEntry{}, fmt.Errorf("no filter in the repository for a video of duration %s", seconds(duration))
+	}
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+
+	var named []libraryEntry
+	normName := normalizeTitle(name)
+	for _, e := range candidates {
+		if strings.Contains(normName, normalizeTitle(e.Title)) &&
+			(e.Year == 0 || strings.Contains(name, strconv.Itoa(e.Year))) {
+			named = append(named, e)
+		}
+	}
+	if len(named) == 1 {
+		return named[0], nil
+	}
+	if len(named) > 1 {
+		candidates = named
+	}
+
+	labels := make([]string, len(candidates))
+	for i, e := range candidates {
+		labels[i] = e.label()
+	}
+	return libraryEntry{}, fmt.Errorf("several filters in the repository could be for this video (download the right one and use -filter): %s",
+		strings.Join(labels, ", "))
+}
+
+// normalizeTitle returns s in lower case with only its letters and
+// digits, so that "The.Movie.2004.mkv" can match "The Movie".
+func normalizeTitle(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, s)
+}
+
+// httpGet returns the body of the resource at u.
+func httpGet(u string) ([]byte, error) {
+	resp, err := http.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// httpGetJSON decodes the JSON resource at u into v.
+func httpGetJSON(u string, v any) error {
+	data, err := httpGet(u)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+)
+
+// rotation returns how many degrees clockwise the stream's frames
+// must be rotated to display upright, according to its display
+// matrix or (in older files) its rotate tag: 0, 90, 180, or 270.
+func (s probeStream) rotation() int {
+	var theta float64
+	if rotate, ok := s.Tags["rotate"]; ok {
+		theta, _ = strconv.ParseFloat(rotate, 64)
+	}
+	for _, sd := range s.SideData {
+		if sd.SideDataType == "Display Matrix" {
+			// the display matrix angle is counter-clockwise
+			theta = -sd.Rotation
+		}
+	}
+	deg := int(math.Round(theta/90)) * 90 % 360
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
+
+// inputRotation returns the rotation of the input video.
+func (e *edit) inputRotation() int {
+	if video := e.inputInfo.stream("video"); video != nil {
+		return video.rotation()
+	}
+	return 0
+}
+
+// rotationFilters returns the filters that rotate the
+// video upright, if the rotation is to be baked in.
+func (e *edit) rotationFilters() []string {
+	if e.opts.rotationMode != "bake" {
+		return nil
+	}
+	switch e.inputRotation() {
+	case 90:
+		return []string{"transpose=clock"}
+	case 180:
+		return []string{"hflip", "vflip"}
+	case 270:
+		return []string{"transpose=cclock"}
+	}
+	return nil
+}
+
+// rotationArgs returns the output options that set the rotation
+// metadata of re-encoded video: none if the rotation was baked
+// into the frames, or the input's rotation if it is being kept.
+func (e *edit) rotationArgs() []string {
+	deg := e.inputRotation()
+	if deg == 0 {
+		return nil
+	}
+	if e.opts.rotationMode == "bake" {
+		deg = 0
+	}
+	return []string{"-metadata:s:v:0", "rotate=" + strconv.Itoa(deg)}
+}
+
+// videoNeedsFilters returns true if the video has to be filtered,
+// and thus re-encoded, even if no actions change it. Rotation
+// doesn't count, since copying the video keeps its rotation.
+func (e *edit) videoNeedsFilters() bool {
+	return len(e.videoFilters()) > len(e.rotationFilters())
+}
+
+// logRotation reports what will be done about the input's rotation.
+func (e *edit) logRotation() {
+	if deg := e.inputRotation(); deg != 0 {
+		if e.opts.rotationMode == "bake" {
+			log.Printf("input is rotated %d°; rotating it upright (use -rotation keep to keep the rotation metadata instead)", deg)
+		} else {
+			log.Printf("input is rotated %d°; keeping its rotation metadata", deg)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// scaleFilter returns the filter that resizes the video according
+// to the -scale and -max-height flags, or "" if neither is set.
+func (e *edit) scaleFilter() (string, error) {
+	if e.opts.outputSize != "" && e.opts.maxHeight > 0 {
+		return "", fmt.Errorf("-scale and -max-height can't be used together")
+	}
+	if e.opts.maxHeight > 0 {
+		// never upscale; -2 keeps the aspect ratio with an even width
+		return fmt.Sprintf("scale=-2:'min(ih,%d)'", e.opts.maxHeight), nil
+	}
+	if e.opts.outputSize == "" {
+		return "", nil
+	}
+	w, h, ok := strings.Cut(strings.ToLower(e.opts.outputSize), "x")
+	if !ok {
+		return "", fmt.Errorf("invalid -scale '%s'; must be WIDTHxHEIGHT, like 1280x720 or 1280x-2", e.opts.outputSize)
+	}
+	for _, dim := range []string{w, h} {
+		n, err := strconv.Atoi(dim)
+		if err != nil || n == 0 || n < -2 {
+			return "", fmt.Errorf("invalid -scale '%s'; dimensions must be positive, or -1 or -2 to keep the aspect ratio", e.opts.outputSize)
+		}
+	}
+	return fmt.Sprintf("scale=%s:%s", w, h), nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import "fmt"
+
+// ringFrequency is the frequency, in Hz, that scrambled audio is
+// ring-modulated with. This mirrors the spectrum of speech around
+// it, like old radio scramblers, so words can't be made out, but
+// the soundtrack goes on about as loud as before instead of
+// dropping to silence.
+const ringFrequency = 3000
+
+// applyScrambles adds the filter for the scramble actions to the
+// audio effects, the same way as for blurs, and returns the other
+// actions.
+func (e *edit) applyScrambles(actions []action) []action {
+	var scrambles, others []action
+	for _, act := range actions {
+		if act.verb == ScrambleVerb {
+			scrambles = append(scrambles, act)
+		} else {
+			others = append(others, act)
+		}
+	}
+	if len(scrambles) > 0 {
+		// one filter for all of them, since aeval runs
+		// its expression for every sample
+		e.effects.audio = append(e.effects.audio, fmt.Sprintf("aeval='val(ch)*if(%s,sin(2*PI*%d*t),1)':c=same",
+			e.timeExpr(scrambles), ringFrequency))
+	}
+	return others
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// runSelect performs the actions with a constant-size filter graph:
+// cut segments are dropped with select and aselect, and muted
+// segments are silenced with volume, all using time expressions
+// instead of splicing segments together. Timestamps are rewritten
+// from frame and sample counts, so this assumes constant frame rate.
+func (e *edit) runSelect(actions []action) error {
+	if len(actions) == 0 {
+		return fmt.Errorf("no actions to perform")
+	}
+
+	var cuts, mutes []action
+	for i, act := range actions {
+		switch act.verb {
+		case CutVerb:
+			cuts = append(cuts, act)
+		case MuteVerb:
+			mutes = append(mutes, act)
+		default:
+			return fmt.Errorf("action %d: verb '%s' is not supported by the select engine", i, act.verb)
+		}
+	}
+
+	videoChain := e.videoInputFilters()
+	audioChain := e.audioInputFilters()
+	if len(mutes) > 0 {
+		audioChain = append(audioChain, fmt.Sprintf("volume=0:enable='%s'", e.timeExpr(mutes)))
+	}
+	// with -mute-fill, the audio so far is mixed with the music
+	// (input 1) before it's cut, while the times still match
+	audioGraph := "[" + e.audioSpec(0) + "]"
+	fill := e.opts.muteFill != "" && len(mutes) > 0
+	if fill {
+		audioGraph = fmt.Sprintf("[%s]%s[muted];%s,", e.audioSpec(0), strings.Join(audioChain, ","), e.muteFillMix("muted", 1, mutes))
+		audioChain = nil
+	}
+	if len(cuts) > 0 {
+		keep := fmt.Sprintf("'not(%s)'", e.timeExpr(cuts))
+		videoChain = append(videoChain, "select="+keep, "setpts=N/FRAME_RATE/TB")
+		audioChain = append(audioChain, "aselect="+keep, "asetpts=N/SR/TB")
+	}
+
+	videoChain = append(videoChain, e.videoOutputFilters()...)
+	audioChain = append(audioChain, e.audioOutputFilters()...)
+	if len(videoChain) == 0 {
+		videoChain = []string{"null"}
+	}
+	if len(audioChain) == 0 {
+		audioChain = []string{"anull"}
+	}
+
+	filterCplx := fmt.Sprintf("[0:v]%s[outv];%s%s[outa]",
+		strings.Join(videoChain, ","), audioGraph, strings.Join(audioChain, ","))
+
+	args := []string{
+		e.overwriteArg(),
+	}
+	args = append(args, e.inputArgs()...)
+	inputs := 1
+	if fill {
+		args = append(args, e.muteSourceArgs()...)
+		inputs++
+	}
+	markedIn, markedOut := e.markedCopyArgs(inputs)
+	args = append(args, markedIn...)
+	args = append(args,
+		"-filter_complex", filterCplx,
+		"-map", "[outv]",
+		"-map", "[outa]")
+	args = append(args, e.metadataArgs(actions, 0, 2)...)
+	args = append(args, e.videoEncodeArgs()...)
+	args = append(args, e.hlsArgs()...)
+	args = append(args, fileArg(e.outputPath()))
+	args = append(args, markedOut...)
+
+	return e.runFFmpeg(args, e.outputPath())
+}
+
+// runWithoutCuts performs actions that don't change the timing of
+// the video (mutes, or none, with only effects like blurs) in one
+// pass, silencing the audio with a time expression. Each stream is
+// copied as-is unless something changes it: the video is copied for
+// mutes, and the audio for blurs. This is much faster than splicing,
+// and there's no concat to cause drift.
+func (e *edit) runWithoutCuts(actions []action) error {
+	args := []string{
+		e.overwriteArg(),
+	}
+	// with -mute-fill, the music (input 1) is mixed in during the
+	// mutes, which needs a filter graph for the two inputs
+	fill := e.opts.muteFill != "" && len(actions) > 0
+	audioMap := e.audioSpec(0)
+	args = append(args, e.inputArgs()...)
+	inputs := 1
+	if fill {
+		args = append(args, e.muteSourceArgs()...)
+		audioMap = "[outa]"
+		inputs++
+	}
+	markedIn, markedOut := e.markedCopyArgs(inputs)
+	args = append(args, markedIn...)
+	args = append(args, "-map", "0:v:0", "-map", audioMap)
+	args = append(args, e.metadataArgs(actions, 0, 2)...)
+	if e.videoNeedsFilters() {
+		args = append(args, "-vf", strings.Join(e.videoFilters(), ","))
+		args = append(args, e.videoEncodeArgs()...)
+	} else {
+		args = append(args, "-c:v", "copy")
+	}
+	audioChain := e.audioInputFilters()
+	if len(actions) > 0 {
+		audioChain = append(audioChain, fmt.Sprintf("volume=0:enable='%s'", e.timeExpr(actions)))
+	}
+	switch {
+	case fill:
+		args = append(args, "-filter_complex", fmt.Sprintf("[%s]%s[muted];%s%s[outa]", e.audioSpec(0),
+			strings.Join(audioChain, ","), e.muteFillMix("muted", 1, actions), chain(e.audioOutputFilters())))
+	case len(audioChain)+len(e.audioOutputFilters()) > 0:
+		audioChain = append(audioChain, e.audioOutputFilters()...)
+		args = append(args, "-af", strings.Join(audioChain, ","))
+	default:
+		args = append(args, "-c:a", "copy")
+	}
+	args = append(args, e.hlsArgs()...)
+	args = append(args, fileArg(e.outputPath()))
+	args = append(args, markedOut...)
+	return e.runFFmpeg(args, e.outputPath())
+}
+
+// timeExpr returns an ffmpeg expression that is true (non-zero)
+// at times t within any of the actions' segments.
+func (e *edit) timeExpr(actions []action) string {
+	terms := make([]string, len(actions))
+	for i, act := range actions {
+		terms[i] = fmt.Sprintf("between(t,%s,%s)", e.secondString(act.start), e.secondString(act.end))
+	}
+	return strings.Join(terms, "+")
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// selectActions returns only the actions chosen by the -only-lines,
+// -only-verb, and -only-category flags. An action must match all of
+// the flags that are set, and any one of the values listed in each.
+func (e *edit) selectActions(actions []action) ([]action, error) {
+	actions, err := e.selectLanguage(actions)
+	if err != nil {
+		return nil, err
+	}
+	if e.opts.onlyLines == "" && e.opts.onlyVerbs == "" && e.opts.onlyCategories == "" {
+		return actions, nil
+	}
+
+	lines, err := parseLineRanges(e.opts.onlyLines)
+	if err != nil {
+		return nil, fmt.Errorf("-only-lines: %v", err)
+	}
+	var verbList []string
+	for _, v := range splitList(e.opts.onlyVerbs) {
+		verb, ok := cfg.verb(v)
+		if !ok {
+			return nil, fmt.Errorf("-only-verb: unrecognized verb '%s'", v)
+		}
+		verbList = append(verbList, string(verb))
+	}
+	categories := splitList(e.opts.onlyCategories)
+
+	var selected []action
+	for _, act := range actions {
+		if len(lines) > 0 && !lineInRanges(act.line(), lines) {
+			continue
+		}
+		if len(verbList) > 0 && !slices.Contains(verbList, string(act.verb)) {
+			continue
+		}
+		if len(categories) > 0 && !slices.Contains(categories, strings.ToLower(act.reason.Category)) &&
+			!slices.Contains(categories, strings.ToLower(reasonString(act.reason))) {
+			continue
+		}
+		selected = append(selected, act)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no actions match the -only selectors")
+	}
+	return selected, nil
+}
+
+// selectors returns the values of the selector flags
+// that are set, in a form that identifies them.
+func (e *edit) selectors() string {
+	var parts []string
+	for _, sel := range []struct{ name, val string }{
+		{"only-lines", e.opts.onlyLines},
+		{"only-verb", e.opts.onlyVerbs},
+		{"only-category", e.opts.onlyCategories},
+		{"audio-lang", e.opts.audioLang},
+	} {
+		if sel.val != "" {
+			parts = append(parts, sel.name+"="+sel.val)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseLineRanges parses a list of line numbers and
+// ranges of them, like "3,7-12,20".
+func parseLineRanges(s string) ([][2]int, error) {
+	var ranges [][2]int
+	for _, item := range splitList(s) {
+		from, to, isRange := strings.Cut(item, "-")
+		start, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("bad line number '%s'", from)
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(to)
+			if err != nil {
+				return nil, fmt.Errorf("bad line number '%s'", to)
+			}
+		}
+		if end < start {
+			return nil, fmt.Errorf("bad line range '%s'", item)
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges, nil
+}
+
+// lineInRanges returns true if line is in any of the ranges.
+func lineInRanges(line int, ranges [][2]int) bool {
+	for _, r := range ranges {
+		if line >= r[0] && line <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// lineList formats line numbers as a list, like "3, 7, 12".
+func lineList(lines []int) string {
+	items := make([]string, len(lines))
+	for i, line := range lines {
+		items[i] = strconv.Itoa(line)
+	}
+	return strings.Join(items, ", ")
+}
+
+// splitList splits a comma-separated list into its
+// non-empty items, trimmed and in lower case.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
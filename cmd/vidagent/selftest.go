@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// The self-test is vidagent's end-to-end test: it makes a short
+// synthetic video with ffmpeg (a moving test pattern with a tone),
+// edits it with each engine and each kind of action, as an edit from
+// the command line would, and checks the outputs: how long they are,
+// that mutes are silent, that cuts are gone, that blurs blur, and
+// that fades go black. It's for checking a change to vidagent, or a
+// build of ffmpeg, before trusting it with real videos.
+
+// selftestDuration is how many seconds long the synthetic video is.
+const selftestDuration = 12
+
+// selftestWidth and selftestHeight are the size of the synthetic video.
+const selftestWidth, selftestHeight = 320, 240
+
+// selftestCase is an edit the self-test makes, and what its output
+// should be like.
+type selftestCase struct {
+	name   string
+	filter string
+	checks []selftestCheck
+}
+
+// selftestCheck is something to check about the output of an edit of
+// the input: check returns the result, and whether it's as it should
+// be. Times are of the output, and of the input where it's said.
+type selftestCheck struct {
+	what  string
+	check func(input, output string) (string, bool, error)
+}
+
+// selftestCases are the edits that the self-test makes with each
+// engine.
+var selftestCases = []selftestCase{
+	{"cut", "cut 0:04-0:07\n", []selftestCheck{
+		checkDuration(9),
+		checkShows(4.5, 7.5, 5.5),
+		checkLoud(1, 3),
+		checkLoud(5, 8),
+	}},
+	{"mute", "mute 0:04-0:07\n", []selftestCheck{
+		checkDuration(12),
+		checkSilent(4, 7),
+		checkLoud(1, 3),
+		checkLoud(8, 11),
+	}},
+	{"blur", "blur 0:04-0:07\n", []selftestCheck{
+		checkDuration(12),
+		checkBlurred(5.5, 5.5),
+		checkSharp(2, 2),
+		checkSharp(9, 9),
+		checkLoud(4, 7),
+	}},
+	{"fade", "fadeout 0:04-0:05\nfadein 0:07-0:08\n", []selftestCheck{
+		checkDuration(12),
+		checkBlack(6),
+		checkSilent(5, 7),
+		checkSharp(2, 2),
+		checkSharp(10, 10),
+		checkLoud(9, 11),
+	}},
+	{"scramble", "scramble 0:04-0:07\n", []selftestCheck{
+		checkDuration(12),
+		checkAudioChanged(4, 7),
+		checkLoud(4, 7),
+		checkAudioSame(1, 3),
+		checkAudioSame(8, 11),
+	}},
+	{"mixed", "cut 0:02-0:03\nmute 0:05-0:06\nblur 0:08-0:09\n", []selftestCheck{
+		checkDuration(11),
+		checkShows(2.5, 3.5, 2.5),
+		checkSilent(4, 5),
+		checkLoud(6, 7),
+		checkBlurred(7.5, 8.5),
+		checkSharp(9.5, 10.5),
+	}},
+}
+
+// selftestCmd runs the self-test.
+func selftestCmd(args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	engineList := fs.String("engines", "", "only test these engines (e.g. filtergraph,concat)")
+	caseList := fs.String("cases", "", "only make these edits: "+selftestCaseNames())
+	keep := fs.Bool("keep", false, "keep the synthetic video and the outputs, and say where they are")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent selftest [-engines <list>] [-cases <list>] [-keep]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	engineNames := slices.Sorted(maps.Keys(engines))
+	if *engineList != "" {
+		engineNames = strings.Split(*engineList, ",")
+		for _, name := range engineNames {
+			if _, ok := engines[name]; !ok {
+				return fmt.Errorf("-engines: unknown engine '%s'", name)
+			}
+		}
+	}
+	cases := selftestCases
+	if *caseList != "" {
+		cases = nil
+		for _, name := range strings.Split(*caseList, ",") {
+			i := slices.IndexFunc(selftestCases, func(c selftestCase) bool { return c.name == name })
+			if i < 0 {
+				return fmt.Errorf("-cases: unknown case '%s'; must be %s", name, selftestCaseNames())
+			}
+			cases = append(cases, selftestCases[i])
+		}
+	}
+
+	dir, err := makeTempDir("", "vidagent-selftest-")
+	if err != nil {
+		return err
+	}
+	if *keep {
+		fmt.Printf("files are in %s\n\n", dir)
+	} else {
+		defer removeTempDir(dir)
+	}
+	input, err := makeSelftestInput(dir)
+	if err != nil {
+		return err
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "engine\tedit\tcheck\tresult\t")
+	var checked, failed, skipped int
+	for _, engine := range engineNames {
+		for _, c := range cases {
+			results, err := runSelftestCase(exe, dir, input, engine, c)
+			if err != nil {
+				return err
+			}
+			for _, r := range results {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t\n", engine, c.name, r.what, r.result)
+				switch {
+				case r.skipped:
+					skipped++
+				case !r.ok:
+					checked++
+					failed++
+				default:
+					checked++
+				}
+			}
+		}
+	}
+	tw.Flush()
+
+	var skips string
+	if skipped > 0 {
+		skips = fmt.Sprintf(" (%d edits skipped, which their engines don't support)", skipped)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed%s", failed, checked, skips)
+	}
+	if checked == 0 {
+		return fmt.Errorf("nothing was checked%s", skips)
+	}
+	fmt.Printf("\nall %d checks passed%s\n", checked, skips)
+	return nil
+}
+
+// makeSelftestInput makes the synthetic video in dir, and returns its
+// name.
+func makeSelftestInput(dir string) (string, error) {
+	input := filepath.Join(dir, "input.mkv")
+	_, err := ffmpegOutput(
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc2=size=%dx%d:rate=25:duration=%d", selftestWidth, selftestHeight, selftestDuration),
+		"-f", "lavfi", "-i", fmt.Sprintf("sine=frequency=440:sample_rate=48000:duration=%d", selftestDuration),
+		"-map", "0:v", "-map", "1:a", "-g", "25", "-pix_fmt", "yuv420p", "-shortest", fileArg(input))
+	if err != nil {
+		return "", fmt.Errorf("making the synthetic video: %v", err)
+	}
+	return input, nil
+}
+
+// selftestResult is how a check of an edit turned out.
+type selftestResult struct {
+	what, result string
+	ok           bool
+	skipped      bool // the engine doesn't support the edit
+}
+
+// runSelftestCase makes the case's edit of the input with the engine,
+// by running exe as vidagent, with its files in dir, and returns the
+// results of checking the output: just one, for the edit, if it
+// couldn't be made. The error is for what keeps it from trying.
+func runSelftestCase(exe, dir, input, engine string, c selftestCase) ([]selftestResult, error) {
+	filter := filepath.Join(dir, c.name+".filter")
+	err := os.WriteFile(filter, []byte(c.filter), 0644)
+	if err != nil {
+		return nil, err
+	}
+	output := filepath.Join(dir, fmt.Sprintf("%s-%s.mkv", c.name, engine))
+	cmd := exec.Command(exe, "-in", input, "-out", output, "-filter", filter,
+		"-engine", engine, "-f", "-no-history", "-allow-large-edits")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := exitMessage(strings.Split(strings.TrimSpace(stderr.String()), "\n"))
+		if strings.Contains(msg, "is not supported by") {
+			return []selftestResult{{what: "edit", result: "skipped: not supported", skipped: true}}, nil
+		}
+		return []selftestResult{{what: "edit", result: "FAILED: " + msg}}, nil
+	}
+	var results []selftestResult
+	for _, check := range c.checks {
+		result, ok, err := check.check(input, output)
+		if err != nil {
+			result, ok = "FAILED: "+err.Error(), false
+		}
+		results = append(results, selftestResult{what: check.what, result: result, ok: ok})
+	}
+	return results, nil
+}
+
+// selftestCaseNames returns the names of the self-test's edits.
+func selftestCaseNames() string {
+	var names []string
+	for _, c := range selftestCases {
+		names = append(names, c.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// checkDuration checks that the output is as long as it should be.
+func checkDuration(want float64) selftestCheck {
+	return selftestCheck{"duration", func(input, output string) (string, bool, error) {
+		info, err := probe(output)
+		if err != nil {
+			return "", false, err
+		}
+		got := info.Format.duration()
+		if math.Abs(got-want) <= verifyDurationTolerance {
+			return fmt.Sprintf("ok: %s", clockString(got)), true, nil
+		}
+		return fmt.Sprintf("FAILED: %s, but expected %s", clockString(got), clockString(want)), false, nil
+	}}
+}
+
+// audioPeak returns the loudest the output's audio is from start to
+// end, in dBFS, away from the ends.
+func audioPeak(output string, start, end float64) (float64, error) {
+	samples, err := decodeAudio(output, start+verifyMargin, end-start-2*verifyMargin)
+	if err != nil {
+		return 0, err
+	}
+	if len(samples) == 0 {
+		return 0, fmt.Errorf("no audio at %s", clockString(start))
+	}
+	var peak float64
+	for _, s := range samples {
+		peak = math.Max(peak, math.Abs(s))
+	}
+	return math.Max(20*math.Log10(peak), -99), nil
+}
+
+// checkSilent checks that the output is silent from start to end.
+func checkSilent(start, end float64) selftestCheck {
+	return selftestCheck{fmt.Sprintf("silent %s-%s", clockString(start), clockString(end)), func(input, output string) (string, bool, error) {
+		level, err := audioPeak(output, start, end)
+		if err != nil {
+			return "", false, err
+		}
+		if level <= silenceLevel {
+			return fmt.Sprintf("ok: peak %.0f dB", level), true, nil
+		}
+		return fmt.Sprintf("FAILED: peak %.0f dB", level), false, nil
+	}}
+}
+
+// checkLoud checks that the output isn't silent from start to end.
+func checkLoud(start, end float64) selftestCheck {
+	return selftestCheck{fmt.Sprintf("sound %s-%s", clockString(start), clockString(end)), func(input, output string) (string, bool, error) {
+		level, err := audioPeak(output, start, end)
+		if err != nil {
+			return "", false, err
+		}
+		if level > silenceLevel {
+			return fmt.Sprintf("ok: peak %.0f dB", level), true, nil
+		}
+		return fmt.Sprintf("FAILED: silent (peak %.0f dB)", level), false, nil
+	}}
+}
+
+// audioSimilarity returns how alike the output's audio from start to
+// end is to the input's, away from the ends: the most they correlate,
+// from 0 to 1, with one shifted by up to 10 ms, so that a codec's
+// slight delay doesn't make them different.
+func audioSimilarity(input, output string, start, end float64) (float64, error) {
+	in, err := decodeAudio(input, start+verifyMargin, end-start-2*verifyMargin)
+	if err != nil {
+		return 0, err
+	}
+	out, err := decodeAudio(output, start+verifyMargin, end-start-2*verifyMargin)
+	if err != nil {
+		return 0, err
+	}
+	maxLag := alignSampleRate / 100
+	n := min(len(in), len(out)) - maxLag
+	if n <= 0 {
+		return 0, fmt.Errorf("no audio at %s", clockString(start))
+	}
+	energy := func(s []float64) float64 {
+		var sum float64
+		for _, v := range s {
+			sum += v * v
+		}
+		return sum
+	}
+	var best float64
+	for lag := -maxLag; lag <= maxLag; lag++ {
+		a, b := in[max(lag, 0):][:n], out[max(-lag, 0):][:n]
+		var dot float64
+		for i := range n {
+			dot += a[i] * b[i]
+		}
+		if norm := math.Sqrt(energy(a) * energy(b)); norm > 0 {
+			best = math.Max(best, dot/norm)
+		}
+	}
+	return best, nil
+}
+
+// checkAudioChanged checks that the output's audio from start to end
+// isn't the input's, like where it's scrambled.
+func checkAudioChanged(start, end float64) selftestCheck {
+	return selftestCheck{fmt.Sprintf("changed %s-%s", clockString(start), clockString(end)), func(input, output string) (string, bool, error) {
+		sim, err := audioSimilarity(input, output, start, end)
+		if err != nil {
+			return "", false, err
+		}
+		if sim < 0.5 {
+			return fmt.Sprintf("ok: %.0f%% like the input", sim*100), true, nil
+		}
+		return fmt.Sprintf("FAILED: %.0f%% like the input", sim*100), false, nil
+	}}
+}
+
+// checkAudioSame checks that the output's audio from start to end is
+// the input's.
+func checkAudioSame(start, end float64) selftestCheck {
+	return selftestCheck{fmt.Sprintf("unchanged %s-%s", clockString(start), clockString(end)), func(input, output string) (string, bool, error) {
+		sim, err := audioSimilarity(input, output, start, end)
+		if err != nil {
+			return "", false, err
+		}
+		if sim > 0.9 {
+			return fmt.Sprintf("ok: %.0f%% like the input", sim*100), true, nil
+		}
+		return fmt.Sprintf("FAILED: %.0f%% like the input", sim*100), false, nil
+	}}
+}
+
+// checkShows checks that the output at the time shows the input's
+// frame at src, not the one at other, like what a cut removed.
+func checkShows(at, src, other float64) selftestCheck {
+	return selftestCheck{fmt.Sprintf("frame at %s", clockString(at)), func(input, output string) (string, bool, error) {
+		out, err := smallFrame(output, at)
+		if err != nil {
+			return "", false, err
+		}
+		want, err := smallFrame(input, src)
+		if err != nil {
+			return "", false, err
+		}
+		notWant, err := smallFrame(input, other)
+		if err != nil {
+			return "", false, err
+		}
+		toWant, toNotWant := frameDifference(out, want), frameDifference(out, notWant)
+		if toWant < toNotWant {
+			return fmt.Sprintf("ok: the input's at %s (difference %.1f, vs. %.1f)", clockString(src), toWant, toNotWant), true, nil
+		}
+		return fmt.Sprintf("FAILED: the input's at %s (difference %.1f, vs. %.1f)", clockString(other), toNotWant, toWant), false, nil
+	}}
+}
+
+// sharpness returns how sharp the file's frame at the time is: the
+// mean difference between neighboring pixels.
+func sharpness(file string, at float64) (float64, error) {
+	w, h := selftestWidth/2, selftestHeight/2
+	frame, err := ffmpegOutput(
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64), "-i", fileArg(file),
+		"-map", "0:v:0", "-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:%d,format=gray", w, h),
+		"-f", "rawvideo", "-")
+	if err != nil {
+		return 0, err
+	}
+	if len(frame) != w*h {
+		return 0, fmt.Errorf("no frame of %s at %s", file, clockString(at))
+	}
+	var sum float64
+	for y := range h - 1 {
+		for x := range w - 1 {
+			p := float64(frame[y*w+x])
+			sum += math.Abs(p-float64(frame[y*w+x+1])) + math.Abs(p-float64(frame[(y+1)*w+x]))
+		}
+	}
+	return sum / float64((w-1)*(h-1)), nil
+}
+
+// checkBlurred checks that the output at the time is much less sharp
+// than the input at src.
+func checkBlurred(at, src float64) selftestCheck {
+	return selftestCheck{fmt.Sprintf("blurred at %s", clockString(at)), sharpnessCheck(at, src, func(ratio float64) bool { return ratio < 0.5 })}
+}
+
+// checkSharp checks that the output at the time is about as sharp as
+// the input at src.
+func checkSharp(at, src float64) selftestCheck {
+	return selftestCheck{fmt.Sprintf("sharp at %s", clockString(at)), sharpnessCheck(at, src, func(ratio float64) bool { return ratio > 0.7 })}
+}
+
+// sharpnessCheck returns a check of whether the ratio of the output's
+// sharpness at the time to the input's at src is ok.
+func sharpnessCheck(at, src float64, ok func(float64) bool) func(input, output string) (string, bool, error) {
+	return func(input, output string) (string, bool, error) {
+		out, err := sharpness(output, at)
+		if err != nil {
+			return "", false, err
+		}
+		in, err := sharpness(input, src)
+		if err != nil {
+			return "", false, err
+		}
+		if in == 0 {
+			return "", false, errors.New("the input's frame is blank")
+		}
+		ratio := out / in
+		if ok(ratio) {
+			return fmt.Sprintf("ok: %.0f%% as sharp as the input", ratio*100), true, nil
+		}
+		return fmt.Sprintf("FAILED: %.0f%% as sharp as the input", ratio*100), false, nil
+	}
+}
+
+// checkBlack checks that the output's frame at the time is black.
+func checkBlack(at float64) selftestCheck {
+	return selftestCheck{fmt.Sprintf("black at %s", clockString(at)), func(input, output string) (string, bool, error) {
+		frame, err := smallFrame(output, at)
+		if err != nil {
+			return "", false, err
+		}
+		var sum float64
+		for _, p := range frame {
+			sum += float64(p)
+		}
+		// (limited-range black is 16)
+		mean := sum / float64(len(frame))
+		if mean < 24 {
+			return fmt.Sprintf("ok: mean brightness %.0f", mean), true, nil
+		}
+		return fmt.Sprintf("FAILED: mean brightness %.0f", mean), false, nil
+	}}
+}
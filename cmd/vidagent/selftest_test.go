@@ -0,0 +1,63 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"slices"
+	"testing"
+)
+
+// selftestMainEnv is set for the test executable when it's run as
+// vidagent, so that it runs main instead of the tests.
+const selftestMainEnv = "VIDAGENT_TEST_MAIN"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(selftestMainEnv) != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// TestSelftest makes the self-test's edits with each engine, the way
+// vidagent selftest does, with the test executable as vidagent. It's
+// skipped without ffmpeg, or with -short, since it runs it a lot.
+func TestSelftest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the self-test is slow")
+	}
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := findTool(tool); err != nil {
+			t.Skipf("the self-test needs %s: %v", tool, err)
+		}
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(selftestMainEnv, "1")
+
+	dir := t.TempDir()
+	input, err := makeSelftestInput(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, engine := range slices.Sorted(maps.Keys(engines)) {
+		for _, c := range selftestCases {
+			t.Run(engine+"/"+c.name, func(t *testing.T) {
+				results, err := runSelftestCase(exe, dir, input, engine, c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, r := range results {
+					switch {
+					case r.skipped:
+						t.Skipf("%s: %s", r.what, r.result)
+					case !r.ok:
+						t.Errorf("%s: %s", r.what, r.result)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,979 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// serveUI is the page that the server serves as its web UI.
+//
+//go:embed serve.html
+var serveUI []byte
+
+// jobOptions are the flags of an edit that jobs may set. The
+// others would let a job read or write outside the root, or
+// override the server's resource limits (jobs can only make the
+// server's time limits shorter).
+var jobOptions = []string{
+	"f", "engine", "copy", "only-lines", "only-verb", "only-category",
+	"lenient", "offset", "time-scale", "snap-to", "snap-window", "precision",
+	"cfr", "tonemap", "deinterlace", "scale", "max-height", "rotation",
+	"check-sync", "check-quality", "min-ssim", "fix-sync", "extract-format",
+	"allow-large-edits", "video-codec", "crf", "preset", "timeout", "stall-timeout",
+}
+
+// job is an edit that the server was asked to make.
+type job struct {
+	jobRequest
+	ID       string    `json:"id"`
+	State    string    `json:"state"` // queued, running, done, failed, or canceled
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	Log      []string  `json:"log,omitempty"` // the last lines of its output
+
+	Progress *progressEvent `json:"progress,omitempty"`
+	Playlist string         `json:"playlist,omitempty"` // of a stream
+
+	// streams are made in a temporary directory, which is
+	// removed when they're ended
+	streamDir  string
+	lastAccess time.Time
+	ended      bool
+
+	args    []string // of vidagent
+	output  string   // absolute path
+	policy  map[string]string
+	tail    *tailWriter
+	cancel  context.CancelCauseFunc
+	changed chan struct{} // closed when anything above changes
+}
+
+// server runs jobs, as many at once as its budget allows.
+type server struct {
+	root        string
+	maxJobs     int
+	threads     int
+	jobMemory   uint64
+	lowPriority bool
+	profiles    map[string]profile
+	streamIdle  time.Duration
+
+	// the -timeout and -stall-timeout of each job; 0 for none
+	jobTimeout, jobStallTimeout time.Duration
+
+	mu   sync.Mutex
+	jobs []*job
+	wake chan struct{}
+}
+
+// serveCmd runs a server with an HTTP API, and a web UI that uses
+// it, for editing the videos in a directory. Each job is run by
+// another vidagent process.
+func serveCmd(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "the address to listen on")
+	root := fs.String("root", ".", "the directory of the videos and filter files that jobs can use")
+	maxJobs := fs.Int("jobs", 1, "how many jobs can run at once")
+	jobThreads := fs.Int("threads", 0, "limit each job's ffmpeg to this many threads for each of decoding, filtering, and encoding (0 for its default)")
+	jobMemory := fs.Int("job-memory", 1024, "don't start a job unless this many MiB of memory are available (0 to not check)")
+	lowPrio := fs.Bool("low-priority", true, "run jobs at reduced CPU and IO priority")
+	keysFile := fs.String("api-keys", "", "require one of the API keys in this file, one per line, or a user from -users")
+	usersFile := fs.String("users", "", "require a user name and password from this file, with user:password on each line, or an API key from -api-keys")
+	certFile := fs.String("tls-cert", "", "serve HTTPS with this certificate file (and -tls-key)")
+	keyFile := fs.String("tls-key", "", "the key file of the -tls-cert certificate")
+	selfSigned := fs.Bool("tls-self-signed", false, "serve HTTPS with a self-signed certificate, made the first time")
+	profilesFile := fs.String("profiles", "", "the file of viewer profiles that jobs can use")
+	streamIdle := fs.Duration("stream-idle", 10*time.Minute, "end streams that haven't been played for this long")
+	jobTimeout := fs.Duration("job-timeout", 24*time.Hour, "fail a job whose ffmpeg runs longer than this, like -timeout (0 for no limit)")
+	jobStallTimeout := fs.Duration("job-stall-timeout", 10*time.Minute, "fail a job whose ffmpeg makes no progress for this long, like -stall-timeout (0 for no limit)")
+	fs.Parse(args)
+
+	if *maxJobs < 1 || *jobThreads < 0 || *jobMemory < 0 {
+		return fmt.Errorf("-jobs must be at least 1, and -threads and -job-memory can't be negative")
+	}
+	if *jobTimeout < 0 || *jobStallTimeout < 0 {
+		return fmt.Errorf("-job-timeout and -job-stall-timeout can't be negative")
+	}
+	if (*certFile == "") != (*keyFile == "") || (*certFile != "" && *selfSigned) {
+		return fmt.Errorf("use both -tls-cert and -tls-key, or -tls-self-signed")
+	}
+	a, err := loadAuth(*keysFile, *usersFile)
+	if err != nil {
+		return err
+	}
+	if !a.enabled() && !isLoopback(*addr) {
+		log.Printf("warning: anyone who can reach %s can use the server; see -api-keys and -users", *addr)
+	}
+	if *selfSigned {
+		*certFile, *keyFile, err = selfSignedCert()
+		if err != nil {
+			return fmt.Errorf("making self-signed certificate: %v", err)
+		}
+	}
+	var profiles map[string]profile
+	if *profilesFile != "" {
+		profiles, err = loadProfiles(*profilesFile)
+		if err != nil {
+			return err
+		}
+	}
+	dir, err := filepath.Abs(*root)
+	if err != nil {
+		return err
+	}
+	s := &server{
+		root:        dir,
+		maxJobs:     *maxJobs,
+		threads:     *jobThreads,
+		jobMemory:   uint64(*jobMemory) << 20,
+		lowPriority: *lowPrio,
+		profiles:    profiles,
+		streamIdle:  *streamIdle,
+		wake:        make(chan struct{}, 1),
+
+		jobTimeout:      *jobTimeout,
+		jobStallTimeout: *jobStallTimeout,
+	}
+	go s.schedule()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/jobs", s.handleJobs)
+	mux.HandleFunc("/api/jobs/", s.handleJob)
+	mux.HandleFunc("/api/files/", s.handleFiles)
+	mux.HandleFunc("/api/profiles", s.listProfiles)
+	mux.HandleFunc("/api/messages", listMessages)
+	mux.HandleFunc("/api/streams", s.createStream)
+	mux.HandleFunc("/api/streams/", s.handleStream)
+	mux.Handle("/files/", http.StripPrefix("/files/", noDotfiles(http.FileServer(http.Dir(s.root)))))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(serveUI)
+	})
+
+	srv := &http.Server{Addr: *addr, Handler: a.wrap(mux)}
+	if *certFile != "" {
+		log.Printf("serving %s on https://%s", s.root, *addr)
+		return srv.ListenAndServeTLS(*certFile, *keyFile)
+	}
+	log.Printf("serving %s on http://%s", s.root, *addr)
+	return srv.ListenAndServe()
+}
+
+// path returns the absolute path of name, which is relative to
+// the root, or an error if it's outside the root.
+func (s *server) path(name string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("'%s' is not a path inside the root", name)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(name)), nil
+}
+
+// checkTimeLimit returns an error if the option, of a job or its
+// profile, is a time limit that's longer than the server's (or none).
+func (s *server) checkTimeLimit(name, val string) error {
+	var limit time.Duration
+	var flagName string
+	switch name {
+	case "timeout":
+		limit, flagName = s.jobTimeout, "-job-timeout"
+	case "stall-timeout":
+		limit, flagName = s.jobStallTimeout, "-job-stall-timeout"
+	default:
+		return nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return fmt.Errorf("option '%s': %v", name, err)
+	}
+	if limit > 0 && (d <= 0 || d > limit) {
+		return fmt.Errorf("option '%s' can't be longer than the server's %s of %s", name, flagName, limit)
+	}
+	return nil
+}
+
+// newJob validates the request and makes the job for it. The
+// output is its absolute path, or empty for the request's.
+func (s *server) newJob(req jobRequest, output string) (*job, error) {
+	var prof profile
+	if req.Profile != "" {
+		var ok bool
+		prof, ok = s.profiles[req.Profile]
+		if !ok {
+			return nil, fmt.Errorf("no such profile '%s'", req.Profile)
+		}
+		if req.Output == "" && req.Input != "" && output == "" {
+			req.Output = prof.outputName(req.Profile, req.Input)
+		}
+	}
+	if req.Input == "" || (req.Output == "" && output == "") {
+		return nil, fmt.Errorf("input and output are required")
+	}
+	if (req.Filter == "") == (req.FilterText == "") {
+		return nil, fmt.Errorf("one of filter or filter_text is required")
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	j := &job{
+		jobRequest: req,
+		ID:         hex.EncodeToString(id),
+		State:      "queued",
+		Created:    time.Now(),
+		tail:       &tailWriter{max: 100},
+		changed:    make(chan struct{}),
+		policy:     prof.Policy,
+	}
+	j.tail.notify = func() {
+		s.mu.Lock()
+		j.update()
+		s.mu.Unlock()
+	}
+
+	input, err := s.path(req.Input)
+	if err != nil {
+		return nil, err
+	}
+	if output == "" {
+		output, err = s.path(req.Output)
+		if err != nil {
+			return nil, err
+		}
+	}
+	j.output = output
+	j.args = []string{"-in", input, "-out", output}
+	if req.Filter != "" {
+		filter, err := s.path(req.Filter)
+		if err != nil {
+			return nil, err
+		}
+		j.args = append(j.args, "-filter", filter)
+	}
+	// the server's time limits come first, so that the profile's
+	// or the job's (which can only be shorter) win over them
+	if s.jobTimeout > 0 {
+		j.args = append(j.args, "-timeout="+s.jobTimeout.String())
+	}
+	if s.jobStallTimeout > 0 {
+		j.args = append(j.args, "-stall-timeout="+s.jobStallTimeout.String())
+	}
+	// the job's options come last, so they win over the profile's
+	for _, name := range slices.Sorted(maps.Keys(prof.Options)) {
+		if err := s.checkTimeLimit(name, prof.Options[name]); err != nil {
+			return nil, fmt.Errorf("profile '%s': %v", req.Profile, err)
+		}
+		j.args = append(j.args, "-"+name+"="+prof.Options[name])
+	}
+	j.args = append(j.args, req.settingArgs()...)
+	for _, name := range slices.Sorted(maps.Keys(req.Options)) {
+		if !slices.Contains(jobOptions, name) {
+			return nil, fmt.Errorf("option '%s' can't be set by jobs", name)
+		}
+		if err := s.checkTimeLimit(name, req.Options[name]); err != nil {
+			return nil, err
+		}
+		j.args = append(j.args, "-"+name+"="+req.Options[name])
+	}
+	if s.threads > 0 {
+		j.args = append(j.args, "-threads", strconv.Itoa(s.threads))
+	}
+	if s.lowPriority {
+		j.args = append(j.args, "-low-priority")
+	}
+	j.args = append(j.args, "-progress-json")
+
+	// fail now, not once the job runs, if it can't be made
+	o := defaultOptions()
+	fs := flag.NewFlagSet("job", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	o.register(fs)
+	err = fs.Parse(j.args)
+	if req.FilterText != "" {
+		o.filterFile = "filter_text" // written to a file when the job runs
+	}
+	if err == nil {
+		err = o.validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// schedule starts queued jobs, in order, whenever the budget allows:
+// fewer than -jobs are running, and there's enough memory. Memory is
+// checked again every few seconds while a job is waiting for it.
+func (s *server) schedule() {
+	for {
+		s.mu.Lock()
+		var running, recent int
+		for _, j := range s.jobs {
+			if j.State == "running" {
+				running++
+				// ffmpeg may not have taken its memory yet
+				if time.Since(j.Started) < 30*time.Second {
+					recent++
+				}
+			}
+		}
+		for _, j := range s.jobs {
+			if running >= s.maxJobs {
+				break
+			}
+			if j.State != "queued" {
+				continue
+			}
+			if s.jobMemory > 0 {
+				avail, ok := availableMemory()
+				if ok && avail < s.jobMemory*uint64(recent+1) {
+					break
+				}
+			}
+			s.start(j)
+			running++
+			recent++
+		}
+		s.endIdleStreams()
+		s.mu.Unlock()
+
+		select {
+		case <-s.wake:
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+// poke makes the scheduler look at the jobs again.
+func (s *server) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// start starts running j. s.mu must be held.
+func (s *server) start(j *job) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	j.State, j.Started, j.cancel = "running", time.Now(), cancel
+	j.update()
+	go func() {
+		err := s.run(ctx, j)
+		cancel(nil)
+		s.mu.Lock()
+		if j.ended {
+			removeTempDir(j.streamDir)
+		}
+		j.Finished = time.Now()
+		switch {
+		case errors.Is(context.Cause(ctx), errJobCanceled):
+			j.State = "canceled"
+		case err != nil:
+			j.State, j.Error = "failed", err.Error()
+		default:
+			j.State = "done"
+		}
+		j.update()
+		s.mu.Unlock()
+		log.Printf("job %s %s", j.ID, j.State)
+		s.poke()
+	}()
+}
+
+var errJobCanceled = errors.New("job canceled")
+
+// run runs vidagent for the job.
+func (s *server) run(ctx context.Context, j *job) error {
+	err := os.MkdirAll(filepath.Dir(j.output), 0755)
+	if err != nil {
+		return err
+	}
+	args := j.args
+	if j.FilterText != "" || len(j.policy) > 0 {
+		dir, err := makeTempDir("", "vidagent-job-")
+		if err != nil {
+			return err
+		}
+		defer removeTempDir(dir)
+		if j.FilterText != "" {
+			filter := filepath.Join(dir, "filter.txt")
+			err = os.WriteFile(filter, []byte(j.FilterText), 0600)
+			if err != nil {
+				return err
+			}
+			args = append(args, "-filter", filter)
+		}
+		if len(j.policy) > 0 {
+			data, err := json.Marshal(j.policy)
+			if err != nil {
+				return err
+			}
+			policy := filepath.Join(dir, "policy.json")
+			err = os.WriteFile(policy, data, 0600)
+			if err != nil {
+				return err
+			}
+			args = append(args, "-policy", policy)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Stdout = &progressWriter{s: s, j: j}
+	cmd.Stderr = j.tail
+	// let it kill ffmpeg and clean up before it's killed too
+	cmd.Cancel = func() error {
+		if err := cmd.Process.Signal(os.Interrupt); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	cmd.WaitDelay = 10 * time.Second
+	if j.streamDir != "" {
+		log.Printf("stream %s started: %s", j.ID, j.Input)
+	} else {
+		log.Printf("job %s started: %s -> %s", j.ID, j.Input, j.Output)
+	}
+	err = cmd.Run()
+	if err != nil {
+		if msg := exitMessage(j.tail.lines()); msg != "" {
+			return errors.New(msg)
+		}
+	}
+	return err
+}
+
+// logLine matches the start of a line that vidagent logs.
+var logLine = regexp.MustCompile(`^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d `)
+
+// exitMessage returns what vidagent logged last, which is why it
+// failed if it did, from the lines of its output.
+func exitMessage(lines []string) string {
+	for i := len(lines) - 1; i >= 0; i-- {
+		if loc := logLine.FindStringIndex(lines[i]); loc != nil {
+			return strings.Join(append([]string{lines[i][loc[1]:]}, lines[i+1:]...), "\n")
+		}
+	}
+	if len(lines) > 0 {
+		return lines[len(lines)-1]
+	}
+	return ""
+}
+
+// update tells anyone watching j that it changed. s.mu must be held.
+func (j *job) update() {
+	close(j.changed)
+	j.changed = make(chan struct{})
+}
+
+// over returns true if j won't change anymore.
+func (j *job) over() bool {
+	return j.State != "queued" && j.State != "running"
+}
+
+// snapshot returns a copy of j for reporting. s.mu must be held.
+func (j *job) snapshot() job {
+	c := *j
+	c.Log = j.tail.lines()
+	return c
+}
+
+// handleJobs lists the jobs (GET) or starts a new one (POST).
+func (s *server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listJobs(w, r)
+	case http.MethodPost:
+		s.createJob(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleJob reports on (GET) or cancels (DELETE) the job whose
+// ID follows /api/jobs/ in the path, or streams its progress
+// (GET with /events after the ID).
+func (s *server) handleJob(w http.ResponseWriter, r *http.Request) {
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/jobs/"), "/")
+	s.mu.Lock()
+	j := s.find(id)
+	s.mu.Unlock()
+	if j == nil {
+		http.Error(w, "no such job", http.StatusNotFound)
+		return
+	}
+	switch {
+	case rest == "" && r.Method == http.MethodGet:
+		s.getJob(w, j)
+	case rest == "" && r.Method == http.MethodDelete:
+		s.cancelJob(w, j)
+	case rest == "events" && r.Method == http.MethodGet:
+		s.streamEvents(w, r, j)
+	case rest == "" || rest == "events":
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *server) listJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]job, len(s.jobs))
+	for i, j := range s.jobs {
+		jobs[i] = j.snapshot()
+		jobs[i].Log = nil
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *server) createJob(w http.ResponseWriter, r *http.Request) {
+	req, err := readJobRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	j, err := s.newJob(req, "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.jobs = append(s.jobs, j)
+	snap := j.snapshot()
+	s.mu.Unlock()
+	s.poke()
+	writeJSON(w, http.StatusCreated, snap)
+}
+
+// readJobRequest reads the job document that the request's body is,
+// in JSON, or YAML if its Content-Type says so.
+func readJobRequest(w http.ResponseWriter, r *http.Request) (jobRequest, error) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		return jobRequest{}, err
+	}
+	mediaType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	return decodeJob(data, strings.HasSuffix(strings.TrimSpace(mediaType), "yaml"))
+}
+
+// listProfiles lists the profiles that jobs can use.
+func (s *server) listProfiles(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	profiles := s.profiles
+	if profiles == nil {
+		profiles = map[string]profile{}
+	}
+	writeJSON(w, http.StatusOK, profiles)
+}
+
+// listMessages writes the web UI's translations for the browser's
+// language, as a map from the English messages, along with the
+// language.
+func listMessages(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	l := acceptLanguage(r.Header.Get("Accept-Language"))
+	messages := l.messages
+	if messages == nil {
+		messages = map[string]string{}
+	}
+	w.Header().Set("Vary", "Accept-Language")
+	writeJSON(w, http.StatusOK, map[string]any{"lang": l.lang, "messages": messages})
+}
+
+// find returns the job with the ID, or nil if there
+// isn't one. s.mu must be held.
+func (s *server) find(id string) *job {
+	for _, j := range s.jobs {
+		if j.ID == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (s *server) getJob(w http.ResponseWriter, j *job) {
+	s.mu.Lock()
+	snap := j.snapshot()
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, snap)
+}
+
+// cancelJob cancels a queued or running job.
+func (s *server) cancelJob(w http.ResponseWriter, j *job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch j.State {
+	case "queued":
+		j.State, j.Finished = "canceled", time.Now()
+		j.update()
+	case "running":
+		// the job is marked canceled when vidagent exits
+		j.cancel(errJobCanceled)
+	default:
+		http.Error(w, "job already "+j.State, http.StatusConflict)
+		return
+	}
+	writeJSON(w, http.StatusOK, j.snapshot())
+}
+
+// jobEvent is a server-sent event about a job's progress. Log has
+// the lines written since the last event.
+type jobEvent struct {
+	State    string         `json:"state"`
+	Error    string         `json:"error,omitempty"`
+	Progress *progressEvent `json:"progress,omitempty"`
+	Log      []string       `json:"log,omitempty"`
+}
+
+// streamEvents sends the job's progress as server-sent events
+// whenever it changes, until the job is over or the client goes
+// away, so that a page can show it without polling.
+func (s *server) streamEvents(w http.ResponseWriter, r *http.Request, j *job) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	var sent int // lines of the log
+	for {
+		s.mu.Lock()
+		ev := jobEvent{State: j.State, Error: j.Error, Progress: j.Progress}
+		ev.Log, sent = j.tail.since(sent)
+		changed, over := j.changed, j.over()
+		s.mu.Unlock()
+
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(w, "event: progress\ndata: %s\n\n", data)
+		flusher.Flush()
+		if over {
+			return
+		}
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// createStream starts a stream (POST): a job like any other, except
+// its output is an HLS playlist in a temporary directory, which can be
+// played from while it's being made. Streams go ahead of the other
+// queued jobs, since someone is waiting to watch.
+func (s *server) createStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	req, err := readJobRequest(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Output = ""
+	dir, err := makeTempDir("", "vidagent-stream-")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	j, err := s.newJob(req, filepath.Join(dir, "index.m3u8"))
+	if err != nil {
+		removeTempDir(dir)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	j.args = append(j.args, "-no-history")
+	j.streamDir, j.lastAccess = dir, time.Now()
+	j.Playlist = "/api/streams/" + j.ID + "/index.m3u8"
+
+	s.mu.Lock()
+	i := slices.IndexFunc(s.jobs, func(j *job) bool { return j.State == "queued" })
+	if i < 0 {
+		i = len(s.jobs)
+	}
+	s.jobs = slices.Insert(s.jobs, i, j)
+	snap := j.snapshot()
+	s.mu.Unlock()
+	s.poke()
+	writeJSON(w, http.StatusCreated, snap)
+}
+
+// handleStream serves the playlist and segments of a stream (GET),
+// or ends it (DELETE), for the stream ID and file name after
+// /api/streams/ in the path. Until ffmpeg has written the playlist,
+// requests for it wait.
+func (s *server) handleStream(w http.ResponseWriter, r *http.Request) {
+	id, file, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/streams/"), "/")
+	s.mu.Lock()
+	j := s.find(id)
+	if j != nil && j.streamDir == "" {
+		j = nil
+	}
+	if j != nil {
+		j.lastAccess = time.Now()
+	}
+	s.mu.Unlock()
+	if j == nil {
+		http.Error(w, "no such stream", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodDelete && file == "":
+		s.mu.Lock()
+		s.endStream(j)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case r.Method != http.MethodGet:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	case file == "" || strings.Contains(file, "/") || strings.HasPrefix(file, "."):
+		http.NotFound(w, r)
+		return
+	}
+
+	path := filepath.Join(j.streamDir, file)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		s.mu.Lock()
+		over := j.over()
+		changed := j.changed
+		s.mu.Unlock()
+		if over || file != "index.m3u8" {
+			http.NotFound(w, r)
+			return
+		}
+		select {
+		case <-changed:
+		case <-time.After(250 * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	switch filepath.Ext(file) {
+	case ".m3u8":
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache")
+	case ".ts":
+		w.Header().Set("Content-Type", "video/mp2t")
+	}
+	http.ServeFile(w, r, path)
+}
+
+// endStream stops the stream and removes it and its files, at once
+// or, if it's running, once vidagent exits. s.mu must be held.
+func (s *server) endStream(j *job) {
+	s.jobs = slices.DeleteFunc(s.jobs, func(other *job) bool { return other == j })
+	j.ended = true
+	switch j.State {
+	case "running":
+		j.cancel(errJobCanceled)
+	case "queued":
+		j.State, j.Finished = "canceled", time.Now()
+		j.update()
+		fallthrough
+	default:
+		removeTempDir(j.streamDir)
+	}
+	log.Printf("stream %s ended", j.ID)
+}
+
+// endIdleStreams ends the streams that haven't been played
+// for -stream-idle. s.mu must be held.
+func (s *server) endIdleStreams() {
+	for _, j := range slices.Clone(s.jobs) {
+		if j.streamDir != "" && time.Since(j.lastAccess) > s.streamIdle {
+			s.endStream(j)
+		}
+	}
+}
+
+// fileEntry is a file or directory in a listing.
+type fileEntry struct {
+	Name     string    `json:"name"`
+	Dir      bool      `json:"dir,omitempty"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// handleFiles lists a directory of the root (GET) or uploads a
+// file to it (PUT), for the path after /api/files/. Uploads don't
+// replace files that exist.
+func (s *server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/files/"), "/")
+	path := s.root
+	if name != "" {
+		var err error
+		path, err = s.path(name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		dirEntries, err := os.ReadDir(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		entries := []fileEntry{}
+		for _, d := range dirEntries {
+			info, err := d.Info()
+			if err != nil || strings.HasPrefix(d.Name(), ".") {
+				continue
+			}
+			entries = append(entries, fileEntry{d.Name(), d.IsDir(), info.Size(), info.ModTime()})
+		}
+		writeJSON(w, http.StatusOK, entries)
+
+	case http.MethodPut:
+		if name == "" {
+			http.Error(w, "a file name is required", http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Stat(path); err == nil {
+			http.Error(w, name+" already exists", http.StatusConflict)
+			return
+		}
+		err := s.upload(path, r.Body)
+		if errors.Is(err, os.ErrExist) {
+			http.Error(w, name+" already exists", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("uploaded %s", name)
+		info, err := os.Stat(path)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, fileEntry{info.Name(), false, info.Size(), info.ModTime()})
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// upload writes the body to the file, under another name until
+// it's all there, so a file with the name is always whole. The file
+// is then linked to its name, which fails with an os.ErrExist error,
+// instead of replacing it, if another upload got there first.
+func (s *server) upload(path string, body io.Reader) error {
+	dir := filepath.Dir(path)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.partial")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Link(f.Name(), path)
+}
+
+// noDotfiles serves requests with h, except those for a path with a
+// file or directory in it whose name starts with ".", like an upload's
+// .partial file, which aren't found.
+func noDotfiles(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, elem := range strings.Split(r.URL.Path, "/") {
+			if strings.HasPrefix(elem, ".") {
+				http.NotFound(w, r)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes v as the JSON response with the status code.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// progressWriter reads the progress events that vidagent writes to
+// standard output into the job. Anything else goes in its log.
+type progressWriter struct {
+	s       *server
+	j       *job
+	partial []byte
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := p.partial[:i+1]
+		var ev progressEvent
+		if json.Unmarshal(line, &ev) == nil && ev.Stage != "" {
+			p.s.mu.Lock()
+			p.j.Progress = &ev
+			p.j.update()
+			p.s.mu.Unlock()
+		} else {
+			p.j.tail.Write(line)
+		}
+		p.partial = p.partial[i+1:]
+	}
+	return len(b), nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// auth is who may use the server: clients that give one of the API
+// keys, or a user name and password with basic auth (which is what
+// browsers use for the web UI). With neither, anyone may.
+type auth struct {
+	keys  []string
+	users map[string]string // passwords by user name
+}
+
+// loadAuth reads the API keys file, which has a key on each line,
+// and the users file, which has a user name and password separated
+// by a colon on each line. Either may be empty. Blank lines and
+// lines starting with # are ignored.
+func loadAuth(keysFile, usersFile string) (*auth, error) {
+	a := &auth{users: make(map[string]string)}
+	if keysFile != "" {
+		lines, err := readConfigLines(keysFile)
+		if err != nil {
+			return nil, err
+		}
+		a.keys = lines
+	}
+	if usersFile != "" {
+		lines, err := readConfigLines(usersFile)
+		if err != nil {
+			return nil, err
+		}
+		for i, line := range lines {
+			user, pass, ok := strings.Cut(line, ":")
+			if !ok || user == "" || pass == "" {
+				return nil, fmt.Errorf("%s: line %d: expected user:password", usersFile, i+1)
+			}
+			a.users[user] = pass
+		}
+	}
+	return a, nil
+}
+
+// readConfigLines returns the lines of the file that aren't
+// blank or comments, with surrounding space removed.
+func readConfigLines(filename string) ([]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+func (a *auth) enabled() bool {
+	return len(a.keys) > 0 || len(a.users) > 0
+}
+
+// allowed returns true if the request gives an API key (as a bearer
+// token or in the X-API-Key header) or user name and password that
+// may use the server.
+func (a *auth) allowed(r *http.Request) bool {
+	key := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		key = bearer
+	}
+	if key != "" {
+		for _, k := range a.keys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+				return true
+			}
+		}
+	}
+	if user, pass, ok := r.BasicAuth(); ok {
+		want, ok := a.users[user]
+		return ok && subtle.ConstantTimeCompare([]byte(pass), []byte(want)) == 1
+	}
+	return false
+}
+
+// wrap returns a handler that only lets allowed requests through
+// to next.
+func (a *auth) wrap(next http.Handler) http.Handler {
+	if !a.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.allowed(r) {
+			if len(a.users) > 0 {
+				w.Header().Set("WWW-Authenticate", `Basic realm="vidagent", charset="UTF-8"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopback returns true if addr only listens on
+// the loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// selfSignedCert returns the files of the server's self-signed
+// certificate and its key, making them the first time. They're kept
+// in the user config directory, so that a browser told to trust the
+// certificate once keeps trusting it. The certificate is for this
+// machine's host name and IP addresses at the time it's made.
+func selfSignedCert() (string, string, error) {
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(config, "vidagent")
+	certFile, keyFile := filepath.Join(dir, "serve-cert.pem"), filepath.Join(dir, "serve-key.pem")
+	if _, err := os.Stat(certFile); err == nil {
+		return certFile, keyFile, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return "", "", err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return "", "", err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "vidagent"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+	}
+	if host, err := os.Hostname(); err == nil {
+		template.DNSNames = append(template.DNSNames, host, host+".local")
+	}
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				template.IPAddresses = append(template.IPAddresses, ipNet.IP)
+			}
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return "", "", err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", "", err
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		return "", "", err
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return "", "", err
+	}
+	log.Printf("made a self-signed certificate, %s", certFile)
+	return certFile, keyFile, nil
+}
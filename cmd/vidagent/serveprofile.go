@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// profile is how videos are edited for a viewer: the policy that
+// decides what is done for each reason, options of the edit (like
+// the size of the output), and where outputs go. Jobs that name a
+// profile get these, so each viewer's devices can ask for their own
+// rendition of the same video. For example:
+//
+//	{
+//		"kids": {
+//			"description": "for the kids' tablets",
+//			"policy": {"language": "mute", "violence": "cut", "nudity": "cut"},
+//			"options": {"max-height": "720"},
+//			"output_dir": "kids"
+//		}
+//	}
+type profile struct {
+	Description string            `json:"description,omitempty"`
+	Policy      map[string]string `json:"policy,omitempty"`
+	Options     map[string]string `json:"options,omitempty"`
+	OutputDir   string            `json:"output_dir,omitempty"`
+}
+
+// loadProfiles reads and checks the profiles file.
+func loadProfiles(filename string) (map[string]profile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var profiles map[string]profile
+	err = json.Unmarshal(data, &profiles)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	for name, p := range profiles {
+		if _, err := parsePolicy(p.Policy); err != nil {
+			return nil, fmt.Errorf("%s: profile '%s': %v", filename, name, err)
+		}
+		for opt := range p.Options {
+			if !slices.Contains(jobOptions, opt) {
+				return nil, fmt.Errorf("%s: profile '%s': option '%s' can't be set by profiles", filename, name, opt)
+			}
+		}
+		if p.OutputDir != "" && !filepath.IsLocal(filepath.FromSlash(p.OutputDir)) {
+			return nil, fmt.Errorf("%s: profile '%s': output_dir must be inside the root", filename, name)
+		}
+	}
+	return profiles, nil
+}
+
+// outputName returns the output for the input of a job with the
+// profile that doesn't say where its output goes: the input's name
+// with the profile's, in the profile's output directory or next to
+// the input.
+func (p profile) outputName(name, input string) string {
+	ext := path.Ext(input)
+	base := strings.TrimSuffix(path.Base(input), ext) + "-" + name + ext
+	if p.OutputDir != "" {
+		return path.Join(p.OutputDir, base)
+	}
+	return path.Join(path.Dir(input), base)
+}
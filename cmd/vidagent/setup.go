@@ -0,0 +1,238 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// toolsDir returns the directory that setup installs ffmpeg into.
+func toolsDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "vidagent", "ffmpeg"), nil
+}
+
+// setupCmd downloads a static build of ffmpeg and ffprobe, from an
+// archive with a known checksum, into the tools directory, where
+// findTool will find them.
+func setupCmd(args []string) error {
+	fs := flag.NewFlagSet("setup", flag.ExitOnError)
+	url := fs.String("url", "", "download ffmpeg from this archive (.zip, .tar.gz, or .tar.xz)")
+	sum := fs.String("sha256", "", "expected SHA-256 checksum of the archive")
+	force := fs.Bool("f", false, "download again even if ffmpeg is already set up")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent setup -url <archive> -sha256 <checksum> [-f]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if *url == "" || *sum == "" {
+		fs.Usage()
+		return errors.New("the archive's URL and SHA-256 checksum are required (use -url and -sha256)")
+	}
+
+	dir, err := toolsDir()
+	if err != nil {
+		return err
+	}
+	ffmpegBin := exeName("ffmpeg")
+	if _, err := os.Stat(filepath.Join(dir, ffmpegBin)); err == nil && !*force {
+		log.Printf("ffmpeg is already set up in %s (use -f to download again)", dir)
+		return nil
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	want := strings.ToLower(*sum)
+	log.Printf("downloading %s", *url)
+	archive, err := os.CreateTemp(dir, "download-*"+archiveExt(*url))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+
+	resp, err := http.Get(*url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading %s: HTTP %s", *url, resp.Status)
+	}
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(archive, h), resp.Body)
+	if cerr := archive.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("downloading %s: %v", *url, err)
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: expected %s but got %s", *url, want, got)
+	}
+
+	err = extractTools(archive.Name(), dir, ffmpegBin, exeName("ffprobe"))
+	if err != nil {
+		return fmt.Errorf("extracting %s: %v", *url, err)
+	}
+
+	log.Printf("installed ffmpeg and ffprobe to %s", dir)
+	return nil
+}
+
+// extractTools extracts the named executables from the archive,
+// wherever they are in it, into dir.
+func extractTools(archive, dir string, names ...string) error {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	install := func(name string, r io.Reader) error {
+		tmp, err := os.CreateTemp(dir, name+".*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, r)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.Chmod(tmp.Name(), 0755); err != nil {
+			return err
+		}
+		delete(wanted, name)
+		return os.Rename(tmp.Name(), filepath.Join(dir, name))
+	}
+
+	switch ext := archiveExt(archive); ext {
+	case ".zip":
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			name := path.Base(f.Name)
+			if !wanted[name] || f.FileInfo().IsDir() {
+				continue
+			}
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = install(name, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+
+	case ".tar.gz", ".tgz":
+		file, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		gzr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		tr := tar.NewReader(gzr)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			name := path.Base(hdr.Name)
+			if !wanted[name] || hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			if err := install(name, tr); err != nil {
+				return err
+			}
+		}
+
+	case ".tar.xz":
+		// the standard library can't decompress xz, but the tar
+		// command on systems these archives are made for can
+		tmpDir, err := os.MkdirTemp(dir, "extract-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+		cmd := exec.Command("tar", "-xJf", archive, "-C", tmpDir)
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("running tar: %v", err)
+		}
+		err = filepath.Walk(tmpDir, func(fpath string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() || !wanted[info.Name()] {
+				return err
+			}
+			f, err := os.Open(fpath)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			return install(info.Name(), f)
+		})
+		if err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unsupported archive type '%s'", ext)
+	}
+
+	for name := range wanted {
+		return fmt.Errorf("%s not found in archive", name)
+	}
+	return nil
+}
+
+// archiveExt returns the extension of an archive file name,
+// including compound extensions like ".tar.gz".
+func archiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tar.xz"} {
+		if strings.HasSuffix(lower, ext) {
+			return ext
+		}
+	}
+	return path.Ext(lower)
+}
+
+// exeName returns the file name of the named executable
+// on the current platform.
+func exeName(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
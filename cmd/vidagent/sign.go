@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Filter files are signed with Ed25519 in minisign's format, so
+// minisign can verify vidagent's signatures and vidagent can verify
+// signatures made with "minisign -S -l". (Without -l, minisign signs
+// a BLAKE2b hash of the file, which the standard library can't make.)
+// Secret keys are vidagent's own, unencrypted format.
+
+const (
+	sigAlgorithm     = "Ed"
+	sigExt           = ".minisig"
+	untrustedComment = "untrusted comment: "
+	trustedComment   = "trusted comment: "
+)
+
+// minisignKeyID formats a key ID the way minisign does.
+func minisignKeyID(id []byte) string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(id))
+}
+
+// publicKey is an Ed25519 public key and its key ID.
+type publicKey struct {
+	id  []byte
+	key ed25519.PublicKey
+}
+
+// parsePublicKey parses a minisign public key, either the base64
+// key itself or the name of a file containing it.
+func parsePublicKey(s string) (publicKey, error) {
+	if data, err := os.ReadFile(s); err == nil {
+		s = lastLine(string(data), untrustedComment)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != 2+8+ed25519.PublicKeySize || string(raw[:2]) != sigAlgorithm {
+		return publicKey{}, fmt.Errorf("not a valid public key (or key file)")
+	}
+	return publicKey{id: raw[2:10], key: raw[10:]}, nil
+}
+
+// lastLine returns the last non-empty line of s
+// that doesn't begin with the comment prefix.
+func lastLine(s, comment string) string {
+	var last string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, comment) {
+			last = line
+		}
+	}
+	return last
+}
+
+// verifyFilterFile checks that the filter file was signed by the
+// owner of the public key, using the signature file next to it.
+func verifyFilterFile(filename, key string) error {
+	pub, err := parsePublicKey(key)
+	if err != nil {
+		return fmt.Errorf("-verify-key: %v", err)
+	}
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	sigData, err := os.ReadFile(filename + sigExt)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%s is not signed (no %s file)", filename, sigExt)
+	}
+	if err != nil {
+		return err
+	}
+
+	// a signature file is an untrusted comment, the signature,
+	// a trusted comment, and a signature of the signature and
+	// the trusted comment together
+	lines := strings.Split(strings.ReplaceAll(string(sigData), "\r\n", "\n"), "\n")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], untrustedComment) || !strings.HasPrefix(lines[2], trustedComment) {
+		return fmt.Errorf("%s%s: malformed signature file", filename, sigExt)
+	}
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("%s%s: malformed signature", filename, sigExt)
+	}
+	if algo := string(sig[:2]); algo != sigAlgorithm {
+		return fmt.Errorf("%s%s: unsupported signature algorithm '%s' (sign with minisign -l)", filename, sigExt, algo)
+	}
+	if !bytes.Equal(sig[2:10], pub.id) {
+		return fmt.Errorf("%s was signed with key %s, not %s", filename, minisignKeyID(sig[2:10]), minisignKeyID(pub.id))
+	}
+	if !ed25519.Verify(pub.key, data, sig[10:]) {
+		return fmt.Errorf("%s: invalid signature; the file may have been tampered with", filename)
+	}
+
+	comment := strings.TrimPrefix(lines[2], trustedComment)
+	globalSig, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || !ed25519.Verify(pub.key, concatBytes(sig[10:], []byte(comment)), globalSig) {
+		return fmt.Errorf("%s%s: invalid signature of trusted comment", filename, sigExt)
+	}
+
+	log.Printf("verified signature of %s by key %s (%s)", filename, minisignKeyID(pub.id), comment)
+	return nil
+}
+
+// concatBytes returns a new slice with the contents of each slice in order.
+func concatBytes(slices ...[]byte) []byte {
+	return bytes.Join(slices, nil)
+}
+
+// keygenCmd generates a key pair for signing filter files.
+func keygenCmd(args []string) error {
+	fs := flag.NewFlagSet("keygen", flag.ExitOnError)
+	out := fs.String("out", "vidagent", "write the keys to this name with .pub and .key extensions")
+	force := fs.Bool("f", false, "overwrite existing key files")
+	fs.Parse(args)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		return err
+	}
+	keyID := minisignKeyID(id)
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	writeKey := func(filename string, perm os.FileMode, comment string, raw []byte) error {
+		f, err := os.OpenFile(filename, flags, perm)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(f, "%s%s\n%s\n", untrustedComment, comment,
+			base64.StdEncoding.EncodeToString(raw))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+
+	err = writeKey(*out+".key", 0600, "vidagent secret key "+keyID, concatBytes([]byte(sigAlgorithm), id, priv))
+	if err != nil {
+		return err
+	}
+	err = writeKey(*out+".pub", 0644, "minisign public key "+keyID, concatBytes([]byte(sigAlgorithm), id, pub))
+	if err != nil {
+		return err
+	}
+
+	log.Printf("wrote secret key to %s.key and public key %s to %s.pub; keep the secret key private", *out, keyID, *out)
+	return nil
+}
+
+// signCmd signs filter files with a secret key from keygen.
+func signCmd(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	e := newEdit(defaultOptions())
+	keyFile := fs.String("key", "vidagent.key", "the secret key file")
+	comment := fs.String("comment", "", "trusted comment to include in the signature (default has the time and file name)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent sign [options] <filter files...>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no filter files given")
+	}
+
+	keyData, err := os.ReadFile(*keyFile)
+	if err != nil {
+		return err
+	}
+	raw, err := base64.StdEncoding.DecodeString(lastLine(string(keyData), untrustedComment))
+	if err != nil || len(raw) != 2+8+ed25519.PrivateKeySize || string(raw[:2]) != sigAlgorithm {
+		return fmt.Errorf("%s: not a valid secret key file", *keyFile)
+	}
+	id, priv := raw[2:10], ed25519.PrivateKey(raw[10:])
+
+	for _, filename := range fs.Args() {
+		// don't sign something that can't be applied
+		if _, err := e.readFilterFile(filename); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		trusted := *comment
+		if trusted == "" {
+			trusted = fmt.Sprintf("timestamp:%d\tfile:%s", time.Now().Unix(), filepath.Base(filename))
+		}
+		sig := ed25519.Sign(priv, data)
+		globalSig := ed25519.Sign(priv, concatBytes(sig, []byte(trusted)))
+
+		sigFile := fmt.Sprintf("%s%s\n%s\n%s%s\n%s\n",
+			untrustedComment, "signature from vidagent secret key "+minisignKeyID(id),
+			base64.StdEncoding.EncodeToString(concatBytes([]byte(sigAlgorithm), id, sig)),
+			trustedComment, trusted,
+			base64.StdEncoding.EncodeToString(globalSig))
+		err = os.WriteFile(filename+sigExt, []byte(sigFile), 0644)
+		if err != nil {
+			return err
+		}
+		log.Printf("signed %s", filename)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// exitSignals are the signals that stop vidagent.
+var exitSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
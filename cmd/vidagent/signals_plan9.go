@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// exitSignals are the signals that stop vidagent.
+var exitSignals = []os.Signal{os.Interrupt}
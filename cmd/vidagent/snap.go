@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// snapKinds are the natural transitions that cut boundaries can be
+// snapped to, and the filters that find them. Each filter prints
+// the metadata of the frames it finds to standard output.
+var snapKinds = map[string][]string{
+	"scene":   {"-map", "0:v:0", "-an", "-vf", "select='gt(scene,0.3)',metadata=mode=print:file=-"},
+	"black":   {"-map", "0:v:0", "-an", "-vf", "blackdetect=d=0.04:pix_th=0.10,metadata=mode=print:file=-"},
+	"silence": {"-map", "0:a:0", "-vn", "-af", "silencedetect=n=-50dB:d=0.15,ametadata=mode=print:file=-"},
+}
+
+// parseSnapKinds parses the comma-separated list of -snap-to.
+func parseSnapKinds(s string) ([]string, error) {
+	kinds := splitList(s)
+	for _, kind := range kinds {
+		if _, ok := snapKinds[kind]; !ok {
+			return nil, fmt.Errorf("unknown snap kind '%s'; must be scene, black, or silence", kind)
+		}
+	}
+	return kinds, nil
+}
+
+// snapCuts moves the boundaries of cuts to the nearest transitions of
+// the given kinds within window seconds, so that the splices land
+// where they're less noticeable. Cuts only ever get longer, so that
+// nothing that was meant to be cut is kept, and they don't grow into
+// neighboring segments.
+func (e *edit) snapCuts(actions []action, kinds []string, window float64) ([]action, error) {
+	// the actions that occupy their segments
+	var segments []int
+	for i, act := range actions {
+		if act.verb != ChapterBreakVerb && act.verb != ExtractVerb {
+			segments = append(segments, i)
+		}
+	}
+
+	const gap = 0.01 // keep snapped cuts apart from their neighbors
+	for j, i := range segments {
+		act := &actions[i]
+		if act.verb != CutVerb {
+			continue
+		}
+
+		lower := math.Max(act.start.SecondNum()-window, 0)
+		if j > 0 {
+			lower = math.Max(lower, actions[segments[j-1]].end.SecondNum()+gap)
+		}
+		upper := act.end.SecondNum() + window
+		if j+1 < len(segments) {
+			upper = math.Min(upper, actions[segments[j+1]].start.SecondNum()-gap)
+		}
+
+		if lower < act.start.SecondNum() {
+			points, err := e.transitions(kinds, lower, act.start.SecondNum())
+			if err != nil {
+				return nil, err
+			}
+			// the latest transition before the start
+			best, kind := -1.0, ""
+			for _, p := range points {
+				if p.time >= lower && p.time <= act.start.SecondNum() && p.time > best {
+					best, kind = p.time, p.kind
+				}
+			}
+			if best >= 0 {
+				log.Printf("line %d: snapping start of cut from %s to %s (%s)",
+					act.line(), act.start, secondsTime(best), kind)
+				act.start = secondsTime(best)
+			}
+		}
+
+		if upper > act.end.SecondNum() {
+			points, err := e.transitions(kinds, act.end.SecondNum(), upper)
+			if err != nil {
+				return nil, err
+			}
+			// the earliest transition after the end
+			best, kind := math.Inf(1), ""
+			for _, p := range points {
+				if p.time >= act.end.SecondNum() && p.time <= upper && p.time < best {
+					best, kind = p.time, p.kind
+				}
+			}
+			if !math.IsInf(best, 1) {
+				log.Printf("line %d: snapping end of cut from %s to %s (%s)",
+					act.line(), act.end, secondsTime(best), kind)
+				act.end = secondsTime(best)
+			}
+		}
+	}
+	return actions, nil
+}
+
+// transition is a point in the input where a snap kind was found.
+type transition struct {
+	time float64 // seconds
+	kind string
+}
+
+// transitions returns the transitions of the given kinds in
+// the input between start and end seconds.
+func (e *edit) transitions(kinds []string, start, end float64) ([]transition, error) {
+	var points []transition
+	for _, kind := range kinds {
+		args := []string{
+			"-ss", strconv.FormatFloat(start, 'f', 3, 64),
+			"-t", strconv.FormatFloat(end-start, 'f', 3, 64),
+		}
+		args = append(args, e.inputArgs()...)
+		for _, arg := range snapKinds[kind] {
+			if arg == "0:a:0" {
+				arg = e.audioSpec(0) // (the silences of the track that's edited)
+			}
+			args = append(args, arg)
+		}
+		args = append(args, "-f", "null", "-")
+		out, err := ffmpegOutput(args...)
+		if err != nil {
+			return nil, fmt.Errorf("finding %s transitions: %v", kind, err)
+		}
+		for _, t := range parseTransitions(kind, out) {
+			// times are from the start of the part that was read
+			points = append(points, transition{start + t, kind})
+		}
+	}
+	return points, nil
+}
+
+// parseTransitions returns the times of transitions in the output of
+// the metadata filters. Scene changes are the frames themselves;
+// black and silence are the middle of each interval.
+func parseTransitions(kind string, out []byte) []float64 {
+	var times []float64
+	var frameTime float64
+	intervalStart := math.NaN()
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "frame:") {
+			_, pts, _ := strings.Cut(line, "pts_time:")
+			frameTime, _ = strconv.ParseFloat(strings.TrimSpace(pts), 64)
+			if kind == "scene" {
+				times = append(times, frameTime)
+			}
+			continue
+		}
+		key, val, ok := strings.Cut(strings.TrimPrefix(line, "lavfi."), "=")
+		if !ok {
+			continue
+		}
+		t, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "black_start", "silence_start":
+			intervalStart = math.Max(t, 0)
+		case "black_end", "silence_end":
+			if !math.IsNaN(intervalStart) {
+				times = append(times, (intervalStart+t)/2)
+			}
+			intervalStart = math.NaN()
+		}
+	}
+	// an interval that lasts to the end of the part that was read
+	if !math.IsNaN(intervalStart) {
+		times = append(times, math.Max(intervalStart, frameTime))
+	}
+	return times
+}
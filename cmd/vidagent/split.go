@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runSplit writes each part of the output to its own numbered file
+// instead of one continuous output. The parts are the regions kept
+// between cuts or, if there are any chapterbreak markers, the regions
+// between the markers (which may themselves contain edits). Parts are
+// made the same way as the concat engine does, regardless of -engine.
+func (e *edit) runSplit(actions []action) (err error) {
+	edits := withoutVerb(actions, ChapterBreakVerb)
+	err = checkConcatVerbs(edits)
+	if err != nil {
+		return err
+	}
+
+	var breaks []Time
+	for _, act := range actions {
+		if act.verb == ChapterBreakVerb {
+			breaks = append(breaks, act.start)
+		}
+	}
+
+	spans := splitSpans(e.outputSpans(edits), breaks)
+	parts := groupSpans(spans, breaks)
+
+	for n := range parts {
+		name := e.partName(n + 1)
+		if _, err := os.Stat(name); err == nil && !e.opts.overwrite {
+			return fmt.Errorf("output file %s already exists (use -f to overwrite)", name)
+		}
+	}
+
+	tmpDir, cleanup, err := e.editTempDir()
+	if err != nil {
+		return err
+	}
+	defer func() { cleanup(err) }()
+	err = e.checkSegmentSpace(tmpDir)
+	if err != nil {
+		return err
+	}
+
+	segments, err := e.extractSpans(spans, tmpDir)
+	if err != nil {
+		return err
+	}
+
+	for n, part := range parts {
+		name := e.partName(n + 1)
+		e.setStage(fmt.Sprintf("joining part %d of %d", n+1, len(parts)), e.spansSeconds(spans[part[0]:part[1]]))
+		err := e.joinSegments(segments[part[0]:part[1]], name, edits)
+		if err != nil {
+			return fmt.Errorf("part %d: %v", n+1, err)
+		}
+		log.Printf("wrote %s", name)
+	}
+
+	return nil
+}
+
+// splitSpans splits any spans that contain one of the
+// break times into two spans at that time.
+func splitSpans(spans []span, breaks []Time) []span {
+	for _, brk := range breaks {
+		var result []span
+		for _, sp := range spans {
+			t := brk.SecondNum()
+			if t > sp.start.SecondNum() && (sp.open || t < sp.end.SecondNum()) {
+				before, after := sp, sp
+				before.end, before.open = brk, false
+				after.start = brk
+				result = append(result, before, after)
+				continue
+			}
+			result = append(result, sp)
+		}
+		spans = result
+	}
+	return spans
+}
+
+// groupSpans groups spans into parts, returning the index range
+// [start, end) of the spans in each part. Without break times, a
+// new part starts wherever there is a gap between spans (a cut);
+// otherwise, a new part starts with the first span after each break.
+func groupSpans(spans []span, breaks []Time) [][2]int {
+	partOf := func(sp span) int {
+		var n int
+		for _, brk := range breaks {
+			if brk.SecondNum() <= sp.start.SecondNum() {
+				n++
+			}
+		}
+		return n
+	}
+
+	var parts [][2]int
+	start := 0
+	for i := 1; i < len(spans); i++ {
+		var boundary bool
+		if len(breaks) == 0 {
+			boundary = spans[i].start.SecondNum() != spans[i-1].end.SecondNum()
+		} else {
+			boundary = partOf(spans[i]) != partOf(spans[i-1])
+		}
+		if boundary {
+			parts = append(parts, [2]int{start, i})
+			start = i
+		}
+	}
+	return append(parts, [2]int{start, len(spans)})
+}
+
+// partName returns the file name of the nth part of the output.
+func (e *edit) partName(n int) string {
+	ext := filepath.Ext(e.opts.outputFile)
+	return fmt.Sprintf("%s-%03d%s", strings.TrimSuffix(e.opts.outputFile, ext), n, ext)
+}
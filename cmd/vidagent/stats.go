@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// statsCmd prints a report of the actions in each of the
+// given filter files: how many there are of each verb and
+// reason category, how much time they cover, the longest
+// edits, and how densely the edits are spread over time.
+func statsCmd(args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	e := newEdit(defaultOptions())
+	top := fs.Int("top", 5, "how many of the longest edits to list")
+	block := fs.Duration("block", 10*time.Minute, "length of the blocks of time to count edits in")
+	policyFile := fs.String("policy", "", "decide the verbs of actions by their reasons according to this policy file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent stats [options] <filter files...>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no filter files given")
+	}
+	if *block <= 0 {
+		return fmt.Errorf("-block must be positive")
+	}
+	if *policyFile != "" {
+		err := e.loadPolicy(*policyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, filename := range fs.Args() {
+		actions, err := e.readFilterFile(filename)
+		if err != nil {
+			return err
+		}
+		disabled, err := disabledLines(filename)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		if i > 0 {
+			fmt.Println()
+		}
+		printStats(os.Stdout, filename, actions, disabled, *top, block.Seconds())
+	}
+	return nil
+}
+
+// tally is a count of actions and the total time they cover.
+type tally struct {
+	count   int
+	seconds float64
+}
+
+// printStats writes the report for one filter file to w, with
+// the lines of its disabled actions.
+func printStats(w io.Writer, filename string, actions []action, disabled []int, top int, blockSeconds float64) {
+	byVerb := make(map[string]tally)
+	byCategory := make(map[string]tally)
+	var edits []action
+	var editSeconds float64
+	for _, act := range actions {
+		length := act.end.SecondNum() - act.start.SecondNum()
+		add := func(m map[string]tally, key string) {
+			t := m[key]
+			t.count++
+			t.seconds += length
+			m[key] = t
+		}
+		add(byVerb, string(act.verb))
+		category := act.reason.Category
+		if category == "" {
+			category = "(none)"
+		}
+		add(byCategory, category)
+		if act.verb == CutVerb || act.verb == MuteVerb {
+			edits = append(edits, act)
+			editSeconds += length
+		}
+	}
+
+	fmt.Fprintf(w, "%s: %d actions, %d edits covering %s\n", filename, len(actions), len(edits), seconds(editSeconds))
+	if len(disabled) > 0 {
+		fmt.Fprintf(w, "%d disabled actions, on lines %s\n", len(disabled), lineList(disabled))
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	printTallies := func(heading string, m map[string]tally, describe func(key string) (string, string)) {
+		keys := make([]string, 0, len(m))
+		for key := range m {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(tw, "\n%s\tcount\tduration\n", heading)
+		for _, key := range keys {
+			name, description := key, ""
+			if describe != nil {
+				name, description = describe(key)
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s", name, m[key].count, seconds(m[key].seconds))
+			if description != "" {
+				fmt.Fprintf(tw, "\t%s", description)
+			}
+			fmt.Fprintln(tw)
+		}
+	}
+	printTallies("verb", byVerb, nil)
+	printTallies("category", byCategory, func(category string) (string, string) {
+		if category == "(none)" {
+			return category, ""
+		}
+		r := Reason{Category: category}
+		return cfg.reasonName(r), cfg.reasonDescription(r)
+	})
+	tw.Flush()
+
+	if len(edits) == 0 {
+		return
+	}
+
+	longest := append([]action(nil), edits...)
+	sort.SliceStable(longest, func(i, j int) bool {
+		return longest[i].end.SecondNum()-longest[i].start.SecondNum() >
+			longest[j].end.SecondNum()-longest[j].start.SecondNum()
+	})
+	if top < len(longest) {
+		longest = longest[:top]
+	}
+	if len(longest) > 0 {
+		fmt.Fprintln(w, "\nlongest edits")
+		for _, act := range longest {
+			fmt.Fprintf(tw, "line %d\t%s\t%s-%s\t%s\t%s\n", act.line(), act.verb,
+				act.start, act.end, seconds(act.end.SecondNum()-act.start.SecondNum()), cfg.reasonName(act.reason))
+		}
+		tw.Flush()
+	}
+
+	// count each edit in every block it overlaps, along
+	// with how much of the block it covers
+	last := edits[len(edits)-1].end.SecondNum()
+	blocks := make([]tally, int(math.Ceil(last/blockSeconds)))
+	for _, act := range edits {
+		for b := range blocks {
+			from, to := float64(b)*blockSeconds, float64(b+1)*blockSeconds
+			overlap := math.Min(to, act.end.SecondNum()) - math.Max(from, act.start.SecondNum())
+			if overlap > 0 {
+				blocks[b].count++
+				blocks[b].seconds += overlap
+			}
+		}
+	}
+	fmt.Fprintf(tw, "\nedits per %s\tcount\tduration\n", seconds(blockSeconds))
+	for b, t := range blocks {
+		fmt.Fprintf(tw, "%s\t%d\t%s\n", seconds(float64(b)*blockSeconds), t.count, seconds(t.seconds))
+	}
+	tw.Flush()
+}
+
+// seconds formats a number of seconds for the report.
+func seconds(sec float64) string {
+	return time.Duration(sec * float64(time.Second)).Round(10 * time.Millisecond).String()
+}
+
+// reasonString returns the reason as it's written in a filter file.
+func reasonString(r Reason) string {
+	if r.Specifier != "" {
+		return r.Category + ":" + r.Specifier
+	}
+	return r.Category
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// subtitlesFilter returns the filter that burns in subtitles
+// according to the -burn-subs flag, or "" if it isn't set. The
+// flag's value is either the number of a subtitle track in the
+// input (0 is the first subtitle track) or a subtitles file.
+//
+// The filter is applied to the video before any edits, so the
+// subtitles are matched to the original timestamps; subtitles
+// within cut segments are cut along with the video.
+func (e *edit) subtitlesFilter() (string, error) {
+	if e.opts.burnSubs == "" {
+		return "", nil
+	}
+	if track, err := strconv.Atoi(e.opts.burnSubs); err == nil {
+		if track < 0 {
+			return "", fmt.Errorf("invalid subtitle track %d", track)
+		}
+		return fmt.Sprintf("subtitles=%s:si=%d", escapeFilterArg(e.opts.inputFile), track), nil
+	}
+	if _, err := os.Stat(e.opts.burnSubs); err != nil {
+		return "", fmt.Errorf("subtitles file: %v", err)
+	}
+	return "subtitles=" + escapeFilterArg(e.opts.burnSubs), nil
+}
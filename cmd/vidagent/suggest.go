@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/png"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// detection is a region that a detector found in a frame.
+type detection struct {
+	X     int     `json:"x"`
+	Y     int     `json:"y"`
+	W     int     `json:"w"`
+	H     int     `json:"h"`
+	Score float64 `json:"score"`
+	Label string  `json:"label,omitempty"`
+}
+
+// suggestBlurCmd proposes the regions to blur for blur actions that
+// don't have any yet, using an external detector (of faces, skin,
+// or whatever it was made for) on frames sampled from each action's
+// segment. It adds them to the filter file as box parameters, with
+// a comment, so that they can be reviewed before the edit is made.
+//
+// The detector is run with the name of a PNG file and writes a JSON
+// array of detections to standard output.
+func suggestBlurCmd(args []string) error {
+	fs := flag.NewFlagSet("suggest-blur", flag.ExitOnError)
+	e := newEdit(defaultOptions())
+	in := fs.String("in", "", "the video the filter file is for")
+	filter := fs.String("filter", "", "the filter file")
+	out := fs.String("out", "", "write the filter file with suggestions to this file instead of standard output")
+	detector := fs.String("detector", "vidagent-detect", "the detector to run on each frame")
+	fps := fs.Float64("fps", 2, "how many frames per second of each segment to run the detector on")
+	minScore := fs.Float64("min-score", 0.5, "ignore detections with a lower score than this")
+	pad := fs.Float64("pad", 0.15, "enlarge each region by this fraction of its size on each side")
+	lines := fs.String("lines", "", "only make suggestions for the blur actions on these lines (e.g. 3,7-12)")
+	fs.Parse(args)
+
+	if *in == "" || *filter == "" {
+		return fmt.Errorf("-in and -filter are required")
+	}
+	if *fps <= 0 || *pad < 0 {
+		return fmt.Errorf("-fps must be positive and -pad can't be negative")
+	}
+	var ranges [][2]int
+	if *lines != "" {
+		var err error
+		ranges, err = parseLineRanges(*lines)
+		if err != nil {
+			return err
+		}
+	}
+	exe, err := findTool(*detector)
+	if err != nil {
+		return fmt.Errorf("finding detector: %v", err)
+	}
+
+	actions, err := e.readFilterFile(*filter)
+	if err != nil {
+		return err
+	}
+
+	suggestions := make(map[int][]blurBox) // by line
+	for _, act := range actions {
+		line := act.line()
+		if act.verb != BlurVerb || len(act.paramValues("box")) > 0 ||
+			(ranges != nil && !lineInRanges(line, ranges)) {
+			continue
+		}
+		boxes, frames, err := e.detectRegions(exe, *in, act, *fps, *minScore, *pad)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+		if len(boxes) == 0 {
+			log.Printf("line %d: nothing detected in %d frames", line, frames)
+			continue
+		}
+		log.Printf("line %d: suggesting %d regions from %d frames", line, len(boxes), frames)
+		suggestions[line] = boxes
+	}
+
+	updated, err := addSuggestions(*filter, suggestions)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(updated)
+		return err
+	}
+	return os.WriteFile(*out, updated, 0644)
+}
+
+// detectRegions runs the detector on frames from the action's
+// segment of the video, and returns the regions that cover what
+// was detected, along with how many frames there were.
+func (e *edit) detectRegions(detector, video string, act action, fps, minScore, pad float64) ([]blurBox, int, error) {
+	dir, err := makeTempDir(e.opts.tempRoot, "vidagent-frames-")
+	if err != nil {
+		return nil, 0, err
+	}
+	defer removeTempDir(dir)
+
+	_, err = ffmpegOutput(
+		"-ss", e.secondString(act.start),
+		"-t", strconv.FormatFloat(act.end.SecondNum()-act.start.SecondNum(), 'f', 3, 64),
+		"-i", fileArg(video),
+		"-map", "0:v:0", "-vf", "fps="+strconv.FormatFloat(fps, 'f', -1, 64),
+		filepath.Join(dir, "%05d.png"))
+	if err != nil {
+		return nil, 0, err
+	}
+	frames, err := filepath.Glob(filepath.Join(dir, "*.png"))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var boxes []blurBox
+	var width, height int
+	for _, frame := range frames {
+		if width == 0 {
+			width, height, err = imageSize(frame)
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		detections, err := runDetector(detector, frame)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, d := range detections {
+			if d.Score < minScore || d.W <= 0 || d.H <= 0 {
+				continue
+			}
+			px, py := int(float64(d.W)*pad), int(float64(d.H)*pad)
+			boxes = append(boxes, clampBox(blurBox{d.X - px, d.Y - py, d.W + 2*px, d.H + 2*py}, width, height))
+		}
+	}
+	return mergeBoxes(boxes), len(frames), nil
+}
+
+// runDetector runs the detector on the image file.
+func runDetector(detector, image string) ([]detection, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command(detector, image)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("running detector: %v", err)
+	}
+	var detections []detection
+	err = json.Unmarshal(stdout.Bytes(), &detections)
+	if err != nil {
+		return nil, fmt.Errorf("decoding detector output: %v", err)
+	}
+	return detections, nil
+}
+
+// imageSize returns the dimensions of the image file.
+func imageSize(file string) (int, int, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	conf, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %v", file, err)
+	}
+	return conf.Width, conf.Height, nil
+}
+
+// clampBox returns the part of b that's within a picture
+// of the given size.
+func clampBox(b blurBox, width, height int) blurBox {
+	x0, y0 := max(b.x, 0), max(b.y, 0)
+	x1, y1 := min(b.x+b.w, width), min(b.y+b.h, height)
+	return blurBox{x0, y0, max(x1-x0, 0), max(y1-y0, 0)}
+}
+
+// overlaps returns true if a and b share any pixels.
+func (b blurBox) overlaps(other blurBox) bool {
+	return b.x < other.x+other.w && other.x < b.x+b.w &&
+		b.y < other.y+other.h && other.y < b.y+b.h
+}
+
+// union returns the smallest box that covers b and other.
+func (b blurBox) union(other blurBox) blurBox {
+	x0, y0 := min(b.x, other.x), min(b.y, other.y)
+	x1, y1 := max(b.x+b.w, other.x+other.w), max(b.y+b.h, other.y+other.h)
+	return blurBox{x0, y0, x1 - x0, y1 - y0}
+}
+
+// mergeBoxes combines overlapping boxes until none overlap, so that
+// something detected in many frames, moving a little from one to the
+// next, is covered by one box for the whole segment.
+func mergeBoxes(boxes []blurBox) []blurBox {
+	var merged []blurBox
+	for _, b := range boxes {
+		if b.w == 0 || b.h == 0 {
+			continue
+		}
+		merged = append(merged, b)
+	}
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(merged) && !changed; i++ {
+			for j := i + 1; j < len(merged); j++ {
+				if merged[i].overlaps(merged[j]) {
+					merged[i] = merged[i].union(merged[j])
+					merged = append(merged[:j], merged[j+1:]...)
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return merged
+}
+
+// addSuggestions returns the contents of the filter file with the
+// suggested boxes added to the end of their lines as parameters.
+func addSuggestions(filename string, suggestions map[int][]blurBox) ([]byte, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := parseSyntax(bytes.NewReader(data), nil)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	for _, line := range tree.lines {
+		boxes, ok := suggestions[line.start.line]
+		if !ok || line.action == nil {
+			buf.WriteString(line.text)
+			buf.WriteByte('\n')
+			continue
+		}
+		var params []string
+		for _, box := range boxes {
+			params = append(params, "box="+box.String())
+		}
+		var comment string
+		if line.comment != nil {
+			comment = strings.TrimSpace(strings.TrimPrefix(line.comment.text, "#")) + "; "
+		}
+		// with any parameters the line has (which have no boxes,
+		// or there wouldn't be suggestions for it)
+		code := line.action.text
+		if n := line.action.params; n != nil {
+			code = strings.TrimRight(code[:n.start.offset-line.action.start.offset], " \t")
+			others, err := parseParams(n)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range others {
+				params = append(params, p.key+"="+p.value)
+			}
+		}
+		fmt.Fprintf(&buf, "%s%s [%s] # %ssuggested regions, review before using\n",
+			line.text[:line.action.start.offset-line.start.offset], code, strings.Join(params, ", "), comment)
+	}
+	return buf.Bytes(), nil
+}
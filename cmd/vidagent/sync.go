@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"math"
+)
+
+// maxDrift is how far apart, in seconds, the audio and video
+// streams can start or end before it is reported as drift;
+// about one frame at 24 fps.
+const maxDrift = 0.042
+
+// checkSync probes file and reports how far its first audio
+// and video streams have drifted apart.
+func checkSync(file string) error {
+	result, err := probe(file)
+	if err != nil {
+		return err
+	}
+	video, audio := result.stream("video"), result.stream("audio")
+	if video == nil || audio == nil {
+		return nil
+	}
+
+	vDur, aDur := video.duration(), audio.duration()
+	if vDur == 0 || aDur == 0 {
+		log.Printf("sync check: stream durations of %s are unknown", file)
+		return nil
+	}
+
+	startDrift := audio.start() - video.start()
+	endDrift := (audio.start() + aDur) - (video.start() + vDur)
+
+	if math.Abs(startDrift) <= maxDrift && math.Abs(endDrift) <= maxDrift {
+		log.Printf("sync check: audio and video are in sync (start %+.3fs, end %+.3fs)", startDrift, endDrift)
+		return nil
+	}
+	log.Printf("sync check: WARNING: audio has drifted from video by %+.3fs at the start and %+.3fs at the end; try again with -fix-sync",
+		startDrift, endDrift)
+	return nil
+}
@@ -0,0 +1,371 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Each line of a filter file is blank, a directive, or an action,
+// and may end with a comment:
+//
+//	@offset -1.2s                          # a directive
+//	mute 1:02-1:04 (language:mild)         # an action
+//	blur 3:00-3:05 (nudity) [box=1:2:3:4]
+//	1:10:00-1:12:30 (violence (battle))
+//
+// An action is a verb (which can be left out if the reason decides
+// it, and may be followed by @ and the audio language the action is
+// for, like mute@spa), a start time, an end time after a -, a reason in parentheses
+// (which may have parentheses in it), and parameters in brackets.
+// An action that starts with ! or off: is disabled: it's parsed and
+// checked like the others, but not applied. A # starts a comment
+// anywhere but in a reason or parameters.
+//
+// Filter files are parsed into a syntax tree that records where each
+// part of every line is, so errors can point at it exactly and tools
+// can change part of a file without disturbing the rest of it.
+
+// pos is a place in a filter file.
+type pos struct {
+	line, col int // starting at 1; col counts characters (a tab is one)
+	offset    int // in bytes from the start of the file
+}
+
+// node is a part of a filter file: its text,
+// and where it starts and ends (just after it).
+type node struct {
+	text       string
+	start, end pos
+}
+
+// syntaxTree is a parsed filter file.
+type syntaxTree struct {
+	lines []*lineNode
+}
+
+// lineNode is a line of a filter file. At most one of directive
+// and action is set; neither is for blank or comment lines.
+type lineNode struct {
+	node      // the whole line, without its line ending
+	directive *directiveNode
+	action    *actionNode
+	comment   *node // including the #; nil if none
+}
+
+// directiveNode is a directive, like "@offset +2s".
+type directiveNode struct {
+	node
+	name *node // including the @
+	args []*node
+}
+
+// actionNode is an action, from its first part to its last.
+type actionNode struct {
+	node
+	disabled  *node // the ! or off: before it; nil if it's enabled
+	verb      *node // nil if there's no verb
+	lang      *node // after an @ after the verb; nil if none
+	startTime *node
+	endTime   *node // nil if there's only a start, as for markers
+	reason    *node // inside the parentheses; nil if none
+	params    *node // including the brackets; nil if none
+}
+
+// parseSyntax parses the filter file read from r into a syntax tree.
+// It only checks the shape of each line; what the parts mean (like
+// whether a verb exists or a time is valid) is up to getActions.
+// Lines that l skips are left empty in the tree.
+func parseSyntax(r io.Reader, l *lenientParse) (*syntaxTree, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(data)
+
+	// what a newer version's syntax would do to parsing doesn't
+	// matter if this version is too old to apply the file anyway
+	err = checkRequiredVersion(lines)
+	if errors.Is(err, errUpgradeRequired) && l != nil {
+		if !l.quiet {
+			log.Printf("warning: %v; applying what this version can", err)
+		}
+	} else if err != nil && !l.skip(err) {
+		return nil, err
+	}
+
+	tree := new(syntaxTree)
+	for _, s := range lines {
+		start := s.pos
+		line, err := s.parseLine()
+		if err != nil {
+			if !l.skip(err) {
+				return nil, err
+			}
+			line = &lineNode{node: node{text: s.text, start: start}}
+			line.end = start
+			line.end.col += utf8.RuneCountInString(s.text)
+			line.end.offset += len(s.text)
+		}
+		tree.lines = append(tree.lines, line)
+	}
+	return tree, nil
+}
+
+// splitLines returns scanners for the lines of the filter file.
+func splitLines(data []byte) []*lineScanner {
+	var lines []*lineScanner
+	offset := 0
+	for lineNum := 1; offset < len(data); lineNum++ {
+		text := data[offset:]
+		next := len(data)
+		if i := bytes.IndexByte(text, '\n'); i >= 0 {
+			text, next = text[:i], offset+i+1
+		}
+		text = bytes.TrimSuffix(text, []byte("\r"))
+		lines = append(lines, &lineScanner{text: string(text), pos: pos{line: lineNum, col: 1, offset: offset}})
+		offset = next
+	}
+	return lines
+}
+
+// lineScanner reads the parts of a line of a filter file.
+type lineScanner struct {
+	text string
+	i    int // in bytes
+	pos  pos // of text[i]
+}
+
+// peek returns the next character, or 0 at the end of the line.
+func (s *lineScanner) peek() rune {
+	if s.i >= len(s.text) {
+		return 0
+	}
+	ch, _ := utf8.DecodeRuneInString(s.text[s.i:])
+	return ch
+}
+
+func (s *lineScanner) next() {
+	_, size := utf8.DecodeRuneInString(s.text[s.i:])
+	s.i += size
+	s.pos.col++
+	s.pos.offset += size
+}
+
+func (s *lineScanner) skipSpace() {
+	for s.i < len(s.text) && unicode.IsSpace(s.peek()) {
+		s.next()
+	}
+}
+
+// word reads characters up to the end of the line, a space,
+// or any of the stop characters.
+func (s *lineScanner) word(stop string) *node {
+	n := &node{start: s.pos}
+	begin := s.i
+	for ch := s.peek(); s.i < len(s.text) && !unicode.IsSpace(ch) && !strings.ContainsRune(stop, ch); ch = s.peek() {
+		s.next()
+	}
+	n.text, n.end = s.text[begin:s.i], s.pos
+	return n
+}
+
+// take reads the next n characters.
+func (s *lineScanner) take(n int) *node {
+	nd := &node{start: s.pos}
+	begin := s.i
+	for range n {
+		s.next()
+	}
+	nd.text, nd.end = s.text[begin:s.i], s.pos
+	return nd
+}
+
+// group reads from open to the matching close, including both.
+// Groups opened with ( may have parentheses in them.
+func (s *lineScanner) group(open, close rune) (*node, error) {
+	n := &node{start: s.pos}
+	begin := s.i
+	depth := 0
+	for s.i < len(s.text) {
+		ch := s.peek()
+		s.next()
+		switch {
+		case ch == open && (open == '(' || depth == 0):
+			depth++
+		case ch == close:
+			depth--
+		}
+		if depth == 0 {
+			n.text, n.end = s.text[begin:s.i], s.pos
+			return n, nil
+		}
+	}
+	if open == '[' {
+		return nil, fmt.Errorf("line %d:%d: parameters aren't closed with ]", n.start.line, n.start.col)
+	}
+	return nil, fmt.Errorf("line %d:%d: reason isn't closed with )", n.start.line, n.start.col)
+}
+
+// cut returns the parts of the text before and after the byte at i.
+func (n *node) cut(i int) (*node, *node) {
+	mid := n.start
+	mid.col += utf8.RuneCountInString(n.text[:i])
+	mid.offset += i
+	after := mid
+	after.col++
+	after.offset++
+	return &node{text: n.text[:i], start: n.start, end: mid}, &node{text: n.text[i+1:], start: after, end: n.end}
+}
+
+// inner returns the text inside a group, without its
+// delimiters or surrounding space.
+func (n *node) inner() *node {
+	text := n.text[1 : len(n.text)-1]
+	trimmed := strings.TrimLeftFunc(text, unicode.IsSpace)
+	start := n.start
+	start.col += 1 + utf8.RuneCountInString(text[:len(text)-len(trimmed)])
+	start.offset += 1 + len(text) - len(trimmed)
+	trimmed = strings.TrimRightFunc(trimmed, unicode.IsSpace)
+	end := start
+	end.col += utf8.RuneCountInString(trimmed)
+	end.offset += len(trimmed)
+	return &node{text: trimmed, start: start, end: end}
+}
+
+func (s *lineScanner) parseLine() (*lineNode, error) {
+	line := &lineNode{node: node{text: s.text, start: s.pos}}
+	defer func() { line.end = s.pos }()
+
+	s.skipSpace()
+	switch ch := s.peek(); {
+	case ch == '@':
+		line.directive = s.parseDirective()
+	case ch != 0 && ch != '#':
+		act, err := s.parseAction()
+		if err != nil {
+			return nil, err
+		}
+		line.action = act
+	}
+
+	s.skipSpace()
+	switch ch := s.peek(); ch {
+	case 0:
+	case '#':
+		line.comment = &node{text: s.text[s.i:], start: s.pos}
+		for s.i < len(s.text) {
+			s.next()
+		}
+		line.comment.end = s.pos
+	default:
+		return nil, fmt.Errorf("line %d:%d: unexpected '%s'", s.pos.line, s.pos.col, s.word("#").text)
+	}
+	return line, nil
+}
+
+func (s *lineScanner) parseDirective() *directiveNode {
+	d := &directiveNode{node: node{start: s.pos}}
+	begin := s.i
+	d.name = s.word("#")
+	for {
+		end, i := s.pos, s.i
+		s.skipSpace()
+		if ch := s.peek(); ch == 0 || ch == '#' {
+			d.text, d.end = s.text[begin:i], end
+			return d
+		}
+		d.args = append(d.args, s.word("#"))
+	}
+}
+
+func (s *lineScanner) parseAction() (*actionNode, error) {
+	act := &actionNode{node: node{start: s.pos}}
+	begin := s.i
+	end, endI := s.pos, s.i
+	mark := func() { end, endI = s.pos, s.i }
+
+	switch rest := s.text[s.i:]; {
+	case strings.HasPrefix(rest, "!"):
+		act.disabled = s.take(1)
+	case len(rest) >= 4 && strings.EqualFold(rest[:4], "off:"):
+		act.disabled = s.take(4)
+	}
+	s.skipSpace()
+
+	// without a verb, the line starts with the start time; verbs
+	// (like those of plugins) may have a - in them
+	if ch := s.peek(); !unicode.IsDigit(ch) && ch != '$' && !isChapterTime(s.text[s.i:]) {
+		act.verb = s.word("([#")
+		// (the language an action is for follows its verb)
+		if at := strings.IndexByte(act.verb.text, '@'); at >= 0 {
+			act.verb, act.lang = act.verb.cut(at)
+		}
+		mark()
+		s.skipSpace()
+	}
+
+	act.startTime = s.word("-([#")
+	if act.startTime.text == "" {
+		return nil, fmt.Errorf("line %d:%d: expected a start time", s.pos.line, s.pos.col)
+	}
+	mark()
+	s.skipSpace()
+	if s.peek() == '-' {
+		s.next()
+		s.skipSpace()
+		act.endTime = s.word("-([#")
+		if act.endTime.text == "" {
+			return nil, fmt.Errorf("line %d:%d: expected an end time after -", s.pos.line, s.pos.col)
+		}
+		mark()
+		s.skipSpace()
+	}
+
+	if s.peek() == '(' {
+		reason, err := s.group('(', ')')
+		if err != nil {
+			return nil, err
+		}
+		act.reason = reason.inner()
+		mark()
+		s.skipSpace()
+	}
+	if s.peek() == '[' {
+		params, err := s.group('[', ']')
+		if err != nil {
+			return nil, err
+		}
+		act.params = params
+		mark()
+	}
+
+	act.text, act.end = s.text[begin:endI], end
+	return act, nil
+}
+
+// lenientParse is parsing a filter file with -lenient, which skips the
+// lines that can't be used (like those with verbs or syntax of newer
+// versions of vidagent) with a warning, instead of failing.
+type lenientParse struct {
+	skipped []error
+	quiet   bool // without warnings, like for finding all the errors
+}
+
+// skip returns true if the line that err is about is skipped,
+// which it is unless l is nil, for parsing strictly.
+func (l *lenientParse) skip(err error) bool {
+	if l == nil {
+		return false
+	}
+	if !l.quiet {
+		log.Printf("warning: skipping %v", err)
+	}
+	l.skipped = append(l.skipped, err)
+	return true
+}
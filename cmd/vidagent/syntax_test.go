@@ -0,0 +1,31 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzParseFilter parses filter files, strictly and with -lenient, to
+// make sure that malformed ones are errors (or skipped lines) instead
+// of crashes or hangs. Its seed corpus, in testdata/fuzz, is made of
+// the kinds of mistakes that filter files from others have.
+func FuzzParseFilter(f *testing.F) {
+	f.Add([]byte("cut 1:32-1:45 (violence)\nmute 2:19.2-2:19.85 (language:mild)  # \"darn\"\n"))
+	f.Add([]byte("@offset +2.5s\n@scale 25/23.976\nblur 1:02:03-1:02:09 (nudity) [box=320:180:200:240, audio=off]\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		e := newEdit(defaultOptions())
+		for _, l := range []*lenientParse{nil, {quiet: true}} {
+			actions, err := e.parseLenient(bytes.NewReader(data), 1, 0, l)
+			if err != nil {
+				continue
+			}
+			for _, act := range actions {
+				if act.start.SecondNum() < 0 || act.end.SecondNum() < act.start.SecondNum() {
+					t.Errorf("line %d: parsed %s-%s, which isn't a valid segment",
+						act.line(), e.secondString(act.start), e.secondString(act.end))
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Temporary files, like the segments of the concat engine, can be
+// as big as the output. They go in -tmpdir (or the system's temporary
+// directory), and are removed when vidagent finishes, fails, or is
+// interrupted. With -resume, they're kept after a failure instead,
+// and running the same edit again reuses the ones that were done.
+
+// tempDirs are the temporary directories to remove if
+// vidagent is interrupted.
+var tempDirs = struct {
+	sync.Mutex
+	m map[string]bool
+}{m: make(map[string]bool)}
+
+// makeTempDir creates a temporary directory in root (or the system's
+// temporary directory, if it's "") whose name starts with prefix,
+// which is removed if vidagent is interrupted.
+func makeTempDir(root, prefix string) (string, error) {
+	dir, err := os.MkdirTemp(root, prefix)
+	if err != nil {
+		return "", err
+	}
+	tempDirs.Lock()
+	tempDirs.m[dir] = true
+	tempDirs.Unlock()
+	return dir, nil
+}
+
+// removeTempDir removes a directory made by makeTempDir.
+func removeTempDir(dir string) {
+	tempDirs.Lock()
+	delete(tempDirs.m, dir)
+	tempDirs.Unlock()
+	os.RemoveAll(dir)
+}
+
+// editTempDir returns the temporary directory for the segments of
+// an edit, and a function that removes it. With -resume, it's named
+// after the input, filter, and options, so running the same edit
+// again finds it, and it's only removed if the edit succeeds.
+func (e *edit) editTempDir() (string, func(error), error) {
+	if !e.opts.resumeTemp {
+		dir, err := makeTempDir(e.opts.tempRoot, "vidagent-")
+		if err != nil {
+			return "", nil, err
+		}
+		return dir, func(error) { removeTempDir(dir) }, nil
+	}
+
+	// anything that changes the segments changes the directory
+	key := []string{absPath(e.opts.inputFile), e.filterHash}
+	key = append(key, e.opts.args("f", "resume", "tmpdir", "no-history", "no-space-check", "low-priority", "threads", "timeout", "stall-timeout", "progress-json")...)
+	sum := sha256.Sum256([]byte(strings.Join(key, "\n")))
+	root := e.opts.tempRoot
+	if root == "" {
+		root = os.TempDir()
+	}
+	dir := filepath.Join(root, "vidagent-resume-"+hex.EncodeToString(sum[:8]))
+	if _, err := os.Stat(dir); err == nil {
+		log.Printf("resuming with the temporary files in %s", dir)
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", nil, err
+	}
+	return dir, func(err error) {
+		if err != nil {
+			log.Printf("keeping temporary files in %s (%s) to resume; run the same command again", dir, byteSize(uint64(dirSize(dir))))
+			return
+		}
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// dirSize returns the total size of the files in dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// checkSegmentSpace returns an error if dir doesn't have room for
+// the segments of the output, besides any that are already there.
+func (e *edit) checkSegmentSpace(dir string) error {
+	if e.opts.noSpaceCheck {
+		return nil
+	}
+	need, err := e.estimateOutputSize()
+	if err != nil {
+		return err
+	}
+	err = checkFreeSpace(dir, max(need-dirSize(dir), 0))
+	if err != nil {
+		return fmt.Errorf("for temporary files: %v; -tmpdir can put them elsewhere", err)
+	}
+	return nil
+}
+
+// cleanUpOnExit kills ffmpeg and removes the temporary directories
+// (but not ones kept for -resume) when vidagent is interrupted or
+// terminated, like by the server canceling a job.
+func cleanUpOnExit() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, exitSignals...)
+	go func() {
+		<-sig
+		ffmpegProcs.Lock()
+		for proc := range ffmpegProcs.m {
+			proc.Kill()
+			proc.Wait()
+		}
+		tempDirs.Lock()
+		for dir := range tempDirs.m {
+			os.RemoveAll(dir)
+		}
+		os.Exit(1)
+	}()
+}
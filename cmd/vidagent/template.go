@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// A template is a filter file for a series that defines, with @def,
+// the times that are different in each episode, like how long its
+// intro is. apply-template fills in those times for each episode,
+// either from a CSV file with a row for each one or by detecting them
+// in the episode, and applies the template to it.
+
+// videoExts are the extensions of the files in a directory
+// that apply-template applies the template to.
+var videoExts = []string{".mp4", ".m4v", ".mov", ".mkv", ".avi", ".webm", ".ts"}
+
+// applyTemplateCmd applies a template filter file to each episode.
+func applyTemplateCmd(args []string) error {
+	fs := flag.NewFlagSet("apply-template", flag.ExitOnError)
+	o := defaultOptions()
+	edits := editFlags(o)
+	edits.VisitAll(func(f *flag.Flag) {
+		if f.Name != "in" && f.Name != "out" && f.Name != "filter" {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	templateFile := fs.String("template", "", "the template filter file")
+	outDir := fs.String("out-dir", "", "the directory to write the edited episodes (and their filter files) to")
+	varsFile := fs.String("vars", "", `a CSV file with a "file" column of episode file names, and a column for each variable to set for them`)
+	manifest := fs.String("manifest", "", "after the episodes are done, write a manifest of their files' hashes, durations, and edit options to this file (.json or .csv)")
+	detect := fs.String("detect", "", "comma-separated variables to detect in each episode, like intro_end=black@0-5:00 for the first black frames in its first 5 minutes (kinds are scene, black, and silence)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent apply-template -template <file> -out-dir <dir> [-vars <file>] [-detect <variables>] [options] <episodes or directories...>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	e := newEdit(o)
+
+	if *templateFile == "" || *outDir == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("template, output directory, and episodes required")
+	}
+	template, err := os.ReadFile(*templateFile)
+	if err != nil {
+		return err
+	}
+	detectors, err := parseDetectors(*detect)
+	if err != nil {
+		return fmt.Errorf("-detect: %v", err)
+	}
+	var episodeVars map[string]map[string]string
+	if *varsFile != "" {
+		episodeVars, err = readEpisodeVars(*varsFile)
+		if err != nil {
+			return fmt.Errorf("%s: %v", *varsFile, err)
+		}
+	}
+	episodes, err := listEpisodes(fs.Args())
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(*outDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// the options given to apply-template are passed on to
+	// each edit, along with the episode's files
+	var editArgs []string
+	fs.Visit(func(f *flag.Flag) {
+		if edits.Lookup(f.Name) != nil {
+			editArgs = append(editArgs, "-"+f.Name+"="+f.Value.String())
+		}
+	})
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	var entries []manifestEntry
+	for _, episode := range episodes {
+		vars, err := e.episodeVariables(episode, detectors, episodeVars)
+		if err == nil {
+			err = applyTemplate(exe, editArgs, template, episode, *outDir, vars)
+		}
+		if err != nil {
+			log.Printf("%s: %v", episode, err)
+			failed++
+		}
+		if *manifest != "" {
+			out, filterFile := episodeFiles(episode, *outDir)
+			entries = append(entries, newManifestEntry(episode, filterFile, out, editArgs, err))
+		}
+	}
+	if *manifest != "" {
+		err := writeManifest(*manifest, entries)
+		if err != nil {
+			return fmt.Errorf("writing manifest: %v", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d episodes failed", failed, len(episodes))
+	}
+	return nil
+}
+
+// applyTemplate fills in the template with vars, writes it to the
+// output directory as the episode's filter file, and runs vidagent
+// to apply it to the episode.
+func applyTemplate(exe string, editArgs []string, template []byte, episode, outDir string, vars map[string]string) error {
+	filled, err := fillTemplate(template, vars)
+	if err != nil {
+		return err
+	}
+	out, filterFile := episodeFiles(episode, outDir)
+	if same, _ := samePath(out, episode); same {
+		return fmt.Errorf("output would overwrite the episode; use another -out-dir")
+	}
+	err = os.WriteFile(filterFile, filled, 0644)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("applying %s to %s", filterFile, episode)
+	args := append(slices.Clone(editArgs), "-in", episode, "-out", out, "-filter", filterFile)
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// episodeFiles returns the names of the output and filter file
+// of the episode in the output directory.
+func episodeFiles(episode, outDir string) (string, string) {
+	out := filepath.Join(outDir, filepath.Base(episode))
+	return out, strings.TrimSuffix(out, filepath.Ext(out)) + ".filter"
+}
+
+func samePath(a, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, err
+	}
+	return absA == absB, nil
+}
+
+// fillTemplate returns the template with the values of its @def
+// directives changed to those of vars. Each of vars must be defined
+// in the template; only the first definition of each is changed.
+func fillTemplate(template []byte, vars map[string]string) ([]byte, error) {
+	tree, err := parseSyntax(bytes.NewReader(template), nil)
+	if err != nil {
+		return nil, fmt.Errorf("template: %v", err)
+	}
+	var values []*node // to change, in order
+	var newValues []string
+	filled := make(map[string]bool)
+	for _, line := range tree.lines {
+		d := line.directive
+		if d == nil || !strings.EqualFold(d.name.text, "@def") {
+			continue
+		}
+		name, _, err := definition(d)
+		if err != nil {
+			return nil, fmt.Errorf("template: %v", err)
+		}
+		value, ok := vars[name]
+		if !ok || filled[name] {
+			continue
+		}
+		values = append(values, d.args[1])
+		newValues = append(newValues, value)
+		filled[name] = true
+	}
+	for name, value := range vars {
+		if !filled[name] {
+			return nil, fmt.Errorf("the template doesn't define $%s with @def", name)
+		}
+		if !isChapterTime(value) && !isTimecode(value) {
+			if _, err := ParseTime(value); err != nil {
+				return nil, fmt.Errorf("$%s: %v", name, err)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	offset := 0
+	for i, value := range values {
+		buf.Write(template[offset:value.start.offset])
+		buf.WriteString(newValues[i])
+		offset = value.end.offset
+	}
+	buf.Write(template[offset:])
+	return buf.Bytes(), nil
+}
+
+// detector finds the value of a variable in each episode: the time of
+// the first transition of its kind between start and end seconds.
+type detector struct {
+	name       string
+	kind       string
+	start, end float64
+}
+
+// detectWindow is how far into each episode detectors look, in
+// seconds, unless they say otherwise.
+const detectWindow = 10 * 60
+
+// parseDetectors parses the comma-separated list of -detect,
+// like "intro_end=black@0-5:00,recap_end=scene".
+func parseDetectors(s string) ([]detector, error) {
+	var detectors []detector
+	for _, item := range splitList(s) {
+		name, kind, ok := strings.Cut(item, "=")
+		if !ok || !variableName.MatchString(name) {
+			return nil, fmt.Errorf("bad detector '%s'; must be like name=kind or name=kind@start-end", item)
+		}
+		d := detector{name: name, end: detectWindow}
+		kind, window, hasWindow := strings.Cut(kind, "@")
+		if _, ok := snapKinds[kind]; !ok {
+			return nil, fmt.Errorf("unknown kind '%s'; must be scene, black, or silence", kind)
+		}
+		d.kind = kind
+		if hasWindow {
+			startStr, endStr, _ := strings.Cut(window, "-")
+			start, err := ParseTime(startStr)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad start time: %v", name, err)
+			}
+			end, err := ParseTime(endStr)
+			if err != nil {
+				return nil, fmt.Errorf("%s: bad end time: %v", name, err)
+			}
+			if end.SecondNum() <= start.SecondNum() {
+				return nil, fmt.Errorf("%s: end time %s isn't after start time %s", name, end, start)
+			}
+			d.start, d.end = start.SecondNum(), end.SecondNum()
+		}
+		detectors = append(detectors, d)
+	}
+	return detectors, nil
+}
+
+// episodeVariables returns the values of the variables for the
+// episode: those that are detected in it, except where its row of
+// the CSV file (if any) has a value instead.
+func (e *edit) episodeVariables(episode string, detectors []detector, episodeVars map[string]map[string]string) (map[string]string, error) {
+	vars := make(map[string]string)
+	row, hasRow := episodeVars[filepath.Base(episode)]
+	if episodeVars != nil && !hasRow {
+		return nil, errors.New("no row for the episode in -vars")
+	}
+
+	e.opts.inputFile = episode // what transitions reads
+	for _, d := range detectors {
+		if row[d.name] != "" {
+			continue
+		}
+		points, err := e.transitions([]string{d.kind}, d.start, d.end)
+		if err != nil {
+			return nil, err
+		}
+		if len(points) == 0 {
+			return nil, fmt.Errorf("$%s: no %s transition between %s and %s",
+				d.name, d.kind, secondsTime(d.start), secondsTime(d.end))
+		}
+		first := math.Inf(1)
+		for _, p := range points {
+			first = math.Min(first, p.time)
+		}
+		vars[d.name] = strconv.FormatFloat(first, 'f', 3, 64)
+		log.Printf("%s: detected $%s = %s", episode, d.name, vars[d.name])
+	}
+	for name, value := range row {
+		if value != "" {
+			vars[name] = value
+		}
+	}
+	return vars, nil
+}
+
+// readEpisodeVars reads a CSV file of the variables for each
+// episode, keyed by the episode's file name (without its directory).
+func readEpisodeVars(filename string) (map[string]map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, errors.New("no header row")
+	}
+	header := records[0]
+	fileCol := slices.Index(header, "file")
+	if fileCol < 0 {
+		return nil, errors.New(`no "file" column`)
+	}
+	for i, name := range header {
+		if i != fileCol && !variableName.MatchString(name) {
+			return nil, fmt.Errorf("bad variable name '%s' in header", name)
+		}
+	}
+	episodes := make(map[string]map[string]string)
+	for _, record := range records[1:] {
+		vars := make(map[string]string)
+		for i, value := range record {
+			if i != fileCol {
+				vars[header[i]] = strings.TrimSpace(value)
+			}
+		}
+		episodes[filepath.Base(record[fileCol])] = vars
+	}
+	return episodes, nil
+}
+
+// listEpisodes returns the episode files named by args, which are
+// files or directories of them, in order.
+func listEpisodes(args []string) ([]string, error) {
+	var episodes []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			episodes = append(episodes, arg)
+			continue
+		}
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && slices.Contains(videoExts, strings.ToLower(filepath.Ext(entry.Name()))) {
+				episodes = append(episodes, filepath.Join(arg, entry.Name()))
+			}
+		}
+	}
+	return episodes, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+)
+
+// Times in a filter file can also be SMPTE timecodes, which count
+// frames instead of fractions of seconds: "01:02:03:04" is frame 4
+// of 1:02:03, and with a ; before the frames, like "01:02:03;04",
+// the timecode is drop-frame, as NTSC broadcasts (at 29.97 or 59.94
+// fps) usually are. Timecodes are converted to times with the frame
+// rate of the input's video, so they need -in. If the input has a
+// timecode of its own, timecodes are relative to it, as an edit list
+// made from a broadcast would be.
+
+// timecodePattern matches a timecode, with a semicolon before the
+// frames (or for all its separators) if it's drop-frame.
+var timecodePattern = regexp.MustCompile(`^(\d{1,2})[:;](\d{2})[:;](\d{2})([:;])(\d{2})$`)
+
+// isTimecode returns true if s is a SMPTE timecode.
+func isTimecode(s string) bool {
+	return timecodePattern.MatchString(s)
+}
+
+// parseTimecode returns the time in the input of the timecode s.
+func (e *edit) parseTimecode(s string) (Time, error) {
+	rate, start, err := e.inputTimecodeRate()
+	if err != nil {
+		return Time{}, err
+	}
+	frame, err := timecodeFrame(s, rate)
+	if err != nil {
+		return Time{}, err
+	}
+	if frame < start {
+		return Time{}, fmt.Errorf("timecode %s is before the input's first timecode", s)
+	}
+	return secondsTime(float64(frame-start) / rate), nil
+}
+
+// timecodeFrame returns the number of the frame of the timecode s in
+// video with the frame rate, counting from 00:00:00:00.
+func timecodeFrame(s string, rate float64) (int, error) {
+	m := timecodePattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, fmt.Errorf("bad timecode '%s'", s)
+	}
+	hours, _ := strconv.Atoi(m[1])
+	mins, _ := strconv.Atoi(m[2])
+	secs, _ := strconv.Atoi(m[3])
+	frames, _ := strconv.Atoi(m[5])
+	dropFrame := m[4] == ";"
+
+	// timecodes count whole frames per second: with 29.97 fps, 30
+	nominal := int(math.Round(rate))
+	if mins > 59 || secs > 59 || frames >= nominal {
+		return 0, fmt.Errorf("timecode '%s' is out of range for %s fps", s, formatRate(rate))
+	}
+	totalMins := hours*60 + mins
+	frame := (totalMins*60+secs)*nominal + frames
+	if !dropFrame {
+		return frame, nil
+	}
+
+	// drop-frame timecode skips the first frame numbers of each
+	// minute, except every tenth minute, so that it keeps up with
+	// the clock: 2 at 29.97 fps, and 4 at 59.94
+	if nominal%30 != 0 || math.Abs(rate-float64(nominal)*1000/1001) > .01 {
+		return 0, fmt.Errorf("timecode '%s' is drop-frame, but the video is %s fps, not 29.97 or 59.94", s, formatRate(rate))
+	}
+	drop := nominal / 15
+	if secs == 0 && frames < drop && mins%10 != 0 {
+		return 0, fmt.Errorf("timecode '%s' doesn't exist in drop-frame timecode, which skips it", s)
+	}
+	return frame - drop*(totalMins-totalMins/10), nil
+}
+
+// inputTimecodeRate returns timecodeRate and startFrame, probing them
+// if needed.
+func (e *edit) inputTimecodeRate() (float64, int, error) {
+	if e.timecodeRateRead {
+		return e.timecodeRate, e.startFrame, nil
+	}
+	if e.opts.inputFile == "" {
+		return 0, 0, fmt.Errorf("timecodes need -in, to find its frame rate")
+	}
+	info, err := probe(e.opts.inputFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	video := info.stream("video")
+	if video == nil {
+		return 0, 0, fmt.Errorf("timecodes need video, to count its frames")
+	}
+	// (the base rate, since timecodes count frames at the nominal
+	// rate, even in video with a variable frame rate)
+	rate := parseRate(video.RFrameRate)
+	if rate == 0 {
+		rate = parseRate(video.AvgFrameRate)
+	}
+	if rate == 0 {
+		return 0, 0, fmt.Errorf("timecodes need the video's frame rate, which ffprobe doesn't know")
+	}
+	var start int
+	tc := video.Tags["timecode"]
+	if tc == "" {
+		tc = info.Format.Tags["timecode"]
+	}
+	if tc != "" {
+		start, err = timecodeFrame(tc, rate)
+		if err != nil {
+			return 0, 0, fmt.Errorf("the input's timecode: %v", err)
+		}
+	}
+	e.timecodeRate, e.startFrame, e.timecodeRateRead = rate, start, true
+	return rate, start, nil
+}
+
+// formatRate formats a frame rate, like 29.97.
+func formatRate(rate float64) string {
+	return strconv.FormatFloat(math.Round(rate*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,98 @@
+package main
+
+import "math"
+
+// timeMap maps times in the input to times in the output of edits,
+// and back, for anything that has to follow the edits: subtitles,
+// chapters, markers, and checks of the output. Only cuts change the
+// timing of the output; what's muted or has effects keeps its place.
+type timeMap struct {
+	spans []span
+	// starts are the times in the output where the spans start
+	starts []float64
+	// inputDuration is how long the input is, for the open span
+	// at the end, or 0 if it isn't known
+	inputDuration float64
+}
+
+// newTimeMap returns the time map of the output of the actions.
+func (e *edit) newTimeMap(actions []action) timeMap {
+	var edits []action
+	for _, act := range actions {
+		if act.verb == CutVerb || act.verb == MuteVerb {
+			edits = append(edits, act)
+		}
+	}
+	return spansTimeMap(e.outputSpans(edits), e.inputInfo.Format.duration())
+}
+
+// spansTimeMap returns the time map of the output made of the spans
+// of an input that's inputDuration seconds long.
+func spansTimeMap(spans []span, inputDuration float64) timeMap {
+	m := timeMap{spans: spans, inputDuration: inputDuration}
+	var pos float64
+	for _, sp := range spans {
+		m.starts = append(m.starts, pos)
+		pos += sp.seconds(m.inputDuration)
+	}
+	return m
+}
+
+// toOutput returns the time in the output of the time t in the input,
+// and whether it's kept. For a time that was cut, it returns where
+// the output continues after the cut.
+func (m timeMap) toOutput(t float64) (float64, bool) {
+	var kept bool
+	for _, sp := range m.spans {
+		if t >= sp.start.SecondNum() && (sp.open || t < sp.end.SecondNum()) {
+			kept = true
+		}
+	}
+	return m.keptBefore(t), kept
+}
+
+// keptBefore returns how much of the input before the time t in it
+// is kept in the output.
+func (m timeMap) keptBefore(t float64) float64 {
+	var kept float64
+	for _, sp := range m.spans {
+		into := math.Max(t-sp.start.SecondNum(), 0)
+		if !sp.open {
+			into = math.Min(into, sp.seconds(m.inputDuration))
+		}
+		kept += into
+	}
+	return kept
+}
+
+// toInput returns the time in the input of the time t in the output.
+// Times past the end of the output are past the end of the last span.
+func (m timeMap) toInput(t float64) float64 {
+	if len(m.spans) == 0 {
+		return t
+	}
+	last := len(m.spans) - 1
+	for i, sp := range m.spans[:last] {
+		if t < m.starts[i]+sp.seconds(m.inputDuration) {
+			return sp.start.SecondNum() + math.Max(t-m.starts[i], 0)
+		}
+	}
+	return m.spans[last].start.SecondNum() + math.Max(t-m.starts[last], 0)
+}
+
+// rangeToOutput returns the range of the output of the range of the
+// input from start to end, and whether any of it is kept.
+func (m timeMap) rangeToOutput(start, end float64) (float64, float64, bool) {
+	outStart, outEnd := m.keptBefore(start), m.keptBefore(end)
+	return outStart, outEnd, outEnd-outStart >= .001
+}
+
+// duration returns how long the output is, or 0 if the last span is
+// open and the length of the input isn't known.
+func (m timeMap) duration() float64 {
+	if len(m.spans) == 0 {
+		return 0
+	}
+	last := len(m.spans) - 1
+	return m.starts[last] + m.spans[last].seconds(m.inputDuration)
+}
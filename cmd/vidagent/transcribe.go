@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// transcript is the part of Whisper's JSON output that's needed:
+// the recognized words and when they were spoken. This is what
+// the openai-whisper command writes with --word_timestamps True.
+type transcript struct {
+	Segments []struct {
+		Words []transcriptWord `json:"words"`
+	} `json:"segments"`
+}
+
+type transcriptWord struct {
+	Word  string  `json:"word"`
+	Start float64 `json:"start"` // seconds
+	End   float64 `json:"end"`   // seconds
+}
+
+// wordRule is an entry of a word list: a word or phrase to mute,
+// and the reason to give. A word ending in * matches any word
+// that starts with the rest of it.
+type wordRule struct {
+	words  []string
+	reason string
+}
+
+// transcribeScanCmd finds the words in a word list in the speech of
+// a video, using Whisper to transcribe it, and prints mute actions
+// for them, to be reviewed and added to a filter file.
+func transcribeScanCmd(args []string) error {
+	fs := flag.NewFlagSet("transcribe-scan", flag.ExitOnError)
+	in := fs.String("in", "", "the video to scan")
+	wordsFile := fs.String("words", "", "the word list: one word or phrase per line, optionally followed by a reason in parentheses")
+	whisper := fs.String("whisper", "whisper", "the Whisper command to run")
+	model := fs.String("model", "small", "the Whisper model to use")
+	language := fs.String("language", "", "the language spoken, so Whisper doesn't have to detect it")
+	transcriptFile := fs.String("transcript", "", "use this Whisper JSON transcript instead of transcribing the video")
+	keep := fs.String("keep-transcript", "", "save the transcript to this file, to scan it again with different words")
+	pad := fs.Duration("pad", 100*time.Millisecond, "mute this much extra before and after each word")
+	out := fs.String("out", "", "write the actions to this file instead of standard output")
+	fs.Parse(args)
+
+	if *wordsFile == "" || (*in == "" && *transcriptFile == "") {
+		return fmt.Errorf("-words and either -in or -transcript are required")
+	}
+	rules, err := readWordList(*wordsFile)
+	if err != nil {
+		return err
+	}
+
+	name := *transcriptFile
+	if name == "" {
+		dir, err := makeTempDir("", "vidagent-transcribe-")
+		if err != nil {
+			return err
+		}
+		defer removeTempDir(dir)
+		name, err = transcribe(*in, dir, *whisper, *model, *language)
+		if err != nil {
+			return err
+		}
+		if *keep != "" {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return err
+			}
+			err = os.WriteFile(*keep, data, 0644)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	words, err := readTranscript(name)
+	if err != nil {
+		return err
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	n := writeMatches(w, words, rules, pad.Seconds())
+	log.Printf("wrote %d mute actions for %d words; review them before using", n, len(words))
+	return nil
+}
+
+// transcribe extracts the video's audio into dir and runs Whisper on
+// it, returning the name of the JSON transcript that it writes.
+func transcribe(video, dir, whisper, model, language string) (string, error) {
+	audio := filepath.Join(dir, "audio.wav")
+	// Whisper works on 16 kHz mono audio
+	_, err := ffmpegOutput("-i", fileArg(video), "-map", "0:a:0", "-ac", "1", "-ar", "16000", audio)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{audio,
+		"--model", model,
+		"--word_timestamps", "True",
+		"--output_format", "json",
+		"--output_dir", dir,
+	}
+	if language != "" {
+		args = append(args, "--language", language)
+	}
+	log.Printf("transcribing %s; this may take a while", video)
+	cmd := exec.Command(whisper, args...)
+	cmd.Stdout = io.Discard
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("running %s: %v", whisper, err)
+	}
+	return filepath.Join(dir, "audio.json"), nil
+}
+
+// readTranscript returns the words in a Whisper JSON transcript.
+func readTranscript(name string) ([]transcriptWord, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var t transcript
+	err = json.Unmarshal(data, &t)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	var words []transcriptWord
+	for _, seg := range t.Segments {
+		words = append(words, seg.Words...)
+	}
+	if len(words) == 0 {
+		return nil, fmt.Errorf("%s has no word timestamps; transcribe with --word_timestamps True", name)
+	}
+	return words, nil
+}
+
+// readWordList reads a word list file. Blank lines and
+// anything after # are ignored.
+func readWordList(name string) ([]wordRule, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var rules []wordRule
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		phrase, reason, hasReason := strings.Cut(line, "(")
+		if hasReason {
+			var ok bool
+			reason, _, ok = strings.Cut(reason, ")")
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: reason isn't closed with )", name, lineNum)
+			}
+			if _, err := ParseReason(reason); err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", name, lineNum, err)
+			}
+		} else {
+			reason = "language"
+		}
+		words := strings.Fields(normalizeWord(phrase))
+		if len(words) == 0 {
+			continue
+		}
+		rules = append(rules, wordRule{words: words, reason: strings.TrimSpace(reason)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("%s has no words", name)
+	}
+	return rules, nil
+}
+
+// normalizeWord lowercases s and removes punctuation (except for
+// the * wildcard), so that transcribed words match the list
+// regardless of capitalization and surrounding punctuation.
+func normalizeWord(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsSpace(r) || r == '*':
+			return unicode.ToLower(r)
+		case r == '-':
+			return ' '
+		}
+		return -1
+	}, s)
+}
+
+// matches returns how many transcribed words, starting at
+// words[0], the rule matches, or 0 if it doesn't match.
+func (r wordRule) matches(words []string) int {
+	if len(words) < len(r.words) {
+		return 0
+	}
+	for i, want := range r.words {
+		if prefix, ok := strings.CutSuffix(want, "*"); ok {
+			if !strings.HasPrefix(words[i], prefix) {
+				return 0
+			}
+		} else if words[i] != want {
+			return 0
+		}
+	}
+	return len(r.words)
+}
+
+// writeMatches writes a mute action for each place in the transcript
+// that matches a rule, padded by pad seconds on each side and
+// combined with the one before if they'd overlap, and returns
+// how many actions it wrote.
+func writeMatches(w io.Writer, words []transcriptWord, rules []wordRule, pad float64) int {
+	// transcribed words may have punctuation, or be more than one
+	// word (like "well-known"), so split them into normalized words,
+	// each with the timing of the word it came from
+	var norm []string
+	var timing []transcriptWord
+	for _, word := range words {
+		for _, field := range strings.Fields(normalizeWord(word.Word)) {
+			norm = append(norm, field)
+			timing = append(timing, word)
+		}
+	}
+
+	type match struct {
+		start, end   float64
+		reason, text string
+	}
+	var matches []match
+	for i := 0; i < len(norm); i++ {
+		for _, rule := range rules {
+			n := rule.matches(norm[i:])
+			if n == 0 {
+				continue
+			}
+			m := match{
+				start:  max(timing[i].Start-pad, 0),
+				end:    timing[i+n-1].End + pad,
+				reason: rule.reason,
+				text:   strings.Join(norm[i:i+n], " "),
+			}
+			if last := len(matches) - 1; last >= 0 && m.start-matches[last].end < .001 {
+				matches[last].end = max(matches[last].end, m.end)
+				matches[last].text += ", " + m.text
+			} else {
+				matches = append(matches, m)
+			}
+			i += n - 1
+			break
+		}
+	}
+
+	for _, m := range matches {
+		fmt.Fprintf(w, "mute %s-%s (%s) # %s\n", clockString(m.start), clockString(m.end), m.reason, m.text)
+	}
+	return len(matches)
+}
+
+// clockString formats seconds as a time for a filter file,
+// like 1:02:03.40.
+func clockString(sec float64) string {
+	t := secondsTime(math.Round(sec*100) / 100)
+	return fmt.Sprintf("%d:%02d:%05.2f", t.Hour, t.Minute, t.Second)
+}
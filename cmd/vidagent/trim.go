@@ -0,0 +1,48 @@
+package main
+
+import "strings"
+
+// trimmedSpan returns the one span of the input that the edits keep,
+// if all they do is cut its head or tail (or both), or false. Effects
+// are left to the engines, since seeking would change their times.
+func (e *edit) trimmedSpan(edits []action) (span, bool) {
+	if len(edits) == 0 || e.hasEffects() {
+		return span{}, false
+	}
+	for _, act := range edits {
+		if act.verb != CutVerb {
+			return span{}, false
+		}
+	}
+	spans := e.outputSpans(edits)
+	if len(spans) != 1 {
+		return span{}, false
+	}
+	return spans[0], true
+}
+
+// runTrim keeps only the span of the input with a simple seek, which
+// is faster than an engine splicing spans together when there's only
+// one of them.
+func (e *edit) runTrim(sp span, edits []action) error {
+	args := []string{
+		e.overwriteArg(),
+	}
+	args = append(args, e.spanInputArgs(sp)...)
+	args = append(args, "-map", "0:v:0", "-map", e.audioSpec(0))
+	args = append(args, e.metadataArgs(edits, 0, 2)...)
+	if e.opts.streamCopy {
+		args = append(args, "-c", "copy", "-avoid_negative_ts", "make_zero")
+	} else {
+		if filters := e.videoFilters(); len(filters) > 0 {
+			args = append(args, "-vf", strings.Join(filters, ","))
+		}
+		args = append(args, e.videoEncodeArgs()...)
+		if audioFilters := append(e.audioInputFilters(), e.audioOutputFilters()...); len(audioFilters) > 0 {
+			args = append(args, "-af", strings.Join(audioFilters, ","))
+		}
+	}
+	args = append(args, e.hlsArgs()...)
+	args = append(args, fileArg(e.outputPath()))
+	return e.runFFmpeg(args, e.outputPath())
+}
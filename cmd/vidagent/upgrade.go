@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// filterUpgrades change lines of filter files from how they could be
+// written for older versions of vidagent to how they're written now,
+// in order. Each returns the line changed and what it did, or the line
+// as it is and "" if it doesn't apply. They're only given lines that
+// don't parse now, so they can't change what a line that's already
+// right means.
+var filterUpgrades = []func(line string) (string, string){
+	closeReason,
+	commentTrailingText,
+}
+
+// upgradeFilterCmd rewrites filter files written for older versions
+// of vidagent the way they're written now, keeping their comments.
+func upgradeFilterCmd(args []string) error {
+	fs := flag.NewFlagSet("upgrade-filter", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the upgraded filter files in place instead of to standard output")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent upgrade-filter [-w] <filter files...>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("at least one filter file required")
+	}
+	if !*write && fs.NArg() > 1 {
+		return fmt.Errorf("upgrading several filter files requires -w")
+	}
+
+	var failed int
+	for _, filename := range fs.Args() {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		upgraded, changes := upgradeFilter(data)
+		for _, change := range changes {
+			log.Printf("%s:%s", filename, change)
+		}
+		if _, err := parseSyntax(bytes.NewReader(upgraded), nil); err != nil {
+			log.Printf("%s: still doesn't parse: %v", filename, err)
+			failed++
+		}
+		if !*write {
+			_, err = os.Stdout.Write(upgraded)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(filename, upgraded, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of the filter files need fixing by hand", failed)
+	}
+	return nil
+}
+
+// upgradeFilter applies the upgrades to the lines of the filter file
+// that don't parse, and returns it changed along with what was done
+// to which lines.
+func upgradeFilter(data []byte) ([]byte, []string) {
+	var out bytes.Buffer
+	var changes []string
+	for _, s := range splitLines(data) {
+		text := s.text
+		for _, upgrade := range filterUpgrades {
+			if lineParses(text) {
+				break
+			}
+			var change string
+			text, change = upgrade(text)
+			if change != "" {
+				changes = append(changes, fmt.Sprintf("%d: %s", s.pos.line, change))
+			}
+		}
+		out.WriteString(text)
+		// with the line ending it had, if any
+		rest := data[s.pos.offset+len(s.text):]
+		if end := bytes.IndexByte(rest, '\n'); end >= 0 {
+			out.Write(rest[:end+1])
+		}
+	}
+	return out.Bytes(), changes
+}
+
+func lineParses(line string) bool {
+	s := &lineScanner{text: line}
+	_, err := s.parseLine()
+	return err == nil
+}
+
+// closeReason closes reasons that aren't closed. Versions before 0.3
+// ended reasons at the end of the line, or at a comment, if there was
+// no ).
+func closeReason(line string) (string, string) {
+	open := strings.IndexByte(line, '(')
+	if open < 0 || strings.Contains(line[:open], "#") {
+		return line, ""
+	}
+	depth := 0
+	for i := open; i < len(line); i++ {
+		switch line[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case '#':
+			if depth > 0 {
+				code := strings.TrimRight(line[:i], " \t")
+				return code + strings.Repeat(")", depth) + line[len(code):], "closed the reason with )"
+			}
+		}
+		if depth == 0 {
+			return line, ""
+		}
+	}
+	return strings.TrimRight(line, " \t") + strings.Repeat(")", depth), "closed the reason with )"
+}
+
+// commentTrailingText makes the text after an action (other than its
+// parameters) part of its comment. Versions before 0.3 ignored it.
+func commentTrailingText(line string) (string, string) {
+	s := &lineScanner{text: line}
+	s.skipSpace()
+	if ch := s.peek(); ch == 0 || ch == '@' || ch == '#' {
+		return line, ""
+	}
+	act, err := s.parseAction()
+	if err != nil {
+		return line, ""
+	}
+	code := line[:act.end.offset]
+	var extra []string
+	for s.skipSpace(); s.i < len(line); s.skipSpace() {
+		switch ch := s.peek(); {
+		case ch == '#':
+			extra = append(extra, strings.TrimSpace(line[s.i+1:]))
+			s.i = len(line)
+		case ch == '[' && act.params == nil:
+			params, err := s.group('[', ']')
+			if err != nil {
+				return line, ""
+			}
+			act.params = params
+			code += " " + params.text
+		default:
+			// up to the next thing that was parsed
+			begin := s.i
+			for s.i < len(line) && s.peek() != '#' && (s.peek() != '[' || act.params != nil) {
+				s.next()
+			}
+			extra = append(extra, strings.TrimSpace(line[begin:s.i]))
+		}
+	}
+	return code + " # " + strings.Join(extra, "; "), "made the text after the action a comment"
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// "@def recap_end 2:35" defines a variable that the lines after it
+// can use for a time, like "cut 0:00-$recap_end (recap)". The filter
+// file for one episode of a series can then be a template for the
+// others, with only the definitions changed. A variable can be
+// defined again, for the lines after that.
+
+var variableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// definition returns the name and value of the variable
+// that the @def directive d defines.
+func definition(d *directiveNode) (string, string, error) {
+	if len(d.args) != 2 {
+		return "", "", fmt.Errorf("line %d: directive %s needs a name and a time", d.start.line, d.name.text)
+	}
+	name, value := d.args[0], d.args[1]
+	if !variableName.MatchString(name.text) {
+		return "", "", fmt.Errorf("line %d:%d: bad variable name '%s'; names are letters, digits, and _",
+			name.start.line, name.start.col, name.text)
+	}
+	// (times relative to chapters and timecodes are checked where
+	// they're used, since that needs the input's chapters or frame
+	// rate)
+	if !isChapterTime(value.text) && !isTimecode(value.text) {
+		if _, err := ParseTime(value.text); err != nil {
+			return "", "", fmt.Errorf("line %d:%d: %v", value.start.line, value.start.col, err)
+		}
+	}
+	return name.text, value.text, nil
+}
+
+// parseActionTime parses a time of an action, which may be
+// a variable defined with @def; see parseChapterTime.
+func (e *edit) parseActionTime(text string, vars map[string]string) (Time, int, error) {
+	if name, ok := strings.CutPrefix(text, "$"); ok {
+		value, ok := vars[name]
+		if !ok {
+			return Time{}, 0, fmt.Errorf("variable $%s isn't defined with @def before it's used", name)
+		}
+		text = value
+	}
+	return e.parseChapterTime(text)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"text/tabwriter"
+)
+
+const (
+	// verifyMargin is how far, in seconds, verify keeps its samples
+	// from the edges of spans, where encoders and the rounding of
+	// times to frames make small differences that don't matter.
+	verifyMargin = 0.1
+
+	// verifyOffset is how far after (or before) a cut, in seconds,
+	// verify compares the frame of the output with the input's.
+	verifyOffset = 0.5
+
+	// silenceLevel is the loudest a muted span can be, in dBFS, to
+	// be silent: lossy audio codecs may leave a little noise.
+	silenceLevel = -50.0
+
+	// verifyDurationTolerance is how much, in seconds, the length of
+	// the output can differ from what the edits should leave.
+	verifyDurationTolerance = 0.5
+)
+
+// verifyCmd audits an edited file against the input and the filter
+// file it was made with: that it's as long as the edits should leave
+// it, that muted spans are silent, and that what was cut is gone, by
+// comparing small frames of the output where each cut was with the
+// input's frames on both sides of the cut.
+func verifyCmd(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	o := defaultOptions()
+	editFlags(o).VisitAll(func(f *flag.Flag) {
+		fs.Var(f.Value, f.Name, f.Usage)
+	})
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent verify -in <original> -filter <file> -out <edited file> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	e := newEdit(o)
+	if err := e.inlineFilter(); err != nil {
+		return err
+	}
+
+	if e.opts.inputFile == "" || e.opts.filterFile == "" || e.opts.outputFile == "" {
+		fs.Usage()
+		return errors.New("input, filter, and edited files required (use -in, -filter, and -out)")
+	}
+	if e.opts.policyFile != "" {
+		err := e.loadPolicy(e.opts.policyFile)
+		if err != nil {
+			return err
+		}
+	}
+	scale, err := parseScale(e.opts.timeScale)
+	if err != nil {
+		return fmt.Errorf("-time-scale: %v", err)
+	}
+	offset, err := parseOffset(e.opts.timeOffset)
+	if err != nil {
+		return fmt.Errorf("-offset: %v", err)
+	}
+	actions, err := e.readShiftedFilterFile(e.opts.filterFile, scale, offset)
+	if err != nil {
+		return err
+	}
+	actions, err = e.selectActions(withoutVerb(actions, NoteVerb, ExtractVerb, ChapterBreakVerb))
+	if err != nil {
+		return err
+	}
+	removeImport, err := e.importTempInput()
+	if err != nil {
+		return err
+	}
+	defer removeImport()
+	e.inputInfo, err = probe(e.opts.inputFile)
+	if err != nil {
+		return err
+	}
+	edited, err := probe(e.opts.outputFile)
+	if err != nil {
+		return err
+	}
+
+	var edits []action
+	for _, act := range actions {
+		if !isEffect(act.verb) {
+			edits = append(edits, act)
+		}
+	}
+	times := spansTimeMap(e.outputSpans(edits), e.inputInfo.Format.duration())
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "line\taction\tresult\t")
+	var checked, failed int
+	report := func(what, result string, ok bool) {
+		fmt.Fprintf(tw, "%s\t%s\t\n", what, result)
+		checked++
+		if !ok {
+			failed++
+		}
+	}
+
+	want, got := times.duration(), edited.Format.duration()
+	if math.Abs(want-got) <= verifyDurationTolerance {
+		report("\tduration", fmt.Sprintf("ok: %s, as expected", clockString(got)), true)
+	} else {
+		report("\tduration", fmt.Sprintf("FAILED: %s, but expected %s", clockString(got), clockString(want)), false)
+	}
+
+	for _, act := range actions {
+		what := fmt.Sprintf("%d\t%s %s-%s", act.line(), act.verb, clockString(act.start.SecondNum()), clockString(act.end.SecondNum()))
+		if act.reason.Category != "" {
+			what += " (" + reasonString(act.reason) + ")"
+		}
+		switch {
+		case act.verb == MuteVerb && e.opts.muteFill != "":
+			fmt.Fprintf(tw, "%s\tnot checked, since it's filled with -mute-fill\t\n", what)
+		case act.verb == MuteVerb:
+			result, ok, err := e.verifyMute(act, times)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", act.line(), err)
+			}
+			report(what, result, ok)
+		case act.verb == CutVerb:
+			result, ok, err := e.verifyCut(act, times)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", act.line(), err)
+			}
+			report(what, result, ok)
+		default:
+			fmt.Fprintf(tw, "%s\tnot checked\t\n", what)
+		}
+	}
+	tw.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, checked)
+	}
+	return nil
+}
+
+// verifyMute returns whether the muted span of the output is silent,
+// and its loudness.
+func (e *edit) verifyMute(act action, times timeMap) (string, bool, error) {
+	start, _ := times.toOutput(act.start.SecondNum())
+	start += verifyMargin
+	dur := act.end.SecondNum() - act.start.SecondNum() - 2*verifyMargin
+	if dur <= 0 {
+		return "too short to check", true, nil
+	}
+	samples, err := decodeAudio(e.opts.outputFile, start, dur)
+	if err != nil {
+		return "", false, err
+	}
+	var peak float64
+	for _, s := range samples {
+		peak = math.Max(peak, math.Abs(s))
+	}
+	level := 20 * math.Log10(peak)
+	if level <= silenceLevel {
+		return fmt.Sprintf("ok: silent (peak %.0f dB)", math.Max(level, -99)), true, nil
+	}
+	return fmt.Sprintf("FAILED: not silent at %s (peak %.0f dB)", clockString(start), level), false, nil
+}
+
+// verifyCut returns whether the output where the cut was looks like
+// the input on the other side of the cut, not like what was cut.
+func (e *edit) verifyCut(act action, times timeMap) (string, bool, error) {
+	if e.inputInfo.stream("video") == nil {
+		return "not checked, since there's no video", true, nil
+	}
+	// compare just after the cut, or if there's too little of the
+	// output after it, just before
+	var kept float64
+	var found bool
+	for _, sp := range times.spans {
+		if math.Abs(sp.start.SecondNum()-act.end.SecondNum()) < .001 && sp.seconds(e.inputInfo.Format.duration()) > verifyOffset+verifyMargin {
+			kept, found = act.end.SecondNum()+verifyOffset, true
+			break
+		}
+		if math.Abs(sp.end.SecondNum()-act.start.SecondNum()) < .001 && !sp.open && sp.seconds(e.inputInfo.Format.duration()) > verifyOffset+verifyMargin {
+			kept, found = act.start.SecondNum()-verifyOffset, true
+		}
+	}
+	if !found {
+		return "not checked, since there's too little of the output around it", true, nil
+	}
+	at, _ := times.toOutput(kept)
+	mid := (act.start.SecondNum() + act.end.SecondNum()) / 2
+
+	out, err := smallFrame(e.opts.outputFile, at)
+	if err != nil {
+		return "", false, err
+	}
+	keptFrame, err := smallFrame(e.opts.inputFile, kept)
+	if err != nil {
+		return "", false, err
+	}
+	cutFrame, err := smallFrame(e.opts.inputFile, mid)
+	if err != nil {
+		return "", false, err
+	}
+	toKept, toCut := frameDifference(out, keptFrame), frameDifference(out, cutFrame)
+	if toKept < toCut {
+		return fmt.Sprintf("ok: the output at %s matches what's around the cut (difference %.1f, vs. %.1f from the cut)",
+			clockString(at), toKept, toCut), true, nil
+	}
+	return fmt.Sprintf("FAILED: the output at %s looks like what was cut (difference %.1f, vs. %.1f from what's around the cut)",
+		clockString(at), toCut, toKept), false, nil
+}
+
+// smallFrameWidth and smallFrameHeight are the size of the frames
+// that verify compares, which are small so that differences in
+// encoding (and scaling) don't matter, but differences in the
+// picture do.
+const smallFrameWidth, smallFrameHeight = 32, 18
+
+// smallFrame returns the frame of the file's video at the time, in
+// grayscale and scaled down to smallFrameWidth by smallFrameHeight.
+func smallFrame(file string, at float64) ([]byte, error) {
+	frame, err := ffmpegOutput(
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64), "-i", fileArg(file),
+		"-map", "0:v:0", "-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:%d,format=gray", smallFrameWidth, smallFrameHeight),
+		"-f", "rawvideo", "-")
+	if err != nil {
+		return nil, err
+	}
+	if len(frame) != smallFrameWidth*smallFrameHeight {
+		return nil, fmt.Errorf("no frame of %s at %s", file, clockString(at))
+	}
+	return frame, nil
+}
+
+// frameDifference returns the mean absolute difference of the
+// pixels of two frames, from 0 (the same) to 255.
+func frameDifference(a, b []byte) float64 {
+	var sum float64
+	for i := range a {
+		sum += math.Abs(float64(a[i]) - float64(b[i]))
+	}
+	return sum / float64(len(a))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// version is the version of vidagent.
+const version = "0.3.0"
+
+// A filter file can say which version of vidagent it needs with a
+// directive like "@vidagent >=0.3", so that older versions refuse
+// to apply it (saying to upgrade) instead of failing to parse newer
+// syntax or, worse, misreading it.
+
+// errUpgradeRequired is the error for filter files
+// that need a newer version of vidagent.
+var errUpgradeRequired = errors.New("upgrade required")
+
+// checkRequiredVersion returns an error if any @vidagent directive
+// in the lines needs a newer version than this one. It's checked
+// before the lines are parsed, so it only needs the directive lines
+// to have the same shape in every version.
+func checkRequiredVersion(lines []*lineScanner) error {
+	for _, line := range lines {
+		s := *line
+		s.skipSpace()
+		if s.peek() != '@' {
+			continue
+		}
+		d := s.parseDirective()
+		if !strings.EqualFold(d.name.text, "@vidagent") {
+			continue
+		}
+		var need string
+		for _, arg := range d.args {
+			need += arg.text
+		}
+		min, ok := strings.CutPrefix(need, ">=")
+		if !ok {
+			return fmt.Errorf("line %d:%d: @vidagent needs a version like >=%s", d.start.line, d.start.col, version)
+		}
+		newer, err := newerVersion(min, version)
+		if err != nil {
+			return fmt.Errorf("line %d:%d: @vidagent: %v", d.start.line, d.start.col, err)
+		}
+		if newer {
+			return fmt.Errorf("line %d: this filter file needs vidagent %s or newer, but this is %s: %w",
+				d.start.line, min, version, errUpgradeRequired)
+		}
+	}
+	return nil
+}
+
+// newerVersion returns true if version a is newer than b. Versions
+// are numbers separated by dots (like 0.3 or 1.2.10), and missing
+// numbers are 0.
+func newerVersion(a, b string) (bool, error) {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := range max(len(as), len(bs)) {
+		var x, y int
+		var err error
+		if i < len(as) {
+			x, err = strconv.Atoi(as[i])
+			if err != nil || x < 0 {
+				return false, fmt.Errorf("bad version '%s'", a)
+			}
+		}
+		if i < len(bs) {
+			y, err = strconv.Atoi(bs[i])
+			if err != nil || y < 0 {
+				return false, fmt.Errorf("bad version '%s'", b)
+			}
+		}
+		if x != y {
+			return x > y, nil
+		}
+	}
+	return false, nil
+}
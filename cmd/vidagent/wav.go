@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isWAV returns true if filename looks like a WAV file.
+func isWAV(filename string) bool {
+	return strings.EqualFold(filepath.Ext(filename), ".wav")
+}
+
+// editWAVFile applies actions to the WAV input file and writes
+// the result to the output file without using ffmpeg. Only
+// cut and mute are supported, and the output is always WAV
+// in the same sample format as the input.
+func (e *edit) editWAVFile(actions []action) error {
+	if !isWAV(e.opts.outputFile) {
+		return fmt.Errorf("output file must be WAV when ffmpeg is not available")
+	}
+	for _, act := range actions {
+		if act.verb != CutVerb && act.verb != MuteVerb {
+			return fmt.Errorf("line %d: verb '%s' requires ffmpeg",
+				act.line(), act.verb)
+		}
+	}
+
+	in, err := os.Open(e.opts.inputFile)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r := bufio.NewReader(in)
+	hdr, err := readWAVHeader(r)
+	if err != nil {
+		return fmt.Errorf("%s: %v", e.opts.inputFile, err)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if e.opts.overwrite {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	out, err := os.OpenFile(e.outputPath(), flags, 0644)
+	if os.IsExist(err) {
+		return fmt.Errorf("output file %s already exists (use -f to overwrite)", e.opts.outputFile)
+	}
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(out)
+	err = editWAV(w, r, hdr, actions)
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(e.outputPath())
+	}
+	return err
+}
+
+// wavHeader describes the parts of a WAV file needed to edit its samples.
+type wavHeader struct {
+	chunks        []byte // raw chunks that precede the data chunk, including fmt
+	formatTag     uint16
+	channels      uint16
+	sampleRate    uint32
+	blockAlign    uint16
+	bitsPerSample uint16
+	dataSize      uint32
+}
+
+const (
+	wavFormatPCM        = 0x0001
+	wavFormatFloat      = 0x0003
+	wavFormatExtensible = 0xFFFE
+)
+
+// readWAVHeader reads a RIFF/WAVE header from r, leaving r
+// positioned at the first byte of sample data.
+func readWAVHeader(r io.Reader) (wavHeader, error) {
+	var hdr wavHeader
+
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return hdr, fmt.Errorf("reading RIFF header: %v", err)
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return hdr, fmt.Errorf("not a RIFF/WAVE file")
+	}
+
+	var haveFmt bool
+	for {
+		var chunkHdr [8]byte
+		if _, err := io.ReadFull(r, chunkHdr[:]); err != nil {
+			return hdr, fmt.Errorf("reading chunk header: %v", err)
+		}
+		id := string(chunkHdr[0:4])
+		size := binary.LittleEndian.Uint32(chunkHdr[4:8])
+
+		if id == "data" {
+			if !haveFmt {
+				return hdr, fmt.Errorf("data chunk comes before fmt chunk")
+			}
+			hdr.dataSize = size
+			return hdr, nil
+		}
+
+		// chunks are padded to an even number of bytes
+		body := make([]byte, size+size%2)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return hdr, fmt.Errorf("reading %s chunk: %v", id, err)
+		}
+
+		if id == "fmt " {
+			if size < 16 {
+				return hdr, fmt.Errorf("fmt chunk too short")
+			}
+			hdr.formatTag = binary.LittleEndian.Uint16(body[0:2])
+			hdr.channels = binary.LittleEndian.Uint16(body[2:4])
+			hdr.sampleRate = binary.LittleEndian.Uint32(body[4:8])
+			hdr.blockAlign = binary.LittleEndian.Uint16(body[12:14])
+			hdr.bitsPerSample = binary.LittleEndian.Uint16(body[14:16])
+			if hdr.formatTag == wavFormatExtensible && size >= 26 {
+				// the first two bytes of the SubFormat GUID are the format code
+				hdr.formatTag = binary.LittleEndian.Uint16(body[24:26])
+			}
+			if hdr.formatTag != wavFormatPCM && hdr.formatTag != wavFormatFloat {
+				return hdr, fmt.Errorf("unsupported WAV format 0x%04x; only PCM and float are supported", hdr.formatTag)
+			}
+			if hdr.blockAlign == 0 || hdr.sampleRate == 0 {
+				return hdr, fmt.Errorf("invalid fmt chunk")
+			}
+			haveFmt = true
+		}
+
+		hdr.chunks = append(hdr.chunks, chunkHdr[:]...)
+		hdr.chunks = append(hdr.chunks, body...)
+	}
+}
+
+// silence returns one frame (one sample for every channel) of silence.
+func (h wavHeader) silence() []byte {
+	frame := make([]byte, h.blockAlign)
+	if h.formatTag == wavFormatPCM && h.bitsPerSample == 8 {
+		// 8-bit PCM is unsigned; the midpoint is silent
+		for i := range frame {
+			frame[i] = 0x80
+		}
+	}
+	return frame
+}
+
+// frames returns the total number of frames in the data chunk.
+func (h wavHeader) frames() int64 {
+	return int64(h.dataSize) / int64(h.blockAlign)
+}
+
+// frameAt returns the index of the frame at time t.
+func (h wavHeader) frameAt(t Time) int64 {
+	frame := int64(math.Round(t.SecondNum() * float64(h.sampleRate)))
+	if frame > h.frames() {
+		frame = h.frames()
+	}
+	return frame
+}
+
+// editWAV writes a WAV file to w consisting of the sample data from
+// r (which must be positioned at the start of the data chunk described
+// by hdr) with actions applied. Actions must be sorted and must not
+// overlap, as ensured by validateSegmentTimes.
+func editWAV(w io.Writer, r io.Reader, hdr wavHeader, actions []action) error {
+	blockAlign := int64(hdr.blockAlign)
+
+	// compute final data size so the header can be written up front
+	outFrames := hdr.frames()
+	for _, act := range actions {
+		if act.verb == CutVerb {
+			outFrames -= hdr.frameAt(act.end) - hdr.frameAt(act.start)
+		}
+	}
+	dataSize := outFrames * blockAlign
+	riffSize := 4 + int64(len(hdr.chunks)) + 8 + dataSize + dataSize%2
+	if riffSize > math.MaxUint32 {
+		return fmt.Errorf("output would exceed the 4 GiB WAV size limit")
+	}
+
+	var buf [12]byte
+	copy(buf[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(riffSize))
+	copy(buf[8:12], "WAVE")
+	if _, err := w.Write(buf[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(hdr.chunks); err != nil {
+		return err
+	}
+	copy(buf[0:4], "data")
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(dataSize))
+	if _, err := w.Write(buf[:8]); err != nil {
+		return err
+	}
+
+	copyFrames := func(n int64) error {
+		_, err := io.CopyN(w, r, n*blockAlign)
+		if err == io.EOF {
+			return fmt.Errorf("unexpected end of sample data")
+		}
+		return err
+	}
+	skipFrames := func(n int64) error {
+		_, err := io.CopyN(io.Discard, r, n*blockAlign)
+		if err == io.EOF {
+			return fmt.Errorf("unexpected end of sample data")
+		}
+		return err
+	}
+	silentFrames := func(n int64) error {
+		frame := hdr.silence()
+		chunk := make([]byte, 0, 4096*len(frame))
+		for len(chunk) < cap(chunk) {
+			chunk = append(chunk, frame...)
+		}
+		for n > 0 {
+			count := int64(len(chunk)) / blockAlign
+			if count > n {
+				count = n
+			}
+			if _, err := w.Write(chunk[:count*blockAlign]); err != nil {
+				return err
+			}
+			n -= count
+		}
+		return nil
+	}
+
+	var pos int64
+	for _, act := range actions {
+		start, end := hdr.frameAt(act.start), hdr.frameAt(act.end)
+		if err := copyFrames(start - pos); err != nil {
+			return err
+		}
+		if err := skipFrames(end - start); err != nil {
+			return err
+		}
+		if act.verb == MuteVerb {
+			if err := silentFrames(end - start); err != nil {
+				return err
+			}
+		}
+		pos = end
+	}
+	if err := copyFrames(hdr.frames() - pos); err != nil {
+		return err
+	}
+
+	if dataSize%2 == 1 {
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
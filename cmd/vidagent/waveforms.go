@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// waveformsCmd draws the audio around the start and end of each mute
+// action as a PNG, so it can be seen whether a mute clips the word
+// before or after it without opening the audio in an editor.
+func waveformsCmd(args []string) error {
+	fs := flag.NewFlagSet("waveforms", flag.ExitOnError)
+	e := newEdit(defaultOptions())
+	fs.StringVar(&e.opts.inputFile, "in", "", "the video the filter file is for")
+	filter := fs.String("filter", "", "the filter file")
+	outDir := fs.String("out-dir", ".", "the directory to write the images to")
+	around := fs.Duration("around", 3*time.Second, "how much audio to draw before and after each boundary")
+	size := fs.String("size", "800x200", "the size of each image")
+	lines := fs.String("lines", "", "only draw the mute actions on these lines (e.g. 3,7-12)")
+	policyFile := fs.String("policy", "", "decide the verbs of actions by their reasons according to this policy file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: vidagent waveforms -in <file> -filter <file> [options]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if e.opts.inputFile == "" || *filter == "" {
+		fs.Usage()
+		return errors.New("input and filter files required (use -in and -filter)")
+	}
+	if *around <= 0 {
+		return errors.New("-around must be positive")
+	}
+	width, height, ok := parseSize(*size)
+	if !ok {
+		return fmt.Errorf("-size: %q isn't a size like 800x200", *size)
+	}
+	var ranges [][2]int
+	if *lines != "" {
+		var err error
+		ranges, err = parseLineRanges(*lines)
+		if err != nil {
+			return err
+		}
+	}
+	if *policyFile != "" {
+		err := e.loadPolicy(*policyFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	actions, err := e.readFilterFile(*filter)
+	if err != nil {
+		return err
+	}
+	info, err := probe(e.opts.inputFile)
+	if err != nil {
+		return err
+	}
+	if info.stream("audio") == nil {
+		return errors.New("the input has no audio")
+	}
+	err = os.MkdirAll(*outDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(*filter), filepath.Ext(*filter))
+	var count int
+	for _, act := range actions {
+		line := act.line()
+		if act.verb != MuteVerb || (ranges != nil && !lineInRanges(line, ranges)) {
+			continue
+		}
+		for _, boundary := range []string{"start", "end"} {
+			at := act.start.SecondNum()
+			if boundary == "end" {
+				at = act.end.SecondNum()
+			}
+			png := filepath.Join(*outDir, fmt.Sprintf("%s-line%d-%s.png", base, line, boundary))
+			err := e.drawWaveform(png, act, at, around.Seconds(), width, height)
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			count++
+		}
+	}
+	if count == 0 {
+		return errors.New("no mute actions to draw")
+	}
+	log.Printf("wrote %d waveforms to %s", count, *outDir)
+	return nil
+}
+
+// drawWaveform writes a PNG of the input's audio from around seconds
+// before to around seconds after at, a boundary of the mute action,
+// with a line at the boundary and the muted part shaded.
+func (e *edit) drawWaveform(png string, act action, at, around float64, width, height int) error {
+	start := math.Max(at-around, 0)
+	dur := at + around - start
+	x := func(t float64) int {
+		t = math.Min(math.Max(t, start), start+dur)
+		return int(math.Round((t - start) / dur * float64(width)))
+	}
+	mutedFrom, mutedTo := x(act.start.SecondNum()), x(act.end.SecondNum())
+
+	graph := fmt.Sprintf("[0:a:0]showwavespic=s=%dx%d:colors=0x2060a0[wave];"+
+		"color=c=white:s=%dx%d[bg];[bg][wave]overlay,"+
+		"drawbox=x=%d:y=0:w=%d:h=ih:color=red@0.2:t=fill,"+
+		"drawbox=x=%d:y=0:w=2:h=ih:color=red:t=fill",
+		width, height, width, height,
+		mutedFrom, max(mutedTo-mutedFrom, 1), max(x(at)-1, 0))
+	_, err := ffmpegOutput("-ss", strconv.FormatFloat(start, 'f', 3, 64),
+		"-t", strconv.FormatFloat(dur, 'f', 3, 64), "-i", fileArg(e.opts.inputFile),
+		"-filter_complex", graph, "-frames:v", "1", "-y", fileArg(png))
+	return err
+}
+
+// parseSize parses a size like 800x200.
+func parseSize(s string) (int, int, bool) {
+	w, h, ok := strings.Cut(s, "x")
+	if !ok {
+		return 0, 0, false
+	}
+	width, err1 := strconv.Atoi(w)
+	height, err2 := strconv.Atoi(h)
+	if err1 != nil || err2 != nil || width <= 0 || height <= 0 {
+		return 0, 0, false
+	}
+	return width, height, true
+}